@@ -39,13 +39,17 @@ import (
 	"github.com/google/cayley/http"
 	"github.com/google/cayley/quad"
 	"github.com/google/cayley/quad/cquads"
+	"github.com/google/cayley/quad/jsonld"
 	"github.com/google/cayley/quad/nquads"
 
 	// Load all supported backends.
 	_ "github.com/google/cayley/graph/bolt"
+	_ "github.com/google/cayley/graph/cache"
 	_ "github.com/google/cayley/graph/leveldb"
 	_ "github.com/google/cayley/graph/memstore"
 	_ "github.com/google/cayley/graph/mongo"
+	_ "github.com/google/cayley/graph/redis"
+	_ "github.com/google/cayley/graph/sql"
 
 	// Load writer registry
 	_ "github.com/google/cayley/writer"
@@ -53,7 +57,7 @@ import (
 
 var (
 	quadFile           = flag.String("quads", "", "Quad file to load before going to REPL.")
-	quadType           = flag.String("format", "cquad", `Quad format to use for loading ("cquad" or "nquad").`)
+	quadType           = flag.String("format", "cquad", `Quad format to use for loading ("cquad", "nquad" or "jsonld").`)
 	cpuprofile         = flag.String("prof", "", "Output profiling file.")
 	queryLanguage      = flag.String("query_lang", "gremlin", "Use this parser as the query language.")
 	configFile         = flag.String("config", "", "Path to an explicit configuration file.")
@@ -65,6 +69,10 @@ var (
 	port               = flag.String("port", "64210", "Port to listen on.")
 	readOnly           = flag.Bool("read_only", false, "Disable writing via HTTP.")
 	timeout            = flag.Duration("timeout", 30*time.Second, "Elapsed time until an individual query times out.")
+	cursorTimeout      = flag.Duration("cursor_timeout", 5*time.Minute, "Time a paused query is kept for its next page of results.")
+	maxCursors         = flag.Int("max_cursors", 100, "Number of paused queries kept at once.")
+	ignoreDup          = flag.Bool("ignore_duplicate", false, "Skip quads that are already in the database when writing.")
+	ignoreMissing      = flag.Bool("ignore_missing", false, "Skip quads that are not in the database when deleting.")
 )
 
 // Filled in by `go build ldflags="-X main.Version `ver`"`.
@@ -83,6 +91,7 @@ Commands:
   load      Bulk-load a quad file into the database.
   http      Serve an HTTP endpoint on the given host and port.
   repl      Drop into a REPL of the given query language.
+  stats     Print the statistics the database keeps about its predicates.
   version   Version information.
 
 Flags:`)
@@ -136,12 +145,32 @@ func configFrom(file string) *config.Config {
 		cfg.Timeout = *timeout
 	}
 
+	if cfg.CursorTimeout == 0 {
+		cfg.CursorTimeout = *cursorTimeout
+	}
+
+	if cfg.MaxCursors == 0 {
+		cfg.MaxCursors = *maxCursors
+	}
+
 	if cfg.LoadSize == 0 {
 		cfg.LoadSize = *loadSize
 	}
 
 	cfg.ReadOnly = cfg.ReadOnly || *readOnly
 
+	if *ignoreDup || *ignoreMissing {
+		if cfg.ReplicationOptions == nil {
+			cfg.ReplicationOptions = make(map[string]interface{})
+		}
+		if *ignoreDup {
+			cfg.ReplicationOptions["ignore_duplicate"] = true
+		}
+		if *ignoreMissing {
+			cfg.ReplicationOptions["ignore_missing"] = true
+		}
+	}
+
 	return cfg
 }
 
@@ -230,6 +259,15 @@ func main() {
 
 		handle.Close()
 
+	case "stats":
+		handle, err = db.Open(cfg)
+		if err != nil {
+			break
+		}
+		err = db.Stats(os.Stdout, handle.QuadStore)
+
+		handle.Close()
+
 	case "http":
 		handle, err = db.Open(cfg)
 		if err != nil {
@@ -301,11 +339,13 @@ func decompressAndLoad(qw graph.QuadWriter, cfg *config.Config, path, typ string
 		dec = cquads.NewDecoder(r)
 	case "nquad":
 		dec = nquads.NewDecoder(r)
+	case "jsonld":
+		dec = jsonld.NewDecoder(r)
 	default:
 		return fmt.Errorf("unknown quad format %q", typ)
 	}
 
-	return db.Load(qw, cfg, dec)
+	return loadFn(qw, cfg, dec)
 }
 
 const (
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -483,7 +484,7 @@ func checkQueries(t *testing.T) {
 			t.Fatalf("Failed to parse benchmark gremlin %s: %v", test.message, err)
 		}
 		c := make(chan interface{}, 5)
-		go ses.ExecInput(test.query, c, 100)
+		go ses.ExecInput(context.Background(), test.query, c, 100)
 		var (
 			got      [][]interface{}
 			timedOut bool
@@ -556,7 +557,7 @@ func runBench(n int, b *testing.B) {
 		// Do the parsing we know works.
 		ses.InputParses(benchmarkQueries[n].query)
 		b.StartTimer()
-		go ses.ExecInput(benchmarkQueries[n].query, c, 100)
+		go ses.ExecInput(context.Background(), benchmarkQueries[n].query, c, 100)
 		for _ = range c {
 		}
 		b.StopTimer()
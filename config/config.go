@@ -33,6 +33,8 @@ type Config struct {
 	ListenPort         string
 	ReadOnly           bool
 	Timeout            time.Duration
+	CursorTimeout      time.Duration
+	MaxCursors         int
 	LoadSize           int
 }
 
@@ -46,6 +48,8 @@ type config struct {
 	ListenPort         string                 `json:"listen_port"`
 	ReadOnly           bool                   `json:"read_only"`
 	Timeout            duration               `json:"timeout"`
+	CursorTimeout      duration               `json:"cursor_timeout"`
+	MaxCursors         int                    `json:"max_cursors"`
 	LoadSize           int                    `json:"load_size"`
 }
 
@@ -65,6 +69,8 @@ func (c *Config) UnmarshalJSON(data []byte) error {
 		ListenPort:         t.ListenPort,
 		ReadOnly:           t.ReadOnly,
 		Timeout:            time.Duration(t.Timeout),
+		CursorTimeout:      time.Duration(t.CursorTimeout),
+		MaxCursors:         t.MaxCursors,
 		LoadSize:           t.LoadSize,
 	}
 	return nil
@@ -81,6 +87,8 @@ func (c *Config) MarshalJSON() ([]byte, error) {
 		ListenPort:         c.ListenPort,
 		ReadOnly:           c.ReadOnly,
 		Timeout:            duration(c.Timeout),
+		CursorTimeout:      duration(c.CursorTimeout),
+		MaxCursors:         c.MaxCursors,
 		LoadSize:           c.LoadSize,
 	})
 }
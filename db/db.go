@@ -68,7 +68,20 @@ func OpenQuadWriter(qs graph.QuadStore, cfg *config.Config) (graph.QuadWriter, e
 	return w, nil
 }
 
+// Load writes the quads from dec. If qw can load them in bulk, as it can into
+// an empty store for some backends, it does so; otherwise they are added in
+// sets of cfg.LoadSize.
 func Load(qw graph.QuadWriter, cfg *config.Config, dec quad.Unmarshaler) error {
+	if bl, ok := qw.(graph.BulkLoader); ok {
+		err := bl.BulkLoad(dec)
+		if err != graph.ErrCannotBulkLoad {
+			if err != nil {
+				return fmt.Errorf("db: failed to bulk load data: %v", err)
+			}
+			return nil
+		}
+		glog.V(2).Infoln("Could not bulk load, adding quads in sets.")
+	}
 	block := make([]quad.Quad, 0, cfg.LoadSize)
 	count := 0
 	for {
@@ -81,16 +94,22 @@ func Load(qw graph.QuadWriter, cfg *config.Config, dec quad.Unmarshaler) error {
 		}
 		block = append(block, t)
 		if len(block) == cap(block) {
+			err := qw.AddQuadSet(block)
+			if err != nil {
+				return fmt.Errorf("db: failed to load data after %d quads: %v", count, err)
+			}
 			count += len(block)
-			qw.AddQuadSet(block)
 			if glog.V(2) {
 				glog.V(2).Infof("Wrote %d quads.", count)
 			}
 			block = block[:0]
 		}
 	}
+	err := qw.AddQuadSet(block)
+	if err != nil {
+		return fmt.Errorf("db: failed to load data after %d quads: %v", count, err)
+	}
 	count += len(block)
-	qw.AddQuadSet(block)
 	if glog.V(2) {
 		glog.V(2).Infof("Wrote %d quads.", count)
 	}
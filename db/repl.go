@@ -15,6 +15,8 @@
 package db
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -28,9 +30,11 @@ import (
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/quad/cquads"
 	"github.com/google/cayley/query"
+	"github.com/google/cayley/query/datalog"
 	"github.com/google/cayley/query/gremlin"
 	"github.com/google/cayley/query/mql"
 	"github.com/google/cayley/query/sexp"
+	"github.com/google/cayley/query/sparql"
 )
 
 func trace(s string) (string, time.Time) {
@@ -43,7 +47,9 @@ func un(s string, startTime time.Time) {
 	fmt.Printf(s, float64(endTime.UnixNano()-startTime.UnixNano())/float64(1E6))
 }
 
-func Run(query string, ses query.Session) {
+// Run runs the query, printing its results, and kills it if it takes longer
+// than timeout. A negative timeout means no limit.
+func Run(query string, ses query.Session, timeout time.Duration) {
 	nResults := 0
 	startTrace, startTime := trace("Elapsed time: %g ms\n\n")
 	defer func() {
@@ -52,8 +58,14 @@ func Run(query string, ses query.Session) {
 		}
 	}()
 	fmt.Printf("\n")
+	ctx := context.Background()
+	if timeout >= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 	c := make(chan interface{}, 5)
-	go ses.ExecInput(query, c, 100)
+	go ses.ExecInput(ctx, query, c, 100)
 	for res := range c {
 		fmt.Print(ses.ToText(res))
 		nResults++
@@ -63,6 +75,34 @@ func Run(query string, ses query.Session) {
 	}
 }
 
+// Explain runs the query with its iterators explained, or profiled, and
+// prints them after its results.
+func Explain(code string, ses query.Session, profile bool, timeout time.Duration) {
+	ex, ok := ses.(query.Explainer)
+	if !ok {
+		fmt.Println("Error: this query language can't explain its queries")
+		return
+	}
+	switch result, err := ses.InputParses(code); result {
+	case query.ParseFail:
+		fmt.Println("Error: ", err)
+		return
+	case query.ParseMore:
+		fmt.Println("Error: incomplete query")
+		return
+	}
+	e := query.NewExplain(profile)
+	ex.SetExplain(e)
+	defer ex.SetExplain(nil)
+	Run(code, ses, timeout)
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		fmt.Printf("failed to format explanation: %v\n", err)
+		return
+	}
+	fmt.Printf("%s\n", b)
+}
+
 const (
 	ps1 = "cayley> "
 	ps2 = "...     "
@@ -77,6 +117,10 @@ func Repl(h *graph.Handle, queryLanguage string, cfg *config.Config) error {
 		ses = sexp.NewSession(h.QuadStore)
 	case "mql":
 		ses = mql.NewSession(h.QuadStore)
+	case "sparql":
+		ses = sparql.NewSession(h.QuadStore)
+	case "datalog":
+		ses = datalog.NewSession(h.QuadStore)
 	case "gremlin":
 		fallthrough
 	default:
@@ -124,6 +168,14 @@ func Repl(h *graph.Handle, queryLanguage string, cfg *config.Config) error {
 				fmt.Println("Debug Toggled")
 				continue
 
+			case strings.HasPrefix(line, ":explain"):
+				Explain(strings.TrimSpace(line[len(":explain"):]), ses, false, cfg.Timeout)
+				continue
+
+			case strings.HasPrefix(line, ":profile"):
+				Explain(strings.TrimSpace(line[len(":profile"):]), ses, true, cfg.Timeout)
+				continue
+
 			case strings.HasPrefix(line, ":a"):
 				quad, err := cquads.Parse(line[3:])
 				if !quad.IsValid() {
@@ -132,7 +184,9 @@ func Repl(h *graph.Handle, queryLanguage string, cfg *config.Config) error {
 					}
 					continue
 				}
-				h.QuadWriter.AddQuad(quad)
+				if err := h.QuadWriter.AddQuad(quad); err != nil {
+					fmt.Printf("error adding quad: %v\n", err)
+				}
 				continue
 
 			case strings.HasPrefix(line, ":d"):
@@ -143,7 +197,9 @@ func Repl(h *graph.Handle, queryLanguage string, cfg *config.Config) error {
 					}
 					continue
 				}
-				h.QuadWriter.RemoveQuad(quad)
+				if err := h.QuadWriter.RemoveQuad(quad); err != nil {
+					fmt.Printf("error deleting quad: %v\n", err)
+				}
 				continue
 			}
 		}
@@ -153,7 +209,7 @@ func Repl(h *graph.Handle, queryLanguage string, cfg *config.Config) error {
 		result, err := ses.InputParses(code)
 		switch result {
 		case query.Parsed:
-			Run(code, ses)
+			Run(code, ses, cfg.Timeout)
 			code = ""
 		case query.ParseFail:
 			fmt.Println("Error: ", err)
@@ -0,0 +1,52 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
+)
+
+// Stats prints the number of quads in the store, and the statistics it keeps
+// for each predicate, in order of their names.
+func Stats(w io.Writer, qs graph.QuadStore) error {
+	fmt.Fprintf(w, "Quads: %d\n", qs.Size())
+	ss, ok := qs.(graph.StatsStore)
+	if !ok {
+		return graph.ErrNoStats
+	}
+	stats, err := ss.AllPredicateStats()
+	if err != nil {
+		return err
+	}
+	preds := make([]string, 0, len(stats))
+	for pred := range stats {
+		preds = append(preds, pred)
+	}
+	sort.Strings(preds)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Predicate\tQuads\tSubjects\tObjects\tFan-out (subject)\tFan-out (object)")
+	for _, pred := range preds {
+		s := stats[pred]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", pred, s.Quads, s.Subjects, s.Objects, s.FanOut(quad.Subject), s.FanOut(quad.Object))
+	}
+	return tw.Flush()
+}
@@ -78,8 +78,9 @@ func (it *AllIterator) Clone() graph.Iterator {
 }
 
 func (it *AllIterator) Next() bool {
+	graph.NextLogIn(it)
 	if it.done {
-		return false
+		return graph.NextLogOut(it, nil, false)
 	}
 	if len(it.buffer) <= it.offset+1 {
 		it.offset = 0
@@ -122,16 +123,16 @@ func (it *AllIterator) Next() bool {
 		if err != nil {
 			glog.Error("Error nexting in database: ", err)
 			it.done = true
-			return false
+			return graph.NextLogOut(it, nil, false)
 		}
 	} else {
 		it.offset++
 	}
 	if it.Result() == nil {
 		it.done = true
-		return false
+		return graph.NextLogOut(it, nil, false)
 	}
-	return true
+	return graph.NextLogOut(it, it.Result(), true)
 }
 
 func (it *AllIterator) ResultTree() *graph.ResultTree {
@@ -164,8 +165,9 @@ func (it *AllIterator) SubIterators() []graph.Iterator {
 }
 
 func (it *AllIterator) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
 	it.result = v.(*Token)
-	return true
+	return graph.ContainsLogOut(it, v, true)
 }
 
 func (it *AllIterator) Close() {
@@ -0,0 +1,379 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bolt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+	"github.com/google/cayley/quad/cquads"
+	"github.com/google/cayley/writer"
+)
+
+func makeQuadSet() []quad.Quad {
+	quadSet := []quad.Quad{
+		{"A", "follows", "B", ""},
+		{"C", "follows", "B", ""},
+		{"C", "follows", "D", ""},
+		{"D", "follows", "B", ""},
+		{"B", "follows", "F", ""},
+		{"F", "follows", "G", ""},
+		{"D", "follows", "G", ""},
+		{"E", "follows", "F", ""},
+		{"B", "status", "cool", "status_graph"},
+		{"D", "status", "cool", "status_graph"},
+		{"G", "status", "cool", "status_graph"},
+	}
+	return quadSet
+}
+
+func iteratedQuads(qs graph.QuadStore, it graph.Iterator) []string {
+	var res []string
+	for graph.Next(it) {
+		res = append(res, qs.Quad(it.Result()).String())
+	}
+	sort.Strings(res)
+	return res
+}
+
+func iteratedNames(qs graph.QuadStore, it graph.Iterator) []string {
+	var res []string
+	for graph.Next(it) {
+		res = append(res, qs.NameOf(it.Result()))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// makeQuadStore creates a bolt QuadStore in a temporary directory, returning
+// it and the directory, which the caller removes.
+func makeQuadStore(t *testing.T) (*QuadStore, string) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create working directory: %v", err)
+	}
+	path := filepath.Join(tmpDir, "cayley.db")
+	err = createNewBolt(path, nil)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create Bolt database: %v", err)
+	}
+	qs, err := newQuadStore(path, nil)
+	if qs == nil || err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create bolt QuadStore: %v", err)
+	}
+	return qs.(*QuadStore), tmpDir
+}
+
+func TestPredicateStats(t *testing.T) {
+	qs, tmpDir := makeQuadStore(t)
+	defer os.RemoveAll(tmpDir)
+	defer qs.Close()
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+
+	expect := map[string]graph.PredicateStats{
+		"follows": {Quads: 8, Subjects: 6, Objects: 4},
+		"status":  {Quads: 3, Subjects: 3, Objects: 1},
+	}
+	got, err := qs.AllPredicateStats()
+	if err != nil || !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected predicate statistics, got:%v (%v) expect:%v", got, err, expect)
+	}
+	if s, ok := qs.PredicateStats(qs.ValueOf("follows")); !ok || s.FanOut(quad.Object) != 2 {
+		t.Errorf("Unexpected fan-out of follows, got:%d expect:2", s.FanOut(quad.Object))
+	}
+	if _, ok := qs.PredicateStats(qs.ValueOf("cool")); ok {
+		t.Error("Unexpected statistics for a node which is not a predicate")
+	}
+	if got := qs.sizeIn(quad.Predicate, qs.ValueOf("status")); got != 3 {
+		t.Errorf("Unexpected size of status as a predicate, got:%d expect:3", got)
+	}
+
+	// Moving a quad keeps its objects; removing one drops the objects it
+	// was the last to use.
+	tx := graph.NewTransaction()
+	tx.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "G", ""})
+	tx.RemoveQuad(quad.Quad{"C", "follows", "D", ""})
+	if err := w.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Unexpected error applying transaction: %v", err)
+	}
+	expect["follows"] = graph.PredicateStats{Quads: 7, Subjects: 6, Objects: 3}
+	got, err = qs.AllPredicateStats()
+	if err != nil || !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected predicate statistics, got:%v (%v) expect:%v", got, err, expect)
+	}
+
+	// A predicate with no quads left has no statistics.
+	for _, q := range makeQuadSet()[8:] {
+		w.RemoveQuad(q)
+	}
+	delete(expect, "status")
+	got, err = qs.AllPredicateStats()
+	if err != nil || !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected predicate statistics, got:%v (%v) expect:%v", got, err, expect)
+	}
+
+	// The statistics of a database without them are rebuilt from its quads.
+	err = qs.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{predicateBucket, predicateNodeBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return qs.buildPredicateStats(tx)
+	})
+	if err != nil {
+		t.Fatalf("Failed to rebuild predicate statistics: %v", err)
+	}
+	got, err = qs.AllPredicateStats()
+	if err != nil || !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected rebuilt predicate statistics, got:%v (%v) expect:%v", got, err, expect)
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	qs, tmpDir := makeQuadStore(t)
+	defer os.RemoveAll(tmpDir)
+	defer qs.Close()
+
+	// Load the quads with a duplicate, in batches smaller than the set, so
+	// that the duplicate comes in a later batch.
+	defer func(size int) { bulkBatchSize = size }(bulkBatchSize)
+	bulkBatchSize = 4
+	var lines []string
+	for _, q := range makeQuadSet() {
+		lines = append(lines, q.NQuad())
+	}
+	lines = append(lines, lines[0])
+	dec := cquads.NewDecoder(strings.NewReader(strings.Join(lines, "\n")))
+	if err := qs.BulkLoad(dec); err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:11", s)
+	}
+	if h := qs.Horizon(); h != 11 {
+		t.Errorf("Unexpected horizon, got:%d expect:11", h)
+	}
+	if s := qs.SizeOf(qs.ValueOf("B")); s != 5 {
+		t.Errorf("Unexpected quadstore size of B, got:%d expect:5", s)
+	}
+	stats, ok := qs.PredicateStats(qs.ValueOf("follows"))
+	if expect := (graph.PredicateStats{Quads: 8, Subjects: 6, Objects: 4}); !ok || stats != expect {
+		t.Errorf("Unexpected statistics of follows, got:%v expect:%v", stats, expect)
+	}
+	got := iteratedQuads(qs, qs.QuadsAllIterator())
+	var expect []string
+	for _, q := range makeQuadSet() {
+		expect = append(expect, q.String())
+	}
+	sort.Strings(expect)
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+
+	// The value index is built as the quads are loaded.
+	it, ok := iterator.NewComparison(qs.NodesAllIterator(), iterator.CompareLTE, "B", qs).Optimize()
+	if !ok || it.Type() != boltValueType {
+		t.Errorf("Failed to optimize a comparison after bulk loading")
+	}
+	if names := iteratedNames(qs, it); !reflect.DeepEqual(names, []string{"A", "B"}) {
+		t.Errorf("Failed to compare bulk loaded values, got:%v expect:[A B]", names)
+	}
+
+	// Bulk loaded quads may be removed like any others.
+	w, _ := writer.NewSingleReplication(qs, nil)
+	if err := w.RemoveQuad(quad.Quad{"A", "follows", "B", ""}); err != nil {
+		t.Errorf("Failed to remove a bulk loaded quad: %v", err)
+	}
+	if s := qs.Size(); s != 10 {
+		t.Errorf("Unexpected quadstore size after RemoveQuad, got:%d expect:10", s)
+	}
+
+	dec = cquads.NewDecoder(strings.NewReader(lines[0]))
+	if err := qs.BulkLoad(dec); err != graph.ErrCannotBulkLoad {
+		t.Errorf("Unexpected error loading into a non-empty store, got:%v expect:%v", err, graph.ErrCannotBulkLoad)
+	}
+}
+
+var comparisonTests = []struct {
+	message string
+	op      iterator.Operator
+	val     interface{}
+	expect  []string
+}{
+	{
+		message: "select integers greater than 20",
+		op:      iterator.CompareGT,
+		val:     int64(20),
+		expect:  []string{"21", "100"},
+	},
+	{
+		message: "select floats up to 20.5",
+		op:      iterator.CompareLTE,
+		val:     20.5,
+		expect:  []string{"-1", "20.5"},
+	},
+	{
+		message: "select dates from 2014",
+		op:      iterator.CompareGTE,
+		val:     time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+		expect:  []string{"2014-06-01T00:00:00Z"},
+	},
+	{
+		message: "select strings with a prefix",
+		op:      iterator.ComparePrefix,
+		val:     "ag",
+		expect:  []string{"age"},
+	},
+}
+
+func TestOptimizeComparison(t *testing.T) {
+	qs, tmpDir := makeQuadStore(t)
+	defer os.RemoveAll(tmpDir)
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet([]quad.Quad{
+		{"A", "age", "21", ""},
+		{"B", "age", "100", ""},
+		{"C", "age", "20.5", ""},
+		{"D", "age", "-1", ""},
+		{"E", "age", "7", ""},
+		{"E", "born", "2014-06-01T00:00:00Z", ""},
+		{"F", "born", "2013-06-01T00:00:00Z", ""},
+	})
+	// Values of nodes no longer in use are dropped from the index.
+	w.RemoveQuad(quad.Quad{"E", "age", "7", ""})
+
+	check := func(qs graph.QuadStore) {
+		for _, test := range comparisonTests {
+			it := iterator.NewComparison(qs.NodesAllIterator(), test.op, test.val, qs)
+			it.Tagger().Add("value")
+			newIt, ok := it.Clone().Optimize()
+			if !ok || newIt.Type() != boltValueType {
+				t.Errorf("Failed to optimize iterator to %s", test.message)
+				continue
+			}
+			got := iteratedNames(qs, newIt)
+			expect := append([]string(nil), test.expect...)
+			sort.Strings(expect)
+			if !reflect.DeepEqual(got, expect) {
+				t.Errorf("Failed to %s, got:%v expect:%v", test.message, got, expect)
+			}
+			newIt.Reset()
+			if !graph.Next(newIt) {
+				t.Errorf("Failed to %s after reset", test.message)
+				continue
+			}
+			results := make(map[string]graph.Value)
+			newIt.TagResults(results)
+			if _, ok := results["value"]; !ok {
+				t.Errorf("Failed to %s, tag results missing, got:%v", test.message, results)
+			}
+			for _, name := range []string{"7", "A"} {
+				if newIt.Contains(qs.ValueOf(name)) {
+					t.Errorf("Failed to %s, %q is contained", test.message, name)
+				}
+			}
+			for _, name := range test.expect {
+				if !newIt.Contains(qs.ValueOf(name)) {
+					t.Errorf("Failed to %s, %q is not contained", test.message, name)
+				}
+			}
+		}
+	}
+	check(qs)
+
+	// The index is kept across reopening the store.
+	qs.Close()
+	reopened, err := newQuadStore(filepath.Join(tmpDir, "cayley.db"), nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen bolt QuadStore: %v", err)
+	}
+	defer reopened.Close()
+	check(reopened)
+}
+
+func TestOptimizeLabelPredicate(t *testing.T) {
+	qs, tmpDir := makeQuadStore(t)
+	defer os.RemoveAll(tmpDir)
+	defer qs.Close()
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+	w.AddQuad(quad.Quad{"E", "status", "cool", "other_graph"})
+	w.AddQuad(quad.Quad{"F", "follows", "E", "other_graph"})
+
+	label := qs.FixedIterator()
+	label.Add(qs.ValueOf("other_graph"))
+	label.Tagger().Add("label")
+	pred := qs.FixedIterator()
+	pred.Add(qs.ValueOf("status"))
+	and := iterator.NewAnd()
+	and.AddSubIterator(iterator.NewLinksTo(qs, label, quad.Label))
+	and.AddSubIterator(iterator.NewLinksTo(qs, pred, quad.Predicate))
+	hasa := iterator.NewHasA(qs, and, quad.Subject)
+
+	oldIt := hasa.Clone()
+	newIt, ok := hasa.Optimize()
+	if !ok {
+		t.Errorf("Failed to optimize iterator")
+	}
+	var scans int
+	var find func(graph.Iterator)
+	find = func(it graph.Iterator) {
+		if it, ok := it.(*Iterator); ok && it.pred != nil {
+			scans++
+		}
+		for _, sub := range it.SubIterators() {
+			find(sub)
+		}
+	}
+	find(newIt)
+	if scans != 1 {
+		t.Errorf("Unexpected number of label and predicate scans, got:%d expect:1", scans)
+	}
+
+	oldNames := iteratedNames(qs, oldIt)
+	newNames := iteratedNames(qs, newIt)
+	if expect := []string{"E"}; !reflect.DeepEqual(newNames, expect) {
+		t.Errorf("Unexpected optimized results, got:%v expect:%v", newNames, expect)
+	}
+	if !reflect.DeepEqual(newNames, oldNames) {
+		t.Errorf("Optimized iteration does not match original, got:%v expect:%v", newNames, oldNames)
+	}
+
+	newIt.Reset()
+	graph.Next(newIt)
+	tags := make(map[string]graph.Value)
+	newIt.TagResults(tags)
+	if got := qs.NameOf(tags["label"]); got != "other_graph" {
+		t.Errorf("Unexpected label tag, got:%q expect:%q", got, "other_graph")
+	}
+}
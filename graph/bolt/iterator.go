@@ -43,6 +43,8 @@ type Iterator struct {
 	tags    graph.Tagger
 	bucket  []byte
 	checkID []byte
+	prefix  []byte
+	pred    []byte
 	dir     quad.Direction
 	qs      *QuadStore
 	result  *Token
@@ -64,15 +66,37 @@ func NewIterator(bucket []byte, d quad.Direction, value graph.Value, qs *QuadSto
 		bucket: bucket,
 		dir:    d,
 		qs:     qs,
-		size:   qs.SizeOf(value),
+		size:   qs.sizeIn(d, value),
 	}
 
 	it.checkID = make([]byte, len(tok.key))
 	copy(it.checkID, tok.key)
+	it.prefix = it.checkID
 
 	return &it
 }
 
+// newLabelPredicateIterator returns an iterator over the quads with both a
+// label and a predicate, which are next to each other in the cps index.
+func newLabelPredicateIterator(label, pred graph.Value, qs *QuadStore) *Iterator {
+	it := NewIterator(cpsBucket, quad.Label, label, qs)
+	if it.done {
+		return it
+	}
+	tok := pred.(*Token)
+	it.pred = make([]byte, len(tok.key))
+	copy(it.pred, tok.key)
+	it.prefix = make([]byte, 0, len(it.checkID)+len(it.pred))
+	it.prefix = append(it.prefix, it.checkID...)
+	it.prefix = append(it.prefix, it.pred...)
+	// We can't know how many quads have both, only that it's no more than
+	// either has.
+	if size := qs.sizeIn(quad.Predicate, pred); size < it.size {
+		it.size = size
+	}
+	return it
+}
+
 func Type() graph.Type { return boltType }
 
 func (it *Iterator) UID() uint64 {
@@ -100,7 +124,12 @@ func (it *Iterator) TagResults(dst map[string]graph.Value) {
 }
 
 func (it *Iterator) Clone() graph.Iterator {
-	out := NewIterator(it.bucket, it.dir, &Token{nodeBucket, it.checkID}, it.qs)
+	var out *Iterator
+	if it.pred != nil {
+		out = newLabelPredicateIterator(&Token{nodeBucket, it.checkID}, &Token{nodeBucket, it.pred}, it.qs)
+	} else {
+		out = NewIterator(it.bucket, it.dir, &Token{nodeBucket, it.checkID}, it.qs)
+	}
 	out.Tagger().CopyFrom(it)
 	return out
 }
@@ -118,8 +147,9 @@ func (it *Iterator) isLiveValue(val []byte) bool {
 }
 
 func (it *Iterator) Next() bool {
+	graph.NextLogIn(it)
 	if it.done {
-		return false
+		return graph.NextLogOut(it, nil, false)
 	}
 	if len(it.buffer) <= it.offset+1 {
 		it.offset = 0
@@ -133,8 +163,8 @@ func (it *Iterator) Next() bool {
 			b := tx.Bucket(it.bucket)
 			cur := b.Cursor()
 			if last == nil {
-				k, _ := cur.Seek(it.checkID)
-				if bytes.HasPrefix(k, it.checkID) {
+				k, _ := cur.Seek(it.prefix)
+				if bytes.HasPrefix(k, it.prefix) {
 					var out []byte
 					out = make([]byte, len(k))
 					copy(out, k)
@@ -152,7 +182,7 @@ func (it *Iterator) Next() bool {
 			}
 			for i < bufferSize {
 				k, v := cur.Next()
-				if k == nil || !bytes.HasPrefix(k, it.checkID) {
+				if k == nil || !bytes.HasPrefix(k, it.prefix) {
 					it.buffer = append(it.buffer, nil)
 					break
 				}
@@ -172,16 +202,16 @@ func (it *Iterator) Next() bool {
 				glog.Errorf("Error nexting in database: %v", err)
 			}
 			it.done = true
-			return false
+			return graph.NextLogOut(it, nil, false)
 		}
 	} else {
 		it.offset++
 	}
 	if it.Result() == nil {
 		it.done = true
-		return false
+		return graph.NextLogOut(it, nil, false)
 	}
-	return true
+	return graph.NextLogOut(it, it.Result(), true)
 }
 
 func (it *Iterator) ResultTree() *graph.ResultTree {
@@ -266,11 +296,18 @@ func PositionOf(tok *Token, d quad.Direction, qs *QuadStore) int {
 }
 
 func (it *Iterator) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
 	val := v.(*Token)
 	if bytes.Equal(val.bucket, nodeBucket) {
-		return false
+		return graph.ContainsLogOut(it, v, false)
 	}
 	offset := PositionOf(val, it.dir, it.qs)
+	if it.pred != nil && len(val.key) != 0 {
+		pOffset := PositionOf(val, quad.Predicate, it.qs)
+		if !bytes.HasPrefix(val.key[pOffset:], it.pred) {
+			return graph.ContainsLogOut(it, v, false)
+		}
+	}
 	if len(val.key) != 0 && bytes.HasPrefix(val.key[offset:], it.checkID) {
 		// You may ask, why don't we check to see if it's a valid (not deleted) quad
 		// again?
@@ -281,13 +318,13 @@ func (it *Iterator) Contains(v graph.Value) bool {
 		// However, if it ever starts coming from somewhere else, it'll be more
 		// efficient to change the interface of the graph.Value for LevelDB to a
 		// struct with a flag for isValid, to save another random read.
-		return true
+		return graph.ContainsLogOut(it, v, true)
 	}
-	return false
+	return graph.ContainsLogOut(it, v, false)
 }
 
 func (it *Iterator) Size() (int64, bool) {
-	return it.size, true
+	return it.size, it.pred == nil
 }
 
 func (it *Iterator) Describe() graph.Description {
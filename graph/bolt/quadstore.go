@@ -21,12 +21,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/barakmich/glog"
 	"github.com/boltdb/bolt"
 
 	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/index"
 	"github.com/google/cayley/graph/iterator"
 	"github.com/google/cayley/quad"
 )
@@ -41,6 +44,10 @@ var (
 	}
 	hashSize         = sha1.Size
 	localFillPercent = 0.7
+
+	// bulkBatchSize is the number of quads written in each transaction by
+	// BulkLoad.
+	bulkBatchSize = 100000
 )
 
 type Token struct {
@@ -92,6 +99,16 @@ func newQuadStore(path string, options graph.Options) (graph.QuadStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = qs.db.Update(qs.buildValueIndex)
+	if err != nil {
+		glog.Errorln("Error, couldn't build value index: ", err)
+		return nil, err
+	}
+	err = qs.db.Update(qs.buildPredicateStats)
+	if err != nil {
+		glog.Errorln("Error, couldn't build predicate statistics: ", err)
+		return nil, err
+	}
 	return &qs, nil
 }
 
@@ -116,10 +133,45 @@ func (qs *QuadStore) createBuckets() error {
 		if err != nil {
 			return fmt.Errorf("could not create bucket: %s", err)
 		}
+		_, err = tx.CreateBucket(valueBucket)
+		if err != nil {
+			return fmt.Errorf("could not create bucket: %s", err)
+		}
+		_, err = tx.CreateBucket(predicateBucket)
+		if err != nil {
+			return fmt.Errorf("could not create bucket: %s", err)
+		}
+		_, err = tx.CreateBucket(predicateNodeBucket)
+		if err != nil {
+			return fmt.Errorf("could not create bucket: %s", err)
+		}
 		return nil
 	})
 }
 
+// buildValueIndex creates and fills the value index of a database made
+// before there was one.
+func (qs *QuadStore) buildValueIndex(tx *bolt.Tx) error {
+	if tx.Bucket(valueBucket) != nil {
+		return nil
+	}
+	_, err := tx.CreateBucket(valueBucket)
+	if err != nil {
+		return fmt.Errorf("could not create bucket: %s", err)
+	}
+	return tx.Bucket(nodeBucket).ForEach(func(k, v []byte) error {
+		var value ValueData
+		err := json.Unmarshal(v, &value)
+		if err != nil {
+			return err
+		}
+		if value.Size <= 0 {
+			return nil
+		}
+		return qs.updateValueIndex(tx, value.Name, true)
+	})
+}
+
 func (qs *QuadStore) Size() int64 {
 	return qs.size
 }
@@ -180,15 +232,30 @@ var (
 	logBucket  = []byte("log")
 	nodeBucket = []byte("node")
 	metaBucket = []byte("meta")
+
+	// valueBucket holds the keys of the value index of live nodes; see
+	// package index.
+	valueBucket = []byte("value")
 )
 
-func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta) error {
+func (qs *QuadStore) ApplyDeltas(in []graph.Delta, opts graph.IgnoreOpts) error {
 	oldSize := qs.size
 	oldHorizon := qs.horizon
 	err := qs.db.Update(func(tx *bolt.Tx) error {
+		deltas, err := graph.CheckDeltas(in, opts, func(q quad.Quad) (bool, error) {
+			entry, err := qs.indexEntry(tx, q)
+			if err != nil {
+				return false, err
+			}
+			return len(entry.History)%2 == 1, nil
+		})
+		if err != nil {
+			return err
+		}
 		b := tx.Bucket(logBucket)
 		b.FillPercent = localFillPercent
 		resizeMap := make(map[string]int64)
+		stats := graph.NewStatsUpdate()
 		sizeChange := int64(0)
 		for _, d := range deltas {
 			bytes, err := json.Marshal(d)
@@ -215,6 +282,7 @@ func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta) error {
 			if d.Quad.Label != "" {
 				resizeMap[d.Quad.Label] += delta
 			}
+			stats.Add(d.Quad, delta)
 			sizeChange += delta
 			qs.horizon = d.ID
 		}
@@ -226,6 +294,10 @@ func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta) error {
 				}
 			}
 		}
+		err = qs.updatePredicateStats(tx, stats)
+		if err != nil {
+			return err
+		}
 		qs.size += sizeChange
 		return qs.WriteHorizonAndSize(tx)
 	})
@@ -239,18 +311,177 @@ func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta) error {
 	return nil
 }
 
-func (qs *QuadStore) buildQuadWrite(tx *bolt.Tx, q quad.Quad, id int64, isAdd bool) error {
+// BulkLoad loads quads into an empty store, without checking them one at a
+// time. Each batch of quads is written in one transaction: the deltas are
+// appended to the log, the index keys are put in order, and the sizes of the
+// nodes are summed before they are updated. Duplicate quads are skipped. If
+// loading fails partway, the store holds the batches written so far.
+func (qs *QuadStore) BulkLoad(dec quad.Unmarshaler) error {
+	if qs.size != 0 || qs.horizon != 0 {
+		return graph.ErrCannotBulkLoad
+	}
+	return graph.BulkBatches(dec, bulkBatchSize, qs.bulkWrite)
+}
+
+func (qs *QuadStore) bulkWrite(batch []quad.Quad) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	oldSize := qs.size
+	oldHorizon := qs.horizon
+	err := qs.db.Update(func(tx *bolt.Tx) error {
+		// The store was empty, so any quad already in the index came from
+		// an earlier batch.
+		spoB := tx.Bucket(spoBucket)
+		var deltas []graph.Delta
+		now := time.Now()
+		for _, q := range batch {
+			if spoB.Get(qs.createKeyFor(spo, q)) != nil {
+				continue
+			}
+			deltas = append(deltas, graph.Delta{
+				ID:        qs.horizon + int64(len(deltas)) + 1,
+				Quad:      q,
+				Action:    graph.Add,
+				Timestamp: now,
+			})
+		}
+		if len(deltas) == 0 {
+			return nil
+		}
+
+		// Delta IDs only increase, so the log is appended to in order.
+		b := tx.Bucket(logBucket)
+		b.FillPercent = 1
+		for _, d := range deltas {
+			bytes, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			err = b.Put(qs.createDeltaKeyFor(d.ID), bytes)
+			if err != nil {
+				return err
+			}
+		}
+
+		entries := make([][]byte, len(deltas))
+		resizeMap := make(map[string]int64)
+		stats := graph.NewStatsUpdate()
+		for i, d := range deltas {
+			entry, err := json.Marshal(IndexEntry{History: []int64{d.ID}})
+			if err != nil {
+				return err
+			}
+			entries[i] = entry
+			resizeMap[d.Quad.Subject]++
+			resizeMap[d.Quad.Predicate]++
+			resizeMap[d.Quad.Object]++
+			if d.Quad.Label != "" {
+				resizeMap[d.Quad.Label]++
+			}
+			stats.Add(d.Quad, 1)
+		}
+		for _, index := range [][4]quad.Direction{spo, osp, pos, cps} {
+			puts := make([]graph.KeyValue, 0, len(deltas))
+			for i, d := range deltas {
+				if index == cps && d.Quad.Label == "" {
+					continue
+				}
+				puts = append(puts, graph.KeyValue{Key: qs.createKeyFor(index, d.Quad), Value: entries[i]})
+			}
+			sort.Sort(graph.ByKey(puts))
+			b := tx.Bucket(bucketFor(index))
+			b.FillPercent = localFillPercent
+			for _, kv := range puts {
+				err := b.Put(kv.Key, kv.Value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Update each node once, in the order of their keys, then index the
+		// values of the new ones, in order too.
+		nodes := make([]graph.KeyValue, 0, len(resizeMap))
+		for name := range resizeMap {
+			nodes = append(nodes, graph.KeyValue{Key: qs.createValueKeyFor(name), Value: []byte(name)})
+		}
+		sort.Sort(graph.ByKey(nodes))
+		b = tx.Bucket(nodeBucket)
+		b.FillPercent = localFillPercent
+		var values []graph.KeyValue
+		for _, kv := range nodes {
+			value := ValueData{Name: string(kv.Value)}
+			if data := b.Get(kv.Key); data != nil {
+				err := json.Unmarshal(data, &value)
+				if err != nil {
+					glog.Errorf("Error: couldn't reconstruct value: %v", err)
+					return err
+				}
+			}
+			if value.Size <= 0 {
+				for _, key := range index.Keys(value.Name, kv.Key) {
+					values = append(values, graph.KeyValue{Key: key})
+				}
+			}
+			value.Size += resizeMap[value.Name]
+			bytes, err := json.Marshal(&value)
+			if err != nil {
+				glog.Errorf("Couldn't write to buffer for value %s: %s", value.Name, err)
+				return err
+			}
+			err = b.Put(kv.Key, bytes)
+			if err != nil {
+				return err
+			}
+		}
+		sort.Sort(graph.ByKey(values))
+		b = tx.Bucket(valueBucket)
+		b.FillPercent = localFillPercent
+		for _, kv := range values {
+			err := b.Put(kv.Key, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := qs.updatePredicateStats(tx, stats)
+		if err != nil {
+			return err
+		}
+
+		qs.size += int64(len(deltas))
+		qs.horizon = deltas[len(deltas)-1].ID
+		return qs.WriteHorizonAndSize(tx)
+	})
+	if err != nil {
+		glog.Error("Couldn't write to DB for bulk load. Error: ", err)
+		qs.horizon = oldHorizon
+		qs.size = oldSize
+		return err
+	}
+	return nil
+}
+
+// indexEntry reads the history of a quad as of tx.
+func (qs *QuadStore) indexEntry(tx *bolt.Tx, q quad.Quad) (*IndexEntry, error) {
 	var entry IndexEntry
-	b := tx.Bucket(spoBucket)
-	b.FillPercent = localFillPercent
-	data := b.Get(qs.createKeyFor(spo, q))
+	data := tx.Bucket(spoBucket).Get(qs.createKeyFor(spo, q))
 	if data != nil {
 		// We got something.
 		err := json.Unmarshal(data, &entry)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
+	return &entry, nil
+}
+
+func (qs *QuadStore) buildQuadWrite(tx *bolt.Tx, q quad.Quad, id int64, isAdd bool) error {
+	entry, err := qs.indexEntry(tx, q)
+	if err != nil {
+		return err
+	}
 
 	if isAdd && len(entry.History)%2 == 1 {
 		glog.Error("Adding a valid quad ", entry)
@@ -293,6 +524,7 @@ func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, tx *bolt.Tx) er
 	b.FillPercent = localFillPercent
 	key := qs.createValueKeyFor(name)
 	data := b.Get(key)
+	var oldSize int64
 
 	if data != nil {
 		// Node exists in the database -- unmarshal and update.
@@ -301,6 +533,7 @@ func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, tx *bolt.Tx) er
 			glog.Errorf("Error: couldn't reconstruct value: %v", err)
 			return err
 		}
+		oldSize = value.Size
 		value.Size += amount
 	}
 
@@ -309,6 +542,15 @@ func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, tx *bolt.Tx) er
 		value.Size = 0
 	}
 
+	// Index the node's value when it comes into use, and drop it when it
+	// goes out.
+	if wasLive, isLive := oldSize > 0, value.Size > 0; wasLive != isLive {
+		err := qs.updateValueIndex(tx, name, isLive)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Repackage and rewrite.
 	bytes, err := json.Marshal(&value)
 	if err != nil {
@@ -319,6 +561,23 @@ func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, tx *bolt.Tx) er
 	return err
 }
 
+func (qs *QuadStore) updateValueIndex(tx *bolt.Tx, name string, isAdd bool) error {
+	b := tx.Bucket(valueBucket)
+	b.FillPercent = localFillPercent
+	for _, key := range index.Keys(name, qs.createValueKeyFor(name)) {
+		var err error
+		if isAdd {
+			err = b.Put(key, nil)
+		} else {
+			err = b.Delete(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (qs *QuadStore) WriteHorizonAndSize(tx *bolt.Tx) error {
 	buf := new(bytes.Buffer)
 	err := binary.Write(buf, binary.LittleEndian, qs.size)
@@ -380,7 +639,10 @@ func (qs *QuadStore) Quad(k graph.Value) quad.Quad {
 			// No harm, no foul.
 			return nil
 		}
-		return json.Unmarshal(data, &q)
+		var d graph.Delta
+		err = json.Unmarshal(data, &d)
+		q = d.Quad
+		return err
 	})
 	if err != nil {
 		glog.Error("Error getting quad: ", err)
@@ -0,0 +1,201 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bolt
+
+// The statistics of each predicate are kept in the predicate bucket, under the
+// hash of the predicate, and the number of its quads at each subject and
+// object in the predicate node bucket, under the hash of the predicate, the
+// direction and the hash of the node.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/barakmich/glog"
+	"github.com/boltdb/bolt"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
+)
+
+var (
+	predicateBucket     = []byte("predicate")
+	predicateNodeBucket = []byte("predicate_node")
+)
+
+type PredicateData struct {
+	Name string
+	graph.PredicateStats
+}
+
+func (qs *QuadStore) createPredicateNodeKeyFor(n graph.PredicateNode) []byte {
+	key := make([]byte, 0, 1+(hashSize*2))
+	key = append(key, hashOf(n.Predicate)...)
+	key = append(key, n.Dir.Prefix())
+	key = append(key, hashOf(n.Node)...)
+	return key
+}
+
+func predicateData(tx *bolt.Tx, key []byte) (PredicateData, error) {
+	var out PredicateData
+	data := tx.Bucket(predicateBucket).Get(key)
+	if data == nil {
+		return out, nil
+	}
+	err := json.Unmarshal(data, &out)
+	if err != nil {
+		glog.Errorf("Error: couldn't reconstruct predicate statistics: %v", err)
+	}
+	return out, err
+}
+
+// PredicateStats returns the statistics of a predicate.
+func (qs *QuadStore) PredicateStats(v graph.Value) (graph.PredicateStats, bool) {
+	if v == nil {
+		return graph.PredicateStats{}, false
+	}
+	var data PredicateData
+	err := qs.db.View(func(tx *bolt.Tx) error {
+		var err error
+		data, err = predicateData(tx, v.(*Token).key)
+		return err
+	})
+	if err != nil || data.Quads <= 0 {
+		return graph.PredicateStats{}, false
+	}
+	return data.PredicateStats, true
+}
+
+// AllPredicateStats returns the statistics of every predicate in use.
+func (qs *QuadStore) AllPredicateStats() (map[string]graph.PredicateStats, error) {
+	out := make(map[string]graph.PredicateStats)
+	err := qs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(predicateBucket).ForEach(func(k, v []byte) error {
+			var data PredicateData
+			err := json.Unmarshal(v, &data)
+			if err != nil {
+				return err
+			}
+			out[data.Name] = data.PredicateStats
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// updatePredicateStats writes the changes of a StatsUpdate.
+func (qs *QuadStore) updatePredicateStats(tx *bolt.Tx, u *graph.StatsUpdate) error {
+	nodes := tx.Bucket(predicateNodeBucket)
+	nodes.FillPercent = localFillPercent
+	stats, err := u.Apply(func(pred string) (graph.PredicateStats, error) {
+		data, err := predicateData(tx, qs.createValueKeyFor(pred))
+		return data.PredicateStats, err
+	}, func(n graph.PredicateNode, change int64) (int64, int64, error) {
+		key := qs.createPredicateNodeKeyFor(n)
+		var old int64
+		if data := nodes.Get(key); data != nil {
+			err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &old)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		now := old + change
+		if now <= 0 {
+			return old, now, nodes.Delete(key)
+		}
+		buf := new(bytes.Buffer)
+		err := binary.Write(buf, binary.LittleEndian, now)
+		if err != nil {
+			return 0, 0, err
+		}
+		return old, now, nodes.Put(key, buf.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+	b := tx.Bucket(predicateBucket)
+	b.FillPercent = localFillPercent
+	for pred, s := range stats {
+		key := qs.createValueKeyFor(pred)
+		if s.Quads <= 0 {
+			err = b.Delete(key)
+		} else {
+			var data []byte
+			data, err = json.Marshal(PredicateData{Name: pred, PredicateStats: s})
+			if err == nil {
+				err = b.Put(key, data)
+			}
+		}
+		if err != nil {
+			glog.Errorf("Couldn't write statistics for predicate %s: %s", pred, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// buildPredicateStats creates and fills the predicate statistics of a
+// database made before there were any.
+func (qs *QuadStore) buildPredicateStats(tx *bolt.Tx) error {
+	if tx.Bucket(predicateBucket) != nil {
+		return nil
+	}
+	for _, name := range [][]byte{predicateBucket, predicateNodeBucket} {
+		_, err := tx.CreateBucket(name)
+		if err != nil {
+			return fmt.Errorf("could not create bucket: %s", err)
+		}
+	}
+	u := graph.NewStatsUpdate()
+	log := tx.Bucket(logBucket)
+	err := tx.Bucket(posBucket).ForEach(func(k, v []byte) error {
+		var entry IndexEntry
+		err := json.Unmarshal(v, &entry)
+		if err != nil {
+			return err
+		}
+		if len(entry.History)%2 == 0 {
+			return nil
+		}
+		var d graph.Delta
+		err = json.Unmarshal(log.Get(qs.createDeltaKeyFor(entry.History[len(entry.History)-1])), &d)
+		if err != nil {
+			return err
+		}
+		u.Add(d.Quad, 1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return qs.updatePredicateStats(tx, u)
+}
+
+// sizeIn returns how many quads have a node in a direction. The size of a
+// node counts its quads in every direction, so the statistics of a predicate
+// are better, if there are any.
+func (qs *QuadStore) sizeIn(d quad.Direction, v graph.Value) int64 {
+	if d == quad.Predicate {
+		if stats, ok := qs.PredicateStats(v); ok {
+			return stats.Quads
+		}
+	}
+	return qs.SizeOf(v)
+}
@@ -0,0 +1,229 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bolt
+
+import (
+	"bytes"
+
+	"github.com/barakmich/glog"
+	"github.com/boltdb/bolt"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/index"
+	"github.com/google/cayley/graph/iterator"
+)
+
+var boltValueType graph.Type
+
+func init() {
+	boltValueType = graph.RegisterIterator("bolt_value")
+}
+
+// ValueIterator returns the nodes whose values satisfy a comparison, by
+// scanning a range of the value index.
+type ValueIterator struct {
+	uid    uint64
+	tags   graph.Tagger
+	op     iterator.Operator
+	val    interface{}
+	r      index.Range
+	qs     *QuadStore
+	result graph.Value
+	buffer [][]byte
+	offset int
+	done   bool
+	size   int64
+}
+
+// NewValueIterator returns an iterator over the nodes whose values satisfy op
+// against val, or false if val cannot be looked up in the value index.
+func NewValueIterator(op iterator.Operator, val interface{}, qs *QuadStore) (*ValueIterator, bool) {
+	r, ok := index.NewRange(op, val)
+	if !ok {
+		return nil, false
+	}
+	return &ValueIterator{
+		uid:  iterator.NextUID(),
+		op:   op,
+		val:  val,
+		r:    r,
+		qs:   qs,
+		size: -1,
+	}, true
+}
+
+func (it *ValueIterator) UID() uint64 {
+	return it.uid
+}
+
+func (it *ValueIterator) Reset() {
+	it.buffer = nil
+	it.offset = 0
+	it.done = false
+	it.result = nil
+}
+
+func (it *ValueIterator) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *ValueIterator) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+}
+
+func (it *ValueIterator) Clone() graph.Iterator {
+	out, _ := NewValueIterator(it.op, it.val, it.qs)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *ValueIterator) Next() bool {
+	graph.NextLogIn(it)
+	if it.done {
+		return graph.NextLogOut(it, nil, false)
+	}
+	it.offset++
+	if it.offset >= len(it.buffer) {
+		start := it.r.Start
+		if len(it.buffer) != 0 {
+			start = it.buffer[len(it.buffer)-1]
+		}
+		err := it.fill(start, len(it.buffer) != 0)
+		if err != nil {
+			glog.Error("Error nexting in database: ", err)
+		}
+		if err != nil || len(it.buffer) == 0 {
+			it.done = true
+			it.result = nil
+			return graph.NextLogOut(it, nil, false)
+		}
+	}
+	key := it.buffer[it.offset]
+	it.result = &Token{bucket: nodeBucket, key: key[len(key)-hashSize:]}
+	return graph.NextLogOut(it, it.result, true)
+}
+
+// fill reads the next keys of the range from start into the buffer,
+// skipping start itself if it has been returned already.
+func (it *ValueIterator) fill(start []byte, skip bool) error {
+	it.buffer = it.buffer[:0]
+	it.offset = 0
+	return it.qs.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(valueBucket).Cursor()
+		k, _ := cur.Seek(start)
+		if skip && bytes.Equal(k, start) {
+			k, _ = cur.Next()
+		}
+		for ; k != nil && len(it.buffer) < bufferSize; k, _ = cur.Next() {
+			if bytes.Compare(k, it.r.Limit) >= 0 {
+				break
+			}
+			out := make([]byte, len(k))
+			copy(out, k)
+			it.buffer = append(it.buffer, out)
+		}
+		return nil
+	})
+}
+
+func (it *ValueIterator) ResultTree() *graph.ResultTree {
+	return graph.NewResultTree(it.Result())
+}
+
+func (it *ValueIterator) Result() graph.Value {
+	return it.result
+}
+
+func (it *ValueIterator) NextPath() bool {
+	return false
+}
+
+// No subiterators.
+func (it *ValueIterator) SubIterators() []graph.Iterator {
+	return nil
+}
+
+func (it *ValueIterator) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
+	tok := v.(*Token)
+	if !bytes.Equal(tok.bucket, nodeBucket) {
+		return graph.ContainsLogOut(it, v, false)
+	}
+	value := it.qs.valueData(tok)
+	if value.Size <= 0 || !it.r.Matches(value.Name) {
+		return graph.ContainsLogOut(it, v, false)
+	}
+	it.result = v
+	return graph.ContainsLogOut(it, v, true)
+}
+
+func (it *ValueIterator) Close() {
+	it.result = nil
+	it.buffer = nil
+	it.done = true
+}
+
+// Size counts the keys in the range the first time it is called.
+func (it *ValueIterator) Size() (int64, bool) {
+	if it.size >= 0 {
+		return it.size, true
+	}
+	var n int64
+	err := it.qs.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(valueBucket).Cursor()
+		for k, _ := cur.Seek(it.r.Start); k != nil && bytes.Compare(k, it.r.Limit) < 0; k, _ = cur.Next() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Error("Error sizing value range: ", err)
+		return it.qs.size, false
+	}
+	it.size = n
+	return n, true
+}
+
+func (it *ValueIterator) Describe() graph.Description {
+	size, _ := it.Size()
+	return graph.Description{
+		UID:  it.UID(),
+		Type: it.Type(),
+		Tags: it.tags.Tags(),
+		Size: size,
+	}
+}
+
+func (it *ValueIterator) Type() graph.Type { return boltValueType }
+func (it *ValueIterator) Sorted() bool     { return false }
+
+func (it *ValueIterator) Optimize() (graph.Iterator, bool) {
+	return it, false
+}
+
+func (it *ValueIterator) Stats() graph.IteratorStats {
+	s, _ := it.Size()
+	return graph.IteratorStats{
+		ContainsCost: 1,
+		NextCost:     2,
+		Size:         s,
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+// Helpers for the BulkLoad of key-value stores, which write quads in large
+// batches with their keys in order.
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/google/cayley/quad"
+)
+
+// BulkBatches reads quads until the end of dec, passing them to write in
+// batches of up to size. Invalid quads, and quads already in the same batch,
+// are skipped; a store must skip those in earlier batches itself.
+func BulkBatches(dec quad.Unmarshaler, size int, write func([]quad.Quad) error) error {
+	for {
+		batch := make([]quad.Quad, 0, size)
+		seen := make(map[quad.Quad]bool)
+		var done bool
+		for len(batch) < size {
+			q, err := dec.Unmarshal()
+			if err != nil {
+				if err == io.EOF {
+					done = true
+					break
+				}
+				return err
+			}
+			if !q.IsValid() || seen[q] {
+				continue
+			}
+			seen[q] = true
+			batch = append(batch, q)
+		}
+		if err := write(batch); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// KeyValue is a key to put, with its value.
+type KeyValue struct {
+	Key, Value []byte
+}
+
+// ByKey sorts KeyValues in the order of their keys.
+type ByKey []KeyValue
+
+func (kv ByKey) Len() int           { return len(kv) }
+func (kv ByKey) Less(i, j int) bool { return bytes.Compare(kv[i].Key, kv[j].Key) < 0 }
+func (kv ByKey) Swap(i, j int)      { kv[i], kv[j] = kv[j], kv[i] }
@@ -0,0 +1,310 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+// A meta-store: a QuadStore that wraps another registered QuadStore and
+// keeps what it reads from it in memory. Node names and values, and the
+// quads linked to a node in each direction, are kept in bounded LRU caches.
+// Everything else goes straight through to the underlying store.
+//
+// Writes go to the underlying store, and drop the cached entries for every
+// node they touch.
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/barakmich/glog"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/graph/lru"
+	"github.com/google/cayley/quad"
+)
+
+func init() {
+	graph.RegisterQuadStore("cache", true, newQuadStore, initQuadStore)
+}
+
+const (
+	// DefaultSize is the default number of entries in each cache.
+	DefaultSize = 1 << 16
+
+	// DefaultIteratorLimit is the default number of quads a QuadIterator may
+	// return and still be cached.
+	DefaultIteratorLimit = 1 << 10
+)
+
+// Stats counts the lookups answered from the caches, and those that had to
+// go to the underlying store.
+type Stats struct {
+	NameHits       int64
+	NameMisses     int64
+	ValueHits      int64
+	ValueMisses    int64
+	IteratorHits   int64
+	IteratorMisses int64
+}
+
+type QuadStore struct {
+	graph.QuadStore
+
+	mu     sync.Mutex
+	names  *lru.Cache
+	values *lru.Cache
+	quads  *lru.Cache
+	size   int
+	limit  int64
+	stats  Stats
+
+	// gen counts writes, so that quads read from the underlying store
+	// during a write are not cached.
+	gen uint64
+}
+
+// quadKey identifies the quads linked to a node in one direction.
+type quadKey struct {
+	dir quad.Direction
+	val interface{}
+}
+
+func backend(options graph.Options) (string, error) {
+	name, ok := options.StringKey("cache_backend")
+	if !ok {
+		return "", fmt.Errorf("cache: the cache_backend option is required")
+	}
+	if name == "cache" {
+		return "", fmt.Errorf("cache: cannot cache a cache")
+	}
+	return name, nil
+}
+
+func initQuadStore(path string, options graph.Options) error {
+	name, err := backend(options)
+	if err != nil {
+		return err
+	}
+	return graph.InitQuadStore(name, path, options)
+}
+
+func newQuadStore(path string, options graph.Options) (graph.QuadStore, error) {
+	name, err := backend(options)
+	if err != nil {
+		return nil, err
+	}
+	qs, err := graph.NewQuadStore(name, path, options)
+	if err != nil {
+		return nil, err
+	}
+	size := DefaultSize
+	if val, ok := options.IntKey("cache_size"); ok {
+		size = val
+	}
+	limit := DefaultIteratorLimit
+	if val, ok := options.IntKey("cache_iterator_limit"); ok {
+		limit = val
+	}
+	return New(qs, size, limit), nil
+}
+
+// New wraps qs in a cache holding up to size entries of each kind. Only
+// QuadIterators of up to limit quads are cached.
+func New(qs graph.QuadStore, size, limit int) *QuadStore {
+	return &QuadStore{
+		QuadStore: qs,
+		names:     lru.New(size),
+		values:    lru.New(size),
+		quads:     lru.New(size),
+		size:      size,
+		limit:     int64(limit),
+	}
+}
+
+// key returns a comparable key for a Value.
+func key(v graph.Value) interface{} {
+	if k, ok := v.(iterator.Keyer); ok {
+		return k.Key()
+	}
+	return v
+}
+
+func equal(a, b graph.Value) bool {
+	return key(a) == key(b)
+}
+
+// Stats returns the hit and miss counts so far.
+func (qs *QuadStore) Stats() Stats {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	return qs.stats
+}
+
+func (qs *QuadStore) ApplyDeltas(in []graph.Delta, opts graph.IgnoreOpts) error {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	// Drop what is known about every node in the deltas, both before the
+	// write, in case the underlying store changes their values, and after.
+	qs.invalidate(in)
+	err := qs.QuadStore.ApplyDeltas(in, opts)
+	qs.invalidate(in)
+	qs.gen++
+	return err
+}
+
+// BulkLoad loads quads with the underlying store's BulkLoad, if it has one,
+// and then empties the caches.
+func (qs *QuadStore) BulkLoad(dec quad.Unmarshaler) error {
+	bl, ok := qs.QuadStore.(graph.BulkLoader)
+	if !ok {
+		return graph.ErrCannotBulkLoad
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	err := bl.BulkLoad(dec)
+	qs.names = lru.New(qs.size)
+	qs.values = lru.New(qs.size)
+	qs.quads = lru.New(qs.size)
+	qs.gen++
+	return err
+}
+
+// PredicateStats returns the underlying store's statistics of a predicate,
+// if it keeps any.
+func (qs *QuadStore) PredicateStats(v graph.Value) (graph.PredicateStats, bool) {
+	ss, ok := qs.QuadStore.(graph.StatsStore)
+	if !ok {
+		return graph.PredicateStats{}, false
+	}
+	return ss.PredicateStats(v)
+}
+
+// AllPredicateStats returns the underlying store's statistics of every
+// predicate, if it keeps any.
+func (qs *QuadStore) AllPredicateStats() (map[string]graph.PredicateStats, error) {
+	ss, ok := qs.QuadStore.(graph.StatsStore)
+	if !ok {
+		return nil, graph.ErrNoStats
+	}
+	return ss.AllPredicateStats()
+}
+
+func (qs *QuadStore) invalidate(in []graph.Delta) {
+	for _, d := range in {
+		for _, dir := range []quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label} {
+			name := d.Quad.Get(dir)
+			if name == "" {
+				continue
+			}
+			if v, ok := qs.values.Get(name); ok {
+				qs.forget(v)
+				qs.values.Remove(name)
+			}
+			qs.forget(qs.QuadStore.ValueOf(name))
+		}
+	}
+}
+
+func (qs *QuadStore) forget(v graph.Value) {
+	k := key(v)
+	qs.names.Remove(k)
+	for _, dir := range []quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label} {
+		qs.quads.Remove(quadKey{dir: dir, val: k})
+	}
+}
+
+func (qs *QuadStore) ValueOf(name string) graph.Value {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	if v, ok := qs.values.Get(name); ok {
+		qs.stats.ValueHits++
+		return v
+	}
+	qs.stats.ValueMisses++
+	v := qs.QuadStore.ValueOf(name)
+	qs.values.Put(name, v)
+	return v
+}
+
+func (qs *QuadStore) NameOf(v graph.Value) string {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	k := key(v)
+	if name, ok := qs.names.Get(k); ok {
+		qs.stats.NameHits++
+		return name.(string)
+	}
+	qs.stats.NameMisses++
+	name := qs.QuadStore.NameOf(v)
+	qs.names.Put(k, name)
+	return name
+}
+
+// QuadIterator returns a Fixed iterator over the quads when they are cached,
+// or can be. Larger sets are left to the underlying store.
+func (qs *QuadStore) QuadIterator(d quad.Direction, val graph.Value) graph.Iterator {
+	qk := quadKey{dir: d, val: key(val)}
+	qs.mu.Lock()
+	gen := qs.gen
+	cached, ok := qs.quads.Get(qk)
+	if ok {
+		qs.stats.IteratorHits++
+	} else {
+		qs.stats.IteratorMisses++
+	}
+	qs.mu.Unlock()
+	if ok {
+		return fixedOf(cached.([]graph.Value))
+	}
+
+	it := qs.QuadStore.QuadIterator(d, val)
+	size, exact := it.Size()
+	if !exact || size > qs.limit {
+		return it
+	}
+	var quads []graph.Value
+	for graph.Next(it) {
+		quads = append(quads, it.Result())
+	}
+	it.Close()
+	if glog.V(2) {
+		glog.V(2).Infof("Caching %d quads for %v %v", len(quads), d, val)
+	}
+	qs.mu.Lock()
+	if qs.gen == gen {
+		qs.quads.Put(qk, quads)
+	}
+	qs.mu.Unlock()
+	return fixedOf(quads)
+}
+
+func fixedOf(vals []graph.Value) graph.Iterator {
+	it := iterator.NewFixed(equal)
+	for _, v := range vals {
+		it.Add(v)
+	}
+	return it
+}
+
+func (qs *QuadStore) FixedIterator() graph.FixedIterator {
+	return iterator.NewFixed(equal)
+}
+
+// Close logs the final statistics and closes the underlying store.
+func (qs *QuadStore) Close() {
+	if glog.V(1) {
+		glog.V(1).Infof("Cache statistics: %+v", qs.Stats())
+	}
+	qs.QuadStore.Close()
+}
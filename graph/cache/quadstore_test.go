@@ -0,0 +1,161 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	_ "github.com/google/cayley/graph/memstore"
+	"github.com/google/cayley/quad"
+	"github.com/google/cayley/writer"
+)
+
+var simpleGraph = []quad.Quad{
+	{"A", "follows", "B", ""},
+	{"C", "follows", "B", ""},
+	{"C", "follows", "D", ""},
+	{"D", "follows", "B", ""},
+	{"B", "follows", "F", ""},
+	{"F", "follows", "G", ""},
+	{"D", "follows", "G", ""},
+	{"E", "follows", "F", ""},
+	{"B", "status", "cool", "status_graph"},
+	{"D", "status", "cool", "status_graph"},
+	{"G", "status", "cool", "status_graph"},
+}
+
+func makeTestStore(t *testing.T, opts graph.Options) (*QuadStore, graph.QuadWriter) {
+	if opts == nil {
+		opts = graph.Options{}
+	}
+	opts["cache_backend"] = "memstore"
+	qs, err := graph.NewQuadStore("cache", "", opts)
+	if err != nil {
+		t.Fatalf("Failed to create cache QuadStore: %v", err)
+	}
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(simpleGraph)
+	return qs.(*QuadStore), w
+}
+
+func iteratedQuads(qs graph.QuadStore, it graph.Iterator) []string {
+	var res []string
+	for graph.Next(it) {
+		res = append(res, qs.Quad(it.Result()).String())
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestNames(t *testing.T) {
+	qs, _ := makeTestStore(t, nil)
+	for i := 0; i < 2; i++ {
+		for _, name := range []string{"A", "follows", "status_graph"} {
+			if got := qs.NameOf(qs.ValueOf(name)); got != name {
+				t.Errorf("Failed to roundtrip %q, got:%q", name, got)
+			}
+		}
+	}
+	// The writer has looked up nothing; the first round missed and the
+	// second hit.
+	expect := Stats{NameHits: 3, NameMisses: 3, ValueHits: 3, ValueMisses: 3}
+	if got := qs.Stats(); got != expect {
+		t.Errorf("Unexpected statistics, got:%+v expect:%+v", got, expect)
+	}
+}
+
+func TestQuadIterator(t *testing.T) {
+	qs, w := makeTestStore(t, nil)
+	expect := []string{
+		quad.Quad{"C", "follows", "B", ""}.String(),
+		quad.Quad{"C", "follows", "D", ""}.String(),
+	}
+	for i := 0; i < 2; i++ {
+		it := qs.QuadIterator(quad.Subject, qs.ValueOf("C"))
+		if got := iteratedQuads(qs, it); !reflect.DeepEqual(got, expect) {
+			t.Errorf("Unexpected quads, got:%v expect:%v", got, expect)
+		}
+	}
+	if s := qs.Stats(); s.IteratorHits != 1 || s.IteratorMisses != 1 {
+		t.Errorf("Unexpected statistics, got:%+v expect one hit and one miss", s)
+	}
+
+	// Writes drop the cached quads of the nodes they touch.
+	w.AddQuad(quad.Quad{"C", "follows", "G", ""})
+	expect = append(expect, quad.Quad{"C", "follows", "G", ""}.String())
+	it := qs.QuadIterator(quad.Subject, qs.ValueOf("C"))
+	if got := iteratedQuads(qs, it); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected quads after a write, got:%v expect:%v", got, expect)
+	}
+	if s := qs.Stats(); s.IteratorMisses != 2 {
+		t.Errorf("Unexpected statistics, got:%+v expect two misses", s)
+	}
+
+	// So do writes of new nodes.
+	qs.ValueOf("H")
+	w.AddQuad(quad.Quad{"H", "follows", "A", ""})
+	it = qs.QuadIterator(quad.Subject, qs.ValueOf("H"))
+	if got := iteratedQuads(qs, it); len(got) != 1 {
+		t.Errorf("Unexpected quads for a new node, got:%v", got)
+	}
+}
+
+func TestIteratorLimit(t *testing.T) {
+	qs, _ := makeTestStore(t, graph.Options{"cache_iterator_limit": float64(2)})
+	for i := 0; i < 2; i++ {
+		it := qs.QuadIterator(quad.Object, qs.ValueOf("B"))
+		if it.Type() == graph.Fixed {
+			t.Errorf("Unexpectedly cached %d quads", len(iteratedQuads(qs, it)))
+		}
+		if got := iteratedQuads(qs, it); len(got) != 3 {
+			t.Errorf("Unexpected quads, got:%v", got)
+		}
+	}
+	if s := qs.Stats(); s.IteratorHits != 0 {
+		t.Errorf("Unexpected statistics, got:%+v expect no hits", s)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	qs, _ := makeTestStore(t, nil)
+
+	fixed := qs.FixedIterator()
+	fixed.Add(qs.ValueOf("F"))
+	fixed.Tagger().Add("internal")
+	lto := iterator.NewLinksTo(qs, fixed, quad.Object)
+
+	oldIt := lto.Clone()
+	newIt, ok := lto.Optimize()
+	if !ok || newIt.Type() != graph.Fixed {
+		t.Fatalf("Failed to optimize iterator, got:%v", newIt.Describe())
+	}
+	newQuads := iteratedQuads(qs, newIt)
+	oldQuads := iteratedQuads(qs, oldIt)
+	if !reflect.DeepEqual(newQuads, oldQuads) {
+		t.Errorf("Optimized iteration does not match original, got:%v expect:%v", newQuads, oldQuads)
+	}
+
+	newIt.Reset()
+	graph.Next(newIt)
+	results := make(map[string]graph.Value)
+	newIt.TagResults(results)
+	if qs.NameOf(results["internal"]) != "F" {
+		t.Errorf("Unexpected tag results, got:%v", results)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+// Explains optimized iterator trees, and profiles them as they run.
+//
+// A profile counts the calls made to each iterator of a tree, and the time
+// they take, through the logging functions the iterators call on the way in
+// and out of Next and Contains.
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/cayley/quad"
+)
+
+// Explanation describes an iterator, what the optimizer expects of it and,
+// once it has been profiled, what it did.
+type Explanation struct {
+	UID          uint64
+	Type         string
+	Name         string   `json:",omitempty"`
+	Tags         []string `json:",omitempty"`
+	Direction    string   `json:",omitempty"`
+	ContainsCost int64
+	NextCost     int64
+	Size         int64
+	Profile      *IteratorProfile `json:",omitempty"`
+	SubIts       []Explanation    `json:",omitempty"`
+}
+
+// Explain returns the explanation of an iterator tree.
+func Explain(it Iterator) Explanation {
+	desc := it.Describe()
+	stats := it.Stats()
+	out := Explanation{
+		UID:          it.UID(),
+		Type:         it.Type().String(),
+		Name:         desc.Name,
+		Tags:         desc.Tags,
+		ContainsCost: stats.ContainsCost,
+		NextCost:     stats.NextCost,
+		Size:         stats.Size,
+	}
+	if desc.Direction != quad.Any {
+		out.Direction = desc.Direction.String()
+	}
+	for _, sub := range it.SubIterators() {
+		out.SubIts = append(out.SubIts, Explain(sub))
+	}
+	return out
+}
+
+// IteratorProfile is what an iterator did while it was profiled.
+type IteratorProfile struct {
+	Next      int64         // The calls to Next.
+	Results   int64         // The calls to Next which found a result.
+	Contains  int64         // The calls to Contains.
+	Contained int64         // The calls to Contains which found the value.
+	Time      time.Duration // The time spent in both, with their subiterators.
+}
+
+// counters are the counts of a profiled iterator as it runs. They are only
+// touched atomically, so a profile can be read while its tree runs.
+type counters struct {
+	next, results, contains, contained int64
+	time                               int64 // In nanoseconds.
+
+	depth int32 // The calls which haven't returned, as some call themselves.
+	start int64 // When the outermost call began, in Unix nanoseconds.
+}
+
+// Profile is a running profile of an iterator tree.
+type Profile struct {
+	its map[uint64]*counters
+}
+
+// profiles holds the counters of every iterator being profiled, by UID. The
+// map is never changed once stored: starting or stopping a profile stores a
+// new one, so that iterators find their counters without taking a lock.
+var profiles struct {
+	sync.Mutex // Held while replacing the map.
+	its        atomic.Value
+}
+
+// updateProfiles replaces the counters being profiled with a copy changed
+// by f.
+func updateProfiles(f func(map[uint64]*counters)) {
+	profiles.Lock()
+	defer profiles.Unlock()
+	its := make(map[uint64]*counters)
+	if old, ok := profiles.its.Load().(map[uint64]*counters); ok {
+		for uid, c := range old {
+			its[uid] = c
+		}
+	}
+	f(its)
+	profiles.its.Store(its)
+}
+
+// StartProfile starts profiling each iterator in a tree. It must be stopped
+// once the tree has run.
+func StartProfile(it Iterator) *Profile {
+	p := &Profile{its: make(map[uint64]*counters)}
+	var add func(Iterator)
+	add = func(it Iterator) {
+		p.its[it.UID()] = &counters{}
+		for _, sub := range it.SubIterators() {
+			add(sub)
+		}
+	}
+	add(it)
+
+	updateProfiles(func(its map[uint64]*counters) {
+		for uid, c := range p.its {
+			its[uid] = c
+		}
+	})
+	return p
+}
+
+// Stop stops the profile.
+func (p *Profile) Stop() {
+	updateProfiles(func(its map[uint64]*counters) {
+		for uid := range p.its {
+			delete(its, uid)
+		}
+	})
+}
+
+// Fill adds the profile of each iterator to its explanation.
+func (p *Profile) Fill(e *Explanation) {
+	if c, ok := p.its[e.UID]; ok {
+		e.Profile = &IteratorProfile{
+			Next:      atomic.LoadInt64(&c.next),
+			Results:   atomic.LoadInt64(&c.results),
+			Contains:  atomic.LoadInt64(&c.contains),
+			Contained: atomic.LoadInt64(&c.contained),
+			Time:      time.Duration(atomic.LoadInt64(&c.time)),
+		}
+	}
+	for i := range e.SubIts {
+		p.Fill(&e.SubIts[i])
+	}
+}
+
+// countersOf returns the counters of an iterator, if it is being profiled.
+func countersOf(it Iterator) *counters {
+	its, _ := profiles.its.Load().(map[uint64]*counters)
+	if len(its) == 0 {
+		return nil
+	}
+	return its[it.UID()]
+}
+
+// profileIn notes a call to an iterator, if it is being profiled.
+func profileIn(it Iterator) {
+	c := countersOf(it)
+	if c == nil {
+		return
+	}
+	if atomic.AddInt32(&c.depth, 1) == 1 {
+		atomic.StoreInt64(&c.start, time.Now().UnixNano())
+	}
+}
+
+// profileOut notes the return from a call to an iterator, if it is being
+// profiled.
+func profileOut(it Iterator, contains, ok bool) {
+	c := countersOf(it)
+	if c == nil || atomic.LoadInt32(&c.depth) == 0 {
+		return
+	}
+	if atomic.AddInt32(&c.depth, -1) > 0 {
+		return
+	}
+	atomic.AddInt64(&c.time, time.Now().UnixNano()-atomic.LoadInt64(&c.start))
+	if contains {
+		atomic.AddInt64(&c.contains, 1)
+		if ok {
+			atomic.AddInt64(&c.contained, 1)
+		}
+	} else {
+		atomic.AddInt64(&c.next, 1)
+		if ok {
+			atomic.AddInt64(&c.results, 1)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package index encodes node names as keys that sort in the order of the
+// values they hold, so that key-value stores can answer the comparisons of
+// iterator.Comparison with a range scan instead of a filter over every node.
+//
+// A name is indexed once for each kind of value it parses as, in the same way
+// iterator.Comparison parses it: as an integer, a float, an RFC3339 time, and
+// always as a string. Each key is a kind byte, the encoded value, then a
+// suffix, usually the hash of the name, that keeps keys unique.
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/cayley/graph/iterator"
+)
+
+// The kind bytes leading each key.
+const (
+	Int    = 'i'
+	Float  = 'f'
+	Time   = 't'
+	String = 's'
+)
+
+// Keys returns the index keys of a node name, each ending in suffix.
+func Keys(name string, suffix []byte) [][]byte {
+	var keys [][]byte
+	if i, err := strconv.ParseInt(name, 10, 64); err == nil {
+		keys = append(keys, appendInt([]byte{Int}, i))
+	}
+	if f, err := strconv.ParseFloat(name, 64); err == nil && !math.IsNaN(f) {
+		keys = append(keys, appendFloat([]byte{Float}, f))
+	}
+	if t, err := time.Parse(time.RFC3339, name); err == nil {
+		keys = append(keys, appendTime([]byte{Time}, t))
+	}
+	keys = append(keys, appendString([]byte{String}, name))
+	for i := range keys {
+		keys[i] = append(keys[i], suffix...)
+	}
+	return keys
+}
+
+// Range is the set of keys from Start up to, but not including, Limit.
+type Range struct {
+	Start []byte
+	Limit []byte
+}
+
+// NewRange returns the range of keys of the names that satisfy op against
+// val. It returns false if val is not of an indexed kind.
+func NewRange(op iterator.Operator, val interface{}) (Range, bool) {
+	var kind byte
+	var enc []byte
+	switch v := val.(type) {
+	case int:
+		kind, enc = Int, appendInt([]byte{Int}, int64(v))
+	case int64:
+		kind, enc = Int, appendInt([]byte{Int}, v)
+	case float64:
+		if math.IsNaN(v) {
+			return Range{}, false
+		}
+		kind, enc = Float, appendFloat([]byte{Float}, v)
+	case time.Time:
+		kind, enc = Time, appendTime([]byte{Time}, v)
+	case string:
+		kind, enc = String, appendString([]byte{String}, v)
+	default:
+		return Range{}, false
+	}
+	first, last := []byte{kind}, []byte{kind + 1}
+	// Every key for a value starts with its encoding, and no encoding is a
+	// prefix of another, so the keys equal to val are those with the prefix.
+	switch op {
+	case iterator.CompareLT:
+		return Range{first, enc}, true
+	case iterator.CompareLTE:
+		return Range{first, successor(enc)}, true
+	case iterator.CompareGT:
+		return Range{successor(enc), last}, true
+	case iterator.CompareGTE:
+		return Range{enc, last}, true
+	case iterator.ComparePrefix:
+		if kind != String {
+			return Range{}, false
+		}
+		// Drop the terminator, so that longer strings match too.
+		prefix := enc[:len(enc)-2]
+		return Range{prefix, successor(prefix)}, true
+	}
+	return Range{}, false
+}
+
+// Contains returns whether key is within the range.
+func (r Range) Contains(key []byte) bool {
+	return bytes.Compare(key, r.Start) >= 0 && bytes.Compare(key, r.Limit) < 0
+}
+
+// Matches returns whether the name has a key within the range.
+func (r Range) Matches(name string) bool {
+	for _, key := range Keys(name, nil) {
+		if r.Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// successor returns the first key after every key with the prefix p.
+func successor(p []byte) []byte {
+	out := make([]byte, len(p))
+	copy(out, p)
+	for i := len(out) - 1; i >= 0; i-- {
+		if out[i] != 0xff {
+			out[i]++
+			return out[:i+1]
+		}
+	}
+	// All keys of a kind are below the next kind byte, so this is unreachable
+	// for the prefixes built here.
+	panic("index: no successor")
+}
+
+// appendInt appends i, with the sign bit flipped so that negative numbers
+// sort first.
+func appendInt(b []byte, i int64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(i)^(1<<63))
+	return append(b, buf[:]...)
+}
+
+// appendFloat appends the bits of f, flipped so that the bytes sort in the
+// order of the numbers.
+func appendFloat(b []byte, f float64) []byte {
+	if f == 0 {
+		// Negative zero is equal to zero.
+		f = 0
+	}
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], bits)
+	return append(b, buf[:]...)
+}
+
+// appendTime appends the instant of t, as seconds and nanoseconds.
+func appendTime(b []byte, t time.Time) []byte {
+	b = appendInt(b, t.Unix())
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(t.Nanosecond()))
+	return append(b, buf[:]...)
+}
+
+// appendString appends s with its zero bytes escaped as 0x00 0xff, then the
+// terminator 0x00 0x01. The result sorts in the order of the strings, and is
+// never a prefix of another.
+func appendString(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 {
+			b = append(b, 0, 0xff)
+		} else {
+			b = append(b, s[i])
+		}
+	}
+	return append(b, 0, 1)
+}
@@ -0,0 +1,168 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/cayley/graph/iterator"
+)
+
+var names = []string{
+	"-10", "-2.5", "-0", "0", "1", "2", "2.5", "10", "1e3",
+	"2014-06-01T00:00:00Z", "2014-06-01T02:00:00+03:00", "2015-01-01T00:00:00.5Z",
+	"", "a", "a\x00", "a\x00b", "ab", "abc", "b", "\xff",
+}
+
+func mustTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+var rangeTests = []struct {
+	message string
+	op      iterator.Operator
+	val     interface{}
+	expect  []string
+}{
+	{
+		message: "select integers less than 2",
+		op:      iterator.CompareLT,
+		val:     int64(2),
+		expect:  []string{"-0", "-10", "0", "1"},
+	},
+	{
+		message: "select integers less than or equal to 2",
+		op:      iterator.CompareLTE,
+		val:     2,
+		expect:  []string{"-0", "-10", "0", "1", "2"},
+	},
+	{
+		message: "select floats greater than 0",
+		op:      iterator.CompareGT,
+		val:     float64(0),
+		expect:  []string{"1", "10", "1e3", "2", "2.5"},
+	},
+	{
+		message: "select floats greater than or equal to 0",
+		op:      iterator.CompareGTE,
+		val:     float64(0),
+		expect:  []string{"-0", "0", "1", "10", "1e3", "2", "2.5"},
+	},
+	{
+		message: "select times at or before an instant",
+		op:      iterator.CompareLTE,
+		val:     mustTime("2014-05-31T23:00:00Z"),
+		expect:  []string{"2014-06-01T02:00:00+03:00"},
+	},
+	{
+		message: "select times after an instant",
+		op:      iterator.CompareGT,
+		val:     mustTime("2015-01-01T00:00:00Z"),
+		expect:  []string{"2015-01-01T00:00:00.5Z"},
+	},
+	{
+		message: "select strings greater than a",
+		op:      iterator.CompareGT,
+		val:     "a",
+		expect: []string{
+			"a\x00", "a\x00b", "ab", "abc", "b", "\xff",
+		},
+	},
+	{
+		message: "select strings less than a\\x00b",
+		op:      iterator.CompareLT,
+		val:     "a\x00b",
+		expect: []string{
+			"", "-0", "-10", "-2.5", "0", "1", "10", "1e3", "2",
+			"2.5", "2014-06-01T00:00:00Z", "2014-06-01T02:00:00+03:00",
+			"2015-01-01T00:00:00.5Z", "a", "a\x00",
+		},
+	},
+	{
+		message: "select strings with a prefix",
+		op:      iterator.ComparePrefix,
+		val:     "ab",
+		expect:  []string{"ab", "abc"},
+	},
+	{
+		message: "select strings with a zero byte prefix",
+		op:      iterator.ComparePrefix,
+		val:     "a\x00",
+		expect:  []string{"a\x00", "a\x00b"},
+	},
+}
+
+func TestRange(t *testing.T) {
+	for _, test := range rangeTests {
+		r, ok := NewRange(test.op, test.val)
+		if !ok {
+			t.Errorf("Failed to %s, value not indexed", test.message)
+			continue
+		}
+		var got []string
+		for _, name := range names {
+			if r.Matches(name) {
+				got = append(got, name)
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s, got:%q expect:%q", test.message, got, test.expect)
+		}
+	}
+	if _, ok := NewRange(iterator.ComparePrefix, int64(1)); ok {
+		t.Errorf("Unexpectedly indexed a numeric prefix")
+	}
+}
+
+var orderTests = []struct {
+	kind  byte
+	names []string
+}{
+	{Int, []string{"-10", "-2", "0", "1", "2", "10"}},
+	{Float, []string{"-2.5", "-1e-3", "0", "1e-3", "2.5", "1e3"}},
+	{Time, []string{"2014-06-01T02:00:00+03:00", "2014-06-01T00:00:00Z", "2014-06-01T00:00:00.5Z"}},
+	{String, []string{"", "a", "a\x00", "a\x00\x00", "a\x00b", "a\x01", "ab"}},
+}
+
+func TestKeyOrder(t *testing.T) {
+	for _, test := range orderTests {
+		var prev []byte
+		for _, name := range test.names {
+			var key []byte
+			for _, k := range Keys(name, []byte{0xff}) {
+				if k[0] == test.kind {
+					key = k
+				}
+			}
+			if key == nil {
+				t.Errorf("Failed to index %q as %c", name, test.kind)
+				continue
+			}
+			if prev != nil && bytes.Compare(prev, key) >= 0 {
+				t.Errorf("Key for %q as %c does not sort after the one before it", name, test.kind)
+			}
+			prev = key
+		}
+	}
+}
@@ -172,6 +172,34 @@ func Next(it Iterator) bool {
 	return false
 }
 
+// Killable is implemented by iterators that may loop over their subiterators
+// within a single call to Next or Contains. Once the kill channel is closed,
+// they stop looping and report that they have nothing more.
+type Killable interface {
+	SetKill(<-chan struct{})
+}
+
+// SetKill passes kill to every Killable iterator in the tree rooted at it. It
+// should be called on the tree as it will be run, after Optimize.
+func SetKill(it Iterator, kill <-chan struct{}) {
+	if k, ok := it.(Killable); ok {
+		k.SetKill(kill)
+	}
+	for _, sub := range it.SubIterators() {
+		SetKill(sub, kill)
+	}
+}
+
+// Killed reports whether kill has been closed. A nil kill is never closed.
+func Killed(kill <-chan struct{}) bool {
+	select {
+	case <-kill:
+		return true
+	default:
+		return false
+	}
+}
+
 // Height is a convienence function to measure the height of an iterator tree.
 func Height(it Iterator, until Type) int {
 	if it.Type() == until {
@@ -219,6 +247,13 @@ const (
 	Not
 	Optional
 	Materialize
+	Regex
+	Limit
+	Skip
+	Unique
+	Order
+	ShortestPath
+	Recursive
 )
 
 var (
@@ -240,6 +275,13 @@ var (
 		"not",
 		"optional",
 		"materialize",
+		"regex",
+		"limit",
+		"skip",
+		"unique",
+		"order",
+		"shortest_path",
+		"recursive",
 	}
 )
 
@@ -305,14 +347,17 @@ func DumpStats(it Iterator) StatsContainer {
 }
 
 // Utility logging functions for when an iterator gets called Next upon, or Contains upon, as
-// well as what they return. Highly useful for tracing the execution path of a query.
+// well as what they return. Highly useful for tracing the execution path of a query, and
+// how profiles count the calls.
 func ContainsLogIn(it Iterator, val Value) {
+	profileIn(it)
 	if glog.V(4) {
 		glog.V(4).Infof("%s %d CHECK CONTAINS %d", strings.ToUpper(it.Type().String()), it.UID(), val)
 	}
 }
 
 func ContainsLogOut(it Iterator, val Value, good bool) bool {
+	profileOut(it, true, good)
 	if glog.V(4) {
 		if good {
 			glog.V(4).Infof("%s %d CHECK CONTAINS %d GOOD", strings.ToUpper(it.Type().String()), it.UID(), val)
@@ -324,12 +369,14 @@ func ContainsLogOut(it Iterator, val Value, good bool) bool {
 }
 
 func NextLogIn(it Iterator) {
+	profileIn(it)
 	if glog.V(4) {
 		glog.V(4).Infof("%s %d NEXT", strings.ToUpper(it.Type().String()), it.UID())
 	}
 }
 
 func NextLogOut(it Iterator, val Value, ok bool) bool {
+	profileOut(it, false, ok)
 	if glog.V(4) {
 		if ok {
 			glog.V(4).Infof("%s %d NEXT IS %d", strings.ToUpper(it.Type().String()), it.UID(), val)
@@ -30,6 +30,7 @@ type And struct {
 	checkList         []graph.Iterator
 	result            graph.Value
 	runstats          graph.IteratorStats
+	kill              <-chan struct{}
 }
 
 // Creates a new And iterator.
@@ -147,6 +148,9 @@ func (it *And) Next() bool {
 	graph.NextLogIn(it)
 	it.runstats.Next += 1
 	for graph.Next(it.primaryIt) {
+		if graph.Killed(it.kill) {
+			break
+		}
 		curr := it.primaryIt.Result()
 		if it.subItsContain(curr, nil) {
 			it.result = curr
@@ -252,6 +256,11 @@ func (it *And) NextPath() bool {
 // Perform and-specific cleanup, of which there currently is none.
 func (it *And) cleanUp() {}
 
+// SetKill stops the And from looking for further results once kill is closed.
+func (it *And) SetKill(kill <-chan struct{}) {
+	it.kill = kill
+}
+
 // Close this iterator, and, by extension, close the subiterators.
 // Close should be idempotent, and it follows that if it's subiterators
 // follow this contract, the And follows the contract.
@@ -150,23 +150,28 @@ func (it *And) optimizeOrder(its []graph.Iterator) []graph.Iterator {
 	// all of it's contents, and to Contains() each of those against everyone
 	// else.
 	for _, root := range its {
-		if _, canNext := root.(graph.Nexter); !canNext {
+		if _, canNext := root.(graph.Nexter); !canNext || isFilter(root) {
 			bad = append(bad, root)
 			continue
 		}
 		rootStats := root.Stats()
 		cost := rootStats.NextCost
 		for _, f := range its {
-			if _, canNext := f.(graph.Nexter); !canNext {
+			if _, canNext := f.(graph.Nexter); !canNext || isFilter(f) {
 				continue
 			}
 			if f == root {
 				continue
 			}
-			stats := f.Stats()
-			cost += stats.ContainsCost * (1 + (rootStats.Size / (stats.Size + 1)))
+			cost += f.Stats().ContainsCost * (1 + (rootStats.Size / (holds(f) + 1)))
 		}
 		cost *= rootStats.Size
+		// A Limit, Skip, Unique or Order only holds for the results it
+		// Next()s, so it leads.
+		switch root.Type() {
+		case graph.Limit, graph.Skip, graph.Unique, graph.Order:
+			cost = 0
+		}
 		if glog.V(3) {
 			glog.V(3).Infoln("And:", it.UID(), "Root:", root.UID(), "Total Cost:", cost, "Best:", bestCost)
 		}
@@ -175,20 +180,26 @@ func (it *And) optimizeOrder(its []graph.Iterator) []graph.Iterator {
 			bestCost = cost
 		}
 	}
+	if best == nil {
+		// Everything is a filter; the first will have to do.
+		for i, f := range bad {
+			if isFilter(f) {
+				best = f
+				bad = append(bad[:i:i], bad[i+1:]...)
+				break
+			}
+		}
+	}
 	if glog.V(3) {
 		glog.V(3).Infoln("And:", it.UID(), "Choosing:", best.UID(), "Best:", bestCost)
 	}
 
-	// TODO(barakmich): Optimization of order need not stop here. Picking a smart
-	// Contains() order based on probability of getting a false Contains() first is
-	// useful (fail faster).
-
 	// Put the best iterator (the one we wish to Next()) at the front...
 	out = append(out, best)
 
 	// ... push everyone else after...
 	for _, it := range its {
-		if _, canNext := it.(graph.Nexter); !canNext {
+		if _, canNext := it.(graph.Nexter); !canNext || isFilter(it) {
 			continue
 		}
 		if it != best {
@@ -200,12 +211,37 @@ func (it *And) optimizeOrder(its []graph.Iterator) []graph.Iterator {
 	return append(out, bad...)
 }
 
+// isFilter returns whether it is a Not over everything, which would Next()
+// nearly the whole graph, but is cheap enough to Contains() after the rest.
+func isFilter(it graph.Iterator) bool {
+	return it.Type() == graph.Not && it.SubIterators()[0].Type() == graph.All
+}
+
+// holds returns how many values an iterator is expected to hold. A HasA over
+// a predicate the store keeps statistics for knows how many distinct nodes it
+// holds; anything else is taken at its size.
+func holds(it graph.Iterator) int64 {
+	if h, ok := it.(*HasA); ok {
+		if n, ok := h.distinct(); ok {
+			return n
+		}
+	}
+	return it.Stats().Size
+}
+
+// byCost orders iterators by the cost of Contains()ing them, weighed by how
+// many values they hold. One which holds few values fails most Contains()
+// calls, so checking it first spares checking the rest (fail faster).
 type byCost []graph.Iterator
 
 func (c byCost) Len() int           { return len(c) }
-func (c byCost) Less(i, j int) bool { return c[i].Stats().ContainsCost < c[j].Stats().ContainsCost }
+func (c byCost) Less(i, j int) bool { return checkCost(c[i]) < checkCost(c[j]) }
 func (c byCost) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
 
+func checkCost(it graph.Iterator) int64 {
+	return it.Stats().ContainsCost * (holds(it) + 1)
+}
+
 // optimizeContains() creates an alternate check list, containing the same contents
 // but with a new ordering, however it wishes.
 func (it *And) optimizeContains() {
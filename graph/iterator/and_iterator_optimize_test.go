@@ -23,6 +23,7 @@ import (
 	"testing"
 
 	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
 )
 
 func TestIteratorPromotion(t *testing.T) {
@@ -110,3 +111,37 @@ func TestAndStatistics(t *testing.T) {
 		t.Error("And didn't optimize. Next cost old ", stats1.NextCost, "and new ", stats2.NextCost)
 	}
 }
+
+func TestOptimizeContainsStats(t *testing.T) {
+	qs := &statsStore{
+		store: store{data: []string{"follows", "status"}},
+		stats: map[string]graph.PredicateStats{
+			"follows": {Quads: 100, Subjects: 10, Objects: 50},
+			"status":  {Quads: 1000, Subjects: 1000, Objects: 3},
+		},
+	}
+	a := NewAnd()
+	a.AddSubIterator(NewInt64(1, 5))
+	a.AddSubIterator(hasAPredicate(qs, "status", quad.Subject))
+	a.AddSubIterator(hasAPredicate(qs, "follows", quad.Subject))
+	newIt, _ := a.Optimize()
+	and, ok := newIt.(*And)
+	if !ok {
+		t.Fatalf("Expected an And, got %v", newIt.Type())
+	}
+	// Of the HasAs, the one for the predicate with fewer subjects fails
+	// more Contains() calls, so it is checked first.
+	var got []string
+	for _, sub := range and.checkList {
+		if h, ok := sub.(*HasA); ok {
+			if h.predStats.Quads == 100 {
+				got = append(got, "follows")
+			} else {
+				got = append(got, "status")
+			}
+		}
+	}
+	if expect := []string{"follows", "status"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected Contains() order, got:%v expect:%v", got, expect)
+	}
+}
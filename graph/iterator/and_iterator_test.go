@@ -140,3 +140,24 @@ func TestAllIterators(t *testing.T) {
 	}
 
 }
+
+// Make sure a killed And stops looking for results.
+func TestAndKill(t *testing.T) {
+	all := NewInt64(1, 100)
+	fix := NewFixed(Identity)
+	fix.Add(int64(50))
+	and := NewAnd()
+	and.AddSubIterator(all)
+	and.AddSubIterator(fix)
+
+	kill := make(chan struct{})
+	graph.SetKill(and, kill)
+	if !graph.Next(and) || and.Result() != int64(50) {
+		t.Errorf("Failed to find 50 before kill")
+	}
+	and.Reset()
+	close(kill)
+	if graph.Next(and) {
+		t.Errorf("Unexpected result after kill: %v", and.Result())
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"testing"
+
+	"github.com/google/cayley/graph"
+)
+
+func TestProfile(t *testing.T) {
+	fix1 := NewFixed(Identity)
+	for _, v := range []int{1, 2, 3} {
+		fix1.Add(v)
+	}
+	fix2 := NewFixed(Identity)
+	for _, v := range []int{2, 3, 4} {
+		fix2.Add(v)
+	}
+	and := NewAnd()
+	and.AddSubIterator(fix1)
+	and.AddSubIterator(fix2)
+
+	e := graph.Explain(and)
+	if e.Type != "and" || len(e.SubIts) != 2 || e.SubIts[1].UID != fix2.UID() {
+		t.Fatalf("Unexpected explanation: %+v", e)
+	}
+
+	p := graph.StartProfile(and)
+	for graph.Next(and) {
+	}
+	p.Stop()
+	// Calls after the profile stops aren't counted.
+	fix2.Contains(4)
+	p.Fill(&e)
+
+	for _, test := range []struct {
+		name   string
+		got    *graph.IteratorProfile
+		expect graph.IteratorProfile
+	}{
+		{name: "and", got: e.Profile, expect: graph.IteratorProfile{Next: 3, Results: 2}},
+		{name: "primary", got: e.SubIts[0].Profile, expect: graph.IteratorProfile{Next: 4, Results: 3}},
+		{name: "checked", got: e.SubIts[1].Profile, expect: graph.IteratorProfile{Contains: 3, Contained: 2}},
+	} {
+		if test.got == nil {
+			t.Errorf("Failed to profile the %s iterator", test.name)
+			continue
+		}
+		got := *test.got
+		got.Time = 0
+		if got != test.expect {
+			t.Errorf("Unexpected profile of the %s iterator, got:%+v expect:%+v", test.name, got, test.expect)
+		}
+	}
+}
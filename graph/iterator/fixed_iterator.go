@@ -55,6 +55,19 @@ func NewFixed(cmp Equality) *Fixed {
 	}
 }
 
+// FixedLabels returns a Fixed iterator, from the store, over the named labels
+// which are in it. Some stores give unknown names the value of the empty
+// label, which unlabelled quads would match, so those are left out.
+func FixedLabels(qs graph.QuadStore, labels []string) graph.FixedIterator {
+	f := qs.FixedIterator()
+	for _, label := range labels {
+		if v := qs.ValueOf(label); v != nil && qs.NameOf(v) == label {
+			f.Add(v)
+		}
+	}
+	return f
+}
+
 func (it *Fixed) UID() uint64 {
 	return it.uid
 }
@@ -52,6 +52,8 @@ type HasA struct {
 	resultIt  graph.Iterator
 	result    graph.Value
 	runstats  graph.IteratorStats
+	predStats *graph.PredicateStats
+	kill      <-chan struct{}
 }
 
 // Construct a new HasA iterator, given the quad subiterator, and the quad
@@ -88,6 +90,7 @@ func (it *HasA) Tagger() *graph.Tagger {
 func (it *HasA) Clone() graph.Iterator {
 	out := NewHasA(it.qs, it.primaryIt.Clone(), it.dir)
 	out.tags.CopyFrom(it)
+	out.predStats = it.predStats
 	return out
 }
 
@@ -97,6 +100,15 @@ func (it *HasA) Direction() quad.Direction { return it.dir }
 // Pass the Optimize() call along to the subiterator. If it becomes Null,
 // then the HasA becomes Null (there are no quads that have any directions).
 func (it *HasA) Optimize() (graph.Iterator, bool) {
+	// Find the statistics of our quads while we can still tell their
+	// predicate, before the store replaces the iterators underneath us.
+	if ss, ok := it.qs.(graph.StatsStore); ok && it.predStats == nil {
+		if pred, ok := predicateOf(it.primaryIt); ok {
+			if stats, ok := ss.PredicateStats(pred); ok {
+				it.predStats = &stats
+			}
+		}
+	}
 	newPrimary, changed := it.primaryIt.Optimize()
 	if changed {
 		it.primaryIt = newPrimary
@@ -104,6 +116,14 @@ func (it *HasA) Optimize() (graph.Iterator, bool) {
 			return it.primaryIt, true
 		}
 	}
+	// Ask the graph.QuadStore if we can be replaced. A store that can answer
+	// the quads underneath us directly may also be able to pick out their
+	// nodes in the same go.
+	newReplacement, hasOne := it.qs.OptimizeIterator(it)
+	if hasOne {
+		it.Close()
+		return newReplacement, true
+	}
 	return it, false
 }
 
@@ -160,6 +180,9 @@ func (it *HasA) Contains(val graph.Value) bool {
 // another match is made.
 func (it *HasA) NextContains() bool {
 	for graph.Next(it.resultIt) {
+		if graph.Killed(it.kill) {
+			return false
+		}
 		it.runstats.ContainsNext += 1
 		link := it.resultIt.Result()
 		if glog.V(4) {
@@ -226,6 +249,13 @@ func (it *HasA) Stats() graph.IteratorStats {
 	// and be optimized.
 	faninFactor := int64(1)
 	fanoutFactor := int64(30)
+	if it.predStats != nil {
+		// The store knows how many quads of our predicate each node has.
+		fanoutFactor = it.predStats.FanOut(it.dir)
+		if fanoutFactor < 1 {
+			fanoutFactor = 1
+		}
+	}
 	nextConstant := int64(2)
 	quadConstant := int64(1)
 	return graph.IteratorStats{
@@ -238,6 +268,21 @@ func (it *HasA) Stats() graph.IteratorStats {
 	}
 }
 
+// distinct returns how many distinct nodes the HasA holds, if the store keeps
+// statistics for its predicate.
+func (it *HasA) distinct() (int64, bool) {
+	if it.predStats == nil {
+		return 0, false
+	}
+	n := it.predStats.Distinct(it.dir)
+	return n, n >= 0
+}
+
+// SetKill stops the HasA from checking further quads once kill is closed.
+func (it *HasA) SetKill(kill <-chan struct{}) {
+	it.kill = kill
+}
+
 // Close the subiterator, the result iterator (if any) and the HasA.
 func (it *HasA) Close() {
 	if it.resultIt != nil {
@@ -252,3 +297,21 @@ func (it *HasA) Type() graph.Type { return graph.HasA }
 func (it *HasA) Size() (int64, bool) {
 	return it.Stats().Size, false
 }
+
+// predicateOf returns the predicate of the quads of an And with a LinksTo
+// from a single fixed predicate, as the query languages build them.
+func predicateOf(it graph.Iterator) (graph.Value, bool) {
+	if it.Type() != graph.And {
+		return nil, false
+	}
+	for _, sub := range it.SubIterators() {
+		lto, ok := sub.(*LinksTo)
+		if !ok || lto.dir != quad.Predicate {
+			continue
+		}
+		if f, ok := lto.primaryIt.(*Fixed); ok && len(f.values) == 1 {
+			return f.values[0], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,75 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"testing"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
+)
+
+// statsStore is a mocked store which keeps the statistics of its predicates.
+type statsStore struct {
+	store
+	stats map[string]graph.PredicateStats
+}
+
+func (qs *statsStore) PredicateStats(v graph.Value) (graph.PredicateStats, bool) {
+	s, ok := qs.stats[qs.NameOf(v)]
+	return s, ok
+}
+
+func (qs *statsStore) AllPredicateStats() (map[string]graph.PredicateStats, error) {
+	return qs.stats, nil
+}
+
+func hasAPredicate(qs graph.QuadStore, pred string, d quad.Direction) *HasA {
+	fixed := qs.FixedIterator()
+	fixed.Add(qs.ValueOf(pred))
+	and := NewAnd()
+	and.AddSubIterator(NewLinksTo(qs, fixed, quad.Predicate))
+	and.AddSubIterator(NewInt64(1, 100))
+	return NewHasA(qs, and, d)
+}
+
+func TestHasAPredicateStats(t *testing.T) {
+	qs := &statsStore{
+		store: store{data: []string{"follows", "status"}},
+		stats: map[string]graph.PredicateStats{
+			"follows": {Quads: 100, Subjects: 10, Objects: 50},
+		},
+	}
+	for _, test := range []struct {
+		pred   string
+		dir    quad.Direction
+		expect int64
+	}{
+		{pred: "follows", dir: quad.Subject, expect: 10},
+		{pred: "follows", dir: quad.Object, expect: 2},
+		// Without statistics, the guess stays the same.
+		{pred: "status", dir: quad.Subject, expect: 30},
+	} {
+		it := hasAPredicate(qs, test.pred, test.dir)
+		it.Optimize()
+		after := it.Stats()
+		if got := after.ContainsCost / (2 * it.primaryIt.Stats().ContainsCost); got != test.expect {
+			t.Errorf("Unexpected fan-out for %s in direction %v, got:%d expect:%d", test.pred, test.dir, got, test.expect)
+		}
+		if clone := it.Clone().(*HasA); clone.Stats() != after {
+			t.Errorf("Failed to keep statistics for %s in a clone", test.pred)
+		}
+	}
+}
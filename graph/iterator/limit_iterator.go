@@ -0,0 +1,186 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// "Limit" stops its subiterator after a number of results, counting each
+// result of Next() and each further path of NextPath(). Contains() only
+// accepts the values that Next() would return, which are found by running a
+// copy of the iterator the first time it is asked.
+//
+// A limit of zero or less is no limit at all.
+
+import (
+	"github.com/google/cayley/graph"
+)
+
+type Limit struct {
+	uid       uint64
+	tags      graph.Tagger
+	limit     int64
+	count     int64
+	primaryIt graph.Iterator
+	emitted   map[interface{}]struct{}
+}
+
+func NewLimit(primaryIt graph.Iterator, limit int64) *Limit {
+	return &Limit{
+		uid:       NextUID(),
+		limit:     limit,
+		primaryIt: primaryIt,
+	}
+}
+
+func (it *Limit) UID() uint64 {
+	return it.uid
+}
+
+func (it *Limit) Reset() {
+	it.primaryIt.Reset()
+	it.count = 0
+}
+
+func (it *Limit) Close() {
+	it.primaryIt.Close()
+}
+
+func (it *Limit) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Limit) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+
+	it.primaryIt.TagResults(dst)
+}
+
+func (it *Limit) Clone() graph.Iterator {
+	out := NewLimit(it.primaryIt.Clone(), it.limit)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+// done returns whether the limit has been reached.
+func (it *Limit) done() bool {
+	return it.limit > 0 && it.count >= it.limit
+}
+
+// emitted returns the keys of every value a fresh copy of it returns from
+// Next() or NextPath().
+func emitted(it graph.Iterator) map[interface{}]struct{} {
+	c := it.Clone()
+	defer c.Close()
+	keys := make(map[interface{}]struct{})
+	for graph.Next(c) {
+		keys[valueKey(c.Result())] = struct{}{}
+		for c.NextPath() {
+		}
+	}
+	return keys
+}
+
+func (it *Limit) Next() bool {
+	graph.NextLogIn(it)
+	if it.done() || !graph.Next(it.primaryIt) {
+		return graph.NextLogOut(it, nil, false)
+	}
+	it.count++
+	return graph.NextLogOut(it, it.Result(), true)
+}
+
+// DEPRECATED
+func (it *Limit) ResultTree() *graph.ResultTree {
+	tree := graph.NewResultTree(it.Result())
+	tree.AddSubtree(it.primaryIt.ResultTree())
+	return tree
+}
+
+func (it *Limit) Result() graph.Value {
+	return it.primaryIt.Result()
+}
+
+func (it *Limit) NextPath() bool {
+	if it.done() || !it.primaryIt.NextPath() {
+		return false
+	}
+	it.count++
+	return true
+}
+
+// Return our sole subiterator.
+func (it *Limit) SubIterators() []graph.Iterator {
+	return []graph.Iterator{it.primaryIt}
+}
+
+func (it *Limit) Contains(val graph.Value) bool {
+	graph.ContainsLogIn(it, val)
+	if it.emitted == nil {
+		it.emitted = emitted(it)
+	}
+	if _, ok := it.emitted[valueKey(val)]; !ok {
+		return graph.ContainsLogOut(it, val, false)
+	}
+	return graph.ContainsLogOut(it, val, it.primaryIt.Contains(val))
+}
+
+func (it *Limit) Type() graph.Type { return graph.Limit }
+
+func (it *Limit) Describe() graph.Description {
+	primary := it.primaryIt.Describe()
+	size, _ := it.Size()
+	return graph.Description{
+		UID:      it.UID(),
+		Type:     it.Type(),
+		Tags:     it.tags.Tags(),
+		Size:     size,
+		Iterator: &primary,
+	}
+}
+
+// Optimize the subiterator. Without a limit, we may as well be it.
+func (it *Limit) Optimize() (graph.Iterator, bool) {
+	newPrimary, changed := it.primaryIt.Optimize()
+	if changed {
+		it.primaryIt.Close()
+		it.primaryIt = newPrimary
+	}
+	if it.limit <= 0 {
+		it.primaryIt.Tagger().CopyFrom(it)
+		return it.primaryIt, true
+	}
+	return it, false
+}
+
+// We cost as much as our subiterator, but return no more than the limit.
+func (it *Limit) Stats() graph.IteratorStats {
+	stats := it.primaryIt.Stats()
+	if it.limit > 0 && stats.Size > it.limit {
+		stats.Size = it.limit
+	}
+	return stats
+}
+
+func (it *Limit) Size() (int64, bool) {
+	size, exact := it.primaryIt.Size()
+	if it.limit > 0 && size > it.limit {
+		return it.limit, exact
+	}
+	return size, exact
+}
@@ -0,0 +1,154 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cayley/graph"
+)
+
+var limitSkipTests = []struct {
+	message string
+	skip    int64
+	limit   int64
+	expect  []int
+}{
+	{
+		message: "limit the results",
+		limit:   2,
+		expect:  []int{0, 1},
+	},
+	{
+		message: "skip the first results",
+		skip:    3,
+		expect:  []int{3, 4},
+	},
+	{
+		message: "skip past all results",
+		skip:    7,
+		expect:  nil,
+	},
+	{
+		message: "skip then limit the results",
+		skip:    1,
+		limit:   3,
+		expect:  []int{1, 2, 3},
+	},
+	{
+		message: "ignore a zero limit",
+		expect:  []int{0, 1, 2, 3, 4},
+	},
+}
+
+func TestLimitAndSkip(t *testing.T) {
+	for _, test := range limitSkipTests {
+		var it graph.Iterator = NewSkip(simpleFixedIterator(), test.skip)
+		it = NewLimit(it, test.limit)
+		for i := 0; i < 2; i++ {
+			if got := iterated(it); !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("Failed to %s on repeat %d, got:%v expect:%v", test.message, i, got, test.expect)
+			}
+			it.Reset()
+		}
+
+		opt, _ := it.Optimize()
+		if got := iterated(opt); !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s after optimization, got:%v expect:%v", test.message, got, test.expect)
+		}
+	}
+}
+
+func TestLimitLeadsAnd(t *testing.T) {
+	and := NewAnd()
+	and.AddSubIterator(NewLimit(simpleFixedIterator(), 2))
+	f := NewFixed(Identity)
+	f.Add(1)
+	f.Add(4)
+	and.AddSubIterator(f)
+
+	// The Fixed iterator is smaller, but only the Limit knows which results
+	// it allows.
+	opt, _ := and.Optimize()
+	if got, expect := iterated(opt), []int{1}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to lead And with Limit, got:%v expect:%v", got, expect)
+	}
+}
+
+// pathsIterated returns the result of each path of it.
+func pathsIterated(it graph.Iterator) []int {
+	var res []int
+	for graph.Next(it) {
+		res = append(res, it.Result().(int))
+		for it.NextPath() {
+			res = append(res, it.Result().(int))
+		}
+	}
+	return res
+}
+
+func TestLimitAndSkipCountPaths(t *testing.T) {
+	for _, test := range []struct {
+		message string
+		skip    int64
+		limit   int64
+		expect  []int
+	}{
+		{
+			message: "limit the paths",
+			limit:   2,
+			expect:  []int{0, 0},
+		},
+		{
+			message: "skip some of the paths of a result",
+			skip:    2,
+			expect:  []int{0, 1, 2},
+		},
+		{
+			message: "skip all the paths of a result",
+			skip:    3,
+			expect:  []int{1, 2},
+		},
+	} {
+		f := NewFixed(Identity)
+		for _, v := range []int{0, 0, 0, 1, 2} {
+			f.Add(v)
+		}
+		// Materialize gives the three 0s as paths of a single result.
+		var it graph.Iterator = NewSkip(NewMaterialize(f), test.skip)
+		it = NewLimit(it, test.limit)
+		if got := pathsIterated(it); !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s, got:%v expect:%v", test.message, got, test.expect)
+		}
+	}
+}
+
+func TestLimitAndSkipContains(t *testing.T) {
+	it := NewLimit(NewSkip(simpleFixedIterator(), 1), 2)
+	for _, test := range []struct {
+		val    int
+		expect bool
+	}{
+		{0, false},
+		{1, true},
+		{2, true},
+		{3, false},
+	} {
+		if got := it.Contains(test.val); got != test.expect {
+			t.Errorf("Unexpected result for Contains(%d), got:%v expect:%v", test.val, got, test.expect)
+		}
+	}
+}
@@ -46,6 +46,7 @@ type LinksTo struct {
 	nextIt    graph.Iterator
 	result    graph.Value
 	runstats  graph.IteratorStats
+	kill      <-chan struct{}
 }
 
 // Construct a new LinksTo iterator around a direction and a subiterator of
@@ -165,7 +166,7 @@ func (it *LinksTo) Next() bool {
 	}
 
 	// Subiterator is empty, get another one
-	if !graph.Next(it.primaryIt) {
+	if graph.Killed(it.kill) || !graph.Next(it.primaryIt) {
 		// We're out of nodes in our subiterator, so we're done as well.
 		return graph.NextLogOut(it, 0, false)
 	}
@@ -180,6 +181,11 @@ func (it *LinksTo) Result() graph.Value {
 	return it.result
 }
 
+// SetKill stops the LinksTo from moving to further nodes once kill is closed.
+func (it *LinksTo) SetKill(kill <-chan struct{}) {
+	it.kill = kill
+}
+
 // Close our subiterators.
 func (it *LinksTo) Close() {
 	it.nextIt.Close()
@@ -0,0 +1,196 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// "Not" is the set difference of two iterators. It returns the values of its
+// primary iterator which its excluded iterator does not contain, by
+// Contains()ing each against the excluded iterator.
+//
+// The excluded iterator is only ever Contains()ed, so its tags say nothing
+// about a result and are dropped.
+//
+// A Not over everything, as from the All iterator, is best used as a filter
+// in an And, which Next()s something else and Contains()s the Not last.
+
+import (
+	"github.com/google/cayley/graph"
+)
+
+type Not struct {
+	uid        uint64
+	tags       graph.Tagger
+	primaryIt  graph.Iterator
+	excludedIt graph.Iterator
+	result     graph.Value
+	runstats   graph.IteratorStats
+}
+
+// NewNot returns an iterator over the values of primaryIt which are not in
+// excludedIt.
+func NewNot(primaryIt, excludedIt graph.Iterator) *Not {
+	return &Not{
+		uid:        NextUID(),
+		primaryIt:  primaryIt,
+		excludedIt: excludedIt,
+	}
+}
+
+func (it *Not) UID() uint64 {
+	return it.uid
+}
+
+func (it *Not) Reset() {
+	it.primaryIt.Reset()
+	it.excludedIt.Reset()
+	it.result = nil
+}
+
+func (it *Not) Close() {
+	it.primaryIt.Close()
+	it.excludedIt.Close()
+}
+
+func (it *Not) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Not) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+
+	it.primaryIt.TagResults(dst)
+}
+
+func (it *Not) Clone() graph.Iterator {
+	out := NewNot(it.primaryIt.Clone(), it.excludedIt.Clone())
+	out.tags.CopyFrom(it)
+	return out
+}
+
+// Next advances the primary iterator to its next value which is not excluded.
+func (it *Not) Next() bool {
+	graph.NextLogIn(it)
+	it.runstats.Next += 1
+	for graph.Next(it.primaryIt) {
+		val := it.primaryIt.Result()
+		if !it.excludedIt.Contains(val) {
+			it.result = val
+			return graph.NextLogOut(it, val, true)
+		}
+	}
+	it.result = nil
+	return graph.NextLogOut(it, nil, false)
+}
+
+// DEPRECATED
+func (it *Not) ResultTree() *graph.ResultTree {
+	tree := graph.NewResultTree(it.Result())
+	tree.AddSubtree(it.primaryIt.ResultTree())
+	return tree
+}
+
+func (it *Not) Result() graph.Value {
+	return it.result
+}
+
+// A value is excluded whatever its path, so the primary iterator's further
+// paths all hold.
+func (it *Not) NextPath() bool {
+	return it.primaryIt.NextPath()
+}
+
+// Return the primary and excluded subiterators.
+func (it *Not) SubIterators() []graph.Iterator {
+	return []graph.Iterator{it.primaryIt, it.excludedIt}
+}
+
+// Contains checks the excluded iterator first when it is the cheaper of the
+// two, as a value it contains is refused without asking the primary.
+func (it *Not) Contains(val graph.Value) bool {
+	graph.ContainsLogIn(it, val)
+	it.runstats.Contains += 1
+	if it.excludedIt.Stats().ContainsCost < it.primaryIt.Stats().ContainsCost {
+		if it.excludedIt.Contains(val) || !it.primaryIt.Contains(val) {
+			return graph.ContainsLogOut(it, val, false)
+		}
+	} else if !it.primaryIt.Contains(val) || it.excludedIt.Contains(val) {
+		return graph.ContainsLogOut(it, val, false)
+	}
+	it.result = val
+	return graph.ContainsLogOut(it, val, true)
+}
+
+func (it *Not) Type() graph.Type { return graph.Not }
+
+func (it *Not) Describe() graph.Description {
+	size, _ := it.Size()
+	return graph.Description{
+		UID:       it.UID(),
+		Type:      it.Type(),
+		Tags:      it.tags.Tags(),
+		Size:      size,
+		Iterators: []graph.Description{it.primaryIt.Describe(), it.excludedIt.Describe()},
+	}
+}
+
+// Optimize both subiterators. Excluding nothing leaves the primary iterator,
+// and excluding everything, or nothing to exclude from, leaves nothing.
+func (it *Not) Optimize() (graph.Iterator, bool) {
+	newPrimary, changed := it.primaryIt.Optimize()
+	if changed {
+		it.primaryIt.Close()
+		it.primaryIt = newPrimary
+	}
+	newExcluded, changed := it.excludedIt.Optimize()
+	if changed {
+		it.excludedIt.Close()
+		it.excludedIt = newExcluded
+	}
+	switch {
+	case it.primaryIt.Type() == graph.Null || it.excludedIt.Type() == graph.All:
+		it.Close()
+		return &Null{}, true
+	case it.excludedIt.Type() == graph.Null:
+		it.excludedIt.Close()
+		it.primaryIt.Tagger().CopyFrom(it)
+		return it.primaryIt, true
+	}
+	return it, false
+}
+
+// Each value of the primary iterator costs a Contains() against the excluded
+// one. We may return as many values as the primary iterator, if nothing is
+// excluded.
+func (it *Not) Stats() graph.IteratorStats {
+	primary := it.primaryIt.Stats()
+	excluded := it.excludedIt.Stats()
+	return graph.IteratorStats{
+		NextCost:     primary.NextCost + excluded.ContainsCost,
+		ContainsCost: primary.ContainsCost + excluded.ContainsCost,
+		Size:         primary.Size,
+		Next:         it.runstats.Next,
+		Contains:     it.runstats.Contains,
+	}
+}
+
+func (it *Not) Size() (int64, bool) {
+	size, _ := it.primaryIt.Size()
+	return size, false
+}
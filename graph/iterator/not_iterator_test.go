@@ -0,0 +1,101 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cayley/graph"
+)
+
+func TestNotIteratorBasics(t *testing.T) {
+	primary := NewFixed(Identity)
+	for _, v := range []int{1, 2, 3, 4} {
+		primary.Add(v)
+	}
+	excluded := NewFixed(Identity)
+	for _, v := range []int{2, 4, 5} {
+		excluded.Add(v)
+	}
+	not := NewNot(primary, excluded)
+
+	expect := []int{1, 3}
+	for i := 0; i < 2; i++ {
+		if got := iterated(not); !reflect.DeepEqual(got, expect) {
+			t.Errorf("Failed to iterate Not correctly on repeat %d, got:%v expect:%v", i, got, expect)
+		}
+		not.Reset()
+	}
+
+	for _, v := range []int{1, 3} {
+		if !not.Contains(v) {
+			t.Errorf("Failed to contain %d", v)
+		}
+	}
+	for _, v := range []int{2, 5, 6} {
+		if not.Contains(v) {
+			t.Errorf("Unexpectedly contained %d", v)
+		}
+	}
+}
+
+func TestNotIteratorOptimize(t *testing.T) {
+	primary := NewFixed(Identity)
+	primary.Add(1)
+	not := NewNot(primary, NewNull())
+	not.Tagger().Add("foo")
+	opt, changed := not.Optimize()
+	if !changed || opt.Type() != graph.Fixed {
+		t.Errorf("Failed to drop Not excluding nothing, got:%v", opt.Type())
+	}
+	if tags := opt.Tagger().Tags(); !reflect.DeepEqual(tags, []string{"foo"}) {
+		t.Errorf("Failed to move tags onto the replacement, got:%v", tags)
+	}
+
+	primary = NewFixed(Identity)
+	primary.Add(1)
+	if opt, _ := NewNot(primary, NewInt64(1, 3)).Optimize(); opt.Type() != graph.Null {
+		t.Errorf("Failed to replace Not excluding everything, got:%v", opt.Type())
+	}
+}
+
+func TestNotFilterTrailsAnd(t *testing.T) {
+	excluded := NewFixed(Identity)
+	excluded.Add(int64(2))
+	fixed := NewFixed(Identity)
+	for _, v := range []int64{1, 2, 3} {
+		fixed.Add(v)
+	}
+	a := NewAnd()
+	a.AddSubIterator(NewNot(NewInt64(1, 3), excluded))
+	a.AddSubIterator(fixed)
+
+	newIt, _ := a.Optimize()
+	if newIt.Type() != graph.And {
+		t.Fatalf("Expected an And, got:%v", newIt.Type())
+	}
+	subs := newIt.SubIterators()
+	if subs[0].Type() != graph.Fixed || subs[1].Type() != graph.Not {
+		t.Errorf("Failed to move the Not filter last, got:%v, %v", subs[0].Type(), subs[1].Type())
+	}
+	var got []int64
+	for graph.Next(newIt) {
+		got = append(got, newIt.Result().(int64))
+	}
+	if expect := []int64{1, 3}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to filter the And, got:%v expect:%v", got, expect)
+	}
+}
@@ -99,10 +99,11 @@ func (it *Optional) SubIterators() []graph.Iterator {
 // of whether the subiterator matched. But we keep track of whether the subiterator
 // matched for results purposes.
 func (it *Optional) Contains(val graph.Value) bool {
+	graph.ContainsLogIn(it, val)
 	checked := it.subIt.Contains(val)
 	it.lastCheck = checked
 	it.result = val
-	return true
+	return graph.ContainsLogOut(it, val, true)
 }
 
 // If we failed the check, then the subiterator should not contribute to the result
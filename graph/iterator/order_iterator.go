@@ -0,0 +1,511 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// "Order" returns every path of its subiterator, sorted. When first Next()ed,
+// it runs the whole subiterator, as Materialize does, and sorts each path by
+// the name of its result, or of the value under a tag. Each path comes out of
+// Next() on its own, so NextPath() has nothing more to give. Contains() is
+// passed straight through.
+//
+// Names sort by their collation. Those which don't parse as the collation
+// asks sort after those that do, by string, and paths missing the tag sort
+// last of all. Ties keep the order of the subiterator.
+//
+// Past orderMaxInMemory paths, sorted runs are spilled to temporary files and
+// merged as they're read back. Spilled values are restored through ValueOf(),
+// by name, so only node values survive being spilled.
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/barakmich/glog"
+
+	"github.com/google/cayley/graph"
+)
+
+var orderMaxInMemory = 100000
+
+type Collation int
+
+const (
+	CollateString Collation = iota
+	CollateNumeric
+	CollateDate
+)
+
+var collationNames = []string{
+	CollateString:  "string",
+	CollateNumeric: "numeric",
+	CollateDate:    "date",
+}
+
+func (c Collation) String() string {
+	if c < 0 || int(c) >= len(collationNames) {
+		return "illegal-collation"
+	}
+	return collationNames[c]
+}
+
+// ParseCollation returns the collation called name. The empty name is string
+// collation.
+func ParseCollation(name string) (Collation, error) {
+	if name == "" {
+		return CollateString, nil
+	}
+	for c, n := range collationNames {
+		if n == name {
+			return Collation(c), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown collation %q", name)
+}
+
+type orderKey struct {
+	name    string
+	missing bool
+	parsed  bool
+	num     float64
+	time    time.Time
+}
+
+func (c Collation) key(name string) orderKey {
+	k := orderKey{name: name}
+	switch c {
+	case CollateNumeric:
+		f, err := strconv.ParseFloat(name, 64)
+		k.num, k.parsed = f, err == nil
+	case CollateDate:
+		t, err := time.Parse(time.RFC3339, name)
+		k.time, k.parsed = t, err == nil
+	}
+	return k
+}
+
+func (c Collation) less(a, b orderKey) bool {
+	if a.missing != b.missing {
+		return b.missing
+	}
+	if a.parsed != b.parsed {
+		return a.parsed
+	}
+	if a.parsed {
+		switch c {
+		case CollateNumeric:
+			if a.num != b.num {
+				return a.num < b.num
+			}
+		case CollateDate:
+			if !a.time.Equal(b.time) {
+				return a.time.Before(b.time)
+			}
+		}
+	}
+	return a.name < b.name
+}
+
+type orderRecord struct {
+	key  orderKey
+	val  graph.Value
+	tags map[string]graph.Value
+}
+
+// spilledRecord is an orderRecord as written to disk.
+type spilledRecord struct {
+	Key     string
+	Missing bool
+	Name    string
+	Tags    map[string]string
+	Depths  map[string]Depth // Tags whose values are depths, not names.
+	Indexes map[string]Index // Tags whose values are path indexes.
+}
+
+type byOrderKey struct {
+	recs      []orderRecord
+	collation Collation
+}
+
+func (s byOrderKey) Len() int      { return len(s.recs) }
+func (s byOrderKey) Swap(i, j int) { s.recs[i], s.recs[j] = s.recs[j], s.recs[i] }
+func (s byOrderKey) Less(i, j int) bool {
+	return s.collation.less(s.recs[i].key, s.recs[j].key)
+}
+
+// orderSource is one sorted run being merged: the in-memory records, or a
+// spilled file.
+type orderSource struct {
+	index int
+	recs  []orderRecord
+	pos   int
+	dec   *gob.Decoder
+	head  orderRecord
+}
+
+type orderMerge struct {
+	sources   []*orderSource
+	collation Collation
+}
+
+func (m *orderMerge) Len() int      { return len(m.sources) }
+func (m *orderMerge) Swap(i, j int) { m.sources[i], m.sources[j] = m.sources[j], m.sources[i] }
+func (m *orderMerge) Less(i, j int) bool {
+	a, b := m.sources[i], m.sources[j]
+	if m.collation.less(a.head.key, b.head.key) {
+		return true
+	}
+	if m.collation.less(b.head.key, a.head.key) {
+		return false
+	}
+	// Earlier runs hold earlier paths.
+	return a.index < b.index
+}
+func (m *orderMerge) Push(x interface{}) { m.sources = append(m.sources, x.(*orderSource)) }
+func (m *orderMerge) Pop() interface{} {
+	n := len(m.sources)
+	src := m.sources[n-1]
+	m.sources = m.sources[:n-1]
+	return src
+}
+
+type Order struct {
+	uid       uint64
+	tags      graph.Tagger
+	subIt     graph.Iterator
+	qs        graph.QuadStore
+	tag       string
+	collation Collation
+	hasRun    bool
+	records   []orderRecord
+	spills    []*os.File
+	merge     *orderMerge
+	result    orderRecord
+	contained bool
+	kill      <-chan struct{}
+}
+
+// NewOrder returns an iterator over the paths of sub, sorted by the name of
+// the value under tag, or of the result itself when tag is empty.
+func NewOrder(sub graph.Iterator, qs graph.QuadStore, tag string, c Collation) *Order {
+	return &Order{
+		uid:       NextUID(),
+		subIt:     sub,
+		qs:        qs,
+		tag:       tag,
+		collation: c,
+	}
+}
+
+func (it *Order) UID() uint64 {
+	return it.uid
+}
+
+// SetKill stops the Order from reading further paths once kill is closed.
+func (it *Order) SetKill(kill <-chan struct{}) {
+	it.kill = kill
+}
+
+func (it *Order) Reset() {
+	it.subIt.Reset()
+	it.merge = nil
+	it.contained = false
+}
+
+func (it *Order) Close() {
+	it.subIt.Close()
+	it.removeSpills()
+	it.records = nil
+	it.merge = nil
+	it.hasRun = false
+}
+
+func (it *Order) removeSpills() {
+	for _, f := range it.spills {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	it.spills = nil
+}
+
+func (it *Order) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Order) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+
+	if it.contained {
+		it.subIt.TagResults(dst)
+		return
+	}
+	for tag, value := range it.result.tags {
+		dst[tag] = value
+	}
+}
+
+func (it *Order) Clone() graph.Iterator {
+	out := NewOrder(it.subIt.Clone(), it.qs, it.tag, it.collation)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+// add records the current path of the subiterator.
+func (it *Order) add() {
+	rec := orderRecord{
+		val:  it.subIt.Result(),
+		tags: make(map[string]graph.Value),
+	}
+	it.subIt.TagResults(rec.tags)
+	sortVal := rec.val
+	if it.tag != "" {
+		sortVal = rec.tags[it.tag]
+	}
+	if sortVal == nil {
+		rec.key = orderKey{missing: true}
+	} else {
+		rec.key = it.collation.key(NameOf(it.qs, sortVal))
+	}
+	it.records = append(it.records, rec)
+	if len(it.records) >= orderMaxInMemory {
+		it.spill()
+	}
+}
+
+func (it *Order) run() {
+	it.hasRun = true
+	for graph.Next(it.subIt) {
+		if graph.Killed(it.kill) {
+			return
+		}
+		it.add()
+		for it.subIt.NextPath() {
+			it.add()
+		}
+	}
+	sort.Stable(byOrderKey{it.records, it.collation})
+}
+
+// spill writes the records held in memory to a file, as a sorted run. If it
+// can't, they stay in memory.
+func (it *Order) spill() {
+	sort.Stable(byOrderKey{it.records, it.collation})
+	f, err := ioutil.TempFile("", "cayley-order")
+	if err != nil {
+		glog.Errorf("Couldn't spill sorted results: %v", err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, rec := range it.records {
+		s := spilledRecord{
+			Key:     rec.key.name,
+			Missing: rec.key.missing,
+			Name:    it.qs.NameOf(rec.val),
+			Tags:    make(map[string]string, len(rec.tags)),
+		}
+		for tag, v := range rec.tags {
+			switch v := v.(type) {
+			case nil:
+			case Depth:
+				if s.Depths == nil {
+					s.Depths = make(map[string]Depth)
+				}
+				s.Depths[tag] = v
+			case Index:
+				if s.Indexes == nil {
+					s.Indexes = make(map[string]Index)
+				}
+				s.Indexes[tag] = v
+			default:
+				s.Tags[tag] = it.qs.NameOf(v)
+			}
+		}
+		if err = enc.Encode(&s); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		glog.Errorf("Couldn't spill sorted results: %v", err)
+		f.Close()
+		os.Remove(f.Name())
+		return
+	}
+	it.spills = append(it.spills, f)
+	it.records = it.records[:0]
+}
+
+// next moves the source on to its next record, returning false once it has
+// none.
+func (it *Order) next(src *orderSource) bool {
+	if src.dec == nil {
+		if src.pos >= len(src.recs) {
+			return false
+		}
+		src.head = src.recs[src.pos]
+		src.pos++
+		return true
+	}
+	var s spilledRecord
+	if err := src.dec.Decode(&s); err != nil {
+		if err != io.EOF {
+			glog.Errorf("Couldn't read spilled results: %v", err)
+		}
+		return false
+	}
+	src.head = orderRecord{
+		key:  orderKey{missing: true},
+		val:  it.qs.ValueOf(s.Name),
+		tags: make(map[string]graph.Value, len(s.Tags)),
+	}
+	if !s.Missing {
+		src.head.key = it.collation.key(s.Key)
+	}
+	for tag, name := range s.Tags {
+		src.head.tags[tag] = it.qs.ValueOf(name)
+	}
+	for tag, d := range s.Depths {
+		src.head.tags[tag] = d
+	}
+	for tag, i := range s.Indexes {
+		src.head.tags[tag] = i
+	}
+	return true
+}
+
+// startMerge readies the sorted runs to be read from the beginning.
+func (it *Order) startMerge() {
+	it.merge = &orderMerge{collation: it.collation}
+	for i, f := range it.spills {
+		if _, err := f.Seek(0, 0); err != nil {
+			glog.Errorf("Couldn't read spilled results: %v", err)
+			continue
+		}
+		src := &orderSource{index: i, dec: gob.NewDecoder(bufio.NewReader(f))}
+		if it.next(src) {
+			it.merge.sources = append(it.merge.sources, src)
+		}
+	}
+	src := &orderSource{index: len(it.spills), recs: it.records}
+	if it.next(src) {
+		it.merge.sources = append(it.merge.sources, src)
+	}
+	heap.Init(it.merge)
+}
+
+func (it *Order) Next() bool {
+	graph.NextLogIn(it)
+	if !it.hasRun {
+		it.run()
+	}
+	if it.merge == nil {
+		it.startMerge()
+	}
+	it.contained = false
+	if it.merge.Len() == 0 || graph.Killed(it.kill) {
+		return graph.NextLogOut(it, nil, false)
+	}
+	src := it.merge.sources[0]
+	it.result = src.head
+	if it.next(src) {
+		heap.Fix(it.merge, 0)
+	} else {
+		heap.Pop(it.merge)
+	}
+	return graph.NextLogOut(it, it.result.val, true)
+}
+
+// DEPRECATED
+func (it *Order) ResultTree() *graph.ResultTree {
+	return graph.NewResultTree(it.Result())
+}
+
+func (it *Order) Result() graph.Value {
+	return it.result.val
+}
+
+func (it *Order) NextPath() bool {
+	if it.contained {
+		return it.subIt.NextPath()
+	}
+	return false
+}
+
+// Return our sole subiterator.
+func (it *Order) SubIterators() []graph.Iterator {
+	return []graph.Iterator{it.subIt}
+}
+
+func (it *Order) Contains(val graph.Value) bool {
+	graph.ContainsLogIn(it, val)
+	it.contained = true
+	it.result = orderRecord{val: val}
+	return graph.ContainsLogOut(it, val, it.subIt.Contains(val))
+}
+
+func (it *Order) Type() graph.Type { return graph.Order }
+
+func (it *Order) Describe() graph.Description {
+	primary := it.subIt.Describe()
+	name := it.collation.String()
+	if it.tag != "" {
+		name = it.tag + " " + name
+	}
+	size, _ := it.Size()
+	return graph.Description{
+		UID:      it.UID(),
+		Name:     name,
+		Type:     it.Type(),
+		Tags:     it.tags.Tags(),
+		Size:     size,
+		Iterator: &primary,
+	}
+}
+
+// There's nothing to optimize, locally, for an order iterator. Replace the
+// underlying iterator if need be.
+func (it *Order) Optimize() (graph.Iterator, bool) {
+	newSub, changed := it.subIt.Optimize()
+	if changed {
+		it.subIt.Close()
+		it.subIt = newSub
+	}
+	return it, false
+}
+
+// We cost as much as our subiterator, which we run through once.
+func (it *Order) Stats() graph.IteratorStats {
+	return it.subIt.Stats()
+}
+
+func (it *Order) Size() (int64, bool) {
+	return it.subIt.Size()
+}
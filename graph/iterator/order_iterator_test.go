@@ -0,0 +1,138 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cayley/graph"
+)
+
+var orderStore = &store{data: []string{
+	"10", "9", "b", "2.5", "a",
+	"2015-01-01T00:00:00Z", "2014-06-01T00:00:00Z",
+}}
+
+func orderFixedIterator() *Fixed {
+	f := NewFixed(Identity)
+	for i := range orderStore.data {
+		f.Add(i)
+	}
+	f.Tagger().Add("x")
+	return f
+}
+
+var orderTests = []struct {
+	message   string
+	tag       string
+	collation Collation
+	expect    []string
+}{
+	{
+		message:   "sort by string",
+		collation: CollateString,
+		expect:    []string{"10", "2.5", "2014-06-01T00:00:00Z", "2015-01-01T00:00:00Z", "9", "a", "b"},
+	},
+	{
+		message:   "sort by number",
+		collation: CollateNumeric,
+		expect:    []string{"2.5", "9", "10", "2014-06-01T00:00:00Z", "2015-01-01T00:00:00Z", "a", "b"},
+	},
+	{
+		message:   "sort by date",
+		collation: CollateDate,
+		expect:    []string{"2014-06-01T00:00:00Z", "2015-01-01T00:00:00Z", "10", "2.5", "9", "a", "b"},
+	},
+	{
+		message:   "sort by a tag",
+		tag:       "x",
+		collation: CollateNumeric,
+		expect:    []string{"2.5", "9", "10", "2014-06-01T00:00:00Z", "2015-01-01T00:00:00Z", "a", "b"},
+	},
+	{
+		message:   "keep the order without the tag",
+		tag:       "y",
+		collation: CollateString,
+		expect:    []string{"10", "9", "b", "2.5", "a", "2015-01-01T00:00:00Z", "2014-06-01T00:00:00Z"},
+	},
+}
+
+func ordered(it graph.Iterator) ([]string, []string) {
+	var got, tagged []string
+	for graph.Next(it) {
+		got = append(got, orderStore.NameOf(it.Result()))
+		tags := make(map[string]graph.Value)
+		it.TagResults(tags)
+		tagged = append(tagged, orderStore.NameOf(tags["x"]))
+	}
+	return got, tagged
+}
+
+func TestOrder(t *testing.T) {
+	defer func(n int) { orderMaxInMemory = n }(orderMaxInMemory)
+	for _, max := range []int{orderMaxInMemory, 2} {
+		orderMaxInMemory = max
+
+		for _, test := range orderTests {
+			it := NewOrder(orderFixedIterator(), orderStore, test.tag, test.collation)
+			for i := 0; i < 2; i++ {
+				got, tagged := ordered(it)
+				if !reflect.DeepEqual(got, test.expect) {
+					t.Errorf("Failed to %s with at most %d in memory on repeat %d, got:%q expect:%q", test.message, max, i, got, test.expect)
+				}
+				if !reflect.DeepEqual(tagged, test.expect) {
+					t.Errorf("Failed to keep tags when asked to %s with at most %d in memory, got:%q expect:%q", test.message, max, tagged, test.expect)
+				}
+				it.Reset()
+			}
+			it.Close()
+		}
+	}
+}
+
+func TestOrderSpillTags(t *testing.T) {
+	defer func(n int) { orderMaxInMemory = n }(orderMaxInMemory)
+	orderMaxInMemory = 2
+
+	f := orderFixedIterator()
+	f.Tagger().AddFixed("depth", Depth(2))
+	f.Tagger().AddFixed("step", Index(3))
+	it := NewOrder(f, orderStore, "", CollateString)
+	defer it.Close()
+	for graph.Next(it) {
+		tags := make(map[string]graph.Value)
+		it.TagResults(tags)
+		if tags["depth"] != Depth(2) || tags["step"] != Index(3) {
+			t.Errorf("Failed to keep depth and index tags through a spill, got:%v", tags)
+		}
+	}
+}
+
+func TestParseCollation(t *testing.T) {
+	for name, expect := range map[string]Collation{
+		"":        CollateString,
+		"string":  CollateString,
+		"numeric": CollateNumeric,
+		"date":    CollateDate,
+	} {
+		if c, err := ParseCollation(name); err != nil || c != expect {
+			t.Errorf("Failed to parse collation %q, got:%v expect:%v", name, c, expect)
+		}
+	}
+	if _, err := ParseCollation("roman"); err == nil {
+		t.Error("Unexpectedly parsed an unknown collation")
+	}
+}
@@ -15,6 +15,8 @@
 package iterator
 
 import (
+	"fmt"
+
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/quad"
 )
@@ -23,8 +25,10 @@ type Node struct {
 	ID         int      `json:"id"`
 	Tags       []string `json:"tags,omitempty"`
 	Values     []string `json:"values,omitempty"`
+	Filters    []string `json:"filters,omitempty"`
 	IsLinkNode bool     `json:"is_link_node"`
 	IsFixed    bool     `json:"is_fixed"`
+	IsExcluded bool     `json:"is_excluded"`
 }
 
 type Link struct {
@@ -84,8 +88,12 @@ func (s *queryShape) StealNode(left *Node, right *Node) {
 	for _, v := range right.Tags {
 		left.Tags = append(left.Tags, v)
 	}
+	for _, v := range right.Filters {
+		left.Filters = append(left.Filters, v)
+	}
 	left.IsLinkNode = left.IsLinkNode || right.IsLinkNode
 	left.IsFixed = left.IsFixed || right.IsFixed
+	left.IsExcluded = left.IsExcluded || right.IsExcluded
 	for i, link := range s.links {
 		rewrite := false
 		if link.LinkNode == right.ID {
@@ -169,6 +177,41 @@ func (s *queryShape) MakeNode(it graph.Iterator) *Node {
 		} else {
 			s.AddNode(newNode)
 		}
+	case graph.Comparison:
+		cmp := it.(*Comparison)
+		n.Filters = append(n.Filters, filterString(cmp.op.String(), cmp.val))
+		s.nodeID++
+		s.StealNode(&n, s.MakeNode(cmp.subIt))
+	case graph.Regex:
+		re := it.(*Regex)
+		n.Filters = append(n.Filters, filterString("regex", re.re.String()))
+		s.nodeID++
+		s.StealNode(&n, s.MakeNode(re.subIt))
+	case graph.Limit, graph.Skip, graph.Unique, graph.Order, graph.Recursive:
+		s.nodeID++
+		s.StealNode(&n, s.MakeNode(it.SubIterators()[0]))
+	case graph.Not:
+		// The values left out are drawn as a node of their own, marked as
+		// excluded and linked from the node they are left out of.
+		not := it.(*Not)
+		s.nodeID++
+		s.StealNode(&n, s.MakeNode(not.primaryIt))
+		s.nodeID++
+		excluded := s.MakeNode(not.excludedIt)
+		excluded.IsExcluded = true
+		s.AddNode(excluded)
+		s.AddLink(&Link{n.ID, excluded.ID, 0, 0})
+	case graph.ShortestPath:
+		// The path is drawn as a link from its start to its goal.
+		n.IsLinkNode = true
+		sp := it.(*ShortestPath)
+		s.nodeID++
+		start := s.MakeNode(sp.startIt)
+		s.AddNode(start)
+		s.nodeID++
+		goal := s.MakeNode(sp.goalIt)
+		s.AddNode(goal)
+		s.AddLink(&Link{start.ID, goal.ID, 0, n.ID})
 	case graph.Optional:
 		// Unsupported, for the moment
 		fallthrough
@@ -176,3 +219,12 @@ func (s *queryShape) MakeNode(it graph.Iterator) *Node {
 	}
 	return &n
 }
+
+// filterString describes a filter on the values of a node, such as `> 5` or
+// `prefix "ab"`.
+func filterString(op string, val interface{}) string {
+	if s, ok := val.(string); ok {
+		return fmt.Sprintf("%s %q", op, s)
+	}
+	return fmt.Sprintf("%s %v", op, val)
+}
@@ -16,6 +16,7 @@ package iterator
 
 import (
 	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/google/cayley/graph"
@@ -129,3 +130,74 @@ func TestQueryShape(t *testing.T) {
 		t.Errorf("Failed to find the correct number of link nodes, got:%d expect:3", n)
 	}
 }
+
+func TestQueryShapeFilters(t *testing.T) {
+	qs := &store{
+		data: []string{
+			1: "cool",
+			2: "abc",
+		},
+	}
+
+	fixed := qs.FixedIterator()
+	fixed.Add(qs.ValueOf("cool"))
+	fixed.Add(qs.ValueOf("abc"))
+	and := NewAnd()
+	and.AddSubIterator(fixed)
+	and.AddSubIterator(NewComparison(qs.NodesAllIterator(), CompareGT, float64(5), qs))
+	and.AddSubIterator(NewComparison(qs.NodesAllIterator(), ComparePrefix, "ab", qs))
+	and.AddSubIterator(NewRegex(qs.NodesAllIterator(), regexp.MustCompile("^a"), qs))
+
+	shape := make(map[string]interface{})
+	OutputQueryShapeForIterator(and, qs, shape)
+
+	nodes := shape["nodes"].([]Node)
+	if len(nodes) != 1 {
+		t.Fatalf("Failed to get correct number of nodes, got:%d expect:1", len(nodes))
+	}
+	expect := []string{"> 5", `prefix "ab"`, `regex "^a"`}
+	if !reflect.DeepEqual(nodes[0].Filters, expect) {
+		t.Errorf("Failed to get correct filters, got:%q expect:%q", nodes[0].Filters, expect)
+	}
+}
+
+func TestQueryShapeNot(t *testing.T) {
+	qs := &store{
+		data: []string{
+			1: "cool",
+			2: "abc",
+		},
+	}
+
+	excluded := qs.FixedIterator()
+	excluded.Add(qs.ValueOf("abc"))
+	excluded.Tagger().Add("left out")
+	not := NewNot(qs.NodesAllIterator(), excluded)
+	not.Tagger().Add("kept")
+
+	shape := make(map[string]interface{})
+	OutputQueryShapeForIterator(not, qs, shape)
+
+	nodes := shape["nodes"].([]Node)
+	if len(nodes) != 2 {
+		t.Fatalf("Failed to get correct number of nodes, got:%d expect:2", len(nodes))
+	}
+	var kept, left Node
+	for _, n := range nodes {
+		if n.IsExcluded {
+			left = n
+		} else {
+			kept = n
+		}
+	}
+	if !reflect.DeepEqual(kept.Tags, []string{"kept"}) {
+		t.Errorf("Failed to find the kept node, got:%+v", nodes)
+	}
+	if !reflect.DeepEqual(left.Tags, []string{"left out"}) || !reflect.DeepEqual(left.Values, []string{"abc"}) {
+		t.Errorf("Failed to find the excluded node, got:%+v", nodes)
+	}
+	links := shape["links"].([]Link)
+	if expect := []Link{{Source: kept.ID, Target: left.ID}}; !reflect.DeepEqual(links, expect) {
+		t.Errorf("Failed to link the excluded node, got:%v expect:%v", links, expect)
+	}
+}
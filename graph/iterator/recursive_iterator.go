@@ -0,0 +1,303 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// "Recursive" returns the transitive closure of a morphism over the nodes of
+// its subiterator: every node reached by applying the morphism to them once,
+// then to those nodes, and so on, up to a maximum depth. It works breadth
+// first, applying the morphism to all the nodes it found at one depth at once,
+// and returns each node once. The nodes it started from are only returned if
+// the morphism reaches them from another.
+//
+// Depth tags are tagged with the depth at which a node was first reached, as a
+// Depth rather than a value of the QuadStore.
+//
+// Contains() finds the whole closure, the first time it's called.
+
+import (
+	"strconv"
+
+	"github.com/google/cayley/graph"
+)
+
+// Morphism builds an iterator for the nodes reached from the nodes of an
+// iterator.
+type Morphism func(graph.Iterator) graph.Iterator
+
+// Depth is the value a Recursive iterator gives its depth tags: the number of
+// times the morphism was applied to reach a node.
+type Depth int
+
+// NameOf returns the name of a value, which may be a Depth or an Index rather
+// than a value of the QuadStore.
+func NameOf(qs graph.QuadStore, v graph.Value) string {
+	switch v := v.(type) {
+	case Depth:
+		return strconv.Itoa(int(v))
+	case Index:
+		return strconv.Itoa(int(v))
+	}
+	return qs.NameOf(v)
+}
+
+type Recursive struct {
+	uid       uint64
+	tags      graph.Tagger
+	depthTags []string
+	subIt     graph.Iterator
+	qs        graph.QuadStore
+	morphism  Morphism
+	maxDepth  int
+
+	started   bool
+	seen      map[interface{}]int
+	followed  map[interface{}]struct{}
+	frontier  []graph.Value
+	levelIt   graph.Iterator
+	depth     int
+	result    graph.Value
+	contained bool
+	kill      <-chan struct{}
+}
+
+// NewRecursive returns an iterator over the nodes reached by applying morphism
+// to the nodes of sub, recursively. If maxDepth is greater than zero, the
+// morphism is applied at most that many times.
+func NewRecursive(qs graph.QuadStore, sub graph.Iterator, morphism Morphism, maxDepth int) *Recursive {
+	return &Recursive{
+		uid:      NextUID(),
+		subIt:    sub,
+		qs:       qs,
+		morphism: morphism,
+		maxDepth: maxDepth,
+		seen:     make(map[interface{}]int),
+		followed: make(map[interface{}]struct{}),
+	}
+}
+
+func (it *Recursive) UID() uint64 {
+	return it.uid
+}
+
+// AddDepthTag adds a tag for the depth at which each node is reached.
+func (it *Recursive) AddDepthTag(tag string) {
+	it.depthTags = append(it.depthTags, tag)
+}
+
+// SetKill stops the Recursive from going deeper once kill is closed.
+func (it *Recursive) SetKill(kill <-chan struct{}) {
+	it.kill = kill
+}
+
+func (it *Recursive) Reset() {
+	it.subIt.Reset()
+	it.closeLevel()
+	it.started = false
+	it.seen = make(map[interface{}]int)
+	it.followed = make(map[interface{}]struct{})
+	it.frontier = nil
+	it.depth = 0
+	it.result = nil
+	it.contained = false
+}
+
+func (it *Recursive) closeLevel() {
+	if it.levelIt != nil {
+		it.levelIt.Close()
+		it.levelIt = nil
+	}
+}
+
+func (it *Recursive) Close() {
+	it.subIt.Close()
+	it.closeLevel()
+	it.seen = nil
+	it.followed = nil
+	it.frontier = nil
+}
+
+func (it *Recursive) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Recursive) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+
+	if it.result == nil {
+		return
+	}
+	depth := Depth(it.seen[valueKey(it.result)])
+	for _, tag := range it.depthTags {
+		dst[tag] = depth
+	}
+	if !it.contained && it.levelIt != nil {
+		it.levelIt.TagResults(dst)
+	}
+}
+
+func (it *Recursive) Clone() graph.Iterator {
+	out := NewRecursive(it.qs, it.subIt.Clone(), it.morphism, it.maxDepth)
+	out.tags.CopyFrom(it)
+	out.depthTags = append(out.depthTags, it.depthTags...)
+	return out
+}
+
+// step finds the next node of the closure, returning false once there are no
+// more. Seen nodes have been reached at some depth, while followed nodes have
+// been put in the frontier, as the start nodes are before they are reached.
+func (it *Recursive) step() bool {
+	if !it.started {
+		it.started = true
+		for graph.Next(it.subIt) {
+			if graph.Killed(it.kill) {
+				return false
+			}
+			val := it.subIt.Result()
+			k := valueKey(val)
+			if _, ok := it.followed[k]; !ok {
+				it.followed[k] = struct{}{}
+				it.frontier = append(it.frontier, val)
+			}
+		}
+	}
+	for {
+		if it.levelIt == nil {
+			if len(it.frontier) == 0 || (it.maxDepth > 0 && it.depth >= it.maxDepth) {
+				return false
+			}
+			it.depth++
+			fixed := it.qs.FixedIterator()
+			for _, val := range it.frontier {
+				fixed.Add(val)
+			}
+			it.frontier = nil
+			it.levelIt, _ = it.morphism(fixed).Optimize()
+			graph.SetKill(it.levelIt, it.kill)
+		}
+		for graph.Next(it.levelIt) {
+			if graph.Killed(it.kill) {
+				return false
+			}
+			val := it.levelIt.Result()
+			k := valueKey(val)
+			if _, ok := it.seen[k]; ok {
+				continue
+			}
+			it.seen[k] = it.depth
+			if _, ok := it.followed[k]; !ok {
+				it.followed[k] = struct{}{}
+				it.frontier = append(it.frontier, val)
+			}
+			it.result = val
+			return true
+		}
+		it.closeLevel()
+	}
+}
+
+func (it *Recursive) Next() bool {
+	graph.NextLogIn(it)
+	it.contained = false
+	if !it.step() {
+		it.result = nil
+		return graph.NextLogOut(it, nil, false)
+	}
+	return graph.NextLogOut(it, it.result, true)
+}
+
+// DEPRECATED
+func (it *Recursive) ResultTree() *graph.ResultTree {
+	tree := graph.NewResultTree(it.Result())
+	tree.AddSubtree(it.subIt.ResultTree())
+	return tree
+}
+
+func (it *Recursive) Result() graph.Value {
+	return it.result
+}
+
+func (it *Recursive) NextPath() bool {
+	return false
+}
+
+// Return our sole subiterator.
+func (it *Recursive) SubIterators() []graph.Iterator {
+	return []graph.Iterator{it.subIt}
+}
+
+func (it *Recursive) Contains(val graph.Value) bool {
+	graph.ContainsLogIn(it, val)
+	it.contained = true
+	k := valueKey(val)
+	for {
+		if _, ok := it.seen[k]; ok {
+			it.result = val
+			return graph.ContainsLogOut(it, val, true)
+		}
+		if !it.step() {
+			break
+		}
+	}
+	it.result = nil
+	return graph.ContainsLogOut(it, val, false)
+}
+
+func (it *Recursive) Type() graph.Type { return graph.Recursive }
+
+func (it *Recursive) Describe() graph.Description {
+	primary := it.subIt.Describe()
+	size, _ := it.Size()
+	return graph.Description{
+		UID:      it.UID(),
+		Type:     it.Type(),
+		Tags:     it.tags.Tags(),
+		Size:     size,
+		Iterator: &primary,
+	}
+}
+
+// Optimize the subiterator. The morphism is optimized at each depth, as it is
+// built.
+func (it *Recursive) Optimize() (graph.Iterator, bool) {
+	newSub, changed := it.subIt.Optimize()
+	if changed {
+		it.subIt.Close()
+		it.subIt = newSub
+	}
+	return it, false
+}
+
+// Each depth costs a run of the morphism. Guess that the closure is a good deal
+// larger than where it starts.
+func (it *Recursive) Stats() graph.IteratorStats {
+	stats := it.subIt.Stats()
+	size, _ := it.Size()
+	return graph.IteratorStats{
+		NextCost:     stats.NextCost * 10,
+		ContainsCost: stats.NextCost * size,
+		Size:         size,
+	}
+}
+
+func (it *Recursive) Size() (int64, bool) {
+	size, _ := it.subIt.Size()
+	return size * 10, false
+}
@@ -0,0 +1,187 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// "Regex" is a unary operator -- a filter across the values in the relevant
+// subiterator, keeping those whose names match a regular expression.
+//
+// Like Comparison, at worst we're as big as our underlying iterator.
+
+import (
+	"regexp"
+
+	"github.com/google/cayley/graph"
+)
+
+type Regex struct {
+	uid    uint64
+	tags   graph.Tagger
+	subIt  graph.Iterator
+	re     *regexp.Regexp
+	qs     graph.QuadStore
+	result graph.Value
+	kill   <-chan struct{}
+}
+
+func NewRegex(sub graph.Iterator, re *regexp.Regexp, qs graph.QuadStore) *Regex {
+	return &Regex{
+		uid:   NextUID(),
+		subIt: sub,
+		re:    re,
+		qs:    qs,
+	}
+}
+
+func (it *Regex) UID() uint64 {
+	return it.uid
+}
+
+// Regexp returns the regular expression names are matched against.
+func (it *Regex) Regexp() *regexp.Regexp { return it.re }
+
+func (it *Regex) matches(val graph.Value) bool {
+	return it.re.MatchString(it.qs.NameOf(val))
+}
+
+// SetKill stops the Regex from checking further values once kill is closed.
+func (it *Regex) SetKill(kill <-chan struct{}) {
+	it.kill = kill
+}
+
+func (it *Regex) Close() {
+	it.subIt.Close()
+}
+
+func (it *Regex) Reset() {
+	it.subIt.Reset()
+}
+
+func (it *Regex) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Regex) Clone() graph.Iterator {
+	out := NewRegex(it.subIt.Clone(), it.re, it.qs)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *Regex) Next() bool {
+	graph.NextLogIn(it)
+	for graph.Next(it.subIt) {
+		if graph.Killed(it.kill) {
+			return graph.NextLogOut(it, nil, false)
+		}
+		val := it.subIt.Result()
+		if it.matches(val) {
+			it.result = val
+			return graph.NextLogOut(it, val, true)
+		}
+	}
+	return graph.NextLogOut(it, nil, false)
+}
+
+// DEPRECATED
+func (it *Regex) ResultTree() *graph.ResultTree {
+	return graph.NewResultTree(it.Result())
+}
+
+func (it *Regex) Result() graph.Value {
+	return it.result
+}
+
+func (it *Regex) NextPath() bool {
+	for {
+		hasNext := it.subIt.NextPath()
+		if !hasNext {
+			return false
+		}
+		if it.matches(it.subIt.Result()) {
+			break
+		}
+	}
+	it.result = it.subIt.Result()
+	return true
+}
+
+// Return our sole subiterator.
+func (it *Regex) SubIterators() []graph.Iterator {
+	return []graph.Iterator{it.subIt}
+}
+
+func (it *Regex) Contains(val graph.Value) bool {
+	graph.ContainsLogIn(it, val)
+	if !it.matches(val) {
+		return graph.ContainsLogOut(it, val, false)
+	}
+	if !it.subIt.Contains(val) {
+		return graph.ContainsLogOut(it, val, false)
+	}
+	it.result = val
+	return graph.ContainsLogOut(it, val, true)
+}
+
+// If we failed the check, then the subiterator should not contribute to the result
+// set. Otherwise, go ahead and tag it.
+func (it *Regex) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+
+	it.subIt.TagResults(dst)
+}
+
+func (it *Regex) Type() graph.Type { return graph.Regex }
+
+func (it *Regex) Describe() graph.Description {
+	primary := it.subIt.Describe()
+	return graph.Description{
+		UID:      it.UID(),
+		Name:     it.re.String(),
+		Type:     it.Type(),
+		Iterator: &primary,
+	}
+}
+
+// There's nothing to optimize, locally, for a regex iterator.
+// Replace the underlying iterator if need be, then give the QuadStore the
+// chance to replace us.
+func (it *Regex) Optimize() (graph.Iterator, bool) {
+	newSub, changed := it.subIt.Optimize()
+	if changed {
+		it.subIt.Close()
+		it.subIt = newSub
+	}
+	newReplacement, hasOne := it.qs.OptimizeIterator(it)
+	if hasOne {
+		it.Close()
+		return newReplacement, true
+	}
+	return it, false
+}
+
+// We're only as expensive as our subiterator.
+func (it *Regex) Stats() graph.IteratorStats {
+	return it.subIt.Stats()
+}
+
+func (it *Regex) Size() (int64, bool) {
+	size, _ := it.subIt.Size()
+	return size, false
+}
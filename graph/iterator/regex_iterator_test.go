@@ -0,0 +1,71 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var regexTests = []struct {
+	message string
+	expr    string
+	expect  []string
+}{
+	{
+		message: "match a prefix",
+		expr:    "^ab",
+		expect:  []string{"abc", "abd"},
+	},
+	{
+		message: "match a decimal",
+		expr:    `^\d+\.\d+$`,
+		expect:  []string{"2.5"},
+	},
+	{
+		message: "match nothing",
+		expr:    "^z",
+		expect:  nil,
+	},
+}
+
+func TestRegex(t *testing.T) {
+	for _, test := range regexTests {
+		qs := typedStore
+		it := NewRegex(typedFixedIterator(), regexp.MustCompile(test.expr), qs)
+
+		var got []string
+		for it.Next() {
+			got = append(got, qs.NameOf(it.Result()))
+		}
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s, got:%q expect:%q", test.message, got, test.expect)
+		}
+	}
+}
+
+func TestRegexContains(t *testing.T) {
+	it := NewRegex(typedFixedIterator(), regexp.MustCompile("^ab"), typedStore)
+	if !it.Contains(typedStore.ValueOf("abd")) {
+		t.Error("Failed to contain a matching value")
+	}
+	if it.Result() != typedStore.ValueOf("abd") {
+		t.Errorf("Failed to keep the contained value, got:%v", it.Result())
+	}
+	if it.Contains(typedStore.ValueOf("b")) {
+		t.Error("Unexpectedly contained a value that does not match")
+	}
+}
@@ -0,0 +1,428 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// "ShortestPath" searches the graph for how nodes are connected. For each
+// node of its start iterator, it finds a shortest path following quads from
+// subject to object to any node of its goal iterator, and returns the nodes
+// along it, in order, from the start to the goal. Every node on a path is
+// tagged with the tags of its start and goal.
+//
+// As paths may share nodes, path tags are tagged with the number of the path a
+// node is on, and step tags with its place along it, both counting from 0 and
+// as an Index rather than a value of the QuadStore.
+//
+// The search is a breadth-first search from both ends at once, widening
+// whichever side has fewer nodes to look at. It may be limited to quads with
+// some predicates, and to paths of at most a number of quads.
+//
+// When first Next()ed or Contains()ed, it finds every path. Contains() checks
+// whether a node is on any of them, and gives it the tags of the first path it
+// is on.
+
+import (
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
+)
+
+// Index is the value a ShortestPath iterator gives its path and step tags.
+type Index int
+
+type ShortestPath struct {
+	uid      uint64
+	tags     graph.Tagger
+	pathTags []string
+	stepTags []string
+	qs       graph.QuadStore
+	startIt  graph.Iterator
+	goalIt   graph.Iterator
+	preds    map[interface{}]bool
+	predVals []graph.Value
+	maxDepth int
+	hasRun   bool
+	paths    []foundPath
+	onPath   map[interface{}]pathStep
+	index    int
+	node     int
+	result   graph.Value
+	kill     <-chan struct{}
+}
+
+type foundPath struct {
+	nodes []graph.Value
+	tags  map[string]graph.Value
+}
+
+// pathStep is where a node is first found on the paths.
+type pathStep struct {
+	path, step int
+}
+
+// NewShortestPath returns an iterator over the shortest paths from the nodes
+// of start to those of goal. If preds is not nil, only quads with one of
+// those predicates are followed. If maxDepth is greater than zero, paths are
+// at most that many quads long.
+func NewShortestPath(qs graph.QuadStore, start, goal graph.Iterator, preds []graph.Value, maxDepth int) *ShortestPath {
+	it := &ShortestPath{
+		uid:      NextUID(),
+		qs:       qs,
+		startIt:  start,
+		goalIt:   goal,
+		predVals: preds,
+		maxDepth: maxDepth,
+	}
+	if preds != nil {
+		it.preds = make(map[interface{}]bool, len(preds))
+		for _, p := range preds {
+			it.preds[valueKey(p)] = true
+		}
+	}
+	return it
+}
+
+// valueKey returns a comparable key for a value.
+func valueKey(v graph.Value) interface{} {
+	if k, ok := v.(Keyer); ok {
+		return k.Key()
+	}
+	return v
+}
+
+func (it *ShortestPath) UID() uint64 {
+	return it.uid
+}
+
+// AddPathTag adds a tag for the number of the path each node is on.
+func (it *ShortestPath) AddPathTag(tag string) {
+	it.pathTags = append(it.pathTags, tag)
+}
+
+// AddStepTag adds a tag for the place of each node along its path.
+func (it *ShortestPath) AddStepTag(tag string) {
+	it.stepTags = append(it.stepTags, tag)
+}
+
+// SetKill stops the ShortestPath from searching further once kill is closed.
+func (it *ShortestPath) SetKill(kill <-chan struct{}) {
+	it.kill = kill
+}
+
+func (it *ShortestPath) Reset() {
+	it.index = 0
+	it.node = -1
+}
+
+func (it *ShortestPath) Close() {
+	it.startIt.Close()
+	it.goalIt.Close()
+	it.paths = nil
+	it.onPath = nil
+	it.hasRun = false
+}
+
+func (it *ShortestPath) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *ShortestPath) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+
+	if it.index < len(it.paths) {
+		for tag, value := range it.paths[it.index].tags {
+			dst[tag] = value
+		}
+		for _, tag := range it.pathTags {
+			dst[tag] = Index(it.index)
+		}
+		for _, tag := range it.stepTags {
+			dst[tag] = Index(it.node)
+		}
+	}
+}
+
+func (it *ShortestPath) Clone() graph.Iterator {
+	out := NewShortestPath(it.qs, it.startIt.Clone(), it.goalIt.Clone(), it.predVals, it.maxDepth)
+	out.tags.CopyFrom(it)
+	out.pathTags = append(out.pathTags, it.pathTags...)
+	out.stepTags = append(out.stepTags, it.stepTags...)
+	return out
+}
+
+// visit records how a node was reached by one side of the search.
+type visit struct {
+	node  graph.Value
+	next  interface{} // The key of the node before it, toward its own side's end.
+	depth int
+	end   bool
+}
+
+// search finds the paths from every start node.
+func (it *ShortestPath) search() {
+	it.hasRun = true
+	it.onPath = make(map[interface{}]pathStep)
+	it.index = 0
+	it.node = -1
+
+	goals := make(map[interface{}]visit)
+	goalTags := make(map[interface{}]map[string]graph.Value)
+	var goalKeys []interface{}
+	for graph.Next(it.goalIt) {
+		if graph.Killed(it.kill) {
+			return
+		}
+		val := it.goalIt.Result()
+		k := valueKey(val)
+		if _, ok := goals[k]; ok {
+			continue
+		}
+		goals[k] = visit{node: val, end: true}
+		goalKeys = append(goalKeys, k)
+		tags := make(map[string]graph.Value)
+		it.goalIt.TagResults(tags)
+		goalTags[k] = tags
+	}
+	if len(goals) == 0 {
+		return
+	}
+
+	for graph.Next(it.startIt) {
+		if graph.Killed(it.kill) {
+			return
+		}
+		nodes := it.searchFrom(it.startIt.Result(), goals, goalKeys)
+		if nodes == nil {
+			continue
+		}
+		p := foundPath{nodes: nodes, tags: make(map[string]graph.Value)}
+		it.startIt.TagResults(p.tags)
+		for tag, value := range goalTags[valueKey(nodes[len(nodes)-1])] {
+			p.tags[tag] = value
+		}
+		for i, n := range nodes {
+			if _, ok := it.onPath[valueKey(n)]; !ok {
+				it.onPath[valueKey(n)] = pathStep{path: len(it.paths), step: i}
+			}
+		}
+		it.paths = append(it.paths, p)
+	}
+}
+
+// searchFrom returns the nodes of a shortest path from start to any of the
+// goals, or nil if there is none. Between paths as short, it picks the same
+// one each time, following the order of the goals and of the quads.
+func (it *ShortestPath) searchFrom(start graph.Value, goals map[interface{}]visit, goalKeys []interface{}) []graph.Value {
+	startKey := valueKey(start)
+	if _, ok := goals[startKey]; ok {
+		return []graph.Value{start}
+	}
+	fwd := map[interface{}]visit{startKey: {node: start, end: true}}
+	bwd := make(map[interface{}]visit, len(goals))
+	fwdFrontier := []interface{}{startKey}
+	bwdFrontier := append([]interface{}(nil), goalKeys...)
+	for k, v := range goals {
+		bwd[k] = v
+	}
+
+	depth := 0
+	for len(fwdFrontier) > 0 && len(bwdFrontier) > 0 {
+		if it.maxDepth > 0 && depth >= it.maxDepth {
+			return nil
+		}
+		depth++
+		var meet interface{}
+		if len(fwdFrontier) <= len(bwdFrontier) {
+			fwdFrontier, meet = it.widen(fwdFrontier, fwd, bwd, quad.Subject, quad.Object)
+		} else {
+			bwdFrontier, meet = it.widen(bwdFrontier, bwd, fwd, quad.Object, quad.Subject)
+		}
+		if graph.Killed(it.kill) {
+			return nil
+		}
+		if meet != nil {
+			return joinPath(meet, fwd, bwd)
+		}
+	}
+	return nil
+}
+
+// widen moves one side of the search a step further, from each node of its
+// frontier in the direction from, to the nodes in the direction to. It
+// returns the new frontier, and the key of a node where the two sides meet on
+// a shortest path, if they do.
+func (it *ShortestPath) widen(frontier []interface{}, seen, other map[interface{}]visit, from, to quad.Direction) ([]interface{}, interface{}) {
+	var (
+		next     []interface{}
+		meet     interface{}
+		meetCost int
+	)
+	for _, k := range frontier {
+		v := seen[k]
+		quads := it.qs.QuadIterator(from, v.node)
+		for graph.Next(quads) {
+			if graph.Killed(it.kill) {
+				quads.Close()
+				return nil, nil
+			}
+			q := quads.Result()
+			if it.preds != nil && !it.preds[valueKey(it.qs.QuadDirection(q, quad.Predicate))] {
+				continue
+			}
+			node := it.qs.QuadDirection(q, to)
+			nk := valueKey(node)
+			if _, ok := seen[nk]; ok {
+				continue
+			}
+			seen[nk] = visit{node: node, next: k, depth: v.depth + 1}
+			next = append(next, nk)
+			if o, ok := other[nk]; ok && (meet == nil || o.depth < meetCost) {
+				meet, meetCost = nk, o.depth
+			}
+		}
+		quads.Close()
+	}
+	return next, meet
+}
+
+// joinPath returns the path through the node with key meet, from the start
+// of the forward search to the goal of the backward one.
+func joinPath(meet interface{}, fwd, bwd map[interface{}]visit) []graph.Value {
+	var nodes []graph.Value
+	for k := meet; ; {
+		v := fwd[k]
+		nodes = append(nodes, v.node)
+		if v.end {
+			break
+		}
+		k = v.next
+	}
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+	for k := meet; !bwd[k].end; {
+		k = bwd[k].next
+		nodes = append(nodes, bwd[k].node)
+	}
+	return nodes
+}
+
+func (it *ShortestPath) Next() bool {
+	graph.NextLogIn(it)
+	if !it.hasRun {
+		it.search()
+	}
+	for it.index < len(it.paths) {
+		it.node++
+		if it.node < len(it.paths[it.index].nodes) {
+			it.result = it.paths[it.index].nodes[it.node]
+			return graph.NextLogOut(it, it.result, true)
+		}
+		it.index++
+		it.node = -1
+	}
+	return graph.NextLogOut(it, nil, false)
+}
+
+// DEPRECATED
+func (it *ShortestPath) ResultTree() *graph.ResultTree {
+	return graph.NewResultTree(it.Result())
+}
+
+func (it *ShortestPath) Result() graph.Value {
+	return it.result
+}
+
+func (it *ShortestPath) NextPath() bool {
+	return false
+}
+
+// Return the start and goal subiterators.
+func (it *ShortestPath) SubIterators() []graph.Iterator {
+	return []graph.Iterator{it.startIt, it.goalIt}
+}
+
+func (it *ShortestPath) Contains(val graph.Value) bool {
+	graph.ContainsLogIn(it, val)
+	if !it.hasRun {
+		it.search()
+	}
+	at, ok := it.onPath[valueKey(val)]
+	if !ok {
+		return graph.ContainsLogOut(it, val, false)
+	}
+	it.index = at.path
+	it.node = at.step
+	it.result = val
+	return graph.ContainsLogOut(it, val, true)
+}
+
+func (it *ShortestPath) Type() graph.Type { return graph.ShortestPath }
+
+func (it *ShortestPath) Describe() graph.Description {
+	size, _ := it.Size()
+	return graph.Description{
+		UID:       it.UID(),
+		Type:      it.Type(),
+		Tags:      it.tags.Tags(),
+		Size:      size,
+		Iterators: []graph.Description{it.startIt.Describe(), it.goalIt.Describe()},
+	}
+}
+
+// Optimize the start and goal iterators.
+func (it *ShortestPath) Optimize() (graph.Iterator, bool) {
+	newStart, changed := it.startIt.Optimize()
+	if changed {
+		it.startIt.Close()
+		it.startIt = newStart
+	}
+	newGoal, changed := it.goalIt.Optimize()
+	if changed {
+		it.goalIt.Close()
+		it.goalIt = newGoal
+	}
+	return it, false
+}
+
+// A search looks at a good part of the graph for each start, so we are
+// expensive. Guess that a path is a few nodes long.
+func (it *ShortestPath) Stats() graph.IteratorStats {
+	start := it.startIt.Stats()
+	goal := it.goalIt.Stats()
+	size, _ := it.Size()
+	cost := (start.NextCost + goal.NextCost) * (it.qs.Size() + 1)
+	return graph.IteratorStats{
+		NextCost:     cost,
+		ContainsCost: cost,
+		Size:         size,
+	}
+}
+
+func (it *ShortestPath) Size() (int64, bool) {
+	if it.hasRun {
+		var n int64
+		for _, p := range it.paths {
+			n += int64(len(p.nodes))
+		}
+		return n, true
+	}
+	size, _ := it.startIt.Size()
+	return size * 3, false
+}
@@ -0,0 +1,175 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// "Skip" drops the first results of its subiterator, counting each result of
+// Next() and each further path of NextPath(), as Limit does. Contains() only
+// accepts the values that Next() would return.
+
+import (
+	"github.com/google/cayley/graph"
+)
+
+type Skip struct {
+	uid       uint64
+	tags      graph.Tagger
+	skip      int64
+	skipped   int64
+	primaryIt graph.Iterator
+	emitted   map[interface{}]struct{}
+}
+
+func NewSkip(primaryIt graph.Iterator, skip int64) *Skip {
+	return &Skip{
+		uid:       NextUID(),
+		skip:      skip,
+		primaryIt: primaryIt,
+	}
+}
+
+func (it *Skip) UID() uint64 {
+	return it.uid
+}
+
+func (it *Skip) Reset() {
+	it.primaryIt.Reset()
+	it.skipped = 0
+}
+
+func (it *Skip) Close() {
+	it.primaryIt.Close()
+}
+
+func (it *Skip) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Skip) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+
+	it.primaryIt.TagResults(dst)
+}
+
+func (it *Skip) Clone() graph.Iterator {
+	out := NewSkip(it.primaryIt.Clone(), it.skip)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *Skip) Next() bool {
+	graph.NextLogIn(it)
+	for it.skipped < it.skip {
+		if !graph.Next(it.primaryIt) {
+			return graph.NextLogOut(it, nil, false)
+		}
+		it.skipped++
+		for it.primaryIt.NextPath() {
+			if it.skipped == it.skip {
+				// The rest of this result's paths are not skipped.
+				return graph.NextLogOut(it, it.Result(), true)
+			}
+			it.skipped++
+		}
+	}
+	if !graph.Next(it.primaryIt) {
+		return graph.NextLogOut(it, nil, false)
+	}
+	return graph.NextLogOut(it, it.Result(), true)
+}
+
+// DEPRECATED
+func (it *Skip) ResultTree() *graph.ResultTree {
+	tree := graph.NewResultTree(it.Result())
+	tree.AddSubtree(it.primaryIt.ResultTree())
+	return tree
+}
+
+func (it *Skip) Result() graph.Value {
+	return it.primaryIt.Result()
+}
+
+// Next() has done all the skipping by the time there is a path to follow.
+func (it *Skip) NextPath() bool {
+	return it.primaryIt.NextPath()
+}
+
+// Return our sole subiterator.
+func (it *Skip) SubIterators() []graph.Iterator {
+	return []graph.Iterator{it.primaryIt}
+}
+
+func (it *Skip) Contains(val graph.Value) bool {
+	graph.ContainsLogIn(it, val)
+	if it.emitted == nil {
+		it.emitted = emitted(it)
+	}
+	if _, ok := it.emitted[valueKey(val)]; !ok {
+		return graph.ContainsLogOut(it, val, false)
+	}
+	return graph.ContainsLogOut(it, val, it.primaryIt.Contains(val))
+}
+
+func (it *Skip) Type() graph.Type { return graph.Skip }
+
+func (it *Skip) Describe() graph.Description {
+	primary := it.primaryIt.Describe()
+	size, _ := it.Size()
+	return graph.Description{
+		UID:      it.UID(),
+		Type:     it.Type(),
+		Tags:     it.tags.Tags(),
+		Size:     size,
+		Iterator: &primary,
+	}
+}
+
+// Optimize the subiterator. Skipping nothing, we may as well be it.
+func (it *Skip) Optimize() (graph.Iterator, bool) {
+	newPrimary, changed := it.primaryIt.Optimize()
+	if changed {
+		it.primaryIt.Close()
+		it.primaryIt = newPrimary
+	}
+	if it.skip <= 0 {
+		it.primaryIt.Tagger().CopyFrom(it)
+		return it.primaryIt, true
+	}
+	return it, false
+}
+
+// We cost as much as our subiterator, but return fewer results.
+func (it *Skip) Stats() graph.IteratorStats {
+	stats := it.primaryIt.Stats()
+	stats.Size -= it.skip
+	if stats.Size < 0 {
+		stats.Size = 0
+	}
+	return stats
+}
+
+func (it *Skip) Size() (int64, bool) {
+	size, exact := it.primaryIt.Size()
+	size -= it.skip
+	if size < 0 {
+		size = 0
+	}
+	return size, exact
+}
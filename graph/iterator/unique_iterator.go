@@ -0,0 +1,312 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+// "Unique" drops the results of its subiterator's Next() which it has seen
+// before, returning each value along a single path: NextPath() has nothing
+// more to give. Contains() is passed straight through.
+//
+// To return every path to each value once, lead an And with a Unique over a
+// clone of the iterator, so that the iterator itself is Contains()ed.
+//
+// Past uniqueMaxSeen values, the values seen are spilled to a temporary file,
+// as a sorted list of the SHA-1 sums of their names, which later values are
+// looked up in. Once spilled, values are told apart by name alone.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/barakmich/glog"
+
+	"github.com/google/cayley/graph"
+)
+
+var uniqueMaxSeen = 1 << 20
+
+type Unique struct {
+	uid     uint64
+	tags    graph.Tagger
+	subIt   graph.Iterator
+	qs      graph.QuadStore
+	seen    map[interface{}]graph.Value
+	spillAt int
+	spills  []uniqueSpill
+	result  graph.Value
+	kill    <-chan struct{}
+}
+
+// uniqueSpill is a file of the sorted sums of the names of n values.
+type uniqueSpill struct {
+	f *os.File
+	n int
+}
+
+// NewUnique returns an iterator over the values of sub, each once. The
+// QuadStore names the values it spills.
+func NewUnique(sub graph.Iterator, qs graph.QuadStore) *Unique {
+	return &Unique{
+		uid:     NextUID(),
+		subIt:   sub,
+		qs:      qs,
+		seen:    make(map[interface{}]graph.Value),
+		spillAt: uniqueMaxSeen,
+	}
+}
+
+func (it *Unique) UID() uint64 {
+	return it.uid
+}
+
+// SetKill stops the Unique from skipping further duplicates once kill is closed.
+func (it *Unique) SetKill(kill <-chan struct{}) {
+	it.kill = kill
+}
+
+func (it *Unique) Reset() {
+	it.subIt.Reset()
+	it.removeSpills()
+	it.seen = make(map[interface{}]graph.Value)
+	it.spillAt = uniqueMaxSeen
+	it.result = nil
+}
+
+func (it *Unique) Close() {
+	it.subIt.Close()
+	it.removeSpills()
+	it.seen = nil
+}
+
+func (it *Unique) removeSpills() {
+	for _, s := range it.spills {
+		s.f.Close()
+		os.Remove(s.f.Name())
+	}
+	it.spills = nil
+}
+
+func (it *Unique) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Unique) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+
+	it.subIt.TagResults(dst)
+}
+
+func (it *Unique) Clone() graph.Iterator {
+	out := NewUnique(it.subIt.Clone(), it.qs)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+// isNew records val as seen, returning whether it had not been before.
+func (it *Unique) isNew(val graph.Value) bool {
+	key := interface{}(val)
+	if h, ok := val.(Keyer); ok {
+		key = h.Key()
+	}
+	if _, ok := it.seen[key]; ok {
+		return false
+	}
+	if len(it.spills) > 0 {
+		sum := sha1.Sum([]byte(NameOf(it.qs, val)))
+		for _, s := range it.spills {
+			if s.contains(sum) {
+				return false
+			}
+		}
+	}
+	it.seen[key] = val
+	if len(it.seen) >= it.spillAt {
+		it.spill()
+	}
+	return true
+}
+
+// spill writes the values held in memory to a file. If it can't, they stay
+// in memory until there are as many again.
+func (it *Unique) spill() {
+	sums := make([][sha1.Size]byte, 0, len(it.seen))
+	for _, v := range it.seen {
+		sums = append(sums, sha1.Sum([]byte(NameOf(it.qs, v))))
+	}
+	sort.Sort(bySum(sums))
+	f, err := ioutil.TempFile("", "cayley-unique")
+	if err != nil {
+		glog.Errorf("Couldn't spill unique values: %v", err)
+		it.spillAt += uniqueMaxSeen
+		return
+	}
+	w := bufio.NewWriter(f)
+	for _, sum := range sums {
+		if _, err = w.Write(sum[:]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		glog.Errorf("Couldn't spill unique values: %v", err)
+		f.Close()
+		os.Remove(f.Name())
+		it.spillAt += uniqueMaxSeen
+		return
+	}
+	it.spills = append(it.spills, uniqueSpill{f: f, n: len(sums)})
+	it.seen = make(map[interface{}]graph.Value)
+	it.spillAt = uniqueMaxSeen
+}
+
+// contains searches the spilled sums for sum.
+func (s uniqueSpill) contains(sum [sha1.Size]byte) bool {
+	var (
+		buf [sha1.Size]byte
+		err error
+	)
+	i := sort.Search(s.n, func(i int) bool {
+		if err != nil {
+			return true
+		}
+		_, err = s.f.ReadAt(buf[:], int64(i)*sha1.Size)
+		return bytes.Compare(buf[:], sum[:]) >= 0
+	})
+	if err != nil {
+		glog.Errorf("Couldn't read spilled unique values: %v", err)
+		return false
+	}
+	if i == s.n {
+		return false
+	}
+	if _, err := s.f.ReadAt(buf[:], int64(i)*sha1.Size); err != nil {
+		glog.Errorf("Couldn't read spilled unique values: %v", err)
+		return false
+	}
+	return buf == sum
+}
+
+type bySum [][sha1.Size]byte
+
+func (s bySum) Len() int           { return len(s) }
+func (s bySum) Less(i, j int) bool { return bytes.Compare(s[i][:], s[j][:]) < 0 }
+func (s bySum) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (it *Unique) Next() bool {
+	graph.NextLogIn(it)
+	for graph.Next(it.subIt) {
+		if graph.Killed(it.kill) {
+			break
+		}
+		val := it.subIt.Result()
+		if it.isNew(val) {
+			it.result = val
+			return graph.NextLogOut(it, val, true)
+		}
+	}
+	return graph.NextLogOut(it, nil, false)
+}
+
+// DEPRECATED
+func (it *Unique) ResultTree() *graph.ResultTree {
+	tree := graph.NewResultTree(it.Result())
+	tree.AddSubtree(it.subIt.ResultTree())
+	return tree
+}
+
+func (it *Unique) Result() graph.Value {
+	return it.result
+}
+
+func (it *Unique) NextPath() bool {
+	return false
+}
+
+// Return our sole subiterator.
+func (it *Unique) SubIterators() []graph.Iterator {
+	return []graph.Iterator{it.subIt}
+}
+
+func (it *Unique) Contains(val graph.Value) bool {
+	graph.ContainsLogIn(it, val)
+	if it.subIt.Contains(val) {
+		it.result = val
+		return graph.ContainsLogOut(it, val, true)
+	}
+	return graph.ContainsLogOut(it, val, false)
+}
+
+func (it *Unique) Type() graph.Type { return graph.Unique }
+
+func (it *Unique) Describe() graph.Description {
+	primary := it.subIt.Describe()
+	size, _ := it.Size()
+	return graph.Description{
+		UID:      it.UID(),
+		Type:     it.Type(),
+		Tags:     it.tags.Tags(),
+		Size:     size,
+		Iterator: &primary,
+	}
+}
+
+// Optimize the subiterator. If it already returns every value once, there is
+// nothing for us to do.
+func (it *Unique) Optimize() (graph.Iterator, bool) {
+	newSub, changed := it.subIt.Optimize()
+	if changed {
+		it.subIt.Close()
+		it.subIt = newSub
+	}
+	if isUnique(it.subIt) {
+		it.subIt.Tagger().CopyFrom(it)
+		return it.subIt, true
+	}
+	return it, false
+}
+
+// isUnique returns whether it is known to return each value once, along a
+// single path.
+func isUnique(it graph.Iterator) bool {
+	switch it.Type() {
+	case graph.All, graph.Unique:
+		return true
+	case graph.Comparison, graph.Regex, graph.Limit, graph.Skip:
+		// These only filter the values of their subiterator.
+		return isUnique(it.SubIterators()[0])
+	}
+	return false
+}
+
+// We cost as much as our subiterator, and may return as many values.
+func (it *Unique) Stats() graph.IteratorStats {
+	return it.subIt.Stats()
+}
+
+func (it *Unique) Size() (int64, bool) {
+	size, _ := it.subIt.Size()
+	return size, false
+}
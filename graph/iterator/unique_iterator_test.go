@@ -0,0 +1,83 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cayley/graph"
+)
+
+func TestUniqueIteratorBasics(t *testing.T) {
+	f := NewFixed(Identity)
+	for _, v := range []int{1, 2, 1, 3, 2, 1} {
+		f.Add(v)
+	}
+	u := NewUnique(f, nil)
+
+	expect := []int{1, 2, 3}
+	for i := 0; i < 2; i++ {
+		if got := iterated(u); !reflect.DeepEqual(got, expect) {
+			t.Errorf("Failed to iterate Unique correctly on repeat %d, got:%v expect:%v", i, got, expect)
+		}
+		u.Reset()
+	}
+
+	if !u.Contains(3) || u.Contains(4) {
+		t.Error("Failed to pass Contains through to the subiterator")
+	}
+}
+
+func TestUniqueIteratorSpill(t *testing.T) {
+	defer func(n int) { uniqueMaxSeen = n }(uniqueMaxSeen)
+	uniqueMaxSeen = 2
+
+	qs := &store{data: []string{"0", "1", "2", "3", "4"}}
+	f := NewFixed(Identity)
+	for _, v := range []int{1, 2, 1, 3, 2, 4, 3, 1, 4} {
+		f.Add(v)
+	}
+	u := NewUnique(f, qs)
+	expect := []int{1, 2, 3, 4}
+	for i := 0; i < 2; i++ {
+		if got := iterated(u); !reflect.DeepEqual(got, expect) {
+			t.Errorf("Failed to remove duplicates past the bound on repeat %d, got:%v expect:%v", i, got, expect)
+		}
+		if len(u.spills) == 0 {
+			t.Errorf("Expected values to be spilled on repeat %d", i)
+		}
+		u.Reset()
+	}
+	u.Close()
+}
+
+func TestUniqueIteratorOptimize(t *testing.T) {
+	u := NewUnique(NewInt64(1, 3), nil)
+	u.Tagger().Add("foo")
+	opt, changed := u.Optimize()
+	if !changed || opt.Type() != graph.All {
+		t.Errorf("Failed to drop Unique over an all iterator, got:%v", opt.Type())
+	}
+	if tags := opt.Tagger().Tags(); !reflect.DeepEqual(tags, []string{"foo"}) {
+		t.Errorf("Failed to move tags onto the replacement, got:%v", tags)
+	}
+
+	f := NewFixed(Identity)
+	f.Add(1)
+	if opt, _ := NewUnique(f, nil).Optimize(); opt.Type() != graph.Unique {
+		t.Errorf("Unexpectedly dropped Unique over a fixed iterator, got:%v", opt.Type())
+	}
+}
@@ -25,10 +25,15 @@ package iterator
 // from a sorted set -- some sort of value index, then go for it.
 //
 // In MQL terms, this is the [{"age>=": 21}] concept.
+//
+// Values may be integers, floats, RFC3339 times or strings. A node takes part
+// in a comparison only if its name parses as the same kind of value.
 
 import (
 	"log"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/cayley/graph"
 )
@@ -36,13 +41,30 @@ import (
 type Operator int
 
 const (
-	compareLT Operator = iota
-	compareLTE
-	compareGT
-	compareGTE
+	CompareLT Operator = iota
+	CompareLTE
+	CompareGT
+	CompareGTE
+	// ComparePrefix matches the strings that start with a string value.
+	ComparePrefix
 	// Why no Equals? Because that's usually an AndIterator.
 )
 
+var operatorNames = []string{
+	CompareLT:     "<",
+	CompareLTE:    "<=",
+	CompareGT:     ">",
+	CompareGTE:    ">=",
+	ComparePrefix: "prefix",
+}
+
+func (op Operator) String() string {
+	if op < 0 || int(op) >= len(operatorNames) {
+		return "illegal-operator"
+	}
+	return operatorNames[op]
+}
+
 type Comparison struct {
 	uid    uint64
 	tags   graph.Tagger
@@ -51,6 +73,7 @@ type Comparison struct {
 	val    interface{}
 	qs     graph.QuadStore
 	result graph.Value
+	kill   <-chan struct{}
 }
 
 func NewComparison(sub graph.Iterator, op Operator, val interface{}, qs graph.QuadStore) *Comparison {
@@ -70,8 +93,10 @@ func (it *Comparison) UID() uint64 {
 // Here's the non-boilerplate part of the ValueComparison iterator. Given a value
 // and our operator, determine whether or not we meet the requirement.
 func (it *Comparison) doComparison(val graph.Value) bool {
-	//TODO(barakmich): Implement string comparison.
 	nodeStr := it.qs.NameOf(val)
+	if _, ok := it.val.(string); !ok && it.op == ComparePrefix {
+		return false
+	}
 	switch cVal := it.val.(type) {
 	case int:
 		cInt := int64(cVal)
@@ -86,24 +111,49 @@ func (it *Comparison) doComparison(val graph.Value) bool {
 			return false
 		}
 		return RunIntOp(intVal, it.op, cVal)
+	case float64:
+		floatVal, err := strconv.ParseFloat(nodeStr, 64)
+		if err != nil {
+			return false
+		}
+		return RunFloatOp(floatVal, it.op, cVal)
+	case time.Time:
+		timeVal, err := time.Parse(time.RFC3339, nodeStr)
+		if err != nil {
+			return false
+		}
+		return RunTimeOp(timeVal, it.op, cVal)
+	case string:
+		return RunStrOp(nodeStr, it.op, cVal)
 	default:
 		return true
 	}
 }
 
+// Operator returns the operator the values are compared with.
+func (it *Comparison) Operator() Operator { return it.op }
+
+// Value returns the value nodes are compared against.
+func (it *Comparison) Value() interface{} { return it.val }
+
+// SetKill stops the Comparison from checking further values once kill is closed.
+func (it *Comparison) SetKill(kill <-chan struct{}) {
+	it.kill = kill
+}
+
 func (it *Comparison) Close() {
 	it.subIt.Close()
 }
 
 func RunIntOp(a int64, op Operator, b int64) bool {
 	switch op {
-	case compareLT:
+	case CompareLT:
 		return a < b
-	case compareLTE:
+	case CompareLTE:
 		return a <= b
-	case compareGT:
+	case CompareGT:
 		return a > b
-	case compareGTE:
+	case CompareGTE:
 		return a >= b
 	default:
 		log.Fatal("Unknown operator type")
@@ -111,6 +161,56 @@ func RunIntOp(a int64, op Operator, b int64) bool {
 	}
 }
 
+func RunFloatOp(a float64, op Operator, b float64) bool {
+	switch op {
+	case CompareLT:
+		return a < b
+	case CompareLTE:
+		return a <= b
+	case CompareGT:
+		return a > b
+	case CompareGTE:
+		return a >= b
+	default:
+		log.Fatal("Unknown operator type")
+		return false
+	}
+}
+
+func RunTimeOp(a time.Time, op Operator, b time.Time) bool {
+	switch op {
+	case CompareLT:
+		return a.Before(b)
+	case CompareLTE:
+		return !a.After(b)
+	case CompareGT:
+		return a.After(b)
+	case CompareGTE:
+		return !a.Before(b)
+	default:
+		log.Fatal("Unknown operator type")
+		return false
+	}
+}
+
+func RunStrOp(a string, op Operator, b string) bool {
+	switch op {
+	case CompareLT:
+		return a < b
+	case CompareLTE:
+		return a <= b
+	case CompareGT:
+		return a > b
+	case CompareGTE:
+		return a >= b
+	case ComparePrefix:
+		return strings.HasPrefix(a, b)
+	default:
+		log.Fatal("Unknown operator type")
+		return false
+	}
+}
+
 func (it *Comparison) Reset() {
 	it.subIt.Reset()
 }
@@ -126,14 +226,18 @@ func (it *Comparison) Clone() graph.Iterator {
 }
 
 func (it *Comparison) Next() bool {
+	graph.NextLogIn(it)
 	for graph.Next(it.subIt) {
+		if graph.Killed(it.kill) {
+			return graph.NextLogOut(it, nil, false)
+		}
 		val := it.subIt.Result()
 		if it.doComparison(val) {
 			it.result = val
-			return true
+			return graph.NextLogOut(it, val, true)
 		}
 	}
-	return false
+	return graph.NextLogOut(it, nil, false)
 }
 
 // DEPRECATED
@@ -159,16 +263,21 @@ func (it *Comparison) NextPath() bool {
 	return true
 }
 
-// No subiterators.
+// Return our sole subiterator.
 func (it *Comparison) SubIterators() []graph.Iterator {
-	return nil
+	return []graph.Iterator{it.subIt}
 }
 
 func (it *Comparison) Contains(val graph.Value) bool {
+	graph.ContainsLogIn(it, val)
 	if !it.doComparison(val) {
-		return false
+		return graph.ContainsLogOut(it, val, false)
+	}
+	if !it.subIt.Contains(val) {
+		return graph.ContainsLogOut(it, val, false)
 	}
-	return it.subIt.Contains(val)
+	it.result = val
+	return graph.ContainsLogOut(it, val, true)
 }
 
 // If we failed the check, then the subiterator should not contribute to the result
@@ -198,14 +307,19 @@ func (it *Comparison) Describe() graph.Description {
 }
 
 // There's nothing to optimize, locally, for a value-comparison iterator.
-// Replace the underlying iterator if need be.
-// potentially replace it.
+// Replace the underlying iterator if need be, then ask the QuadStore if it
+// has an index that can replace us.
 func (it *Comparison) Optimize() (graph.Iterator, bool) {
 	newSub, changed := it.subIt.Optimize()
 	if changed {
 		it.subIt.Close()
 		it.subIt = newSub
 	}
+	newReplacement, hasOne := it.qs.OptimizeIterator(it)
+	if hasOne {
+		it.Close()
+		return newReplacement, true
+	}
 	return it, false
 }
 
@@ -17,6 +17,7 @@ package iterator
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/google/cayley/graph"
 )
@@ -40,27 +41,39 @@ var comparisonTests = []struct {
 	{
 		message:  "successful int64 less than comparison",
 		operand:  int64(3),
-		operator: compareLT,
+		operator: CompareLT,
 		expect:   []string{"0", "1", "2"},
 	},
 	{
 		message:  "empty int64 less than comparison",
 		operand:  int64(0),
-		operator: compareLT,
+		operator: CompareLT,
 		expect:   nil,
 	},
 	{
 		message:  "successful int64 greater than comparison",
 		operand:  int64(2),
-		operator: compareGT,
+		operator: CompareGT,
 		expect:   []string{"3", "4"},
 	},
 	{
 		message:  "successful int64 greater than or equal comparison",
 		operand:  int64(2),
-		operator: compareGTE,
+		operator: CompareGTE,
 		expect:   []string{"2", "3", "4"},
 	},
+	{
+		message:  "successful float64 less than comparison",
+		operand:  float64(2.5),
+		operator: CompareLT,
+		expect:   []string{"0", "1", "2"},
+	},
+	{
+		message:  "successful string greater than comparison",
+		operand:  "2",
+		operator: CompareGT,
+		expect:   []string{"3", "4"},
+	},
 }
 
 func TestValueComparison(t *testing.T) {
@@ -86,25 +99,25 @@ var vciContainsTests = []struct {
 }{
 	{
 		message:  "1 is less than 2",
-		operator: compareGTE,
+		operator: CompareGTE,
 		check:    1,
 		expect:   false,
 	},
 	{
 		message:  "2 is greater than or equal to 2",
-		operator: compareGTE,
+		operator: CompareGTE,
 		check:    2,
 		expect:   true,
 	},
 	{
 		message:  "3 is greater than or equal to 2",
-		operator: compareGTE,
+		operator: CompareGTE,
 		check:    3,
 		expect:   true,
 	},
 	{
 		message:  "5 is absent from iterator",
-		operator: compareGTE,
+		operator: CompareGTE,
 		check:    5,
 		expect:   false,
 	},
@@ -116,5 +129,68 @@ func TestVCIContains(t *testing.T) {
 		if vc.Contains(test.check) != test.expect {
 			t.Errorf("Failed to show %s", test.message)
 		}
+		if test.expect && vc.Result() != test.check {
+			t.Errorf("Failed to keep the result to show %s, got:%v expect:%v", test.message, vc.Result(), test.check)
+		}
+	}
+}
+
+var typedStore = &store{data: []string{
+	"2", "2.5", "abc", "abd", "b",
+	"2014-06-01T00:00:00Z", "2015-01-01T00:00:00Z",
+}}
+
+func typedFixedIterator() *Fixed {
+	f := NewFixed(Identity)
+	for i := range typedStore.data {
+		f.Add(i)
+	}
+	return f
+}
+
+var typedComparisonTests = []struct {
+	message  string
+	operand  graph.Value
+	operator Operator
+	expect   []string
+}{
+	{
+		message:  "successful int64 comparison skipping floats",
+		operand:  int64(1),
+		operator: CompareGT,
+		expect:   []string{"2"},
+	},
+	{
+		message:  "successful time less than comparison",
+		operand:  time.Date(2014, 12, 31, 0, 0, 0, 0, time.UTC),
+		operator: CompareLT,
+		expect:   []string{"2014-06-01T00:00:00Z"},
+	},
+	{
+		message:  "successful string prefix comparison",
+		operand:  "ab",
+		operator: ComparePrefix,
+		expect:   []string{"abc", "abd"},
+	},
+	{
+		message:  "empty int64 prefix comparison",
+		operand:  int64(2),
+		operator: ComparePrefix,
+		expect:   nil,
+	},
+}
+
+func TestTypedValueComparison(t *testing.T) {
+	for _, test := range typedComparisonTests {
+		qs := typedStore
+		vc := NewComparison(typedFixedIterator(), test.operator, test.operand, qs)
+
+		var got []string
+		for vc.Next() {
+			got = append(got, qs.NameOf(vc.Result()))
+		}
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to show %s, got:%q expect:%q", test.message, got, test.expect)
+		}
 	}
 }
@@ -100,9 +100,10 @@ func (it *AllIterator) Clone() graph.Iterator {
 }
 
 func (it *AllIterator) Next() bool {
+	graph.NextLogIn(it)
 	if !it.open {
 		it.result = nil
-		return false
+		return graph.NextLogOut(it, nil, false)
 	}
 	var out []byte
 	out = make([]byte, len(it.iter.Key()))
@@ -113,10 +114,10 @@ func (it *AllIterator) Next() bool {
 	}
 	if !bytes.HasPrefix(out, it.prefix) {
 		it.Close()
-		return false
+		return graph.NextLogOut(it, nil, false)
 	}
 	it.result = Token(out)
-	return true
+	return graph.NextLogOut(it, it.result, true)
 }
 
 func (it *AllIterator) ResultTree() *graph.ResultTree {
@@ -137,8 +138,9 @@ func (it *AllIterator) SubIterators() []graph.Iterator {
 }
 
 func (it *AllIterator) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
 	it.result = v
-	return true
+	return graph.ContainsLogOut(it, v, true)
 }
 
 func (it *AllIterator) Close() {
@@ -38,6 +38,7 @@ type Iterator struct {
 	qs             *QuadStore
 	ro             *opt.ReadOptions
 	originalPrefix string
+	predicate      []byte
 	result         graph.Value
 }
 
@@ -73,6 +74,16 @@ func NewIterator(prefix string, d quad.Direction, value graph.Value, qs *QuadSto
 	return &it
 }
 
+// newLabelPredicateIterator returns an iterator over the quads with both a
+// label and a predicate, which are next to each other in the cps index.
+func newLabelPredicateIterator(label, predicate graph.Value, qs *QuadStore) *Iterator {
+	it := NewIterator("cp", quad.Label, label, qs)
+	it.predicate = predicate.(Token)
+	it.nextPrefix = append(it.nextPrefix, it.predicate[1:]...)
+	it.Reset()
+	return it
+}
+
 func (it *Iterator) UID() uint64 {
 	return it.uid
 }
@@ -104,7 +115,12 @@ func (it *Iterator) TagResults(dst map[string]graph.Value) {
 }
 
 func (it *Iterator) Clone() graph.Iterator {
-	out := NewIterator(it.originalPrefix, it.dir, Token(it.checkID), it.qs)
+	var out *Iterator
+	if it.predicate != nil {
+		out = newLabelPredicateIterator(Token(it.checkID), Token(it.predicate), it.qs)
+	} else {
+		out = NewIterator(it.originalPrefix, it.dir, Token(it.checkID), it.qs)
+	}
 	out.tags.CopyFrom(it)
 	return out
 }
@@ -123,18 +139,19 @@ func (it *Iterator) isLiveValue(val []byte) bool {
 }
 
 func (it *Iterator) Next() bool {
+	graph.NextLogIn(it)
 	if it.iter == nil {
 		it.result = nil
-		return false
+		return graph.NextLogOut(it, nil, false)
 	}
 	if !it.open {
 		it.result = nil
-		return false
+		return graph.NextLogOut(it, nil, false)
 	}
 	if !it.iter.Valid() {
 		it.result = nil
 		it.Close()
-		return false
+		return graph.NextLogOut(it, nil, false)
 	}
 	if bytes.HasPrefix(it.iter.Key(), it.nextPrefix) {
 		if !it.isLiveValue(it.iter.Value()) {
@@ -147,11 +164,11 @@ func (it *Iterator) Next() bool {
 		if !ok {
 			it.Close()
 		}
-		return true
+		return graph.NextLogOut(it, it.result, true)
 	}
 	it.Close()
 	it.result = nil
-	return false
+	return graph.NextLogOut(it, nil, false)
 }
 
 func (it *Iterator) ResultTree() *graph.ResultTree {
@@ -171,6 +188,9 @@ func (it *Iterator) SubIterators() []graph.Iterator {
 	return nil
 }
 
+// PositionOf returns the offset of the hash of a direction in a key of the
+// index with the given prefix. Every index but cps keeps the label last,
+// after the other three hashes.
 func PositionOf(prefix []byte, d quad.Direction, qs *QuadStore) int {
 	if bytes.Equal(prefix, []byte("sp")) {
 		switch d {
@@ -193,7 +213,7 @@ func PositionOf(prefix []byte, d quad.Direction, qs *QuadStore) int {
 		case quad.Object:
 			return hashSize + 2
 		case quad.Label:
-			return hashSize + 2
+			return 3*hashSize + 2
 		}
 	}
 	if bytes.Equal(prefix, []byte("os")) {
@@ -224,11 +244,18 @@ func PositionOf(prefix []byte, d quad.Direction, qs *QuadStore) int {
 }
 
 func (it *Iterator) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
 	val := v.(Token)
 	if val[0] == 'z' {
-		return false
+		return graph.ContainsLogOut(it, v, false)
 	}
 	offset := PositionOf(val[0:2], it.dir, it.qs)
+	if it.predicate != nil {
+		pOffset := PositionOf(val[0:2], quad.Predicate, it.qs)
+		if !bytes.HasPrefix(val[pOffset:], it.predicate[1:]) {
+			return graph.ContainsLogOut(it, v, false)
+		}
+	}
 	if bytes.HasPrefix(val[offset:], it.checkID[1:]) {
 		// You may ask, why don't we check to see if it's a valid (not deleted) quad
 		// again?
@@ -239,13 +266,22 @@ func (it *Iterator) Contains(v graph.Value) bool {
 		// However, if it ever starts coming from somewhere else, it'll be more
 		// efficient to change the interface of the graph.Value for LevelDB to a
 		// struct with a flag for isValid, to save another random read.
-		return true
+		return graph.ContainsLogOut(it, v, true)
 	}
-	return false
+	return graph.ContainsLogOut(it, v, false)
 }
 
 func (it *Iterator) Size() (int64, bool) {
-	return it.qs.SizeOf(Token(it.checkID)), true
+	size := it.qs.sizeIn(it.dir, Token(it.checkID))
+	if it.predicate != nil {
+		// We can't know how many quads have both, only that it's no more
+		// than either has.
+		if s := it.qs.sizeIn(quad.Predicate, Token(it.predicate)); s < size {
+			size = s
+		}
+		return size, false
+	}
+	return size, true
 }
 
 func (it *Iterator) Describe() graph.Description {
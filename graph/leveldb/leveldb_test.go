@@ -19,11 +19,16 @@ import (
 	"os"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
 
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
 	"github.com/google/cayley/quad"
+	"github.com/google/cayley/quad/cquads"
 	"github.com/google/cayley/writer"
 )
 
@@ -192,6 +197,74 @@ func TestLoadDatabase(t *testing.T) {
 	qs.Close()
 }
 
+func TestTransaction(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create working directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatal("Failed to create LevelDB database.")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+	ts := qs.(*QuadStore)
+
+	tx := graph.NewTransaction()
+	tx.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "G", ""})
+	if err := w.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Unexpected error applying transaction: %v", err)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:11", s)
+	}
+	if s := ts.SizeOf(qs.ValueOf("G")); s != 4 {
+		t.Errorf("Unexpected node size, got:%d expect:4", s)
+	}
+
+	// Removing and re-adding a quad in one set of deltas keeps it.
+	horizon := qs.Horizon()
+	tx = graph.NewTransaction()
+	tx.RemoveQuad(quad.Quad{"E", "follows", "G", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "G", ""})
+	if err := w.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Unexpected error applying transaction: %v", err)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:11", s)
+	}
+	if h := qs.Horizon(); h != horizon+2 {
+		t.Errorf("Unexpected horizon, got:%d expect:%d", h, horizon+2)
+	}
+
+	// A bad delta anywhere leaves the store untouched.
+	horizon = qs.Horizon()
+	tx = graph.NewTransaction()
+	tx.AddQuad(quad.Quad{"A", "follows", "G", ""})
+	tx.AddQuad(quad.Quad{"A", "follows", "B", ""})
+	if err := w.ApplyTransaction(tx); err != graph.ErrQuadExists {
+		t.Errorf("Unexpected error, got:%v expect:%v", err, graph.ErrQuadExists)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:11", s)
+	}
+	if h := qs.Horizon(); h != horizon {
+		t.Errorf("Unexpected horizon, got:%d expect:%d", h, horizon)
+	}
+	it := qs.QuadIterator(quad.Subject, qs.ValueOf("A"))
+	if got := iteratedQuads(qs, it); len(got) != 1 {
+		t.Errorf("Unexpected quads from A, got:%v", got)
+	}
+}
+
 func TestIterator(t *testing.T) {
 	tmpDir, err := ioutil.TempDir(os.TempDir(), "cayley_test")
 	if err != nil {
@@ -454,3 +527,360 @@ func TestOptimize(t *testing.T) {
 		t.Errorf("Discordant tag results, new:%v old:%v", newResults, oldResults)
 	}
 }
+
+func TestOptimizeLabelPredicate(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatalf("Failed to create leveldb QuadStore.")
+	}
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+	w.AddQuad(quad.Quad{"E", "status", "cool", "other_graph"})
+	w.AddQuad(quad.Quad{"F", "follows", "E", "other_graph"})
+
+	label := qs.FixedIterator()
+	label.Add(qs.ValueOf("other_graph"))
+	label.Tagger().Add("label")
+	pred := qs.FixedIterator()
+	pred.Add(qs.ValueOf("status"))
+	and := iterator.NewAnd()
+	and.AddSubIterator(iterator.NewLinksTo(qs, label, quad.Label))
+	and.AddSubIterator(iterator.NewLinksTo(qs, pred, quad.Predicate))
+	hasa := iterator.NewHasA(qs, and, quad.Subject)
+
+	oldIt := hasa.Clone()
+	newIt, ok := hasa.Optimize()
+	if !ok {
+		t.Errorf("Failed to optimize iterator")
+	}
+	var scans int
+	var find func(graph.Iterator)
+	find = func(it graph.Iterator) {
+		if it, ok := it.(*Iterator); ok && it.predicate != nil {
+			scans++
+		}
+		for _, sub := range it.SubIterators() {
+			find(sub)
+		}
+	}
+	find(newIt)
+	if scans != 1 {
+		t.Errorf("Unexpected number of label and predicate scans, got:%d expect:1", scans)
+	}
+
+	oldNames := iteratedNames(qs, oldIt)
+	newNames := iteratedNames(qs, newIt)
+	if expect := []string{"E"}; !reflect.DeepEqual(newNames, expect) {
+		t.Errorf("Unexpected optimized results, got:%v expect:%v", newNames, expect)
+	}
+	if !reflect.DeepEqual(newNames, oldNames) {
+		t.Errorf("Optimized iteration does not match original, got:%v expect:%v", newNames, oldNames)
+	}
+
+	newIt.Reset()
+	graph.Next(newIt)
+	tags := make(map[string]graph.Value)
+	newIt.TagResults(tags)
+	if got := qs.NameOf(tags["label"]); got != "other_graph" {
+		t.Errorf("Unexpected label tag, got:%q expect:%q", got, "other_graph")
+	}
+}
+
+var comparisonTests = []struct {
+	message string
+	op      iterator.Operator
+	val     interface{}
+	expect  []string
+}{
+	{
+		message: "select integers greater than 20",
+		op:      iterator.CompareGT,
+		val:     int64(20),
+		expect:  []string{"21", "100"},
+	},
+	{
+		message: "select floats up to 20.5",
+		op:      iterator.CompareLTE,
+		val:     20.5,
+		expect:  []string{"-1", "20.5"},
+	},
+	{
+		message: "select dates from 2014",
+		op:      iterator.CompareGTE,
+		val:     time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+		expect:  []string{"2014-06-01T00:00:00Z"},
+	},
+	{
+		message: "select strings with a prefix",
+		op:      iterator.ComparePrefix,
+		val:     "ag",
+		expect:  []string{"age"},
+	},
+}
+
+func TestOptimizeComparison(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatalf("Failed to create leveldb QuadStore.")
+	}
+
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet([]quad.Quad{
+		{"A", "age", "21", ""},
+		{"B", "age", "100", ""},
+		{"C", "age", "20.5", ""},
+		{"D", "age", "-1", ""},
+		{"E", "age", "7", ""},
+		{"E", "born", "2014-06-01T00:00:00Z", ""},
+		{"F", "born", "2013-06-01T00:00:00Z", ""},
+	})
+	// Values of nodes no longer in use are dropped from the index.
+	w.RemoveQuad(quad.Quad{"E", "age", "7", ""})
+
+	check := func(qs graph.QuadStore) {
+		for _, test := range comparisonTests {
+			it := iterator.NewComparison(qs.NodesAllIterator(), test.op, test.val, qs)
+			it.Tagger().Add("value")
+			newIt, ok := it.Clone().Optimize()
+			if !ok || newIt.Type() != levelDBValueType {
+				t.Errorf("Failed to optimize iterator to %s", test.message)
+				continue
+			}
+			got := iteratedNames(qs, newIt)
+			expect := append([]string(nil), test.expect...)
+			sort.Strings(expect)
+			if !reflect.DeepEqual(got, expect) {
+				t.Errorf("Failed to %s, got:%v expect:%v", test.message, got, expect)
+			}
+			newIt.Reset()
+			if !graph.Next(newIt) {
+				t.Errorf("Failed to %s after reset", test.message)
+				continue
+			}
+			results := make(map[string]graph.Value)
+			newIt.TagResults(results)
+			if _, ok := results["value"]; !ok {
+				t.Errorf("Failed to %s, tag results missing, got:%v", test.message, results)
+			}
+			for _, name := range []string{"7", "A"} {
+				if newIt.Contains(qs.ValueOf(name)) {
+					t.Errorf("Failed to %s, %q is contained", test.message, name)
+				}
+			}
+			for _, name := range test.expect {
+				if !newIt.Contains(qs.ValueOf(name)) {
+					t.Errorf("Failed to %s, %q is not contained", test.message, name)
+				}
+			}
+		}
+	}
+	check(qs)
+
+	// The index is kept across reopening the store.
+	qs.Close()
+	qs, err = newQuadStore(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen leveldb QuadStore: %v", err)
+	}
+	defer qs.Close()
+	check(qs)
+}
+
+func TestBulkLoad(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatalf("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+
+	// Load the quads with a duplicate.
+	var lines []string
+	for _, q := range makeQuadSet() {
+		lines = append(lines, q.NQuad())
+	}
+	lines = append(lines, lines[0])
+	dec := cquads.NewDecoder(strings.NewReader(strings.Join(lines, "\n")))
+	if err := qs.(graph.BulkLoader).BulkLoad(dec); err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:11", s)
+	}
+	if h := qs.Horizon(); h != 11 {
+		t.Errorf("Unexpected horizon, got:%d expect:11", h)
+	}
+	if s := qs.(*QuadStore).SizeOf(qs.ValueOf("B")); s != 5 {
+		t.Errorf("Unexpected quadstore size of B, got:%d expect:5", s)
+	}
+	stats, ok := qs.(*QuadStore).PredicateStats(qs.ValueOf("follows"))
+	if expect := (graph.PredicateStats{Quads: 8, Subjects: 6, Objects: 4}); !ok || stats != expect {
+		t.Errorf("Unexpected statistics of follows, got:%v expect:%v", stats, expect)
+	}
+	got := iteratedQuads(qs, qs.QuadsAllIterator())
+	expect := makeQuadSet()
+	sort.Sort(ordered(expect))
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Failed to get expected results, got:%v expect:%v", got, expect)
+	}
+
+	// The value index is built as the quads are loaded.
+	it, ok := iterator.NewComparison(qs.NodesAllIterator(), iterator.CompareLTE, "B", qs).Optimize()
+	if !ok || it.Type() != levelDBValueType {
+		t.Errorf("Failed to optimize a comparison after bulk loading")
+	}
+	if names := iteratedNames(qs, it); !reflect.DeepEqual(names, []string{"A", "B"}) {
+		t.Errorf("Failed to compare bulk loaded values, got:%v expect:[A B]", names)
+	}
+
+	// Bulk loaded quads may be removed like any others.
+	w, _ := writer.NewSingleReplication(qs, nil)
+	if err := w.RemoveQuad(quad.Quad{"A", "follows", "B", ""}); err != nil {
+		t.Errorf("Failed to remove a bulk loaded quad: %v", err)
+	}
+	if s := qs.Size(); s != 10 {
+		t.Errorf("Unexpected quadstore size after RemoveQuad, got:%d expect:10", s)
+	}
+
+	dec = cquads.NewDecoder(strings.NewReader(lines[0]))
+	if err := qs.(graph.BulkLoader).BulkLoad(dec); err != graph.ErrCannotBulkLoad {
+		t.Errorf("Unexpected error loading into a non-empty store, got:%v expect:%v", err, graph.ErrCannotBulkLoad)
+	}
+}
+
+func TestPredicateStats(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create working directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatal("Failed to create LevelDB database.")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatal("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+	ts := qs.(*QuadStore)
+
+	expect := map[string]graph.PredicateStats{
+		"follows": {Quads: 8, Subjects: 6, Objects: 4},
+		"status":  {Quads: 3, Subjects: 3, Objects: 1},
+	}
+	got, err := ts.AllPredicateStats()
+	if err != nil || !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected predicate statistics, got:%v (%v) expect:%v", got, err, expect)
+	}
+	if s, ok := ts.PredicateStats(qs.ValueOf("follows")); !ok || s.FanOut(quad.Object) != 2 {
+		t.Errorf("Unexpected fan-out of follows, got:%d expect:2", s.FanOut(quad.Object))
+	}
+	if _, ok := ts.PredicateStats(qs.ValueOf("cool")); ok {
+		t.Error("Unexpected statistics for a node which is not a predicate")
+	}
+
+	// Moving a quad keeps its objects; removing one drops the objects it
+	// was the last to use.
+	tx := graph.NewTransaction()
+	tx.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "G", ""})
+	tx.RemoveQuad(quad.Quad{"C", "follows", "D", ""})
+	if err := w.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Unexpected error applying transaction: %v", err)
+	}
+	expect["follows"] = graph.PredicateStats{Quads: 7, Subjects: 6, Objects: 3}
+	got, err = ts.AllPredicateStats()
+	if err != nil || !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected predicate statistics, got:%v (%v) expect:%v", got, err, expect)
+	}
+
+	// A predicate with no quads left has no statistics.
+	for _, q := range makeQuadSet()[8:] {
+		w.RemoveQuad(q)
+	}
+	delete(expect, "status")
+	got, err = ts.AllPredicateStats()
+	if err != nil || !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected predicate statistics, got:%v (%v) expect:%v", got, err, expect)
+	}
+
+	// The statistics of a database without them are rebuilt from its quads.
+	for _, prefix := range []string{"S", "n", string(predicateStatsKey)} {
+		it := ts.db.NewIterator(util.BytesPrefix([]byte(prefix)), ts.readopts)
+		for it.Next() {
+			ts.db.Delete(it.Key(), ts.writeopts)
+		}
+		it.Release()
+	}
+	if got, _ := ts.AllPredicateStats(); len(got) != 0 {
+		t.Fatalf("Failed to delete predicate statistics, got:%v", got)
+	}
+	if err := ts.buildPredicateStats(); err != nil {
+		t.Fatalf("Failed to build predicate statistics: %v", err)
+	}
+	got, err = ts.AllPredicateStats()
+	if err != nil || !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected rebuilt predicate statistics, got:%v (%v) expect:%v", got, err, expect)
+	}
+}
+
+func TestPositionOf(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir(os.TempDir(), "cayley_test")
+	defer os.RemoveAll(tmpDir)
+	err := createNewLevelDB(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to create working directory")
+	}
+	qs, err := newQuadStore(tmpDir, nil)
+	if qs == nil || err != nil {
+		t.Fatalf("Failed to create leveldb QuadStore.")
+	}
+	defer qs.Close()
+	ts := qs.(*QuadStore)
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+
+	q := quad.Quad{"B", "status", "cool", "status_graph"}
+	for _, index := range [][4]quad.Direction{spo, osp, pos, cps} {
+		key := Token(ts.createKeyFor(index, q))
+		for _, d := range []quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label} {
+			if got := qs.NameOf(qs.QuadDirection(key, d)); got != q.Get(d) {
+				t.Errorf("Unexpected %v of a %s key, got:%q expect:%q", d, key[:2], got, q.Get(d))
+			}
+		}
+	}
+
+	// All quads are read from the po index.
+	var labels []string
+	it := qs.QuadsAllIterator()
+	for graph.Next(it) {
+		if label := qs.NameOf(qs.QuadDirection(it.Result(), quad.Label)); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	if expect := []string{"status_graph", "status_graph", "status_graph"}; !reflect.DeepEqual(labels, expect) {
+		t.Errorf("Unexpected labels of all quads, got:%v expect:%v", labels, expect)
+	}
+}
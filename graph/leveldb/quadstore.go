@@ -22,7 +22,9 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/barakmich/glog"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -31,6 +33,7 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/util"
 
 	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/index"
 	"github.com/google/cayley/graph/iterator"
 	"github.com/google/cayley/quad"
 )
@@ -42,6 +45,10 @@ func init() {
 const (
 	DefaultCacheSize       = 2
 	DefaultWriteBufferSize = 20
+
+	// bulkBatchSize is the number of quads written in each batch by
+	// BulkLoad.
+	bulkBatchSize = 100000
 )
 
 var (
@@ -118,6 +125,16 @@ func newQuadStore(path string, options graph.Options) (graph.QuadStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = qs.buildValueIndex()
+	if err != nil {
+		glog.Errorln("Error, could not build value index: ", err)
+		return nil, err
+	}
+	err = qs.buildPredicateStats()
+	if err != nil {
+		glog.Errorln("Error, could not build predicate statistics: ", err)
+		return nil, err
+	}
 	return &qs, nil
 }
 
@@ -167,6 +184,47 @@ func (qs *QuadStore) createValueKeyFor(s string) []byte {
 	return key
 }
 
+// createValueIndexKeysFor returns the keys of the value index for a node;
+// see package index.
+func (qs *QuadStore) createValueIndexKeysFor(s string) [][]byte {
+	keys := index.Keys(s, hashOf(s))
+	for i, k := range keys {
+		keys[i] = append([]byte("v"), k...)
+	}
+	return keys
+}
+
+// valueIndexKey marks a database whose value index has been built.
+var valueIndexKey = []byte("__value_index")
+
+// buildValueIndex fills the value index of a database made before there was
+// one.
+func (qs *QuadStore) buildValueIndex() error {
+	_, err := qs.db.Get(valueIndexKey, qs.readopts)
+	if err != leveldb.ErrNotFound {
+		return err
+	}
+	batch := &leveldb.Batch{}
+	it := qs.db.NewIterator(util.BytesPrefix([]byte("z")), qs.readopts)
+	for it.Next() {
+		var value ValueData
+		err := json.Unmarshal(it.Value(), &value)
+		if err != nil {
+			it.Release()
+			return err
+		}
+		if value.Size > 0 {
+			qs.updateValueIndex(batch, value.Name, true)
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+	batch.Put(valueIndexKey, nil)
+	return qs.db.Write(batch, qs.writeopts)
+}
+
 type IndexEntry struct {
 	quad.Quad
 	History []int64
@@ -180,17 +238,31 @@ var (
 	cps = [4]quad.Direction{quad.Label, quad.Predicate, quad.Subject, quad.Object}
 )
 
-func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta) error {
+func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, opts graph.IgnoreOpts) error {
+	// Check the whole set first; nothing is written unless the batch is.
+	deltas, err := graph.CheckDeltas(deltas, opts, func(q quad.Quad) (bool, error) {
+		entry, err := qs.indexEntry(q)
+		if err != nil {
+			return false, err
+		}
+		return len(entry.History)%2 == 1, nil
+	})
+	if err != nil {
+		return err
+	}
 	batch := &leveldb.Batch{}
+	entries := make(map[quad.Quad]*IndexEntry)
 	resizeMap := make(map[string]int64)
+	stats := graph.NewStatsUpdate()
 	sizeChange := int64(0)
+	horizon := qs.horizon
 	for _, d := range deltas {
 		bytes, err := json.Marshal(d)
 		if err != nil {
 			return err
 		}
 		batch.Put(keyFor(d), bytes)
-		err = qs.buildQuadWrite(batch, d.Quad, d.ID, d.Action == graph.Add)
+		err = qs.buildQuadWrite(batch, entries, d.Quad, d.ID, d.Action == graph.Add)
 		if err != nil {
 			return err
 		}
@@ -204,8 +276,9 @@ func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta) error {
 		if d.Quad.Label != "" {
 			resizeMap[d.Quad.Label] += delta
 		}
+		stats.Add(d.Quad, delta)
 		sizeChange += delta
-		qs.horizon = d.ID
+		horizon = d.ID
 	}
 	for k, v := range resizeMap {
 		if v != 0 {
@@ -215,12 +288,116 @@ func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta) error {
 			}
 		}
 	}
-	err := qs.db.Write(batch, qs.writeopts)
+	err = qs.updatePredicateStats(batch, stats)
+	if err != nil {
+		return err
+	}
+	err = qs.db.Write(batch, qs.writeopts)
 	if err != nil {
 		glog.Error("could not write to DB for quadset.")
 		return err
 	}
 	qs.size += sizeChange
+	qs.horizon = horizon
+	return nil
+}
+
+// BulkLoad loads quads into an empty store, without checking them one at a
+// time. Each batch of quads is written at once, with the deltas, the index
+// keys and the nodes put in key order, and the sizes of the nodes summed
+// before they are updated. Duplicate quads are skipped. If loading fails
+// partway, the store holds the batches written so far.
+func (qs *QuadStore) BulkLoad(dec quad.Unmarshaler) error {
+	if qs.size != 0 || qs.horizon != 0 {
+		return graph.ErrCannotBulkLoad
+	}
+	return graph.BulkBatches(dec, bulkBatchSize, qs.bulkWrite)
+}
+
+func (qs *QuadStore) bulkWrite(batch []quad.Quad) error {
+	// The store was empty, so any quad already in the index came from an
+	// earlier batch.
+	var deltas []graph.Delta
+	now := time.Now()
+	for _, q := range batch {
+		_, err := qs.db.Get(qs.createKeyFor(spo, q), qs.readopts)
+		if err == nil {
+			continue
+		}
+		if err != leveldb.ErrNotFound {
+			glog.Error("could not access DB to prepare index: ", err)
+			return err
+		}
+		deltas = append(deltas, graph.Delta{
+			ID:        qs.horizon + int64(len(deltas)) + 1,
+			Quad:      q,
+			Action:    graph.Add,
+			Timestamp: now,
+		})
+	}
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	var puts []graph.KeyValue
+	resizeMap := make(map[string]int64)
+	stats := graph.NewStatsUpdate()
+	for _, d := range deltas {
+		bytes, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		puts = append(puts, graph.KeyValue{Key: keyFor(d), Value: bytes})
+		entry, err := json.Marshal(IndexEntry{Quad: d.Quad, History: []int64{d.ID}})
+		if err != nil {
+			return err
+		}
+		for _, index := range [][4]quad.Direction{spo, osp, pos, cps} {
+			if index == cps && d.Quad.Label == "" {
+				continue
+			}
+			puts = append(puts, graph.KeyValue{Key: qs.createKeyFor(index, d.Quad), Value: entry})
+		}
+		resizeMap[d.Quad.Subject]++
+		resizeMap[d.Quad.Predicate]++
+		resizeMap[d.Quad.Object]++
+		if d.Quad.Label != "" {
+			resizeMap[d.Quad.Label]++
+		}
+		stats.Add(d.Quad, 1)
+	}
+	sort.Sort(graph.ByKey(puts))
+	b := &leveldb.Batch{}
+	for _, kv := range puts {
+		b.Put(kv.Key, kv.Value)
+	}
+
+	// Update each node once, in the order of their keys.
+	nodes := make([]graph.KeyValue, 0, len(resizeMap))
+	for name := range resizeMap {
+		nodes = append(nodes, graph.KeyValue{Key: qs.createValueKeyFor(name), Value: []byte(name)})
+	}
+	sort.Sort(graph.ByKey(nodes))
+	for _, kv := range nodes {
+		name := string(kv.Value)
+		err := qs.UpdateValueKeyBy(name, resizeMap[name], b)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := qs.updatePredicateStats(b, stats)
+	if err != nil {
+		return err
+	}
+
+	err = qs.db.Write(b, qs.writeopts)
+	if err != nil {
+		glog.Error("could not write to DB for bulk load.")
+		return err
+	}
+	qs.size += int64(len(deltas))
+	qs.horizon = deltas[len(deltas)-1].ID
 	return nil
 }
 
@@ -231,22 +408,39 @@ func keyFor(d graph.Delta) []byte {
 	return key
 }
 
-func (qs *QuadStore) buildQuadWrite(batch *leveldb.Batch, q quad.Quad, id int64, isAdd bool) error {
+// indexEntry reads the stored history of a quad.
+func (qs *QuadStore) indexEntry(q quad.Quad) (*IndexEntry, error) {
 	var entry IndexEntry
 	data, err := qs.db.Get(qs.createKeyFor(spo, q), qs.readopts)
 	if err != nil && err != leveldb.ErrNotFound {
 		glog.Error("could not access DB to prepare index: ", err)
-		return err
+		return nil, err
 	}
 	if err == nil {
 		// We got something.
 		err = json.Unmarshal(data, &entry)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else {
 		entry.Quad = q
 	}
+	return &entry, nil
+}
+
+// buildQuadWrite adds the index writes for a delta to the batch. Entries
+// already changed by the batch are kept in entries, since the batch cannot
+// be read back before it is written.
+func (qs *QuadStore) buildQuadWrite(batch *leveldb.Batch, entries map[quad.Quad]*IndexEntry, q quad.Quad, id int64, isAdd bool) error {
+	entry, ok := entries[q]
+	if !ok {
+		var err error
+		entry, err = qs.indexEntry(q)
+		if err != nil {
+			return err
+		}
+		entries[q] = entry
+	}
 	entry.History = append(entry.History, id)
 
 	if isAdd && len(entry.History)%2 == 0 {
@@ -275,8 +469,14 @@ type ValueData struct {
 
 func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, batch *leveldb.Batch) error {
 	value := &ValueData{name, amount}
+	// Without a batch, the update is written on its own.
+	write := batch == nil
+	if write {
+		batch = &leveldb.Batch{}
+	}
 	key := qs.createValueKeyFor(name)
 	b, err := qs.db.Get(key, qs.readopts)
+	var oldSize int64
 
 	// Error getting the node from the database.
 	if err != nil && err != leveldb.ErrNotFound {
@@ -291,6 +491,7 @@ func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, batch *leveldb.
 			glog.Errorf("Error: could not reconstruct value: %v", err)
 			return err
 		}
+		oldSize = value.Size
 		value.Size += amount
 	}
 
@@ -299,20 +500,35 @@ func (qs *QuadStore) UpdateValueKeyBy(name string, amount int64, batch *leveldb.
 		value.Size = 0
 	}
 
+	// Index the node's value when it comes into use, and drop it when it
+	// goes out.
+	if wasLive, isLive := oldSize > 0, value.Size > 0; wasLive != isLive {
+		qs.updateValueIndex(batch, name, isLive)
+	}
+
 	// Repackage and rewrite.
 	bytes, err := json.Marshal(&value)
 	if err != nil {
 		glog.Errorf("could not write to buffer for value %s: %s", name, err)
 		return err
 	}
-	if batch == nil {
-		qs.db.Put(key, bytes, qs.writeopts)
-	} else {
-		batch.Put(key, bytes)
+	batch.Put(key, bytes)
+	if write {
+		return qs.db.Write(batch, qs.writeopts)
 	}
 	return nil
 }
 
+func (qs *QuadStore) updateValueIndex(batch *leveldb.Batch, name string, isAdd bool) {
+	for _, key := range qs.createValueIndexKeysFor(name) {
+		if isAdd {
+			batch.Put(key, nil)
+		} else {
+			batch.Delete(key)
+		}
+	}
+}
+
 func (qs *QuadStore) Close() {
 	buf := new(bytes.Buffer)
 	err := binary.Write(buf, binary.LittleEndian, qs.size)
@@ -17,12 +17,17 @@ package leveldb
 import (
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
 )
 
 func (qs *QuadStore) OptimizeIterator(it graph.Iterator) (graph.Iterator, bool) {
 	switch it.Type() {
 	case graph.LinksTo:
 		return qs.optimizeLinksTo(it.(*iterator.LinksTo))
+	case graph.HasA:
+		return qs.optimizeHasA(it.(*iterator.HasA))
+	case graph.Comparison:
+		return qs.optimizeComparison(it.(*iterator.Comparison))
 
 	}
 	return it, false
@@ -53,3 +58,63 @@ func (qs *QuadStore) optimizeLinksTo(it *iterator.LinksTo) (graph.Iterator, bool
 	}
 	return it, false
 }
+
+// optimizeComparison replaces a comparison over all nodes with a scan of the
+// matching range of the value index.
+func (qs *QuadStore) optimizeComparison(it *iterator.Comparison) (graph.Iterator, bool) {
+	sub, ok := it.SubIterators()[0].(*AllIterator)
+	if !ok || sub.dir != quad.Any {
+		return it, false
+	}
+	newIt, ok := NewValueIterator(it.Operator(), it.Value(), qs)
+	if !ok {
+		return it, false
+	}
+	nt := newIt.Tagger()
+	nt.CopyFrom(it)
+	nt.CopyFrom(sub)
+	return newIt, true
+}
+
+// optimizeHasA replaces a label and a predicate under a HasA with a single
+// scan of the quads with both, from the cps index.
+func (qs *QuadStore) optimizeHasA(it *iterator.HasA) (graph.Iterator, bool) {
+	and, ok := it.SubIterators()[0].(*iterator.And)
+	if !ok {
+		return it, false
+	}
+	var (
+		label, pred *Iterator
+		rest        []graph.Iterator
+	)
+	for _, sub := range and.SubIterators() {
+		if sub, ok := sub.(*Iterator); ok && sub.predicate == nil {
+			if sub.dir == quad.Label && label == nil {
+				label = sub
+				continue
+			}
+			if sub.dir == quad.Predicate && pred == nil {
+				pred = sub
+				continue
+			}
+		}
+		rest = append(rest, sub)
+	}
+	if label == nil || pred == nil {
+		return it, false
+	}
+	scan := newLabelPredicateIterator(Token(label.checkID), Token(pred.checkID), qs)
+	scan.tags.CopyFrom(label)
+	scan.tags.CopyFrom(pred)
+	newAnd := iterator.NewAnd()
+	newAnd.Tagger().CopyFrom(and)
+	newAnd.AddSubIterator(scan)
+	// The HasA closes its old subiterators once it's replaced.
+	for _, sub := range rest {
+		newAnd.AddSubIterator(sub.Clone())
+	}
+	hasa := iterator.NewHasA(qs, newAnd, it.Direction())
+	hasa.Tagger().CopyFrom(it)
+	newIt, _ := hasa.Optimize()
+	return newIt, true
+}
@@ -0,0 +1,192 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leveldb
+
+// The statistics of each predicate are kept under "S" and the hash of the
+// predicate, and the number of its quads at each subject and object under "n",
+// the hash of the predicate, the direction and the hash of the node.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/barakmich/glog"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/quad"
+)
+
+type PredicateData struct {
+	Name string
+	graph.PredicateStats
+}
+
+// predicateStatsKey marks a database whose predicate statistics have been
+// built.
+var predicateStatsKey = []byte("__predicate_stats")
+
+func (qs *QuadStore) createPredicateKeyFor(pred string) []byte {
+	key := make([]byte, 0, 1+hashSize)
+	key = append(key, 'S')
+	key = append(key, hashOf(pred)...)
+	return key
+}
+
+func (qs *QuadStore) createPredicateNodeKeyFor(n graph.PredicateNode) []byte {
+	key := make([]byte, 0, 2+(hashSize*2))
+	key = append(key, 'n')
+	key = append(key, hashOf(n.Predicate)...)
+	key = append(key, n.Dir.Prefix())
+	key = append(key, hashOf(n.Node)...)
+	return key
+}
+
+func (qs *QuadStore) predicateData(key []byte) (PredicateData, error) {
+	var out PredicateData
+	b, err := qs.db.Get(key, qs.readopts)
+	if err == leveldb.ErrNotFound {
+		return out, nil
+	}
+	if err != nil {
+		glog.Errorln("Error: could not get predicate statistics from DB")
+		return out, err
+	}
+	err = json.Unmarshal(b, &out)
+	if err != nil {
+		glog.Errorln("Error: could not reconstruct predicate statistics")
+	}
+	return out, err
+}
+
+// PredicateStats returns the statistics of a predicate.
+func (qs *QuadStore) PredicateStats(v graph.Value) (graph.PredicateStats, bool) {
+	if v == nil {
+		return graph.PredicateStats{}, false
+	}
+	key := append([]byte{'S'}, v.(Token)[1:]...)
+	data, err := qs.predicateData(key)
+	if err != nil || data.Quads <= 0 {
+		return graph.PredicateStats{}, false
+	}
+	return data.PredicateStats, true
+}
+
+// AllPredicateStats returns the statistics of every predicate in use.
+func (qs *QuadStore) AllPredicateStats() (map[string]graph.PredicateStats, error) {
+	out := make(map[string]graph.PredicateStats)
+	it := qs.db.NewIterator(util.BytesPrefix([]byte("S")), qs.readopts)
+	defer it.Release()
+	for it.Next() {
+		var data PredicateData
+		err := json.Unmarshal(it.Value(), &data)
+		if err != nil {
+			return nil, err
+		}
+		out[data.Name] = data.PredicateStats
+	}
+	return out, it.Error()
+}
+
+// updatePredicateStats adds the writes for a StatsUpdate to the batch. As the
+// batch can't be read back, each node is counted once, with all its changes.
+func (qs *QuadStore) updatePredicateStats(batch *leveldb.Batch, u *graph.StatsUpdate) error {
+	stats, err := u.Apply(func(pred string) (graph.PredicateStats, error) {
+		data, err := qs.predicateData(qs.createPredicateKeyFor(pred))
+		return data.PredicateStats, err
+	}, func(n graph.PredicateNode, change int64) (int64, int64, error) {
+		key := qs.createPredicateNodeKeyFor(n)
+		old, err := qs.getInt64ForKey(string(key), 0)
+		if err != nil {
+			return 0, 0, err
+		}
+		now := old + change
+		if now <= 0 {
+			batch.Delete(key)
+			return old, now, nil
+		}
+		buf := new(bytes.Buffer)
+		err = binary.Write(buf, binary.LittleEndian, now)
+		if err != nil {
+			return 0, 0, err
+		}
+		batch.Put(key, buf.Bytes())
+		return old, now, nil
+	})
+	if err != nil {
+		return err
+	}
+	for pred, s := range stats {
+		key := qs.createPredicateKeyFor(pred)
+		if s.Quads <= 0 {
+			batch.Delete(key)
+			continue
+		}
+		b, err := json.Marshal(PredicateData{Name: pred, PredicateStats: s})
+		if err != nil {
+			glog.Errorf("could not write to buffer for predicate %s: %s", pred, err)
+			return err
+		}
+		batch.Put(key, b)
+	}
+	return nil
+}
+
+// buildPredicateStats counts the quads of a database made before there were
+// predicate statistics.
+func (qs *QuadStore) buildPredicateStats() error {
+	_, err := qs.db.Get(predicateStatsKey, qs.readopts)
+	if err != leveldb.ErrNotFound {
+		return err
+	}
+	u := graph.NewStatsUpdate()
+	it := qs.db.NewIterator(util.BytesPrefix([]byte{quad.Predicate.Prefix(), quad.Object.Prefix()}), qs.readopts)
+	for it.Next() {
+		var entry IndexEntry
+		err := json.Unmarshal(it.Value(), &entry)
+		if err != nil {
+			it.Release()
+			return err
+		}
+		if len(entry.History)%2 == 1 {
+			u.Add(entry.Quad, 1)
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+	batch := &leveldb.Batch{}
+	err = qs.updatePredicateStats(batch, u)
+	if err != nil {
+		return err
+	}
+	batch.Put(predicateStatsKey, nil)
+	return qs.db.Write(batch, qs.writeopts)
+}
+
+// sizeIn returns how many quads have a node in a direction. The size of a
+// node counts its quads in every direction, so the statistics of a predicate
+// are better, if there are any.
+func (qs *QuadStore) sizeIn(d quad.Direction, v graph.Value) int64 {
+	if d == quad.Predicate {
+		if stats, ok := qs.PredicateStats(v); ok {
+			return stats.Quads
+		}
+	}
+	return qs.SizeOf(v)
+}
@@ -0,0 +1,201 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leveldb
+
+import (
+	ldbit "github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/index"
+	"github.com/google/cayley/graph/iterator"
+)
+
+var levelDBValueType graph.Type
+
+func init() {
+	levelDBValueType = graph.RegisterIterator("leveldb_value")
+}
+
+// ValueIterator returns the nodes whose values satisfy a comparison, by
+// scanning a range of the value index.
+type ValueIterator struct {
+	uid    uint64
+	tags   graph.Tagger
+	op     iterator.Operator
+	val    interface{}
+	r      index.Range
+	keys   *util.Range
+	open   bool
+	iter   ldbit.Iterator
+	qs     *QuadStore
+	ro     *opt.ReadOptions
+	result graph.Value
+	size   int64
+}
+
+// NewValueIterator returns an iterator over the nodes whose values satisfy op
+// against val, or false if val cannot be looked up in the value index.
+func NewValueIterator(op iterator.Operator, val interface{}, qs *QuadStore) (*ValueIterator, bool) {
+	r, ok := index.NewRange(op, val)
+	if !ok {
+		return nil, false
+	}
+	opts := &opt.ReadOptions{
+		DontFillCache: true,
+	}
+	keys := &util.Range{
+		Start: append([]byte("v"), r.Start...),
+		Limit: append([]byte("v"), r.Limit...),
+	}
+	return &ValueIterator{
+		uid:  iterator.NextUID(),
+		op:   op,
+		val:  val,
+		r:    r,
+		keys: keys,
+		ro:   opts,
+		iter: qs.db.NewIterator(keys, opts),
+		open: true,
+		qs:   qs,
+		size: -1,
+	}, true
+}
+
+func (it *ValueIterator) UID() uint64 {
+	return it.uid
+}
+
+func (it *ValueIterator) Reset() {
+	it.Close()
+	it.iter = it.qs.db.NewIterator(it.keys, it.ro)
+	it.open = true
+	it.result = nil
+}
+
+func (it *ValueIterator) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *ValueIterator) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+}
+
+func (it *ValueIterator) Clone() graph.Iterator {
+	out, _ := NewValueIterator(it.op, it.val, it.qs)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *ValueIterator) Next() bool {
+	graph.NextLogIn(it)
+	if !it.open || !it.iter.Next() {
+		it.result = nil
+		it.Close()
+		return graph.NextLogOut(it, nil, false)
+	}
+	key := it.iter.Key()
+	out := make([]byte, 0, 1+hashSize)
+	out = append(out, 'z')
+	out = append(out, key[len(key)-hashSize:]...)
+	it.result = Token(out)
+	return graph.NextLogOut(it, it.result, true)
+}
+
+func (it *ValueIterator) ResultTree() *graph.ResultTree {
+	return graph.NewResultTree(it.Result())
+}
+
+func (it *ValueIterator) Result() graph.Value {
+	return it.result
+}
+
+func (it *ValueIterator) NextPath() bool {
+	return false
+}
+
+// No subiterators.
+func (it *ValueIterator) SubIterators() []graph.Iterator {
+	return nil
+}
+
+func (it *ValueIterator) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
+	val := v.(Token)
+	if val[0] != 'z' {
+		return graph.ContainsLogOut(it, v, false)
+	}
+	value := it.qs.valueData(val)
+	if value.Size <= 0 || !it.r.Matches(value.Name) {
+		return graph.ContainsLogOut(it, v, false)
+	}
+	it.result = v
+	return graph.ContainsLogOut(it, v, true)
+}
+
+func (it *ValueIterator) Close() {
+	if it.open {
+		it.iter.Release()
+		it.open = false
+	}
+}
+
+// Size counts the keys in the range the first time it is called.
+func (it *ValueIterator) Size() (int64, bool) {
+	if it.size >= 0 {
+		return it.size, true
+	}
+	iter := it.qs.db.NewIterator(it.keys, it.ro)
+	defer iter.Release()
+	var n int64
+	for iter.Next() {
+		n++
+	}
+	it.size = n
+	return n, true
+}
+
+func (it *ValueIterator) Describe() graph.Description {
+	size, _ := it.Size()
+	return graph.Description{
+		UID:  it.UID(),
+		Type: it.Type(),
+		Tags: it.tags.Tags(),
+		Size: size,
+	}
+}
+
+func (it *ValueIterator) Type() graph.Type { return levelDBValueType }
+func (it *ValueIterator) Sorted() bool     { return false }
+
+func (it *ValueIterator) Optimize() (graph.Iterator, bool) {
+	return it, false
+}
+
+func (it *ValueIterator) Stats() graph.IteratorStats {
+	s, _ := it.Size()
+	return graph.IteratorStats{
+		ContainsCost: 1,
+		NextCost:     2,
+		Size:         s,
+	}
+}
@@ -12,7 +12,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package mongo
+// Package lru provides a size-bounded cache for the QuadStores, which drops
+// the least recently used entry when it is full.
+package lru
 
 import (
 	"container/list"
@@ -20,28 +22,32 @@ import (
 
 // TODO(kortschak) Reimplement without container/list.
 
-// cache implements an LRU cache.
-type cache struct {
-	cache    map[string]*list.Element
+// Cache implements an LRU cache. Keys must be comparable. It is not safe for
+// concurrent use.
+type Cache struct {
+	cache    map[interface{}]*list.Element
 	priority *list.List
 	maxSize  int
 }
 
 type kv struct {
-	key   string
-	value string
+	key   interface{}
+	value interface{}
 }
 
-func newCache(size int) *cache {
-	var lru cache
+func New(size int) *Cache {
+	var lru Cache
 	lru.maxSize = size
 	lru.priority = list.New()
-	lru.cache = make(map[string]*list.Element)
+	lru.cache = make(map[interface{}]*list.Element)
 	return &lru
 }
 
-func (lru *cache) Put(key string, value string) {
-	if _, ok := lru.Get(key); ok {
+// Put adds or replaces the value for key.
+func (lru *Cache) Put(key, value interface{}) {
+	if element, ok := lru.cache[key]; ok {
+		element.Value = kv{key: key, value: value}
+		lru.priority.MoveToFront(element)
 		return
 	}
 	if len(lru.cache) == lru.maxSize {
@@ -51,15 +57,28 @@ func (lru *cache) Put(key string, value string) {
 	lru.cache[key] = lru.priority.Front()
 }
 
-func (lru *cache) Get(key string) (string, bool) {
+func (lru *Cache) Get(key interface{}) (interface{}, bool) {
 	if element, ok := lru.cache[key]; ok {
 		lru.priority.MoveToFront(element)
 		return element.Value.(kv).value, true
 	}
-	return "", false
+	return nil, false
+}
+
+// Remove drops key from the cache, if it is there.
+func (lru *Cache) Remove(key interface{}) {
+	if element, ok := lru.cache[key]; ok {
+		lru.priority.Remove(element)
+		delete(lru.cache, key)
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (lru *Cache) Len() int {
+	return len(lru.cache)
 }
 
-func (lru *cache) removeOldest() {
+func (lru *Cache) removeOldest() {
 	last := lru.priority.Remove(lru.priority.Back())
 	delete(lru.cache, last.(kv).key)
 }
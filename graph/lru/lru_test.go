@@ -0,0 +1,47 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lru
+
+import "testing"
+
+func TestCache(t *testing.T) {
+	c := New(2)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Unexpected value for a, got:%v,%t expect:1,true", v, ok)
+	}
+
+	// b is now the least recently used.
+	c.Put("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Failed to evict b")
+	}
+	if c.Len() != 2 {
+		t.Errorf("Unexpected length, got:%d expect:2", c.Len())
+	}
+
+	c.Put("a", 4)
+	if v, _ := c.Get("a"); v != 4 {
+		t.Errorf("Failed to replace a, got:%v expect:4", v)
+	}
+	c.Remove("a")
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Failed to remove a")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Unexpected length, got:%d expect:1", c.Len())
+	}
+}
@@ -16,6 +16,8 @@ package memstore
 
 import (
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/barakmich/glog"
 
@@ -94,7 +96,15 @@ func newQuadStore() *QuadStore {
 	}
 }
 
-func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta) error {
+func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, opts graph.IgnoreOpts) error {
+	// Check the whole set first, so that a bad delta leaves the store as it was.
+	deltas, err := graph.CheckDeltas(deltas, opts, func(q quad.Quad) (bool, error) {
+		_, exists := qs.indexOf(q)
+		return exists, nil
+	})
+	if err != nil {
+		return err
+	}
 	for _, d := range deltas {
 		var err error
 		if d.Action == graph.Add {
@@ -111,6 +121,36 @@ func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta) error {
 
 const maxInt = int(^uint(0) >> 1)
 
+// BulkLoad loads quads into an empty store, adding each one directly rather
+// than checking it as part of a set of deltas. Duplicate quads are skipped.
+func (qs *QuadStore) BulkLoad(dec quad.Unmarshaler) error {
+	if len(qs.log) > 1 {
+		return graph.ErrCannotBulkLoad
+	}
+	now := time.Now()
+	for {
+		q, err := dec.Unmarshal()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if !q.IsValid() {
+			continue
+		}
+		err = qs.AddDelta(graph.Delta{
+			ID:        qs.nextQuadID,
+			Quad:      q,
+			Action:    graph.Add,
+			Timestamp: now,
+		})
+		if err != nil && err != graph.ErrQuadExists {
+			return err
+		}
+	}
+}
+
 func (qs *QuadStore) indexOf(t quad.Quad) (int64, bool) {
 	min := maxInt
 	var tree *b.Tree
@@ -15,13 +15,16 @@
 package memstore
 
 import (
+	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
 	"github.com/google/cayley/quad"
+	"github.com/google/cayley/quad/cquads"
 	"github.com/google/cayley/writer"
 )
 
@@ -204,3 +207,338 @@ func TestRemoveQuad(t *testing.T) {
 		t.Error("E should not have any followers.")
 	}
 }
+
+func TestTransaction(t *testing.T) {
+	qs, w, _ := makeTestStore(simpleGraph)
+	size := qs.Size()
+	horizon := qs.Horizon()
+
+	// Replacing an edge applies both halves.
+	tx := graph.NewTransaction()
+	tx.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "G", ""})
+	if err := w.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Unexpected error applying transaction: %v", err)
+	}
+	if _, ok := qs.indexOf(quad.Quad{"E", "follows", "F", ""}); ok {
+		t.Errorf("Removed quad is still present")
+	}
+	if _, ok := qs.indexOf(quad.Quad{"E", "follows", "G", ""}); !ok {
+		t.Errorf("Added quad is missing")
+	}
+	if s := qs.Size(); s != size {
+		t.Errorf("Unexpected quadstore size, got:%d expect:%d", s, size)
+	}
+
+	// A bad delta anywhere leaves the store untouched.
+	size = qs.Size()
+	horizon = qs.Horizon()
+	tx = graph.NewTransaction()
+	tx.AddQuad(quad.Quad{"A", "follows", "G", ""})
+	tx.RemoveQuad(quad.Quad{"A", "follows", "E", ""})
+	if err := w.ApplyTransaction(tx); err != graph.ErrQuadNotExist {
+		t.Errorf("Unexpected error, got:%v expect:%v", err, graph.ErrQuadNotExist)
+	}
+	if _, ok := qs.indexOf(quad.Quad{"A", "follows", "G", ""}); ok {
+		t.Errorf("Quad from a failed transaction was added")
+	}
+	if s := qs.Size(); s != size {
+		t.Errorf("Unexpected quadstore size, got:%d expect:%d", s, size)
+	}
+	if h := qs.Horizon(); h != horizon {
+		t.Errorf("Unexpected horizon, got:%d expect:%d", h, horizon)
+	}
+}
+
+func TestIgnoreOpts(t *testing.T) {
+	qs, w, _ := makeTestStore(simpleGraph)
+	size := qs.Size()
+
+	if err := w.AddQuad(quad.Quad{"A", "follows", "B", ""}); err != graph.ErrQuadExists {
+		t.Errorf("Unexpected error adding a duplicate, got:%v expect:%v", err, graph.ErrQuadExists)
+	}
+	if err := w.RemoveQuad(quad.Quad{"A", "follows", "G", ""}); err != graph.ErrQuadNotExist {
+		t.Errorf("Unexpected error removing a missing quad, got:%v expect:%v", err, graph.ErrQuadNotExist)
+	}
+
+	tx := graph.NewTransaction()
+	tx.AddQuad(quad.Quad{"A", "follows", "B", ""})
+	tx.AddQuad(quad.Quad{"A", "follows", "G", ""})
+	tx.RemoveQuad(quad.Quad{"A", "follows", "E", ""})
+	yes, no := true, false
+	tx.Ignore = graph.IgnoreOverride{IgnoreDup: &yes, IgnoreMissing: &yes}
+	if err := w.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Unexpected error applying a lenient transaction: %v", err)
+	}
+	if s := qs.Size(); s != size+1 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:%d", s, size+1)
+	}
+
+	lenient, _ := writer.NewSingleReplication(qs, graph.Options{"ignore_duplicate": true})
+	if err := lenient.AddQuadSet([]quad.Quad{{"A", "follows", "B", ""}, {"A", "follows", "D", ""}}); err != nil {
+		t.Errorf("Unexpected error from a writer ignoring duplicates: %v", err)
+	}
+	if s := qs.Size(); s != size+2 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:%d", s, size+2)
+	}
+
+	// A transaction's own options replace the writer's.
+	tx = graph.NewTransaction()
+	tx.AddQuad(quad.Quad{"A", "follows", "B", ""})
+	tx.Ignore = graph.IgnoreOverride{IgnoreDup: &no}
+	if err := lenient.ApplyTransaction(tx); err != graph.ErrQuadExists {
+		t.Errorf("Unexpected error overriding the writer's options, got:%v expect:%v", err, graph.ErrQuadExists)
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	qs := newQuadStore()
+	w, _ := writer.NewSingleReplication(qs, nil)
+
+	// Load the quads with a duplicate.
+	var lines []string
+	for _, q := range simpleGraph {
+		lines = append(lines, q.NQuad())
+	}
+	lines = append(lines, lines[0])
+	dec := cquads.NewDecoder(strings.NewReader(strings.Join(lines, "\n")))
+	if err := w.(graph.BulkLoader).BulkLoad(dec); err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if s := qs.Size(); s != int64(len(simpleGraph)) {
+		t.Errorf("Unexpected quadstore size, got:%d expect:%d", s, len(simpleGraph))
+	}
+	for _, q := range simpleGraph {
+		if _, ok := qs.indexOf(q); !ok {
+			t.Errorf("Failed to find bulk loaded quad %v", q)
+		}
+	}
+
+	// The writer carries on after the loaded quads.
+	err := w.AddQuad(quad.Quad{"G", "follows", "A", ""})
+	if err != nil {
+		t.Errorf("Failed to add a quad after bulk loading: %v", err)
+	}
+	if h := qs.Horizon(); h != int64(len(simpleGraph))+1 {
+		t.Errorf("Unexpected horizon, got:%d expect:%d", h, len(simpleGraph)+1)
+	}
+
+	dec = cquads.NewDecoder(strings.NewReader(lines[0]))
+	if err := w.(graph.BulkLoader).BulkLoad(dec); err != graph.ErrCannotBulkLoad {
+		t.Errorf("Unexpected error loading into a non-empty store, got:%v expect:%v", err, graph.ErrCannotBulkLoad)
+	}
+}
+
+var shortestPathTests = []struct {
+	message  string
+	start    []string
+	goal     []string
+	preds    []string
+	maxDepth int
+	expect   []string
+}{
+	{
+		message: "find a path",
+		start:   []string{"A"},
+		goal:    []string{"G"},
+		expect:  []string{"A", "B", "F", "G"},
+	},
+	{
+		message: "find a path from each start",
+		start:   []string{"E", "C"},
+		goal:    []string{"G"},
+		expect:  []string{"E", "F", "G", "C", "D", "G"},
+	},
+	{
+		message: "find a path along predicates",
+		start:   []string{"A"},
+		goal:    []string{"cool"},
+		preds:   []string{"follows", "status"},
+		expect:  []string{"A", "B", "cool"},
+	},
+	{
+		message: "find no path along other predicates",
+		start:   []string{"A"},
+		goal:    []string{"cool"},
+		preds:   []string{"follows"},
+		expect:  nil,
+	},
+	{
+		message:  "find a path within a depth",
+		start:    []string{"C"},
+		goal:     []string{"G"},
+		maxDepth: 2,
+		expect:   []string{"C", "D", "G"},
+	},
+	{
+		message:  "find no path within a smaller depth",
+		start:    []string{"A"},
+		goal:     []string{"G"},
+		maxDepth: 2,
+		expect:   nil,
+	},
+	{
+		message: "find no path against the quads",
+		start:   []string{"G"},
+		goal:    []string{"A"},
+		expect:  nil,
+	},
+}
+
+func TestShortestPath(t *testing.T) {
+	qs, _, _ := makeTestStore(simpleGraph)
+	fixedOf := func(names []string) graph.Iterator {
+		f := qs.FixedIterator()
+		for _, name := range names {
+			f.Add(qs.ValueOf(name))
+		}
+		return f
+	}
+	for _, test := range shortestPathTests {
+		var preds []graph.Value
+		for _, name := range test.preds {
+			preds = append(preds, qs.ValueOf(name))
+		}
+		it := iterator.NewShortestPath(qs, fixedOf(test.start), fixedOf(test.goal), preds, test.maxDepth)
+		for i := 0; i < 2; i++ {
+			var got []string
+			for graph.Next(it) {
+				got = append(got, qs.NameOf(it.Result()))
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("Failed to %s on repeat %d, got:%v expect:%v", test.message, i, got, test.expect)
+			}
+			it.Reset()
+		}
+		if len(test.expect) > 0 && !it.Contains(qs.ValueOf(test.expect[1])) {
+			t.Errorf("Failed to %s, %s is not contained", test.message, test.expect[1])
+		}
+		if it.Contains(qs.ValueOf("status_graph")) {
+			t.Errorf("Failed to %s, status_graph is contained", test.message)
+		}
+	}
+}
+
+func TestShortestPathTags(t *testing.T) {
+	qs, _, _ := makeTestStore(simpleGraph)
+	start := qs.FixedIterator()
+	start.Add(qs.ValueOf("E"))
+	start.Add(qs.ValueOf("C"))
+	goal := qs.FixedIterator()
+	goal.Add(qs.ValueOf("G"))
+	it := iterator.NewShortestPath(qs, start, goal, nil, 0)
+	it.AddPathTag("path")
+	it.AddStepTag("step")
+
+	stepOf := func() string {
+		tags := make(map[string]graph.Value)
+		it.TagResults(tags)
+		return fmt.Sprintf("%s:%s.%s", qs.NameOf(it.Result()), iterator.NameOf(qs, tags["path"]), iterator.NameOf(qs, tags["step"]))
+	}
+	var got []string
+	for graph.Next(it) {
+		got = append(got, stepOf())
+	}
+	expect := []string{"E:0.0", "F:0.1", "G:0.2", "C:1.0", "D:1.1", "G:1.2"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected path steps, got:%v expect:%v", got, expect)
+	}
+
+	// A node on several paths is contained on the first.
+	for _, test := range []struct{ node, expect string }{{"D", "D:1.1"}, {"G", "G:0.2"}} {
+		if !it.Contains(qs.ValueOf(test.node)) {
+			t.Errorf("Expected %s to be contained", test.node)
+			continue
+		}
+		if got := stepOf(); got != test.expect {
+			t.Errorf("Unexpected path step of a contained node, got:%s expect:%s", got, test.expect)
+		}
+	}
+}
+
+var recursiveTests = []struct {
+	message  string
+	start    []string
+	maxDepth int
+	expect   map[string]int
+}{
+	{
+		message: "follow to the end",
+		start:   []string{"A"},
+		expect:  map[string]int{"B": 1, "F": 2, "G": 3},
+	},
+	{
+		message:  "follow within a depth",
+		start:    []string{"A"},
+		maxDepth: 2,
+		expect:   map[string]int{"B": 1, "F": 2},
+	},
+	{
+		message: "follow each node once",
+		start:   []string{"C"},
+		expect:  map[string]int{"B": 1, "D": 1, "F": 2, "G": 2},
+	},
+	{
+		message: "follow past the starts",
+		start:   []string{"B", "D"},
+		expect:  map[string]int{"B": 1, "F": 1, "G": 1},
+	},
+	{
+		message:  "follow to starts reached from other starts",
+		start:    []string{"B", "D", "G", "F"},
+		maxDepth: 2,
+		expect:   map[string]int{"B": 1, "F": 1, "G": 1},
+	},
+	{
+		message: "follow from several starts",
+		start:   []string{"A", "E"},
+		expect:  map[string]int{"B": 1, "F": 1, "G": 2},
+	},
+	{
+		message: "follow nowhere",
+		start:   []string{"G"},
+		expect:  map[string]int{},
+	},
+}
+
+func TestRecursive(t *testing.T) {
+	qs, _, _ := makeTestStore(simpleGraph)
+	follows := func(base graph.Iterator) graph.Iterator {
+		pred := qs.FixedIterator()
+		pred.Add(qs.ValueOf("follows"))
+		and := iterator.NewAnd()
+		and.AddSubIterator(iterator.NewLinksTo(qs, base, quad.Subject))
+		and.AddSubIterator(iterator.NewLinksTo(qs, pred, quad.Predicate))
+		return iterator.NewHasA(qs, and, quad.Object)
+	}
+	for _, test := range recursiveTests {
+		start := qs.FixedIterator()
+		for _, name := range test.start {
+			start.Add(qs.ValueOf(name))
+		}
+		it := iterator.NewRecursive(qs, start, follows, test.maxDepth)
+		it.AddDepthTag("depth")
+		for i := 0; i < 2; i++ {
+			got := make(map[string]int)
+			for graph.Next(it) {
+				tags := make(map[string]graph.Value)
+				it.TagResults(tags)
+				got[qs.NameOf(it.Result())] = int(tags["depth"].(iterator.Depth))
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("Failed to %s on repeat %d, got:%v expect:%v", test.message, i, got, test.expect)
+			}
+			it.Reset()
+		}
+		for name := range test.expect {
+			if !it.Contains(qs.ValueOf(name)) {
+				t.Errorf("Failed to %s, %s is not contained", test.message, name)
+			}
+		}
+		for _, name := range test.start {
+			if _, ok := test.expect[name]; !ok && it.Contains(qs.ValueOf(name)) {
+				t.Errorf("Failed to %s, start %s is contained", test.message, name)
+			}
+		}
+	}
+}
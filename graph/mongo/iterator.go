@@ -128,6 +128,7 @@ func (it *Iterator) Clone() graph.Iterator {
 }
 
 func (it *Iterator) Next() bool {
+	graph.NextLogIn(it)
 	var result struct {
 		ID      string  `bson:"_id"`
 		Added   []int64 `bson:"Added"`
@@ -139,13 +140,13 @@ func (it *Iterator) Next() bool {
 		if err != nil {
 			glog.Errorln("Error Nexting Iterator: ", err)
 		}
-		return false
+		return graph.NextLogOut(it, nil, false)
 	}
 	if it.collection == "quads" && len(result.Added) <= len(result.Deleted) {
 		return it.Next()
 	}
 	it.result = result.ID
-	return true
+	return graph.NextLogOut(it, it.result, true)
 }
 
 func (it *Iterator) ResultTree() *graph.ResultTree {
@@ -26,6 +26,7 @@ import (
 	"github.com/barakmich/glog"
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/graph/lru"
 	"github.com/google/cayley/quad"
 )
 
@@ -45,7 +46,7 @@ var (
 type QuadStore struct {
 	session *mgo.Session
 	db      *mgo.Database
-	ids     *cache
+	ids     *lru.Cache
 }
 
 func createNewMongoGraph(addr string, options graph.Options) error {
@@ -97,7 +98,7 @@ func newQuadStore(addr string, options graph.Options) (graph.QuadStore, error) {
 	}
 	qs.db = conn.DB(dbName)
 	qs.session = conn
-	qs.ids = newCache(1 << 16)
+	qs.ids = lru.New(1 << 16)
 	return &qs, nil
 }
 
@@ -173,23 +174,23 @@ func (qs *QuadStore) updateQuad(q quad.Quad, id int64, proc graph.Procedure) err
 	return err
 }
 
-func (qs *QuadStore) checkValid(key string) bool {
+func (qs *QuadStore) checkValid(key string) (bool, error) {
 	var indexEntry struct {
 		Added   []int64 `bson:"Added"`
 		Deleted []int64 `bson:"Deleted"`
 	}
 	err := qs.db.C("quads").FindId(key).One(&indexEntry)
 	if err == mgo.ErrNotFound {
-		return false
+		return false, nil
 	}
 	if err != nil {
-		glog.Errorln("Other error checking valid quad: %s %v.", key, err)
-		return false
+		glog.Errorf("Other error checking valid quad: %s %v.", key, err)
+		return false, err
 	}
 	if len(indexEntry.Added) <= len(indexEntry.Deleted) {
-		return false
+		return false, nil
 	}
-	return true
+	return true, nil
 }
 
 func (qs *QuadStore) updateLog(d graph.Delta) error {
@@ -212,22 +213,18 @@ func (qs *QuadStore) updateLog(d graph.Delta) error {
 	return err
 }
 
-func (qs *QuadStore) ApplyDeltas(in []graph.Delta) error {
+func (qs *QuadStore) ApplyDeltas(in []graph.Delta, opts graph.IgnoreOpts) error {
 	qs.session.SetSafe(nil)
+	defer qs.session.SetSafe(&mgo.Safe{})
 	ids := make(map[string]int)
-	// Pre-check the existence condition.
-	for _, d := range in {
-		key := qs.getIDForQuad(d.Quad)
-		switch d.Action {
-		case graph.Add:
-			if qs.checkValid(key) {
-				return graph.ErrQuadExists
-			}
-		case graph.Delete:
-			if !qs.checkValid(key) {
-				return graph.ErrQuadNotExist
-			}
-		}
+	// Pre-check the existence condition of the whole set, so that a bad
+	// delta is rejected before anything is written. MongoDB has no
+	// transactions, so a failure to write partway through cannot be undone.
+	in, err := graph.CheckDeltas(in, opts, func(q quad.Quad) (bool, error) {
+		return qs.checkValid(qs.getIDForQuad(q))
+	})
+	if err != nil {
+		return err
 	}
 	if glog.V(2) {
 		glog.Infoln("Existence verified. Proceeding.")
@@ -262,7 +259,6 @@ func (qs *QuadStore) ApplyDeltas(in []graph.Delta) error {
 			return err
 		}
 	}
-	qs.session.SetSafe(&mgo.Safe{})
 	return nil
 }
 
@@ -294,7 +290,7 @@ func (qs *QuadStore) ValueOf(s string) graph.Value {
 func (qs *QuadStore) NameOf(v graph.Value) string {
 	val, ok := qs.ids.Get(v.(string))
 	if ok {
-		return val
+		return val.(string)
 	}
 	var node MongoNode
 	err := qs.db.C("nodes").FindId(v.(string)).One(&node)
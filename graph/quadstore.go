@@ -43,8 +43,9 @@ type Value interface{}
 
 type QuadStore interface {
 	// The only way in is through building a transaction, which
-	// is done by a replication strategy.
-	ApplyDeltas([]Delta) error
+	// is done by a replication strategy. Either all the deltas are applied,
+	// apart from those skipped by the IgnoreOpts, or none of them are.
+	ApplyDeltas([]Delta, IgnoreOpts) error
 
 	// Given an opaque token, returns the quad for that token from the store.
 	Quad(Value) quad.Quad
@@ -58,6 +58,91 @@ var (
 	ErrQuadNotExist = errors.New("quad does not exist")
 )
 
+// IgnoreOpts lets a write skip the deltas that would otherwise make it fail.
+type IgnoreOpts struct {
+	// IgnoreDup skips adding quads that are already present.
+	IgnoreDup bool
+	// IgnoreMissing skips removing quads that are not present.
+	IgnoreMissing bool
+}
+
+// IgnoreOverride replaces a QuadWriter's own IgnoreOpts for a single write.
+// Options left nil keep the QuadWriter's setting.
+type IgnoreOverride struct {
+	IgnoreDup     *bool
+	IgnoreMissing *bool
+}
+
+// Over returns opts with the options o sets replaced.
+func (o IgnoreOverride) Over(opts IgnoreOpts) IgnoreOpts {
+	if o.IgnoreDup != nil {
+		opts.IgnoreDup = *o.IgnoreDup
+	}
+	if o.IgnoreMissing != nil {
+		opts.IgnoreMissing = *o.IgnoreMissing
+	}
+	return opts
+}
+
+// CheckDeltas makes sure that every delta can be applied, in order, to a
+// store in which exists reports whether a quad is present, and returns the
+// deltas that should be. It lets a QuadStore reject a bad set of deltas
+// before it has written any of them.
+func CheckDeltas(deltas []Delta, opts IgnoreOpts, exists func(quad.Quad) (bool, error)) ([]Delta, error) {
+	pending := make(map[quad.Quad]bool)
+	out := make([]Delta, 0, len(deltas))
+	for _, d := range deltas {
+		present, ok := pending[d.Quad]
+		if !ok {
+			var err error
+			present, err = exists(d.Quad)
+			if err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case d.Action == Add && present:
+			if opts.IgnoreDup {
+				continue
+			}
+			return nil, ErrQuadExists
+		case d.Action == Delete && !present:
+			if opts.IgnoreMissing {
+				continue
+			}
+			return nil, ErrQuadNotExist
+		}
+		pending[d.Quad] = d.Action == Add
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// Transaction collects quads to add and remove so that they can be applied
+// together, by QuadWriter.ApplyTransaction, as a single set of deltas.
+type Transaction struct {
+	// Deltas holds the changes in the order they were made. Their IDs and
+	// timestamps are filled in by the QuadWriter.
+	Deltas []Delta
+
+	// Ignore overrides the QuadWriter's own options for this transaction.
+	Ignore IgnoreOverride
+}
+
+func NewTransaction() *Transaction {
+	return &Transaction{}
+}
+
+// AddQuad adds a quad to the transaction.
+func (t *Transaction) AddQuad(q quad.Quad) {
+	t.Deltas = append(t.Deltas, Delta{Quad: q, Action: Add})
+}
+
+// RemoveQuad removes a quad in the transaction.
+func (t *Transaction) RemoveQuad(q quad.Quad) {
+	t.Deltas = append(t.Deltas, Delta{Quad: q, Action: Delete})
+}
+
 type QuadWriter interface {
 	// Add a quad to the store.
 	AddQuad(quad.Quad) error
@@ -69,6 +154,9 @@ type QuadWriter interface {
 	// if it exists. Does nothing otherwise.
 	RemoveQuad(quad.Quad) error
 
+	// Applies all the changes in a transaction, or none of them.
+	ApplyTransaction(*Transaction) error
+
 	// Cleans up replication and closes the writing aspect of the database.
 	Close() error
 }
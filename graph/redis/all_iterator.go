@@ -0,0 +1,150 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"github.com/barakmich/glog"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+)
+
+type AllIterator struct {
+	uid    uint64
+	tags   graph.Tagger
+	nodes  bool
+	scan   scanner
+	qs     *QuadStore
+	result graph.Value
+}
+
+// NewAllIterator returns an iterator over every node in use, or over every
+// quad, in spo order.
+func NewAllIterator(qs *QuadStore, nodes bool) *AllIterator {
+	key := "spo"
+	if nodes {
+		key = "nodes"
+	}
+	return &AllIterator{
+		uid:   iterator.NextUID(),
+		nodes: nodes,
+		scan:  scanner{qs: qs, key: qs.key(key)},
+		qs:    qs,
+	}
+}
+
+func (it *AllIterator) UID() uint64 {
+	return it.uid
+}
+
+func (it *AllIterator) Reset() {
+	it.scan.reset()
+	it.result = nil
+}
+
+func (it *AllIterator) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *AllIterator) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+}
+
+func (it *AllIterator) Clone() graph.Iterator {
+	out := NewAllIterator(it.qs, it.nodes)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *AllIterator) Next() bool {
+	graph.NextLogIn(it)
+	member, ok := it.scan.next()
+	if !ok {
+		it.result = nil
+		return graph.NextLogOut(it, nil, false)
+	}
+	it.result = Token(member)
+	return graph.NextLogOut(it, it.result, true)
+}
+
+func (it *AllIterator) ResultTree() *graph.ResultTree {
+	return graph.NewResultTree(it.Result())
+}
+
+func (it *AllIterator) Result() graph.Value {
+	return it.result
+}
+
+func (it *AllIterator) NextPath() bool {
+	return false
+}
+
+// No subiterators.
+func (it *AllIterator) SubIterators() []graph.Iterator {
+	return nil
+}
+
+func (it *AllIterator) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
+	conn := it.qs.pool.Get()
+	defer conn.Close()
+	score, err := conn.Do("ZSCORE", it.scan.key, string(v.(Token)))
+	if err != nil {
+		glog.Errorln("Error: could not read from DB:", err)
+	}
+	if score == nil {
+		return graph.ContainsLogOut(it, v, false)
+	}
+	it.result = v
+	return graph.ContainsLogOut(it, v, true)
+}
+
+func (it *AllIterator) Close() {}
+
+func (it *AllIterator) Size() (int64, bool) {
+	return it.scan.size(), true
+}
+
+func (it *AllIterator) Describe() graph.Description {
+	size, _ := it.Size()
+	return graph.Description{
+		UID:  it.UID(),
+		Type: it.Type(),
+		Tags: it.tags.Tags(),
+		Size: size,
+	}
+}
+
+func (it *AllIterator) Type() graph.Type { return graph.All }
+func (it *AllIterator) Sorted() bool     { return false }
+
+func (it *AllIterator) Optimize() (graph.Iterator, bool) {
+	return it, false
+}
+
+func (it *AllIterator) Stats() graph.IteratorStats {
+	s, _ := it.Size()
+	return graph.IteratorStats{
+		ContainsCost: 1,
+		NextCost:     2,
+		Size:         s,
+	}
+}
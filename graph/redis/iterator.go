@@ -0,0 +1,225 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"github.com/barakmich/glog"
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+)
+
+// pageSize is the number of members read from Redis at a time.
+const pageSize = 1000
+
+// scanner pages through the members of a sorted set that start with prefix,
+// in order.
+type scanner struct {
+	qs     *QuadStore
+	key    string
+	prefix string
+	last   string
+	page   []string
+	done   bool
+}
+
+func (s *scanner) reset() {
+	s.last = ""
+	s.page = nil
+	s.done = false
+}
+
+func (s *scanner) bounds() (string, string) {
+	return "[" + s.prefix, "[" + s.prefix + "\xff"
+}
+
+func (s *scanner) next() (string, bool) {
+	if len(s.page) == 0 {
+		if s.done {
+			return "", false
+		}
+		min, max := s.bounds()
+		if s.last != "" {
+			min = "(" + s.last
+		}
+		conn := s.qs.pool.Get()
+		page, err := redis.Strings(conn.Do("ZRANGEBYLEX", s.key, min, max, "LIMIT", 0, pageSize))
+		conn.Close()
+		if err != nil {
+			glog.Errorln("Error: could not read from DB:", err)
+			s.done = true
+			return "", false
+		}
+		s.page = page
+		s.done = len(page) < pageSize
+		if len(page) == 0 {
+			return "", false
+		}
+	}
+	s.last = s.page[0]
+	s.page = s.page[1:]
+	return s.last, true
+}
+
+// size returns the number of members that start with prefix.
+func (s *scanner) size() int64 {
+	min, max := s.bounds()
+	conn := s.qs.pool.Get()
+	defer conn.Close()
+	n, err := redis.Int64(conn.Do("ZLEXCOUNT", s.key, min, max))
+	if err != nil {
+		glog.Errorln("Error: could not read from DB:", err)
+	}
+	return n
+}
+
+type Iterator struct {
+	uid     uint64
+	tags    graph.Tagger
+	dir     quad.Direction
+	checkID Token
+	order   [4]quad.Direction
+	scan    scanner
+	qs      *QuadStore
+	result  graph.Value
+}
+
+func NewIterator(qs *QuadStore, d quad.Direction, value graph.Value) *Iterator {
+	name, order := indexFor(d)
+	return &Iterator{
+		uid:     iterator.NextUID(),
+		dir:     d,
+		checkID: value.(Token),
+		order:   order,
+		scan: scanner{
+			qs:     qs,
+			key:    qs.key(name),
+			prefix: string(value.(Token)),
+		},
+		qs: qs,
+	}
+}
+
+func (it *Iterator) UID() uint64 {
+	return it.uid
+}
+
+func (it *Iterator) Reset() {
+	it.scan.reset()
+	it.result = nil
+}
+
+func (it *Iterator) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Iterator) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+}
+
+func (it *Iterator) Clone() graph.Iterator {
+	out := NewIterator(it.qs, it.dir, it.checkID)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *Iterator) Close() {}
+
+func (it *Iterator) Next() bool {
+	graph.NextLogIn(it)
+	member, ok := it.scan.next()
+	if !ok {
+		it.result = nil
+		return graph.NextLogOut(it, nil, false)
+	}
+	it.result = tokenFor(it.order, member)
+	return graph.NextLogOut(it, it.result, true)
+}
+
+func (it *Iterator) ResultTree() *graph.ResultTree {
+	return graph.NewResultTree(it.Result())
+}
+
+func (it *Iterator) Result() graph.Value {
+	return it.result
+}
+
+func (it *Iterator) NextPath() bool {
+	return false
+}
+
+// No subiterators.
+func (it *Iterator) SubIterators() []graph.Iterator {
+	return nil
+}
+
+func (it *Iterator) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
+	// As with Next, any quad Token has already been read from the store, so
+	// it is only the direction that needs checking.
+	if it.qs.QuadDirection(v, it.dir) == it.checkID {
+		it.result = v
+		return graph.ContainsLogOut(it, v, true)
+	}
+	return graph.ContainsLogOut(it, v, false)
+}
+
+func (it *Iterator) Size() (int64, bool) {
+	return it.scan.size(), true
+}
+
+func (it *Iterator) Describe() graph.Description {
+	size, _ := it.Size()
+	return graph.Description{
+		UID:       it.UID(),
+		Name:      it.qs.NameOf(it.checkID),
+		Type:      it.Type(),
+		Tags:      it.tags.Tags(),
+		Size:      size,
+		Direction: it.dir,
+	}
+}
+
+var redisType graph.Type
+
+func init() {
+	redisType = graph.RegisterIterator("redis")
+}
+
+func Type() graph.Type { return redisType }
+
+func (it *Iterator) Type() graph.Type { return redisType }
+func (it *Iterator) Sorted() bool     { return false }
+
+func (it *Iterator) Optimize() (graph.Iterator, bool) {
+	return it, false
+}
+
+func (it *Iterator) Stats() graph.IteratorStats {
+	s, _ := it.Size()
+	return graph.IteratorStats{
+		ContainsCost: 1,
+		NextCost:     2,
+		Size:         s,
+	}
+}
@@ -0,0 +1,516 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+// A QuadStore kept in Redis. Nodes are named by the hex SHA1 of their
+// names, and each quad is indexed in the spo, osp, pos and cps orders of the
+// LevelDB and Bolt stores, as a member of a sorted set made of the hashes in
+// that order. Every member has the same score, so the set is sorted
+// lexicographically and the quads linked to a node are a ZRANGEBYLEX away.
+//
+// The keys used, all under the key_prefix option, are:
+//
+//   spo, osp, pos, cps  the quad indexes; cps only holds quads with labels
+//   nodes               a sorted set of the hashes of all nodes in use
+//   names               a hash from node hashes to names
+//   sizes               a hash from node hashes to the number of quads using them
+//   log                 a hash from delta IDs to the deltas, as JSON
+//   meta                a hash holding the size and horizon
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/barakmich/glog"
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+)
+
+func init() {
+	graph.RegisterQuadStore("redis", true, newQuadStore, createNewRedis)
+}
+
+const (
+	DefaultKeyPrefix = "cayley:"
+
+	// bulkBatchSize is the number of quads read at a time by BulkLoad.
+	bulkBatchSize = 10000
+)
+
+var (
+	hashPool = sync.Pool{
+		New: func() interface{} { return sha1.New() },
+	}
+	hashSize = sha1.Size * 2
+)
+
+// ErrConcurrentWrite is returned by ApplyDeltas when another writer changed
+// the store while the deltas were being checked. Nothing is written.
+var ErrConcurrentWrite = errors.New("redis: concurrent write")
+
+// Token is the hash of a node, or the hashes of a quad in spo order.
+type Token string
+
+type QuadStore struct {
+	pool    *redis.Pool
+	prefix  string
+	size    int64
+	horizon int64
+}
+
+// Short hand for direction permutations.
+var (
+	spo = [4]quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label}
+	osp = [4]quad.Direction{quad.Object, quad.Subject, quad.Predicate, quad.Label}
+	pos = [4]quad.Direction{quad.Predicate, quad.Object, quad.Subject, quad.Label}
+	cps = [4]quad.Direction{quad.Label, quad.Predicate, quad.Subject, quad.Object}
+)
+
+// indexFor returns the name and order of the index that leads with d.
+func indexFor(d quad.Direction) (string, [4]quad.Direction) {
+	switch d {
+	case quad.Subject:
+		return "spo", spo
+	case quad.Predicate:
+		return "pos", pos
+	case quad.Object:
+		return "osp", osp
+	case quad.Label:
+		return "cps", cps
+	}
+	panic("redis: invalid direction")
+}
+
+func newPool(addr string, options graph.Options) *redis.Pool {
+	var opts []redis.DialOption
+	if val, ok := options.IntKey("database"); ok {
+		opts = append(opts, redis.DialDatabase(val))
+	}
+	if val, ok := options.StringKey("password"); ok {
+		opts = append(opts, redis.DialPassword(val))
+	}
+	return &redis.Pool{
+		MaxIdle: 8,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr, opts...)
+		},
+	}
+}
+
+func keyPrefix(options graph.Options) string {
+	if val, ok := options.StringKey("key_prefix"); ok {
+		return val
+	}
+	return DefaultKeyPrefix
+}
+
+func createNewRedis(addr string, options graph.Options) error {
+	pool := newPool(addr, options)
+	defer pool.Close()
+	conn := pool.Get()
+	defer conn.Close()
+	meta := keyPrefix(options) + "meta"
+	conn.Send("HSETNX", meta, "size", 0)
+	conn.Send("HSETNX", meta, "horizon", 0)
+	if err := conn.Flush(); err != nil {
+		glog.Errorf("Error: could not create database: %v", err)
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Receive(); err != nil {
+			glog.Errorf("Error: could not create database: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func newQuadStore(addr string, options graph.Options) (graph.QuadStore, error) {
+	qs := &QuadStore{
+		pool:   newPool(addr, options),
+		prefix: keyPrefix(options),
+	}
+	conn := qs.pool.Get()
+	defer conn.Close()
+	vals, err := redis.Values(conn.Do("HMGET", qs.key("meta"), "size", "horizon"))
+	if err == nil {
+		_, err = redis.Scan(vals, &qs.size, &qs.horizon)
+	}
+	if err != nil {
+		glog.Errorln("Error, could not read metadata:", err)
+		qs.pool.Close()
+		return nil, err
+	}
+	return qs, nil
+}
+
+func (qs *QuadStore) key(name string) string {
+	return qs.prefix + name
+}
+
+func hashOf(s string) string {
+	h := hashPool.Get().(hash.Hash)
+	h.Reset()
+	defer hashPool.Put(h)
+	key := make([]byte, 0, sha1.Size)
+	h.Write([]byte(s))
+	key = h.Sum(key)
+	return hex.EncodeToString(key)
+}
+
+// createKeyFor returns the member of the index in order d for q.
+func createKeyFor(d [4]quad.Direction, q quad.Quad) string {
+	key := make([]byte, 0, hashSize*4)
+	for _, dir := range d {
+		key = append(key, hashOf(q.Get(dir))...)
+	}
+	return string(key)
+}
+
+// tokenFor turns a member of the index in order d into a quad Token.
+func tokenFor(d [4]quad.Direction, member string) Token {
+	key := make([]byte, hashSize*4)
+	for i, dir := range d {
+		copy(key[int(dir-1)*hashSize:], member[i*hashSize:(i+1)*hashSize])
+	}
+	return Token(key)
+}
+
+func (qs *QuadStore) exists(conn redis.Conn, q quad.Quad) (bool, error) {
+	score, err := conn.Do("ZSCORE", qs.key("spo"), createKeyFor(spo, q))
+	return score != nil, err
+}
+
+func (qs *QuadStore) ApplyDeltas(deltas []graph.Delta, opts graph.IgnoreOpts) error {
+	conn := qs.pool.Get()
+	defer conn.Close()
+	// Any write to the quads or node counts made by someone else between
+	// here and EXEC aborts the transaction.
+	if _, err := conn.Do("WATCH", qs.key("spo"), qs.key("sizes")); err != nil {
+		return err
+	}
+	defer conn.Do("UNWATCH")
+	// Check the whole set first; nothing is written unless all of it is.
+	deltas, err := graph.CheckDeltas(deltas, opts, func(q quad.Quad) (bool, error) {
+		return qs.exists(conn, q)
+	})
+	if err != nil {
+		return err
+	}
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	resizeMap := make(map[string]int64)
+	var names []string
+	sizeChange := int64(0)
+	for _, d := range deltas {
+		delta := int64(1)
+		if d.Action == graph.Delete {
+			delta = -1
+		}
+		for _, dir := range spo {
+			name := d.Quad.Get(dir)
+			if dir == quad.Label && name == "" {
+				continue
+			}
+			if _, ok := resizeMap[name]; !ok {
+				names = append(names, name)
+			}
+			resizeMap[name] += delta
+		}
+		sizeChange += delta
+	}
+	args := redis.Args{}.Add(qs.key("sizes"))
+	for _, name := range names {
+		args = args.Add(hashOf(name))
+	}
+	sizes, err := redis.Int64s(conn.Do("HMGET", args...))
+	if err != nil && err != redis.ErrNil {
+		glog.Errorln("Error: could not read node sizes:", err)
+		return err
+	}
+
+	conn.Send("MULTI")
+	for _, d := range deltas {
+		bytes, err := json.Marshal(d)
+		if err != nil {
+			conn.Do("DISCARD")
+			return err
+		}
+		conn.Send("HSET", qs.key("log"), d.ID, bytes)
+		qs.sendQuadWrite(conn, d.Quad, d.Action == graph.Add)
+	}
+	for i, name := range names {
+		h := hashOf(name)
+		size := sizes[i] + resizeMap[name]
+		if size > 0 {
+			conn.Send("HSET", qs.key("names"), h, name)
+			conn.Send("HSET", qs.key("sizes"), h, size)
+			conn.Send("ZADD", qs.key("nodes"), 0, h)
+		} else {
+			conn.Send("HDEL", qs.key("names"), h)
+			conn.Send("HDEL", qs.key("sizes"), h)
+			conn.Send("ZREM", qs.key("nodes"), h)
+		}
+	}
+	horizon := deltas[len(deltas)-1].ID
+	conn.Send("HINCRBY", qs.key("meta"), "size", sizeChange)
+	conn.Send("HSET", qs.key("meta"), "horizon", horizon)
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err == redis.ErrNil {
+		err = ErrConcurrentWrite
+	}
+	if err == nil {
+		err = firstError(replies)
+	}
+	if err != nil {
+		glog.Error("could not write to DB for quadset: ", err)
+		return err
+	}
+	qs.size += sizeChange
+	qs.horizon = horizon
+	return nil
+}
+
+// firstError returns the first error among a set of replies.
+func firstError(replies []interface{}) error {
+	for _, r := range replies {
+		if err, ok := r.(redis.Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendQuadWrite queues the index writes for adding or removing q.
+func (qs *QuadStore) sendQuadWrite(conn redis.Conn, q quad.Quad, isAdd bool) {
+	cmd := "ZREM"
+	if isAdd {
+		cmd = "ZADD"
+	}
+	for _, d := range [][4]quad.Direction{spo, osp, pos, cps} {
+		if d == cps && q.Label == "" {
+			continue
+		}
+		name, _ := indexFor(d[0])
+		if isAdd {
+			conn.Send(cmd, qs.key(name), 0, createKeyFor(d, q))
+		} else {
+			conn.Send(cmd, qs.key(name), createKeyFor(d, q))
+		}
+	}
+}
+
+// BulkLoad loads quads into an empty store, without checking them one at a
+// time or logging them as deltas. It does not change the horizon. Quads are
+// written a batch at a time; if loading fails partway, the store holds the
+// batches written so far.
+func (qs *QuadStore) BulkLoad(dec quad.Unmarshaler) error {
+	if qs.size != 0 {
+		return graph.ErrCannotBulkLoad
+	}
+	conn := qs.pool.Get()
+	defer conn.Close()
+	n, err := redis.Int64(conn.Do("ZCARD", qs.key("spo")))
+	if err != nil {
+		return err
+	}
+	if n != 0 {
+		return graph.ErrCannotBulkLoad
+	}
+
+	for {
+		batch := make([]quad.Quad, 0, bulkBatchSize)
+		seen := make(map[quad.Quad]bool)
+		var done bool
+		for len(batch) < bulkBatchSize {
+			q, err := dec.Unmarshal()
+			if err != nil {
+				if err == io.EOF {
+					done = true
+					break
+				}
+				return err
+			}
+			if !q.IsValid() || seen[q] {
+				continue
+			}
+			seen[q] = true
+			batch = append(batch, q)
+		}
+		if err := qs.bulkWrite(conn, batch); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+func (qs *QuadStore) bulkWrite(conn redis.Conn, batch []quad.Quad) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	// Duplicates of quads from earlier batches are only found as they are
+	// added to the spo index, so that goes first.
+	for _, q := range batch {
+		conn.Send("ZADD", qs.key("spo"), 0, createKeyFor(spo, q))
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	var added []quad.Quad
+	for _, q := range batch {
+		n, err := redis.Int(conn.Receive())
+		if err != nil {
+			return err
+		}
+		if n == 1 {
+			added = append(added, q)
+		}
+	}
+
+	resizeMap := make(map[string]int64)
+	for _, q := range added {
+		for _, d := range [][4]quad.Direction{osp, pos, cps} {
+			if d == cps && q.Label == "" {
+				continue
+			}
+			name, _ := indexFor(d[0])
+			conn.Send("ZADD", qs.key(name), 0, createKeyFor(d, q))
+		}
+		for _, dir := range spo {
+			if name := q.Get(dir); dir != quad.Label || name != "" {
+				resizeMap[name]++
+			}
+		}
+	}
+	for name, n := range resizeMap {
+		h := hashOf(name)
+		conn.Send("HSET", qs.key("names"), h, name)
+		conn.Send("HINCRBY", qs.key("sizes"), h, n)
+		conn.Send("ZADD", qs.key("nodes"), 0, h)
+	}
+	conn.Send("HINCRBY", qs.key("meta"), "size", len(added))
+	replies, err := redis.Values(conn.Do(""))
+	if err == nil {
+		err = firstError(replies)
+	}
+	if err != nil {
+		glog.Error("could not write to DB for quadset: ", err)
+		return err
+	}
+	qs.size += int64(len(added))
+	return nil
+}
+
+func (qs *QuadStore) Size() int64 {
+	return qs.size
+}
+
+func (qs *QuadStore) Horizon() int64 {
+	return qs.horizon
+}
+
+func (qs *QuadStore) Close() {
+	qs.pool.Close()
+}
+
+func (qs *QuadStore) Quad(val graph.Value) quad.Quad {
+	t := val.(Token)
+	args := redis.Args{}.Add(qs.key("names"))
+	for i := 0; i < 4; i++ {
+		args = args.Add(string(t[i*hashSize : (i+1)*hashSize]))
+	}
+	conn := qs.pool.Get()
+	defer conn.Close()
+	names, err := redis.Strings(conn.Do("HMGET", args...))
+	if err != nil {
+		glog.Error("Error: could not get quad from DB: ", err)
+		return quad.Quad{}
+	}
+	return quad.Quad{
+		Subject:   names[0],
+		Predicate: names[1],
+		Object:    names[2],
+		Label:     names[3],
+	}
+}
+
+func (qs *QuadStore) ValueOf(s string) graph.Value {
+	return Token(hashOf(s))
+}
+
+func (qs *QuadStore) NameOf(val graph.Value) string {
+	if val == nil {
+		glog.V(2).Info("val was nil")
+		return ""
+	}
+	conn := qs.pool.Get()
+	defer conn.Close()
+	name, err := redis.String(conn.Do("HGET", qs.key("names"), string(val.(Token))))
+	if err != nil && err != redis.ErrNil {
+		glog.Errorln("Error: could not get value from DB:", err)
+	}
+	return name
+}
+
+// SizeOf returns the number of quads that use a node.
+func (qs *QuadStore) SizeOf(val graph.Value) int64 {
+	if val == nil {
+		return 0
+	}
+	conn := qs.pool.Get()
+	defer conn.Close()
+	size, err := redis.Int64(conn.Do("HGET", qs.key("sizes"), string(val.(Token))))
+	if err != nil && err != redis.ErrNil {
+		glog.Errorln("Error: could not get value from DB:", err)
+	}
+	return size
+}
+
+func (qs *QuadStore) QuadIterator(d quad.Direction, val graph.Value) graph.Iterator {
+	return NewIterator(qs, d, val)
+}
+
+func (qs *QuadStore) NodesAllIterator() graph.Iterator {
+	return NewAllIterator(qs, true)
+}
+
+func (qs *QuadStore) QuadsAllIterator() graph.Iterator {
+	return NewAllIterator(qs, false)
+}
+
+func (qs *QuadStore) FixedIterator() graph.FixedIterator {
+	return iterator.NewFixed(iterator.Identity)
+}
+
+func (qs *QuadStore) QuadDirection(val graph.Value, d quad.Direction) graph.Value {
+	if d == quad.Any {
+		panic(fmt.Sprintf("redis: invalid direction %v", d))
+	}
+	offset := int(d-1) * hashSize
+	return val.(Token)[offset : offset+hashSize]
+}
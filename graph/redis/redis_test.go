@@ -0,0 +1,388 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+	"github.com/google/cayley/quad/cquads"
+	"github.com/google/cayley/writer"
+)
+
+// The tests run against an in-process fake of Redis. Set
+// CAYLEY_REDIS_TEST_ADDR to the address of a Redis server to run them against
+// that instead; they only touch keys under a prefix of their own.
+
+func makeQuadSet() []quad.Quad {
+	quadSet := []quad.Quad{
+		{"A", "follows", "B", ""},
+		{"C", "follows", "B", ""},
+		{"C", "follows", "D", ""},
+		{"D", "follows", "B", ""},
+		{"B", "follows", "F", ""},
+		{"F", "follows", "G", ""},
+		{"D", "follows", "G", ""},
+		{"E", "follows", "F", ""},
+		{"B", "status", "cool", "status_graph"},
+		{"D", "status", "cool", "status_graph"},
+		{"G", "status", "cool", "status_graph"},
+	}
+	return quadSet
+}
+
+// testDatabase returns the address and options of an empty database, and a
+// function to remove it.
+func testDatabase(t *testing.T) (string, graph.Options, func()) {
+	if addr := os.Getenv("CAYLEY_REDIS_TEST_ADDR"); addr != "" {
+		prefix := fmt.Sprintf("cayley_test_%d:", time.Now().UnixNano())
+		opts := graph.Options{"key_prefix": prefix}
+		if err := createNewRedis(addr, opts); err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+		return addr, opts, func() {
+			conn, err := redis.Dial("tcp", addr)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			keys, _ := redis.Strings(conn.Do("KEYS", prefix+"*"))
+			for _, key := range keys {
+				conn.Do("DEL", key)
+			}
+		}
+	}
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start fake Redis server: %v", err)
+	}
+	opts := graph.Options{}
+	if err := createNewRedis(s.Addr(), opts); err != nil {
+		s.Close()
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	return s.Addr(), opts, s.Close
+}
+
+func makeTestStore(t *testing.T) (graph.QuadStore, graph.QuadWriter, func()) {
+	addr, opts, cleanup := testDatabase(t)
+	qs, err := newQuadStore(addr, opts)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to create redis QuadStore: %v", err)
+	}
+	w, _ := writer.NewSingleReplication(qs, nil)
+	if err := w.AddQuadSet(makeQuadSet()); err != nil {
+		t.Fatalf("Failed to add quads: %v", err)
+	}
+	return qs, w, func() {
+		qs.Close()
+		cleanup()
+	}
+}
+
+func iteratedQuads(qs graph.QuadStore, it graph.Iterator) []quad.Quad {
+	var res ordered
+	for graph.Next(it) {
+		res = append(res, qs.Quad(it.Result()))
+	}
+	sort.Sort(res)
+	return res
+}
+
+type ordered []quad.Quad
+
+func (o ordered) Len() int { return len(o) }
+func (o ordered) Less(i, j int) bool {
+	switch {
+	case o[i].Subject < o[j].Subject,
+
+		o[i].Subject == o[j].Subject &&
+			o[i].Predicate < o[j].Predicate,
+
+		o[i].Subject == o[j].Subject &&
+			o[i].Predicate == o[j].Predicate &&
+			o[i].Object < o[j].Object,
+
+		o[i].Subject == o[j].Subject &&
+			o[i].Predicate == o[j].Predicate &&
+			o[i].Object == o[j].Object &&
+			o[i].Label < o[j].Label:
+
+		return true
+
+	default:
+		return false
+	}
+}
+func (o ordered) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+
+func iteratedNames(qs graph.QuadStore, it graph.Iterator) []string {
+	var res []string
+	for graph.Next(it) {
+		res = append(res, qs.NameOf(it.Result()))
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestLoadDatabase(t *testing.T) {
+	addr, opts, cleanup := testDatabase(t)
+	defer cleanup()
+
+	qs, err := newQuadStore(addr, opts)
+	if err != nil {
+		t.Fatalf("Failed to create redis QuadStore: %v", err)
+	}
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:11", s)
+	}
+	if s := qs.(*QuadStore).SizeOf(qs.ValueOf("B")); s != 5 {
+		t.Errorf("Unexpected quadstore size of B, got:%d expect:5", s)
+	}
+	w.RemoveQuad(quad.Quad{"A", "follows", "B", ""})
+	if s := qs.Size(); s != 10 {
+		t.Errorf("Unexpected quadstore size after RemoveQuad, got:%d expect:10", s)
+	}
+	if s := qs.(*QuadStore).SizeOf(qs.ValueOf("A")); s != 0 {
+		t.Errorf("Unexpected quadstore size of A after RemoveQuad, got:%d expect:0", s)
+	}
+	horizon := qs.Horizon()
+	qs.Close()
+
+	qs, err = newQuadStore(addr, opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen redis QuadStore: %v", err)
+	}
+	defer qs.Close()
+	if s := qs.Size(); s != 10 {
+		t.Errorf("Unexpected quadstore size after reopening, got:%d expect:10", s)
+	}
+	if h := qs.Horizon(); h != horizon {
+		t.Errorf("Unexpected horizon after reopening, got:%d expect:%d", h, horizon)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	qs, w, cleanup := makeTestStore(t)
+	defer cleanup()
+
+	tx := graph.NewTransaction()
+	tx.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "G", ""})
+	if err := w.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Unexpected error applying transaction: %v", err)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:11", s)
+	}
+
+	// A bad delta anywhere leaves the store untouched.
+	horizon := qs.Horizon()
+	tx = graph.NewTransaction()
+	tx.AddQuad(quad.Quad{"A", "follows", "G", ""})
+	tx.AddQuad(quad.Quad{"A", "follows", "B", ""})
+	if err := w.ApplyTransaction(tx); err != graph.ErrQuadExists {
+		t.Errorf("Unexpected error, got:%v expect:%v", err, graph.ErrQuadExists)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:11", s)
+	}
+	if h := qs.Horizon(); h != horizon {
+		t.Errorf("Unexpected horizon, got:%d expect:%d", h, horizon)
+	}
+	it := qs.QuadIterator(quad.Subject, qs.ValueOf("A"))
+	if got := iteratedQuads(qs, it); len(got) != 1 {
+		t.Errorf("Unexpected quads from A, got:%v", got)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	qs, _, cleanup := makeTestStore(t)
+	defer cleanup()
+
+	it := qs.NodesAllIterator()
+	expect := []string{
+		"A", "B", "C", "D", "E", "F", "G",
+		"cool", "follows", "status", "status_graph",
+	}
+	if got := iteratedNames(qs, it); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected nodes, got:%v expect:%v", got, expect)
+	}
+	if size, _ := it.Size(); size != int64(len(expect)) {
+		t.Errorf("Unexpected size, got:%d expect:%d", size, len(expect))
+	}
+	for _, name := range []string{"B", "status_graph"} {
+		if !it.Contains(qs.ValueOf(name)) {
+			t.Errorf("Failed to find %q", name)
+		}
+	}
+	if it.Contains(qs.ValueOf("Z")) {
+		t.Errorf("Unexpectedly found Z")
+	}
+
+	it = qs.QuadsAllIterator()
+	if got := iteratedQuads(qs, it); len(got) != 11 {
+		t.Errorf("Unexpected quads, got:%v", got)
+	}
+
+	var tests = []struct {
+		dir    quad.Direction
+		name   string
+		expect []quad.Quad
+	}{
+		{quad.Subject, "C", []quad.Quad{
+			{"C", "follows", "B", ""},
+			{"C", "follows", "D", ""},
+		}},
+		{quad.Object, "F", []quad.Quad{
+			{"B", "follows", "F", ""},
+			{"E", "follows", "F", ""},
+		}},
+		{quad.Predicate, "status", []quad.Quad{
+			{"B", "status", "cool", "status_graph"},
+			{"D", "status", "cool", "status_graph"},
+			{"G", "status", "cool", "status_graph"},
+		}},
+		{quad.Label, "status_graph", []quad.Quad{
+			{"B", "status", "cool", "status_graph"},
+			{"D", "status", "cool", "status_graph"},
+			{"G", "status", "cool", "status_graph"},
+		}},
+	}
+	for _, test := range tests {
+		it := qs.QuadIterator(test.dir, qs.ValueOf(test.name))
+		if got := iteratedQuads(qs, it); !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Unexpected quads for %v %s, got:%v expect:%v", test.dir, test.name, got, test.expect)
+		}
+		if size, exact := it.Size(); size != int64(len(test.expect)) || !exact {
+			t.Errorf("Unexpected size for %v %s, got:%d,%t expect:%d,true", test.dir, test.name, size, exact, len(test.expect))
+		}
+	}
+
+	it = qs.QuadIterator(quad.Subject, qs.ValueOf("C"))
+	all := qs.QuadsAllIterator()
+	var contained []quad.Quad
+	for graph.Next(all) {
+		if it.Contains(all.Result()) {
+			contained = append(contained, qs.Quad(all.Result()))
+		}
+	}
+	sort.Sort(ordered(contained))
+	if !reflect.DeepEqual(contained, tests[0].expect) {
+		t.Errorf("Unexpected contained quads, got:%v expect:%v", contained, tests[0].expect)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	qs, _, cleanup := makeTestStore(t)
+	defer cleanup()
+
+	// With a linksto-fixed pair
+	fixed := qs.FixedIterator()
+	fixed.Add(qs.ValueOf("F"))
+	fixed.Tagger().Add("internal")
+	lto := iterator.NewLinksTo(qs, fixed, quad.Object)
+
+	oldIt := lto.Clone()
+	newIt, ok := lto.Optimize()
+	if !ok {
+		t.Errorf("Failed to optimize iterator")
+	}
+	if newIt.Type() != Type() {
+		t.Errorf("Optimized iterator type does not match original, got:%v expect:%v", newIt.Type(), Type())
+	}
+
+	newQuads := iteratedQuads(qs, newIt)
+	oldQuads := iteratedQuads(qs, oldIt)
+	if !reflect.DeepEqual(newQuads, oldQuads) {
+		t.Errorf("Optimized iteration does not match original")
+	}
+
+	oldIt.Reset()
+	newIt.Reset()
+	graph.Next(oldIt)
+	oldResults := make(map[string]graph.Value)
+	oldIt.TagResults(oldResults)
+	graph.Next(newIt)
+	newResults := make(map[string]graph.Value)
+	newIt.TagResults(newResults)
+	if !reflect.DeepEqual(newResults, oldResults) {
+		t.Errorf("Discordant tag results, new:%v old:%v", newResults, oldResults)
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	addr, opts, cleanup := testDatabase(t)
+	defer cleanup()
+
+	qs, err := newQuadStore(addr, opts)
+	if err != nil {
+		t.Fatalf("Failed to create redis QuadStore: %v", err)
+	}
+	defer qs.Close()
+
+	// Enough quads for several pages of iteration, with a duplicate.
+	var lines []string
+	for i := 0; i < 2*pageSize; i++ {
+		lines = append(lines, fmt.Sprintf("<n%d> <follows> <n%d> .", i, i+1))
+	}
+	lines = append(lines, lines[0])
+	dec := cquads.NewDecoder(strings.NewReader(strings.Join(lines, "\n")))
+	if err := qs.(graph.BulkLoader).BulkLoad(dec); err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	if s := qs.Size(); s != 2*pageSize {
+		t.Errorf("Unexpected quadstore size, got:%d expect:%d", s, 2*pageSize)
+	}
+	if h := qs.Horizon(); h != 0 {
+		t.Errorf("Unexpected horizon, got:%d expect:0", h)
+	}
+	it := qs.NodesAllIterator()
+	var n int
+	for graph.Next(it) {
+		n++
+	}
+	if n != 2*pageSize+2 {
+		t.Errorf("Unexpected number of nodes, got:%d expect:%d", n, 2*pageSize+2)
+	}
+	if s := qs.(*QuadStore).SizeOf(qs.ValueOf("n1")); s != 2 {
+		t.Errorf("Unexpected quadstore size of n1, got:%d expect:2", s)
+	}
+
+	// Bulk loaded quads may be removed like any others.
+	w, _ := writer.NewSingleReplication(qs, nil)
+	if err := w.RemoveQuad(quad.Quad{"n0", "follows", "n1", ""}); err != nil {
+		t.Errorf("Failed to remove a bulk loaded quad: %v", err)
+	}
+
+	dec = cquads.NewDecoder(strings.NewReader(lines[0]))
+	if err := qs.(graph.BulkLoader).BulkLoad(dec); err != graph.ErrCannotBulkLoad {
+		t.Errorf("Unexpected error loading into a non-empty store, got:%v expect:%v", err, graph.ErrCannotBulkLoad)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"database/sql"
+
+	"github.com/barakmich/glog"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+)
+
+// Nodes are not stored by themselves, so they are every distinct name used
+// in any direction of a quad.
+const allNodes = `SELECT subject FROM quads
+	UNION SELECT predicate FROM quads
+	UNION SELECT object FROM quads
+	UNION SELECT label FROM quads WHERE label <> ''`
+
+// AllIterator iterates over every node in the store.
+type AllIterator struct {
+	uid    uint64
+	tags   graph.Tagger
+	qs     *QuadStore
+	rows   *sql.Rows
+	result graph.Value
+}
+
+func NewAllIterator(qs *QuadStore) *AllIterator {
+	return &AllIterator{
+		uid: iterator.NextUID(),
+		qs:  qs,
+	}
+}
+
+func (it *AllIterator) UID() uint64 {
+	return it.uid
+}
+
+func (it *AllIterator) Reset() {
+	if it.rows != nil {
+		it.rows.Close()
+		it.rows = nil
+	}
+}
+
+func (it *AllIterator) Close() {
+	it.Reset()
+}
+
+func (it *AllIterator) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *AllIterator) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+}
+
+func (it *AllIterator) Clone() graph.Iterator {
+	out := NewAllIterator(it.qs)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *AllIterator) Next() bool {
+	graph.NextLogIn(it)
+	if it.rows == nil {
+		rows, err := it.qs.db.Query(allNodes)
+		if err != nil {
+			glog.Errorln("Error listing nodes:", err)
+			return graph.NextLogOut(it, nil, false)
+		}
+		it.rows = rows
+	}
+	if !it.rows.Next() {
+		if err := it.rows.Err(); err != nil {
+			glog.Errorln("Error listing nodes:", err)
+		}
+		return graph.NextLogOut(it, nil, false)
+	}
+	var name string
+	if err := it.rows.Scan(&name); err != nil {
+		glog.Errorln("Error reading node:", err)
+		return graph.NextLogOut(it, nil, false)
+	}
+	it.result = name
+	return graph.NextLogOut(it, it.result, true)
+}
+
+func (it *AllIterator) ResultTree() *graph.ResultTree {
+	return graph.NewResultTree(it.Result())
+}
+
+func (it *AllIterator) Result() graph.Value {
+	return it.result
+}
+
+func (it *AllIterator) NextPath() bool {
+	return false
+}
+
+// No subiterators.
+func (it *AllIterator) SubIterators() []graph.Iterator {
+	return nil
+}
+
+func (it *AllIterator) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
+	name := it.qs.NameOf(v)
+	if name == "" {
+		return graph.ContainsLogOut(it, v, false)
+	}
+	var n int64
+	err := it.qs.db.QueryRow(`SELECT COUNT(*) FROM quads WHERE subject = $1 OR predicate = $1 OR object = $1 OR label = $1`, name).Scan(&n)
+	if err != nil {
+		glog.Errorln("Error checking node:", err)
+		return graph.ContainsLogOut(it, v, false)
+	}
+	if n == 0 {
+		return graph.ContainsLogOut(it, v, false)
+	}
+	it.result = name
+	return graph.ContainsLogOut(it, v, true)
+}
+
+func (it *AllIterator) Size() (int64, bool) {
+	var n int64
+	err := it.qs.db.QueryRow(`SELECT COUNT(*) FROM (` + allNodes + `) AS nodes`).Scan(&n)
+	if err != nil {
+		glog.Errorln("Trouble getting size for iterator!", err)
+		return it.qs.Size(), false
+	}
+	return n, true
+}
+
+func (it *AllIterator) Type() graph.Type { return graph.All }
+
+func (it *AllIterator) Sorted() bool                     { return false }
+func (it *AllIterator) Optimize() (graph.Iterator, bool) { return it, false }
+
+func (it *AllIterator) Describe() graph.Description {
+	size, _ := it.Size()
+	return graph.Description{
+		UID:  it.UID(),
+		Type: it.Type(),
+		Tags: it.tags.Tags(),
+		Size: size,
+	}
+}
+
+func (it *AllIterator) Stats() graph.IteratorStats {
+	size, _ := it.Size()
+	return graph.IteratorStats{
+		ContainsCost: 1,
+		NextCost:     1,
+		Size:         size,
+	}
+}
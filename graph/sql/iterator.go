@@ -0,0 +1,347 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/barakmich/glog"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+)
+
+// constraint restricts one direction of a quad, either to a set of names or
+// to the results of another Iterator, which becomes a subquery.
+type constraint struct {
+	dir  quad.Direction
+	vals []string
+	sub  *Iterator
+}
+
+// Iterator runs a single query over the quads table. Its results are the
+// quads matching all of its constraints or, when it has a direction, the
+// node in that direction of each of them.
+type Iterator struct {
+	uid   uint64
+	tags  graph.Tagger
+	qs    *QuadStore
+	dir   quad.Direction
+	where []constraint
+	size  int64
+
+	// quadTags name the matching quad, rather than the result, once a HasA
+	// has been folded into the iterator.
+	quadTags []string
+
+	rows   *sql.Rows
+	paths  []quad.Quad
+	quad   quad.Quad
+	result graph.Value
+}
+
+// NewIterator returns an iterator over every quad, or every node in the
+// direction d of every quad if d is not quad.Any.
+func NewIterator(qs *QuadStore, d quad.Direction) *Iterator {
+	return &Iterator{
+		uid:  iterator.NextUID(),
+		qs:   qs,
+		dir:  d,
+		size: -1,
+	}
+}
+
+func (it *Iterator) constrain(d quad.Direction, vals ...string) {
+	it.where = append(it.where, constraint{dir: d, vals: vals})
+	it.size = -1
+}
+
+// query builds the statement for the iterator, numbering its parameters
+// after those already in args.
+func (it *Iterator) query(cols string, args *[]interface{}, extra ...constraint) string {
+	var clauses []string
+	for _, c := range append(it.where[:len(it.where):len(it.where)], extra...) {
+		if c.sub != nil {
+			clauses = append(clauses, fmt.Sprintf("%s IN (%s)", c.dir, c.sub.query(c.sub.dir.String(), args)))
+			continue
+		}
+		params := make([]string, len(c.vals))
+		for i, v := range c.vals {
+			*args = append(*args, v)
+			params[i] = fmt.Sprintf("$%d", len(*args))
+		}
+		if len(params) == 1 {
+			clauses = append(clauses, fmt.Sprintf("%s = %s", c.dir, params[0]))
+		} else {
+			clauses = append(clauses, fmt.Sprintf("%s IN (%s)", c.dir, strings.Join(params, ", ")))
+		}
+	}
+	q := "SELECT " + cols + " FROM quads"
+	if len(clauses) != 0 {
+		q += " WHERE " + strings.Join(clauses, " AND ")
+	}
+	return q
+}
+
+const quadColumns = "subject, predicate, object, label"
+
+func (it *Iterator) run(extra ...constraint) (*sql.Rows, error) {
+	var args []interface{}
+	q := it.query(quadColumns, &args, extra...)
+	if glog.V(4) {
+		glog.V(4).Infoln("Running query", q, args)
+	}
+	return it.qs.db.Query(q, args...)
+}
+
+func (it *Iterator) UID() uint64 {
+	return it.uid
+}
+
+func (it *Iterator) Reset() {
+	if it.rows != nil {
+		it.rows.Close()
+		it.rows = nil
+	}
+	it.paths = nil
+}
+
+func (it *Iterator) Close() {
+	it.Reset()
+}
+
+func (it *Iterator) Tagger() *graph.Tagger {
+	return &it.tags
+}
+
+func (it *Iterator) TagResults(dst map[string]graph.Value) {
+	for _, tag := range it.tags.Tags() {
+		dst[tag] = it.Result()
+	}
+
+	for _, tag := range it.quadTags {
+		dst[tag] = it.quad
+	}
+
+	for tag, value := range it.tags.Fixed() {
+		dst[tag] = value
+	}
+}
+
+func (it *Iterator) Clone() graph.Iterator {
+	m := NewIterator(it.qs, it.dir)
+	m.where = append(m.where, it.where...)
+	m.size = it.size
+	m.quadTags = append(m.quadTags, it.quadTags...)
+	m.tags.CopyFrom(it)
+	return m
+}
+
+func (it *Iterator) setQuad(q quad.Quad) {
+	it.quad = q
+	if it.dir == quad.Any {
+		it.result = q
+	} else {
+		it.result = q.Get(it.dir)
+	}
+}
+
+func scanQuad(rows *sql.Rows) (quad.Quad, error) {
+	var q quad.Quad
+	err := rows.Scan(&q.Subject, &q.Predicate, &q.Object, &q.Label)
+	return q, err
+}
+
+func (it *Iterator) Next() bool {
+	graph.NextLogIn(it)
+	it.paths = nil
+	if it.rows == nil {
+		rows, err := it.run()
+		if err != nil {
+			glog.Errorln("Error running SQL iterator:", err)
+			return graph.NextLogOut(it, nil, false)
+		}
+		it.rows = rows
+	}
+	if !it.rows.Next() {
+		if err := it.rows.Err(); err != nil {
+			glog.Errorln("Error nexting SQL iterator:", err)
+		}
+		return graph.NextLogOut(it, nil, false)
+	}
+	q, err := scanQuad(it.rows)
+	if err != nil {
+		glog.Errorln("Error reading quad:", err)
+		return graph.NextLogOut(it, nil, false)
+	}
+	it.setQuad(q)
+	return graph.NextLogOut(it, it.result, true)
+}
+
+func (it *Iterator) ResultTree() *graph.ResultTree {
+	return graph.NewResultTree(it.Result())
+}
+
+func (it *Iterator) Result() graph.Value {
+	return it.result
+}
+
+// NextPath moves on to the next quad with the node found by Contains.
+func (it *Iterator) NextPath() bool {
+	if len(it.paths) == 0 {
+		return false
+	}
+	it.setQuad(it.paths[0])
+	it.paths = it.paths[1:]
+	return true
+}
+
+// No subiterators. Those that were folded into this one are part of the query.
+func (it *Iterator) SubIterators() []graph.Iterator {
+	return nil
+}
+
+// simple reports whether q can be checked against the constraints without
+// asking the database, and if so whether it meets them.
+func (it *Iterator) simple(q quad.Quad) (ok, simple bool) {
+	for _, c := range it.where {
+		if c.sub != nil {
+			return false, false
+		}
+		found := false
+		for _, v := range c.vals {
+			if q.Get(c.dir) == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, true
+		}
+	}
+	return true, true
+}
+
+func (it *Iterator) Contains(v graph.Value) bool {
+	graph.ContainsLogIn(it, v)
+	it.paths = nil
+	var extra []constraint
+	if it.dir == quad.Any {
+		q, ok := v.(quad.Quad)
+		if !ok {
+			return graph.ContainsLogOut(it, v, false)
+		}
+		if ok, simple := it.simple(q); simple {
+			if ok {
+				it.setQuad(q)
+			}
+			return graph.ContainsLogOut(it, v, ok)
+		}
+		for _, d := range []quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label} {
+			extra = append(extra, constraint{dir: d, vals: []string{q.Get(d)}})
+		}
+	} else {
+		extra = []constraint{{dir: it.dir, vals: []string{it.qs.NameOf(v)}}}
+	}
+	rows, err := it.run(extra...)
+	if err != nil {
+		glog.Errorln("Error running SQL iterator:", err)
+		return graph.ContainsLogOut(it, v, false)
+	}
+	defer rows.Close()
+	var paths []quad.Quad
+	for rows.Next() {
+		q, err := scanQuad(rows)
+		if err != nil {
+			glog.Errorln("Error reading quad:", err)
+			return graph.ContainsLogOut(it, v, false)
+		}
+		paths = append(paths, q)
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorln("Error checking SQL iterator:", err)
+		return graph.ContainsLogOut(it, v, false)
+	}
+	if len(paths) == 0 {
+		return graph.ContainsLogOut(it, v, false)
+	}
+	it.setQuad(paths[0])
+	it.paths = paths[1:]
+	return graph.ContainsLogOut(it, v, true)
+}
+
+func (it *Iterator) Size() (int64, bool) {
+	if it.size < 0 {
+		var args []interface{}
+		q := it.query("COUNT(*)", &args)
+		if err := it.qs.db.QueryRow(q, args...).Scan(&it.size); err != nil {
+			glog.Errorln("Trouble getting size for iterator!", err)
+			return it.qs.Size(), false
+		}
+	}
+	return it.size, true
+}
+
+var sqlType graph.Type
+
+func init() {
+	sqlType = graph.RegisterIterator("sql")
+}
+
+func Type() graph.Type { return sqlType }
+
+func (it *Iterator) Type() graph.Type {
+	if it.dir == quad.Any && len(it.where) == 0 {
+		return graph.All
+	}
+	return sqlType
+}
+
+func (it *Iterator) Sorted() bool                     { return false }
+func (it *Iterator) Optimize() (graph.Iterator, bool) { return it, false }
+
+func (it *Iterator) Describe() graph.Description {
+	cols := quadColumns
+	if it.dir != quad.Any {
+		cols = it.dir.String()
+	}
+	var args []interface{}
+	q := it.query(cols, &args)
+	size, _ := it.Size()
+	return graph.Description{
+		UID:       it.UID(),
+		Name:      fmt.Sprintf("%s %v", q, args),
+		Type:      it.Type(),
+		Tags:      it.tags.Tags(),
+		Size:      size,
+		Direction: it.dir,
+	}
+}
+
+func (it *Iterator) Stats() graph.IteratorStats {
+	size, _ := it.Size()
+	containsCost := int64(1)
+	if it.dir != quad.Any {
+		containsCost = 5
+	}
+	return graph.IteratorStats{
+		ContainsCost: containsCost,
+		NextCost:     5,
+		Size:         size,
+	}
+}
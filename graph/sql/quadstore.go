@@ -0,0 +1,254 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+// A QuadStore backed by a SQL database. Quads are kept as rows of a single
+// table, with an index leading on each direction, so that a LinksTo becomes
+// a WHERE clause and a HasA becomes the column that is selected. Nodes are
+// not stored apart from the quads that use them; a node's Value is its name.
+//
+// The database/sql driver is chosen with the "driver" option. It defaults to
+// Postgres, and the database path is passed to it as the data source name.
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/barakmich/glog"
+	_ "github.com/lib/pq"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+)
+
+func init() {
+	graph.RegisterQuadStore("sql", true, newQuadStore, createSQLTables)
+}
+
+const DefaultDriver = "postgres"
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS quads (
+		subject TEXT NOT NULL,
+		predicate TEXT NOT NULL,
+		object TEXT NOT NULL,
+		label TEXT NOT NULL DEFAULT '',
+		id BIGINT NOT NULL,
+		PRIMARY KEY (subject, predicate, object, label)
+	)`,
+	`CREATE INDEX IF NOT EXISTS quads_pos ON quads (predicate, object, subject)`,
+	`CREATE INDEX IF NOT EXISTS quads_osp ON quads (object, subject, predicate)`,
+	`CREATE INDEX IF NOT EXISTS quads_lps ON quads (label, predicate, subject)`,
+	`CREATE TABLE IF NOT EXISTS horizon (
+		id BIGINT NOT NULL
+	)`,
+}
+
+type QuadStore struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	size    int64
+	horizon int64
+}
+
+func openDB(addr string, options graph.Options) (*sql.DB, error) {
+	driver := DefaultDriver
+	if val, ok := options.StringKey("driver"); ok {
+		driver = val
+	}
+	db, err := sql.Open(driver, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func createSQLTables(addr string, options graph.Options) error {
+	db, err := openDB(addr, options)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range schema {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			glog.Errorf("Couldn't create table: %v", err)
+			return err
+		}
+	}
+	var n int64
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM horizon`).Scan(&n); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		if _, err := tx.Exec(`INSERT INTO horizon (id) VALUES (0)`); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func newQuadStore(addr string, options graph.Options) (graph.QuadStore, error) {
+	db, err := openDB(addr, options)
+	if err != nil {
+		return nil, err
+	}
+	qs := &QuadStore{db: db}
+	err = db.QueryRow(`SELECT COUNT(*) FROM quads`).Scan(&qs.size)
+	if err == nil {
+		err = db.QueryRow(`SELECT id FROM horizon`).Scan(&qs.horizon)
+	}
+	if err != nil {
+		glog.Errorf("Couldn't read quad store state; has it been initialized? %v", err)
+		db.Close()
+		return nil, err
+	}
+	return qs, nil
+}
+
+func (qs *QuadStore) ApplyDeltas(in []graph.Delta, opts graph.IgnoreOpts) error {
+	tx, err := qs.db.Begin()
+	if err != nil {
+		return err
+	}
+	// Everything happens within the transaction, so either all the deltas
+	// are written or none are.
+	sizeChange, err := qs.applyDeltas(tx, in, opts)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	qs.mu.Lock()
+	qs.size += sizeChange
+	if len(in) != 0 {
+		qs.horizon = in[len(in)-1].ID
+	}
+	qs.mu.Unlock()
+	return nil
+}
+
+// applyDeltas writes the deltas within tx, returning the change in the number
+// of quads, as counted from the rows each statement affected.
+func (qs *QuadStore) applyDeltas(tx *sql.Tx, in []graph.Delta, opts graph.IgnoreOpts) (int64, error) {
+	deltas, err := graph.CheckDeltas(in, opts, func(q quad.Quad) (bool, error) {
+		var n int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM quads WHERE subject = $1 AND predicate = $2 AND object = $3 AND label = $4`,
+			q.Subject, q.Predicate, q.Object, q.Label).Scan(&n)
+		return n > 0, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	var sizeChange int64
+	for _, d := range deltas {
+		q := d.Quad
+		var (
+			res  sql.Result
+			sign int64
+		)
+		switch d.Action {
+		case graph.Add:
+			res, err = tx.Exec(`INSERT INTO quads (subject, predicate, object, label, id) VALUES ($1, $2, $3, $4, $5)`,
+				q.Subject, q.Predicate, q.Object, q.Label, d.ID)
+			sign = 1
+		case graph.Delete:
+			res, err = tx.Exec(`DELETE FROM quads WHERE subject = $1 AND predicate = $2 AND object = $3 AND label = $4`,
+				q.Subject, q.Predicate, q.Object, q.Label)
+			sign = -1
+		default:
+			err = fmt.Errorf("sql: unknown action %v", d.Action)
+		}
+		if err == nil {
+			var n int64
+			n, err = res.RowsAffected()
+			sizeChange += sign * n
+		}
+		if err != nil {
+			glog.Errorf("Couldn't write quad %v: %v", q, err)
+			return 0, err
+		}
+	}
+	if len(in) != 0 {
+		_, err = tx.Exec(`UPDATE horizon SET id = $1`, in[len(in)-1].ID)
+	}
+	return sizeChange, err
+}
+
+func (qs *QuadStore) Quad(val graph.Value) quad.Quad {
+	return val.(quad.Quad)
+}
+
+func (qs *QuadStore) QuadIterator(d quad.Direction, val graph.Value) graph.Iterator {
+	it := NewIterator(qs, quad.Any)
+	it.constrain(d, qs.NameOf(val))
+	return it
+}
+
+func (qs *QuadStore) NodesAllIterator() graph.Iterator {
+	return NewAllIterator(qs)
+}
+
+func (qs *QuadStore) QuadsAllIterator() graph.Iterator {
+	return NewIterator(qs, quad.Any)
+}
+
+func (qs *QuadStore) ValueOf(s string) graph.Value {
+	return s
+}
+
+func (qs *QuadStore) NameOf(v graph.Value) string {
+	s, _ := v.(string)
+	return s
+}
+
+func (qs *QuadStore) Size() int64 {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	return qs.size
+}
+
+func (qs *QuadStore) Horizon() int64 {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	return qs.horizon
+}
+
+func (qs *QuadStore) FixedIterator() graph.FixedIterator {
+	return iterator.NewFixed(iterator.Identity)
+}
+
+func (qs *QuadStore) Close() {
+	qs.db.Close()
+}
+
+func (qs *QuadStore) QuadDirection(val graph.Value, d quad.Direction) graph.Value {
+	return val.(quad.Quad).Get(d)
+}
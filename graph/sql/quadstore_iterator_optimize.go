@@ -0,0 +1,121 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+)
+
+// The optimizations here work from the bottom of a tree up, as Optimize is
+// passed down and the replacements come back. A LinksTo over fixed nodes
+// becomes an Iterator with a WHERE clause, a HasA over such Iterators, or
+// over an And of them, becomes an Iterator selecting one column, and a
+// LinksTo over that becomes a subquery. A whole traversal can therefore end
+// up as a single statement.
+//
+// A subquery only filters, so an Iterator carrying tags of its own is never
+// folded into another; its tags would have nothing to report.
+
+func (qs *QuadStore) OptimizeIterator(it graph.Iterator) (graph.Iterator, bool) {
+	switch it.Type() {
+	case graph.LinksTo:
+		return qs.optimizeLinksTo(it.(*iterator.LinksTo))
+	case graph.HasA:
+		return qs.optimizeHasA(it.(*iterator.HasA))
+	}
+	return it, false
+}
+
+func (qs *QuadStore) optimizeLinksTo(it *iterator.LinksTo) (graph.Iterator, bool) {
+	subs := it.SubIterators()
+	if len(subs) != 1 {
+		return it, false
+	}
+	primary := subs[0]
+	c := constraint{dir: it.Direction()}
+	fixed := make(map[string]graph.Value)
+	if sub, ok := primary.(*Iterator); ok {
+		if sub.dir == quad.Any || len(sub.tags.Tags()) != 0 || len(sub.quadTags) != 0 {
+			return it, false
+		}
+		c.sub = sub
+	} else {
+		if primary.Type() != graph.Fixed {
+			return it, false
+		}
+		size, _ := primary.Size()
+		tags := primary.Tagger().Tags()
+		if size == 0 || (size > 1 && len(tags) != 0) {
+			return it, false
+		}
+		var val graph.Value
+		for graph.Next(primary) {
+			val = primary.Result()
+			c.vals = append(c.vals, qs.NameOf(val))
+		}
+		// A tagged Fixed holds the one value, read while it was there.
+		for _, tag := range tags {
+			fixed[tag] = val
+		}
+	}
+	for tag, val := range primary.Tagger().Fixed() {
+		fixed[tag] = val
+	}
+	newIt := NewIterator(qs, quad.Any)
+	newIt.where = append(newIt.where, c)
+	nt := newIt.Tagger()
+	nt.CopyFrom(it)
+	for tag, val := range fixed {
+		nt.AddFixed(tag, val)
+	}
+	return newIt, true
+}
+
+func (qs *QuadStore) optimizeHasA(it *iterator.HasA) (graph.Iterator, bool) {
+	subs := it.SubIterators()
+	if len(subs) != 1 {
+		return it, false
+	}
+	primary := subs[0]
+	parts := []graph.Iterator{primary}
+	if primary.Type() == graph.And {
+		parts = primary.SubIterators()
+	}
+	newIt := NewIterator(qs, it.Direction())
+	for _, part := range parts {
+		sub, ok := part.(*Iterator)
+		if !ok || sub.dir != quad.Any {
+			return it, false
+		}
+		newIt.where = append(newIt.where, sub.where...)
+	}
+	// Tags below us named quads, and still do.
+	for _, part := range parts {
+		newIt.quadTags = append(newIt.quadTags, part.Tagger().Tags()...)
+		for tag, val := range part.Tagger().Fixed() {
+			newIt.tags.AddFixed(tag, val)
+		}
+	}
+	if primary.Type() == graph.And {
+		newIt.quadTags = append(newIt.quadTags, primary.Tagger().Tags()...)
+		for tag, val := range primary.Tagger().Fixed() {
+			newIt.tags.AddFixed(tag, val)
+		}
+	}
+	newIt.tags.CopyFrom(it)
+	return newIt, true
+}
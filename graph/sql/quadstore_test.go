@@ -0,0 +1,406 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+	"github.com/google/cayley/writer"
+)
+
+// The tests run against SQLite, which needs no server. Set CAYLEY_SQL_TEST_DSN
+// to a Postgres data source name to run them against Postgres instead.
+
+func makeQuadSet() []quad.Quad {
+	quadSet := []quad.Quad{
+		{"A", "follows", "B", ""},
+		{"C", "follows", "B", ""},
+		{"C", "follows", "D", ""},
+		{"D", "follows", "B", ""},
+		{"B", "follows", "F", ""},
+		{"F", "follows", "G", ""},
+		{"D", "follows", "G", ""},
+		{"E", "follows", "F", ""},
+		{"B", "status", "cool", "status_graph"},
+		{"D", "status", "cool", "status_graph"},
+		{"G", "status", "cool", "status_graph"},
+	}
+	return quadSet
+}
+
+// testDatabase returns the address and options of an empty database, and a
+// function to remove it.
+func testDatabase(t *testing.T) (string, graph.Options, func()) {
+	if dsn := os.Getenv("CAYLEY_SQL_TEST_DSN"); dsn != "" {
+		opts := graph.Options{"driver": "postgres"}
+		if err := createSQLTables(dsn, opts); err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+		return dsn, opts, func() {
+			db, err := openDB(dsn, opts)
+			if err != nil {
+				return
+			}
+			db.Exec(`DROP TABLE quads`)
+			db.Exec(`DROP TABLE horizon`)
+			db.Close()
+		}
+	}
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "cayley_test")
+	if err != nil {
+		t.Fatalf("Could not create working directory: %v", err)
+	}
+	addr := filepath.Join(tmpDir, "cayley.db")
+	opts := graph.Options{"driver": "sqlite3"}
+	if err := createSQLTables(addr, opts); err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+	return addr, opts, func() { os.RemoveAll(tmpDir) }
+}
+
+func makeTestStore(t *testing.T) (graph.QuadStore, graph.QuadWriter, func()) {
+	addr, opts, cleanup := testDatabase(t)
+	qs, err := newQuadStore(addr, opts)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to create sql QuadStore: %v", err)
+	}
+	w, _ := writer.NewSingleReplication(qs, nil)
+	if err := w.AddQuadSet(makeQuadSet()); err != nil {
+		t.Fatalf("Failed to add quads: %v", err)
+	}
+	return qs, w, func() {
+		qs.Close()
+		cleanup()
+	}
+}
+
+func iteratedQuads(qs graph.QuadStore, it graph.Iterator) []quad.Quad {
+	var res ordered
+	for graph.Next(it) {
+		res = append(res, qs.Quad(it.Result()))
+	}
+	sort.Sort(res)
+	return res
+}
+
+type ordered []quad.Quad
+
+func (o ordered) Len() int { return len(o) }
+func (o ordered) Less(i, j int) bool {
+	switch {
+	case o[i].Subject < o[j].Subject,
+
+		o[i].Subject == o[j].Subject &&
+			o[i].Predicate < o[j].Predicate,
+
+		o[i].Subject == o[j].Subject &&
+			o[i].Predicate == o[j].Predicate &&
+			o[i].Object < o[j].Object,
+
+		o[i].Subject == o[j].Subject &&
+			o[i].Predicate == o[j].Predicate &&
+			o[i].Object == o[j].Object &&
+			o[i].Label < o[j].Label:
+
+		return true
+
+	default:
+		return false
+	}
+}
+func (o ordered) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+
+func iteratedNames(qs graph.QuadStore, it graph.Iterator) []string {
+	var res []string
+	for graph.Next(it) {
+		res = append(res, qs.NameOf(it.Result()))
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestLoadDatabase(t *testing.T) {
+	addr, opts, cleanup := testDatabase(t)
+	defer cleanup()
+
+	qs, err := newQuadStore(addr, opts)
+	if err != nil {
+		t.Fatalf("Failed to create sql QuadStore: %v", err)
+	}
+	w, _ := writer.NewSingleReplication(qs, nil)
+	w.AddQuadSet(makeQuadSet())
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:11", s)
+	}
+	w.RemoveQuad(quad.Quad{"A", "follows", "B", ""})
+	if s := qs.Size(); s != 10 {
+		t.Errorf("Unexpected quadstore size after RemoveQuad, got:%d expect:10", s)
+	}
+	horizon := qs.Horizon()
+	qs.Close()
+
+	qs, err = newQuadStore(addr, opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen sql QuadStore: %v", err)
+	}
+	defer qs.Close()
+	if s := qs.Size(); s != 10 {
+		t.Errorf("Unexpected quadstore size after reopening, got:%d expect:10", s)
+	}
+	if h := qs.Horizon(); h != horizon {
+		t.Errorf("Unexpected horizon after reopening, got:%d expect:%d", h, horizon)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	qs, w, cleanup := makeTestStore(t)
+	defer cleanup()
+
+	tx := graph.NewTransaction()
+	tx.RemoveQuad(quad.Quad{"E", "follows", "F", ""})
+	tx.AddQuad(quad.Quad{"E", "follows", "G", ""})
+	if err := w.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Unexpected error applying transaction: %v", err)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:11", s)
+	}
+
+	// A bad delta anywhere leaves the store untouched.
+	horizon := qs.Horizon()
+	tx = graph.NewTransaction()
+	tx.AddQuad(quad.Quad{"A", "follows", "G", ""})
+	tx.AddQuad(quad.Quad{"A", "follows", "B", ""})
+	if err := w.ApplyTransaction(tx); err != graph.ErrQuadExists {
+		t.Errorf("Unexpected error, got:%v expect:%v", err, graph.ErrQuadExists)
+	}
+	if s := qs.Size(); s != 11 {
+		t.Errorf("Unexpected quadstore size, got:%d expect:11", s)
+	}
+	if h := qs.Horizon(); h != horizon {
+		t.Errorf("Unexpected horizon, got:%d expect:%d", h, horizon)
+	}
+	it := qs.QuadIterator(quad.Subject, qs.ValueOf("A"))
+	if got := iteratedQuads(qs, it); len(got) != 1 {
+		t.Errorf("Unexpected quads from A, got:%v", got)
+	}
+
+	// The size only counts the quads actually written.
+	err := qs.ApplyDeltas([]graph.Delta{
+		{ID: horizon + 1, Quad: quad.Quad{"A", "follows", "B", ""}, Action: graph.Add},
+		{ID: horizon + 2, Quad: quad.Quad{"Z", "follows", "B", ""}, Action: graph.Delete},
+		{ID: horizon + 3, Quad: quad.Quad{"A", "follows", "G", ""}, Action: graph.Add},
+	}, graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true})
+	if err != nil {
+		t.Fatalf("Unexpected error applying deltas: %v", err)
+	}
+	if s := qs.Size(); s != 12 {
+		t.Errorf("Unexpected quadstore size after skipping deltas, got:%d expect:12", s)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	qs, _, cleanup := makeTestStore(t)
+	defer cleanup()
+
+	it := qs.NodesAllIterator()
+	expect := []string{
+		"A", "B", "C", "D", "E", "F", "G",
+		"cool", "follows", "status", "status_graph",
+	}
+	if got := iteratedNames(qs, it); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected nodes, got:%v expect:%v", got, expect)
+	}
+	if size, _ := it.Size(); size != int64(len(expect)) {
+		t.Errorf("Unexpected size, got:%d expect:%d", size, len(expect))
+	}
+	for _, name := range []string{"B", "status_graph"} {
+		if !it.Contains(qs.ValueOf(name)) {
+			t.Errorf("Failed to find %q", name)
+		}
+	}
+	if it.Contains(qs.ValueOf("Z")) {
+		t.Errorf("Unexpectedly found Z")
+	}
+
+	it = qs.QuadIterator(quad.Subject, qs.ValueOf("C"))
+	expectQuads := []quad.Quad{
+		{"C", "follows", "B", ""},
+		{"C", "follows", "D", ""},
+	}
+	if got := iteratedQuads(qs, it); !reflect.DeepEqual(got, expectQuads) {
+		t.Errorf("Unexpected quads, got:%v expect:%v", got, expectQuads)
+	}
+	if !it.Contains(quad.Quad{"C", "follows", "D", ""}) {
+		t.Errorf("Failed to find quad")
+	}
+	if it.Contains(quad.Quad{"D", "follows", "G", ""}) {
+		t.Errorf("Unexpectedly found quad")
+	}
+}
+
+// outIterator follows predicate out of the nodes from it.
+func outIterator(qs graph.QuadStore, it graph.Iterator, predicate string) graph.Iterator {
+	fixed := qs.FixedIterator()
+	fixed.Add(qs.ValueOf(predicate))
+	and := iterator.NewAnd()
+	and.AddSubIterator(iterator.NewLinksTo(qs, it, quad.Subject))
+	and.AddSubIterator(iterator.NewLinksTo(qs, fixed, quad.Predicate))
+	return iterator.NewHasA(qs, and, quad.Object)
+}
+
+var optimizeTests = []struct {
+	message string
+	start   []string
+	hops    int
+	expect  []string
+}{
+	{
+		message: "follow one hop",
+		start:   []string{"C"},
+		hops:    1,
+		expect:  []string{"B", "D"},
+	},
+	{
+		message: "follow two hops",
+		start:   []string{"C"},
+		hops:    2,
+		expect:  []string{"B", "F", "G"},
+	},
+	{
+		message: "follow from several nodes",
+		start:   []string{"A", "E"},
+		hops:    2,
+		expect:  []string{"F", "G"},
+	},
+}
+
+func TestOptimize(t *testing.T) {
+	qs, _, cleanup := makeTestStore(t)
+	defer cleanup()
+
+	// With a linksto-fixed pair
+	fixed := qs.FixedIterator()
+	fixed.Add(qs.ValueOf("F"))
+	fixed.Tagger().Add("internal")
+	lto := iterator.NewLinksTo(qs, fixed, quad.Object)
+
+	oldIt := lto.Clone()
+	newIt, ok := lto.Optimize()
+	if !ok {
+		t.Errorf("Failed to optimize iterator")
+	}
+	if newIt.Type() != Type() {
+		t.Errorf("Optimized iterator type does not match original, got:%v expect:%v", newIt.Type(), Type())
+	}
+	newQuads := iteratedQuads(qs, newIt)
+	oldQuads := iteratedQuads(qs, oldIt)
+	if !reflect.DeepEqual(newQuads, oldQuads) {
+		t.Errorf("Optimized iteration does not match original")
+	}
+	oldIt.Reset()
+	newIt.Reset()
+	graph.Next(oldIt)
+	oldResults := make(map[string]graph.Value)
+	oldIt.TagResults(oldResults)
+	graph.Next(newIt)
+	newResults := make(map[string]graph.Value)
+	newIt.TagResults(newResults)
+	if !reflect.DeepEqual(newResults, oldResults) {
+		t.Errorf("Discordant tag results, new:%v old:%v", newResults, oldResults)
+	}
+
+	// With whole traversals
+	for _, test := range optimizeTests {
+		start := qs.FixedIterator()
+		for _, name := range test.start {
+			start.Add(qs.ValueOf(name))
+		}
+		var it graph.Iterator = start
+		for i := 0; i < test.hops; i++ {
+			it = outIterator(qs, it, "follows")
+		}
+		oldIt := it.Clone()
+		newIt, ok := it.Optimize()
+		if !ok || newIt.Type() != Type() {
+			t.Errorf("Failed to %s in a single query, got:%v", test.message, newIt.Describe())
+			continue
+		}
+		oldNames := iteratedNames(qs, oldIt)
+		newNames := iteratedNames(qs, newIt)
+		if !reflect.DeepEqual(oldNames, newNames) {
+			t.Errorf("Optimized iteration does not match original, got:%v expect:%v", newNames, oldNames)
+		}
+		var got []string
+		for _, name := range newNames {
+			if len(got) == 0 || got[len(got)-1] != name {
+				got = append(got, name)
+			}
+		}
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s, got:%v expect:%v", test.message, got, test.expect)
+		}
+		for _, name := range test.expect {
+			if !newIt.Contains(qs.ValueOf(name)) {
+				t.Errorf("Failed to %s, %q is not contained", test.message, name)
+			}
+		}
+		if newIt.Contains(qs.ValueOf("cool")) {
+			t.Errorf("Failed to %s, cool is contained", test.message)
+		}
+	}
+}
+
+func TestOptimizeTags(t *testing.T) {
+	qs, _, cleanup := makeTestStore(t)
+	defer cleanup()
+
+	start := qs.FixedIterator()
+	start.Add(qs.ValueOf("E"))
+	start.Tagger().Add("start")
+	it := outIterator(qs, start, "follows")
+	it.Tagger().Add("end")
+	it.SubIterators()[0].Tagger().Add("link")
+
+	newIt, ok := it.Optimize()
+	if !ok || newIt.Type() != Type() {
+		t.Fatalf("Failed to optimize iterator, got:%v", newIt.Describe())
+	}
+	if !graph.Next(newIt) {
+		t.Fatalf("Failed to iterate")
+	}
+	got := make(map[string]graph.Value)
+	newIt.TagResults(got)
+	expect := map[string]graph.Value{
+		"start": "E",
+		"end":   "F",
+		"link":  quad.Quad{"E", "follows", "F", ""},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected tag results, got:%v expect:%v", got, expect)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+// Defines the statistics a QuadStore may keep about its predicates, so that
+// iterators can guess their costs from the data rather than from constants.
+//
+// For each predicate, a store counts its quads, and how many distinct nodes
+// are their subjects and their objects. The fan-out in a direction is how
+// many of those quads share a node there, on average.
+
+import (
+	"errors"
+
+	"github.com/google/cayley/quad"
+)
+
+var ErrNoStats = errors.New("quadstore: no predicate statistics")
+
+// PredicateStats are the statistics kept for the quads with one predicate.
+type PredicateStats struct {
+	Quads    int64 // The number of quads.
+	Subjects int64 // The number of distinct subjects.
+	Objects  int64 // The number of distinct objects.
+}
+
+// Distinct returns the number of distinct nodes in a direction, or -1 if it
+// isn't kept.
+func (s PredicateStats) Distinct(d quad.Direction) int64 {
+	switch d {
+	case quad.Subject:
+		return s.Subjects
+	case quad.Object:
+		return s.Objects
+	case quad.Predicate:
+		if s.Quads > 0 {
+			return 1
+		}
+		return 0
+	}
+	return -1
+}
+
+// FanOut returns the average number of quads which share a node in a
+// direction, rounded up, or -1 if it isn't known.
+func (s PredicateStats) FanOut(d quad.Direction) int64 {
+	n := s.Distinct(d)
+	if n < 0 {
+		return -1
+	}
+	if n == 0 {
+		return 0
+	}
+	return (s.Quads + n - 1) / n
+}
+
+// A StatsStore is a QuadStore which keeps statistics about its predicates.
+type StatsStore interface {
+	// PredicateStats returns the statistics of the predicate with the given
+	// value, and whether the store has them.
+	PredicateStats(Value) (PredicateStats, bool)
+
+	// AllPredicateStats returns the statistics of every predicate in use,
+	// by name.
+	AllPredicateStats() (map[string]PredicateStats, error)
+}
+
+// PredicateNode is a node in a direction of the quads with a predicate.
+type PredicateNode struct {
+	Predicate string
+	Dir       quad.Direction
+	Node      string
+}
+
+// A StatsUpdate gathers the changes a set of deltas makes to the statistics
+// of their predicates, for a store to apply as it writes them.
+//
+// A store applies it by adding each change in Nodes to the count it keeps for
+// that node, adding one to the distinct nodes of the predicate for each count
+// which becomes positive, and taking one away for each which drops to zero.
+type StatsUpdate struct {
+	Quads map[string]int64        // The change in quads, by predicate.
+	Nodes map[PredicateNode]int64 // The change in quads, by node.
+}
+
+func NewStatsUpdate() *StatsUpdate {
+	return &StatsUpdate{
+		Quads: make(map[string]int64),
+		Nodes: make(map[PredicateNode]int64),
+	}
+}
+
+// Add records a quad being added, for a positive n, or deleted, for a
+// negative one.
+func (u *StatsUpdate) Add(q quad.Quad, n int64) {
+	u.Quads[q.Predicate] += n
+	u.Nodes[PredicateNode{q.Predicate, quad.Subject, q.Subject}] += n
+	u.Nodes[PredicateNode{q.Predicate, quad.Object, q.Object}] += n
+}
+
+// Apply applies the update to the statistics of each predicate, given the
+// counts of the nodes before and after it, as read and written by count. It
+// returns the predicates whose statistics changed.
+func (u *StatsUpdate) Apply(stats func(pred string) (PredicateStats, error), count func(n PredicateNode, change int64) (old, new int64, err error)) (map[string]PredicateStats, error) {
+	out := make(map[string]PredicateStats)
+	get := func(pred string) (PredicateStats, error) {
+		if s, ok := out[pred]; ok {
+			return s, nil
+		}
+		return stats(pred)
+	}
+	for pred, n := range u.Quads {
+		if n == 0 {
+			continue
+		}
+		s, err := get(pred)
+		if err != nil {
+			return nil, err
+		}
+		s.Quads += n
+		out[pred] = s
+	}
+	for node, n := range u.Nodes {
+		if n == 0 {
+			continue
+		}
+		old, now, err := count(node, n)
+		if err != nil {
+			return nil, err
+		}
+		var distinct int64
+		switch {
+		case old <= 0 && now > 0:
+			distinct = 1
+		case old > 0 && now <= 0:
+			distinct = -1
+		default:
+			continue
+		}
+		s, err := get(node.Predicate)
+		if err != nil {
+			return nil, err
+		}
+		if node.Dir == quad.Subject {
+			s.Subjects += distinct
+		} else {
+			s.Objects += distinct
+		}
+		out[node.Predicate] = s
+	}
+	return out, nil
+}
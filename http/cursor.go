@@ -0,0 +1,211 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+// Cursors keep queries that have returned a page of their results running,
+// paused, until the next page is asked for or they have been idle too long.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/cayley/query"
+)
+
+var (
+	// ErrNoCursor is returned for a cursor which has expired, finished, or
+	// never existed.
+	ErrNoCursor = errors.New("no such cursor")
+	// ErrStaleCursor is returned for a cursor whose store has been written
+	// to since its query started.
+	ErrStaleCursor = errors.New("cursor is stale, the store has changed")
+	// ErrCursorLanguage is returned for a cursor asked for with another query
+	// language than its query's.
+	ErrCursorLanguage = errors.New("cursor is for a query in another language")
+)
+
+// DefaultCursorTimeout is how long a cursor is kept without being used, unless
+// configured otherwise.
+const DefaultCursorTimeout = 5 * time.Minute
+
+// DefaultMaxCursors is how many cursors are kept at once, unless configured
+// otherwise. Once there are as many, keeping another closes the one which
+// has been idle longest.
+const DefaultMaxCursors = 100
+
+type cursor struct {
+	id      string
+	lang    string
+	horizon int64
+	ses     query.HTTP
+	results chan interface{}
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	kept    time.Time
+
+	// Results read from the query but not yet returned, which tell whether
+	// there are more.
+	pending []interface{}
+	done    bool
+}
+
+type cursors struct {
+	sync.Mutex
+	idle time.Duration
+	max  int
+	open map[string]*cursor
+}
+
+func newCursors(idle time.Duration, max int) *cursors {
+	if idle <= 0 {
+		idle = DefaultCursorTimeout
+	}
+	if max <= 0 {
+		max = DefaultMaxCursors
+	}
+	return &cursors{
+		idle: idle,
+		max:  max,
+		open: make(map[string]*cursor),
+	}
+}
+
+// start runs a query for a cursor, which isn't kept until it has a page left
+// over. The query runs until it's done or the cursor is closed.
+func (cs *cursors) start(lang, code string, ses query.HTTP, horizon int64) *cursor {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &cursor{
+		lang:    lang,
+		horizon: horizon,
+		ses:     ses,
+		results: make(chan interface{}, 5),
+		cancel:  cancel,
+	}
+	go ses.ExecInput(ctx, code, c.results, -1)
+	return c
+}
+
+// take removes a cursor, so that only one request reads from it at a time.
+// A cursor asked for with the wrong language is left where it is.
+func (cs *cursors) take(id, lang string) (*cursor, error) {
+	cs.Lock()
+	defer cs.Unlock()
+	c, ok := cs.open[id]
+	if !ok {
+		return nil, ErrNoCursor
+	}
+	if c.lang != lang {
+		return nil, ErrCursorLanguage
+	}
+	cs.remove(c)
+	return c, nil
+}
+
+// remove removes a kept cursor without closing it. cs must be locked.
+func (cs *cursors) remove(c *cursor) {
+	delete(cs.open, c.id)
+	c.timer.Stop()
+}
+
+// keep adds a cursor with results left, returning its id. It's closed once it
+// has been idle too long, or to make room for others.
+func (cs *cursors) keep(c *cursor) (string, error) {
+	if c.id == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			c.close()
+			return "", err
+		}
+		c.id = hex.EncodeToString(b)
+	}
+	cs.Lock()
+	defer cs.Unlock()
+	if len(cs.open) >= cs.max {
+		var oldest *cursor
+		for _, o := range cs.open {
+			if oldest == nil || o.kept.Before(oldest.kept) {
+				oldest = o
+			}
+		}
+		cs.remove(oldest)
+		oldest.close()
+	}
+	c.kept = time.Now()
+	cs.open[c.id] = c
+	c.timer = time.AfterFunc(cs.idle, func() {
+		cs.Lock()
+		if cs.open[c.id] == c {
+			delete(cs.open, c.id)
+			c.close()
+		}
+		cs.Unlock()
+	})
+	return c.id, nil
+}
+
+// page returns up to n results of a cursor's query, giving up once ctx is
+// done, and whether there are more. Results are built as they're read, so
+// nothing the query's session holds is touched until it has finished.
+func (c *cursor) page(ctx context.Context, n int) ([]interface{}, bool, error) {
+	// Read a result past the page, to learn whether there are more.
+	for !c.done && len(c.pending) <= n {
+		select {
+		case r, ok := <-c.results:
+			if !ok {
+				c.done = true
+				// The query may have failed without a result to say so.
+				if _, err := c.ses.GetJSON(); err != nil {
+					c.close()
+					return nil, false, err
+				}
+				continue
+			}
+			results, err := c.ses.ResultJSON(r)
+			if err != nil {
+				c.close()
+				return nil, false, err
+			}
+			c.pending = append(c.pending, results...)
+		case <-ctx.Done():
+			c.close()
+			return nil, false, query.KillError(ctx)
+		}
+	}
+	if len(c.pending) > n {
+		results := c.pending[:n:n]
+		c.pending = c.pending[n:]
+		return results, true, nil
+	}
+	results := c.pending
+	c.pending = nil
+	if results == nil {
+		results = make([]interface{}, 0)
+	}
+	return results, false, nil
+}
+
+// close kills a cursor's query.
+func (c *cursor) close() {
+	c.cancel()
+	// The query may be waiting to send a result.
+	go func() {
+		for range c.results {
+		}
+	}()
+}
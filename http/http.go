@@ -101,17 +101,22 @@ func (h *TemplateRequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Reques
 }
 
 type API struct {
-	config *config.Config
-	handle *graph.Handle
+	config  *config.Config
+	handle  *graph.Handle
+	cursors *cursors
 }
 
 func (api *API) APIv1(r *httprouter.Router) {
 	r.POST("/api/v1/query/:query_lang", LogRequest(api.ServeV1Query))
+	r.GET("/api/v1/query/:query_lang/next", LogRequest(api.ServeV1Next))
+	r.POST("/api/v1/query/:query_lang/next", LogRequest(api.ServeV1Next))
 	r.POST("/api/v1/shape/:query_lang", LogRequest(api.ServeV1Shape))
+	r.POST("/api/v1/explain/:query_lang", LogRequest(api.ServeV1Explain))
 	r.POST("/api/v1/write", LogRequest(api.ServeV1Write))
 	r.POST("/api/v1/write/file/nquad", LogRequest(api.ServeV1WriteNQuad))
 	//TODO(barakmich): /write/text/nquad, which reads from request.body instead of HTML5 file form?
 	r.POST("/api/v1/delete", LogRequest(api.ServeV1Delete))
+	r.GET("/api/v1/stats", LogRequest(api.ServeV1Stats))
 }
 
 func SetupRoutes(handle *graph.Handle, cfg *config.Config) {
@@ -124,7 +129,7 @@ func SetupRoutes(handle *graph.Handle, cfg *config.Config) {
 	templates.ParseGlob(fmt.Sprint(assets, "/templates/*.html"))
 	root := &TemplateRequestHandler{templates: templates}
 	docs := &DocRequestHandler{assets: assets}
-	api := &API{config: cfg, handle: handle}
+	api := &API{config: cfg, handle: handle, cursors: newCursors(cfg.CursorTimeout, cfg.MaxCursors)}
 	api.APIv1(r)
 
 	//m.Use(martini.Static("static", martini.StaticOptions{Prefix: "/static", SkipLogging: true}))
@@ -15,11 +15,24 @@
 package http
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/google/cayley/config"
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/cache"
+	_ "github.com/google/cayley/graph/memstore"
 	"github.com/google/cayley/quad"
+	_ "github.com/google/cayley/writer"
 )
 
 var parseTests = []struct {
@@ -71,3 +84,361 @@ func TestParseJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestParseTransaction(t *testing.T) {
+	tx, err := ParseJSONToTransaction([]byte(`{
+		"add": [{"subject": "foo", "predicate": "bar", "object": "qux"}],
+		"delete": [{"subject": "foo", "predicate": "bar", "object": "baz"}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := []graph.Delta{
+		{Quad: quad.Quad{"foo", "bar", "baz", ""}, Action: graph.Delete},
+		{Quad: quad.Quad{"foo", "bar", "qux", ""}, Action: graph.Add},
+	}
+	if !reflect.DeepEqual(tx.Deltas, expect) {
+		t.Errorf("Unexpected deltas, got:%v expect:%v", tx.Deltas, expect)
+	}
+
+	_, err = ParseJSONToTransaction([]byte(`{"add": [{"subject": "foo"}]}`))
+	if err == nil {
+		t.Errorf("Expected an error for an invalid quad")
+	}
+}
+
+var ignoreTests = []struct {
+	message  string
+	query    string
+	defaults graph.IgnoreOpts
+	expect   graph.IgnoreOpts
+	err      bool
+}{
+	{
+		message: "default to strict writes",
+		query:   "",
+		expect:  graph.IgnoreOpts{},
+	},
+	{
+		message: "parse both options",
+		query:   "ignore_duplicate=true&ignore_missing=1",
+		expect:  graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true},
+	},
+	{
+		message:  "keep the writer's options",
+		query:    "",
+		defaults: graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true},
+		expect:   graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true},
+	},
+	{
+		message:  "override the writer's options",
+		query:    "ignore_duplicate=false",
+		defaults: graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true},
+		expect:   graph.IgnoreOpts{IgnoreMissing: true},
+	},
+	{
+		message: "reject a bad value",
+		query:   "ignore_missing=maybe",
+		err:     true,
+	},
+}
+
+func TestParseIgnoreOpts(t *testing.T) {
+	for _, test := range ignoreTests {
+		r, _ := http.NewRequest("POST", "/api/v1/write?"+test.query, nil)
+		got, err := ParseIgnoreOpts(r)
+		if (err != nil) != test.err {
+			t.Errorf("Failed to %s, unexpected error: %v", test.message, err)
+			continue
+		}
+		if err == nil && got.Over(test.defaults) != test.expect {
+			t.Errorf("Failed to %s, got:%+v expect:%+v", test.message, got.Over(test.defaults), test.expect)
+		}
+	}
+}
+
+func makeTestAPI(t *testing.T, cursorTimeout time.Duration) *API {
+	qs, _ := graph.NewQuadStore("memstore", "", nil)
+	w, _ := graph.NewQuadWriter("single", qs, nil)
+	for _, o := range []string{"1", "2", "3", "4", "5"} {
+		if err := w.AddQuad(quad.Quad{"a", "p", o, ""}); err != nil {
+			t.Fatalf("Failed to add quad: %v", err)
+		}
+	}
+	cfg := &config.Config{Timeout: 10 * time.Second}
+	return &API{
+		config:  cfg,
+		handle:  &graph.Handle{QuadStore: qs, QuadWriter: w},
+		cursors: newCursors(cursorTimeout, 0),
+	}
+}
+
+type page struct {
+	Result []map[string]interface{} `json:"result"`
+	Cursor string                   `json:"cursor"`
+	Error  string                   `json:"error"`
+}
+
+func getPage(t *testing.T, api *API, method, url, body string) (int, page) {
+	r, _ := http.NewRequest(method, url, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	lang := strings.TrimPrefix(r.URL.Path, "/api/v1/query/")
+	lang = strings.TrimSuffix(lang, "/next")
+	params := httprouter.Params{{Key: "query_lang", Value: lang}}
+	if strings.Contains(url, "/next") {
+		api.ServeV1Next(w, r, params)
+	} else {
+		api.ServeV1Query(w, r, params)
+	}
+	var p page
+	json.Unmarshal(w.Body.Bytes(), &p)
+	return w.Code, p
+}
+
+func TestCursor(t *testing.T) {
+	api := makeTestAPI(t, time.Minute)
+
+	var got []string
+	var sizes []int
+	code, p := getPage(t, api, "POST", "/api/v1/query/gremlin?limit=3", `g.V().All()`)
+	for {
+		if code != 200 {
+			t.Fatalf("Unexpected status %d, error: %s", code, p.Error)
+		}
+		sizes = append(sizes, len(p.Result))
+		for _, r := range p.Result {
+			got = append(got, r["id"].(string))
+		}
+		if p.Cursor == "" {
+			break
+		}
+		code, p = getPage(t, api, "GET", "/api/v1/query/gremlin/next?limit=3&cursor="+p.Cursor, "")
+	}
+	sort.Strings(got)
+	if expect := []string{"1", "2", "3", "4", "5", "a", "p"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected results, got:%v expect:%v", got, expect)
+	}
+	if expect := []int{3, 3, 1}; !reflect.DeepEqual(sizes, expect) {
+		t.Errorf("Unexpected page sizes, got:%v expect:%v", sizes, expect)
+	}
+
+	_, p = getPage(t, api, "POST", "/api/v1/query/gremlin?limit=2", `g.V().All()`)
+	if p.Cursor == "" {
+		t.Fatalf("Expected a cursor for a query with more results")
+	}
+	if err := api.handle.QuadWriter.AddQuad(quad.Quad{"a", "p", "6", ""}); err != nil {
+		t.Fatalf("Failed to add quad: %v", err)
+	}
+	if code, _ = getPage(t, api, "GET", "/api/v1/query/gremlin/next?cursor="+p.Cursor, ""); code != 410 {
+		t.Errorf("Unexpected status for a stale cursor, got:%d expect:410", code)
+	}
+	if code, _ = getPage(t, api, "GET", "/api/v1/query/gremlin/next?cursor="+p.Cursor, ""); code != 404 {
+		t.Errorf("Unexpected status for a closed cursor, got:%d expect:404", code)
+	}
+	if code, _ = getPage(t, api, "POST", "/api/v1/query/gremlin?limit=0", `g.V().All()`); code != 400 {
+		t.Errorf("Unexpected status for a bad limit, got:%d expect:400", code)
+	}
+}
+
+func TestCursorObjects(t *testing.T) {
+	api := makeTestAPI(t, time.Minute)
+	for _, q := range []quad.Quad{{"b", "p", "1", ""}, {"b", "p", "2", ""}} {
+		if err := api.handle.QuadWriter.AddQuad(q); err != nil {
+			t.Fatalf("Failed to add quad: %v", err)
+		}
+	}
+
+	// Each object matches the query several ways, which mustn't be split
+	// between pages.
+	var got []string
+	code, p := getPage(t, api, "POST", "/api/v1/query/mql?limit=1", `[{"id": null, "p": [{"id": null}]}]`)
+	for {
+		if code != 200 {
+			t.Fatalf("Unexpected status %d, error: %s", code, p.Error)
+		}
+		if len(p.Result) != 1 {
+			t.Fatalf("Unexpected page size, got:%d expect:1", len(p.Result))
+		}
+		b, _ := json.Marshal(p.Result[0])
+		got = append(got, string(b))
+		if p.Cursor == "" {
+			break
+		}
+		code, p = getPage(t, api, "GET", "/api/v1/query/mql/next?limit=1&cursor="+p.Cursor, "")
+	}
+	sort.Strings(got)
+	expect := []string{
+		`{"id":"a","p":[{"id":"1"},{"id":"2"},{"id":"3"},{"id":"4"},{"id":"5"}]}`,
+		`{"id":"b","p":[{"id":"1"},{"id":"2"}]}`,
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected results, got:%v expect:%v", got, expect)
+	}
+}
+
+func TestCursorLimits(t *testing.T) {
+	api := makeTestAPI(t, time.Minute)
+	api.cursors = newCursors(time.Minute, 2)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		_, p := getPage(t, api, "POST", "/api/v1/query/mql?limit=1", `[{"id": null}]`)
+		if p.Cursor == "" {
+			t.Fatalf("Expected a cursor for a query with more results")
+		}
+		ids = append(ids, p.Cursor)
+	}
+	if code, _ := getPage(t, api, "GET", "/api/v1/query/mql/next?cursor="+ids[0], ""); code != 404 {
+		t.Errorf("Unexpected status for an evicted cursor, got:%d expect:404", code)
+	}
+	if code, _ := getPage(t, api, "GET", "/api/v1/query/sparql/next?cursor="+ids[1], ""); code != 400 {
+		t.Errorf("Unexpected status for a cursor of another language, got:%d expect:400", code)
+	}
+	if code, p := getPage(t, api, "GET", "/api/v1/query/mql/next?cursor="+ids[1], ""); code != 200 {
+		t.Errorf("Unexpected status for a cursor, got:%d error: %s", code, p.Error)
+	}
+}
+
+func TestCursorExpiry(t *testing.T) {
+	api := makeTestAPI(t, 10*time.Millisecond)
+	_, p := getPage(t, api, "POST", "/api/v1/query/gremlin?limit=2", `g.V().All()`)
+	if p.Cursor == "" {
+		t.Fatalf("Expected a cursor for a query with more results")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if code, _ := getPage(t, api, "GET", "/api/v1/query/gremlin/next?cursor="+p.Cursor, ""); code != 404 {
+		t.Errorf("Unexpected status for an expired cursor, got:%d expect:404", code)
+	}
+}
+
+var streamTests = []struct {
+	message string
+	lang    string
+	query   string
+	stream  string
+	accept  string
+	timeout time.Duration
+	expect  []string
+}{
+	{
+		message: "stream results by parameter",
+		query:   `g.V("a").Out("p").All()`,
+		stream:  "1",
+		expect:  []string{`{"id":"1"}`, `{"id":"2"}`, `{"id":"3"}`, `{"id":"4"}`, `{"id":"5"}`},
+	},
+	{
+		message: "stream results by header",
+		query:   `g.V("a").Out("p").Is("2").All()`,
+		accept:  "application/x-ndjson",
+		expect:  []string{`{"id":"2"}`},
+	},
+	{
+		message: "end a stream with an error",
+		query:   `g.V("a").All(); while (true) {}`,
+		stream:  "true",
+		timeout: 50 * time.Millisecond,
+		expect:  []string{`{"id":"a"}`, `{"error":"query timed out"}`},
+	},
+	{
+		message: "stream each MQL object whole",
+		lang:    "mql",
+		query:   `[{"id": null, "p": [{"id": null}]}]`,
+		stream:  "1",
+		expect:  []string{`{"id":"a","p":[{"id":"1"},{"id":"2"},{"id":"3"},{"id":"4"},{"id":"5"}]}`},
+	},
+}
+
+func TestStream(t *testing.T) {
+	for _, test := range streamTests {
+		api := makeTestAPI(t, time.Minute)
+		if test.timeout != 0 {
+			api.config.Timeout = test.timeout
+		}
+		lang := test.lang
+		if lang == "" {
+			lang = "gremlin"
+		}
+		url := "/api/v1/query/" + lang
+		if test.stream != "" {
+			url += "?stream=" + test.stream
+		}
+		r, _ := http.NewRequest("POST", url, strings.NewReader(test.query))
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		api.ServeV1Query(w, r, httprouter.Params{{Key: "query_lang", Value: lang}})
+		if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Failed to %s, unexpected content type %q", test.message, ct)
+		}
+		got := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if test.timeout == 0 {
+			sort.Strings(got)
+		}
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s, got:%q expect:%q", test.message, got, test.expect)
+		}
+	}
+}
+
+func TestJSONLD(t *testing.T) {
+	api := makeTestAPI(t, time.Minute)
+	doc := `{
+		"@context": {"@vocab": "http://example.org/"},
+		"@id": "a",
+		"name": "Alice"
+	}`
+	r, _ := http.NewRequest("POST", "/api/v1/write", strings.NewReader(doc))
+	r.Header.Set("Content-Type", "application/ld+json; charset=utf-8")
+	w := httptest.NewRecorder()
+	if code := api.ServeV1Write(w, r, nil); code != 200 {
+		t.Fatalf("Failed to write JSON-LD, status %d: %s", code, w.Body)
+	}
+
+	r, _ = http.NewRequest("POST", "/api/v1/query/gremlin?format=jsonld", strings.NewReader(`g.V("a").Tag("who").Out("http://example.org/name").Tag("name").All()`))
+	r.Host = "localhost:64210"
+	w = httptest.NewRecorder()
+	api.ServeV1Query(w, r, httprouter.Params{{Key: "query_lang", Value: "gremlin"}})
+	var got struct {
+		Result map[string]interface{} `json:"result"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &got)
+	expect := map[string]interface{}{
+		"@context": map[string]interface{}{"@vocab": "http://localhost:64210/api/v1/query/gremlin#", "id": "@id"},
+		"@graph": []interface{}{
+			map[string]interface{}{"id": "Alice", "name": "Alice", "who": "a"},
+		},
+	}
+	if !reflect.DeepEqual(got.Result, expect) {
+		t.Errorf("Unexpected JSON-LD results, got:%v expect:%v", got.Result, expect)
+	}
+}
+
+func TestStats(t *testing.T) {
+	api := makeTestAPI(t, time.Minute)
+	api.handle.QuadStore = cache.New(api.handle.QuadStore, 16, 16)
+	for i := 0; i < 2; i++ {
+		if code, p := getPage(t, api, "POST", "/api/v1/query/gremlin", `g.V("a").Out("p").All()`); code != 200 {
+			t.Fatalf("Failed to run query, status %d: %s", code, p.Error)
+		}
+	}
+
+	r, _ := http.NewRequest("GET", "/api/v1/stats", nil)
+	w := httptest.NewRecorder()
+	if code := api.ServeV1Stats(w, r, nil); code != 200 {
+		t.Fatalf("Failed to get stats, status %d: %s", code, w.Body)
+	}
+	var got struct {
+		Result StoreStats `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to read stats: %v", err)
+	}
+	if got.Result.Quads != 5 {
+		t.Errorf("Unexpected number of quads, got:%d expect:5", got.Result.Quads)
+	}
+	c := got.Result.Cache
+	if c == nil || c.IteratorHits == 0 || c.IteratorMisses == 0 {
+		t.Errorf("Expected cache hits and misses, got:%+v", c)
+	}
+}
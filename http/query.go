@@ -15,20 +15,27 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/google/cayley/quad/jsonld"
 	"github.com/google/cayley/query"
+	"github.com/google/cayley/query/datalog"
 	"github.com/google/cayley/query/gremlin"
 	"github.com/google/cayley/query/mql"
+	"github.com/google/cayley/query/sparql"
 )
 
 type SuccessQueryWrapper struct {
 	Result interface{} `json:"result"`
+	Cursor string      `json:"cursor,omitempty"`
 }
 
 type ErrorQueryWrapper struct {
@@ -47,9 +54,9 @@ func WrapResult(result interface{}) ([]byte, error) {
 	return json.MarshalIndent(wrap, "", " ")
 }
 
-func Run(q string, ses query.HTTP) (interface{}, error) {
+func Run(ctx context.Context, q string, ses query.HTTP) (interface{}, error) {
 	c := make(chan interface{}, 5)
-	go ses.ExecInput(q, c, 100)
+	go ses.ExecInput(ctx, q, c, 100)
 	for res := range c {
 		ses.BuildJSON(res)
 	}
@@ -66,17 +73,136 @@ func GetQueryShape(q string, ses query.HTTP) ([]byte, error) {
 	return json.Marshal(data)
 }
 
+// DefaultPageSize is the number of results a query returns at once, unless
+// asked for some other number.
+const DefaultPageSize = 100
+
+// ParsePageSize reads the number of results to return at once from the limit
+// parameter of a request.
+func ParsePageSize(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return DefaultPageSize, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid limit parameter %q", s)
+	}
+	return n, nil
+}
+
+// WantsStream reads whether a request asks for its results as they're found,
+// one JSON value a line, from its stream parameter or its Accept header.
+func WantsStream(r *http.Request) (bool, error) {
+	if s := r.URL.Query().Get("stream"); s != "" {
+		stream, err := strconv.ParseBool(s)
+		if err != nil {
+			return false, fmt.Errorf("invalid stream parameter %q", s)
+		}
+		return stream, nil
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson"), nil
+}
+
+// WantsJSONLD reads whether a request asks for its results as a JSON-LD
+// document, from its format parameter or its Accept header.
+func WantsJSONLD(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "jsonld"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/ld+json")
+}
+
+// resultVocab returns the vocabulary of the results of queries in a language
+// as JSON-LD, which their tags are the terms of.
+func resultVocab(r *http.Request, lang string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/api/v1/query/%s#", scheme, r.Host, lang)
+}
+
+// Stream writes the results of a query as they're found, one JSON value a
+// line, flushing each. An error ends the stream with a line of its own, with
+// the same wrapper as an error otherwise has.
+func Stream(ctx context.Context, w http.ResponseWriter, q string, ses query.HTTP) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c := make(chan interface{}, 5)
+	go ses.ExecInput(ctx, q, c, -1)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	var err error
+	for res := range c {
+		if err != nil {
+			// Wait for the query to stop.
+			continue
+		}
+		var results []interface{}
+		results, err = ses.ResultJSON(res)
+		if err != nil {
+			cancel()
+			continue
+		}
+		for _, r := range results {
+			if werr := enc.Encode(r); werr != nil {
+				// The client has gone away, so there's nobody to tell.
+				cancel()
+				for range c {
+				}
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err == nil {
+		// The query has finished, so it may have failed without a result
+		// to say so.
+		_, err = ses.GetJSON()
+	}
+	if err == nil {
+		err = query.KillError(ctx)
+	}
+	if err != nil {
+		enc.Encode(ErrorQueryWrapper{Error: err.Error()})
+	}
+}
+
 // TODO(barakmich): Turn this into proper middleware.
 func (api *API) ServeV1Query(w http.ResponseWriter, r *http.Request, params httprouter.Params) int {
 	var ses query.HTTP
 	switch params.ByName("query_lang") {
 	case "gremlin":
-		ses = gremlin.NewSession(api.handle.QuadStore, api.config.Timeout, false)
+		// The timeout applies to each page, not to the whole query.
+		ses = gremlin.NewSession(api.handle.QuadStore, -1, false)
 	case "mql":
 		ses = mql.NewSession(api.handle.QuadStore)
+	case "sparql":
+		ses = sparql.NewSession(api.handle.QuadStore)
+	case "datalog":
+		ses = datalog.NewSession(api.handle.QuadStore)
 	default:
 		return jsonResponse(w, 400, "Need a query language.")
 	}
+	n, err := ParsePageSize(r)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	stream, err := WantsStream(r)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	var vocab string
+	if WantsJSONLD(r) {
+		if stream {
+			return jsonResponse(w, 400, "JSON-LD results can't be streamed.")
+		}
+		vocab = resultVocab(r, params.ByName("query_lang"))
+	}
 	bodyBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return jsonResponse(w, 400, err)
@@ -85,33 +211,95 @@ func (api *API) ServeV1Query(w http.ResponseWriter, r *http.Request, params http
 	result, err := ses.InputParses(code)
 	switch result {
 	case query.Parsed:
-		var output interface{}
-		var bytes []byte
-		var err error
-		output, err = Run(code, ses)
-		if err != nil {
-			bytes, err = WrapErrResult(err)
-			http.Error(w, string(bytes), 400)
-			ses = nil
-			return 400
+		if stream {
+			// The query is killed if the client goes away or it runs too
+			// long.
+			ctx := r.Context()
+			if api.config.Timeout >= 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, api.config.Timeout)
+				defer cancel()
+			}
+			Stream(ctx, w, code, ses)
+			return 200
 		}
-		bytes, err = WrapResult(output)
-		if err != nil {
-			ses = nil
-			return jsonResponse(w, 400, err)
-		}
-		fmt.Fprint(w, string(bytes))
-		ses = nil
-		return 200
+		c := api.cursors.start(params.ByName("query_lang"), code, ses, api.handle.QuadStore.Horizon())
+		return api.servePage(w, r, c, n, vocab)
 	case query.ParseFail:
-		ses = nil
 		return jsonResponse(w, 400, err)
 	default:
-		ses = nil
 		return jsonResponse(w, 500, "Incomplete data?")
 	}
 }
 
+// ServeV1Next returns the next page of results of a query, from the cursor
+// which came with the last.
+func (api *API) ServeV1Next(w http.ResponseWriter, r *http.Request, params httprouter.Params) int {
+	n, err := ParsePageSize(r)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	id := r.URL.Query().Get("cursor")
+	if id == "" {
+		return jsonResponse(w, 400, "Need a cursor.")
+	}
+	c, err := api.cursors.take(id, params.ByName("query_lang"))
+	switch err {
+	case nil:
+	case ErrCursorLanguage:
+		return jsonResponse(w, 400, err)
+	default:
+		return jsonResponse(w, 404, err)
+	}
+	if api.handle.QuadStore.Horizon() != c.horizon {
+		c.close()
+		return jsonResponse(w, 410, ErrStaleCursor)
+	}
+	var vocab string
+	if WantsJSONLD(r) {
+		vocab = resultVocab(r, params.ByName("query_lang"))
+	}
+	return api.servePage(w, r, c, n, vocab)
+}
+
+// servePage writes a page of up to n results of a cursor, with the cursor
+// again if there are more. Given a vocabulary, the page is a JSON-LD document.
+func (api *API) servePage(w http.ResponseWriter, r *http.Request, c *cursor, n int, vocab string) int {
+	// The query is killed if the client goes away or the page takes too long.
+	ctx := r.Context()
+	if api.config.Timeout >= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, api.config.Timeout)
+		defer cancel()
+	}
+	results, more, err := c.page(ctx, n)
+	wrap := SuccessQueryWrapper{Result: results}
+	if err == nil && vocab != "" {
+		wrap.Result, err = jsonld.Results(results, vocab)
+	}
+	if err == nil && more {
+		wrap.Cursor, err = api.cursors.keep(c)
+	}
+	if err != nil {
+		if more {
+			c.close()
+		}
+		status := 400
+		if err == query.ErrKillTimeout {
+			status = 408
+		}
+		bytes, _ := WrapErrResult(err)
+		http.Error(w, string(bytes), status)
+		return status
+	}
+	bytes, err := json.MarshalIndent(wrap, "", " ")
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	fmt.Fprint(w, string(bytes))
+	return 200
+}
+
 func (api *API) ServeV1Shape(w http.ResponseWriter, r *http.Request, params httprouter.Params) int {
 	var ses query.HTTP
 	switch params.ByName("query_lang") {
@@ -119,6 +307,8 @@ func (api *API) ServeV1Shape(w http.ResponseWriter, r *http.Request, params http
 		ses = gremlin.NewSession(api.handle.QuadStore, api.config.Timeout, false)
 	case "mql":
 		ses = mql.NewSession(api.handle.QuadStore)
+	case "sparql":
+		ses = sparql.NewSession(api.handle.QuadStore)
 	default:
 		return jsonResponse(w, 400, "Need a query language.")
 	}
@@ -144,3 +334,65 @@ func (api *API) ServeV1Shape(w http.ResponseWriter, r *http.Request, params http
 		return jsonResponse(w, 500, "Incomplete data?")
 	}
 }
+
+// ServeV1Explain returns the optimized iterators of a query, with their
+// estimated costs. With the profile parameter set, it runs the query and
+// adds what each iterator did.
+func (api *API) ServeV1Explain(w http.ResponseWriter, r *http.Request, params httprouter.Params) int {
+	var ses query.HTTP
+	switch params.ByName("query_lang") {
+	case "gremlin":
+		ses = gremlin.NewSession(api.handle.QuadStore, api.config.Timeout, false)
+	case "mql":
+		ses = mql.NewSession(api.handle.QuadStore)
+	case "sparql":
+		ses = sparql.NewSession(api.handle.QuadStore)
+	default:
+		return jsonResponse(w, 400, "Need a query language.")
+	}
+	var profile bool
+	if s := r.URL.Query().Get("profile"); s != "" {
+		var err error
+		profile, err = strconv.ParseBool(s)
+		if err != nil {
+			return jsonResponse(w, 400, fmt.Sprintf("invalid profile parameter %q", s))
+		}
+	}
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	code := string(bodyBytes)
+	result, err := ses.InputParses(code)
+	switch result {
+	case query.Parsed:
+		ctx := r.Context()
+		if api.config.Timeout >= 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, api.config.Timeout)
+			defer cancel()
+		}
+		explain := query.NewExplain(profile)
+		ses.(query.Explainer).SetExplain(explain)
+		_, err = Run(ctx, code, ses)
+		if err != nil {
+			status := 400
+			if err == query.ErrKillTimeout {
+				status = 408
+			}
+			bytes, _ := WrapErrResult(err)
+			http.Error(w, string(bytes), status)
+			return status
+		}
+		bytes, err := WrapResult(explain)
+		if err != nil {
+			return jsonResponse(w, 400, err)
+		}
+		fmt.Fprint(w, string(bytes))
+		return 200
+	case query.ParseFail:
+		return jsonResponse(w, 400, err)
+	default:
+		return jsonResponse(w, 500, "Incomplete data?")
+	}
+}
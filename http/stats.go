@@ -0,0 +1,58 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/cache"
+)
+
+// StoreStats is what a running store can tell about itself. The statistics
+// of its predicates are only there for a store which keeps them, and those of
+// its cache for a cache store.
+type StoreStats struct {
+	Quads      int64
+	Predicates map[string]graph.PredicateStats `json:",omitempty"`
+	Cache      *cache.Stats                    `json:",omitempty"`
+}
+
+// ServeV1Stats returns the statistics of the store, as they are while it
+// serves queries.
+func (api *API) ServeV1Stats(w http.ResponseWriter, r *http.Request, params httprouter.Params) int {
+	qs := api.handle.QuadStore
+	stats := StoreStats{Quads: qs.Size()}
+	if ss, ok := qs.(graph.StatsStore); ok {
+		preds, err := ss.AllPredicateStats()
+		if err != nil && err != graph.ErrNoStats {
+			return jsonResponse(w, 500, err)
+		}
+		stats.Predicates = preds
+	}
+	if c, ok := qs.(*cache.QuadStore); ok {
+		s := c.Stats()
+		stats.Cache = &s
+	}
+	bytes, err := WrapResult(stats)
+	if err != nil {
+		return jsonResponse(w, 500, err)
+	}
+	fmt.Fprint(w, string(bytes))
+	return 200
+}
@@ -15,18 +15,22 @@
 package http
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"strconv"
 
 	"github.com/barakmich/glog"
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/google/cayley/graph"
 	"github.com/google/cayley/quad"
 	"github.com/google/cayley/quad/cquads"
+	"github.com/google/cayley/quad/jsonld"
 )
 
 func ParseJSONToQuadList(jsonBody []byte) ([]quad.Quad, error) {
@@ -43,20 +47,151 @@ func ParseJSONToQuadList(jsonBody []byte) ([]quad.Quad, error) {
 	return quads, nil
 }
 
+// ParseJSONLDToQuadList reads the quads of a JSON-LD document.
+func ParseJSONLDToQuadList(body []byte) ([]quad.Quad, error) {
+	dec := jsonld.NewDecoder(bytes.NewReader(body))
+	var quads []quad.Quad
+	for {
+		q, err := dec.Unmarshal()
+		if err == io.EOF {
+			return quads, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !q.IsValid() {
+			return nil, fmt.Errorf("invalid quad at index %d. %s", len(quads), q)
+		}
+		quads = append(quads, q)
+	}
+}
+
+// isJSONLD returns whether a media type is that of JSON-LD.
+func isJSONLD(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	return err == nil && t == "application/ld+json"
+}
+
+// WriteRequest is the body of a write that both adds and deletes quads.
+type WriteRequest struct {
+	Add    []quad.Quad `json:"add"`
+	Delete []quad.Quad `json:"delete"`
+}
+
+// ParseJSONToTransaction reads a WriteRequest into a transaction that
+// deletes quads before adding them, so that a quad can be replaced.
+func ParseJSONToTransaction(jsonBody []byte) (*graph.Transaction, error) {
+	var req WriteRequest
+	err := json.Unmarshal(jsonBody, &req)
+	if err != nil {
+		return nil, err
+	}
+	tx := graph.NewTransaction()
+	for i, q := range req.Delete {
+		if !q.IsValid() {
+			return nil, fmt.Errorf("invalid quad to delete at index %d. %s", i, q)
+		}
+		tx.RemoveQuad(q)
+	}
+	for i, q := range req.Add {
+		if !q.IsValid() {
+			return nil, fmt.Errorf("invalid quad to add at index %d. %s", i, q)
+		}
+		tx.AddQuad(q)
+	}
+	return tx, nil
+}
+
+// ParseIgnoreOpts reads the ignore_duplicate and ignore_missing query
+// parameters of a write request. Those it has replace the writer's options.
+func ParseIgnoreOpts(r *http.Request) (graph.IgnoreOverride, error) {
+	var opts graph.IgnoreOverride
+	for _, p := range []struct {
+		name string
+		val  **bool
+	}{
+		{"ignore_duplicate", &opts.IgnoreDup},
+		{"ignore_missing", &opts.IgnoreMissing},
+	} {
+		s := r.URL.Query().Get(p.name)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s parameter %q", p.name, s)
+		}
+		*p.val = &v
+	}
+	return opts, nil
+}
+
+// writeErrorCode is the status for a failed write: a request that does not
+// fit the data is the client's fault, anything else is the server's.
+func writeErrorCode(err error) int {
+	if err == graph.ErrQuadExists || err == graph.ErrQuadNotExist {
+		return 400
+	}
+	return 500
+}
+
 func (api *API) ServeV1Write(w http.ResponseWriter, r *http.Request, _ httprouter.Params) int {
 	if api.config.ReadOnly {
 		return jsonResponse(w, 400, "Database is read-only.")
 	}
+	opts, err := ParseIgnoreOpts(r)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
 	bodyBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return jsonResponse(w, 400, err)
 	}
-	quads, err := ParseJSONToQuadList(bodyBytes)
+	var quads []quad.Quad
+	if isJSONLD(r.Header.Get("Content-Type")) {
+		quads, err = ParseJSONLDToQuadList(bodyBytes)
+	} else if bytes.HasPrefix(bytes.TrimSpace(bodyBytes), []byte("{")) {
+		return api.writeTransaction(w, bodyBytes, opts)
+	} else {
+		quads, err = ParseJSONToQuadList(bodyBytes)
+	}
 	if err != nil {
 		return jsonResponse(w, 400, err)
 	}
-	api.handle.QuadWriter.AddQuadSet(quads)
-	fmt.Fprintf(w, "{\"result\": \"Successfully wrote %d quads.\"}", len(quads))
+	tx := graph.NewTransaction()
+	tx.Ignore = opts
+	for _, q := range quads {
+		tx.AddQuad(q)
+	}
+	err = api.handle.QuadWriter.ApplyTransaction(tx)
+	if err != nil {
+		return jsonResponse(w, writeErrorCode(err), err)
+	}
+	// Skipped quads can't be told apart, so they're counted too.
+	fmt.Fprintf(w, "{\"result\": \"Successfully wrote %d quads, counting any skipped.\"}", len(quads))
+	return 200
+}
+
+// writeTransaction applies a WriteRequest body as a single transaction.
+func (api *API) writeTransaction(w http.ResponseWriter, body []byte, opts graph.IgnoreOverride) int {
+	tx, err := ParseJSONToTransaction(body)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
+	tx.Ignore = opts
+	err = api.handle.QuadWriter.ApplyTransaction(tx)
+	if err != nil {
+		return jsonResponse(w, writeErrorCode(err), err)
+	}
+	var added, deleted int
+	for _, d := range tx.Deltas {
+		if d.Action == graph.Add {
+			added++
+		} else {
+			deleted++
+		}
+	}
+	fmt.Fprintf(w, "{\"result\": \"Successfully wrote %d quads and deleted %d quads, counting any skipped.\"}", added, deleted)
 	return 200
 }
 
@@ -64,6 +199,10 @@ func (api *API) ServeV1WriteNQuad(w http.ResponseWriter, r *http.Request, params
 	if api.config.ReadOnly {
 		return jsonResponse(w, 400, "Database is read-only.")
 	}
+	opts, err := ParseIgnoreOpts(r)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
 
 	formFile, _, err := r.FormFile("NQuadFile")
 	if err != nil {
@@ -84,26 +223,38 @@ func (api *API) ServeV1WriteNQuad(w http.ResponseWriter, r *http.Request, params
 	var (
 		n int
 
-		block = make([]quad.Quad, 0, blockSize)
+		tx = graph.NewTransaction()
 	)
+	write := func() int {
+		tx.Ignore = opts
+		err := api.handle.QuadWriter.ApplyTransaction(tx)
+		if err != nil {
+			return jsonResponse(w, writeErrorCode(err), fmt.Sprintf("Wrote %d quads before failing: %v", n, err))
+		}
+		n += len(tx.Deltas)
+		tx = graph.NewTransaction()
+		return 200
+	}
 	for {
 		t, err := dec.Unmarshal()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			panic("what can do this here?") // FIXME(kortschak)
+			return jsonResponse(w, 400, fmt.Sprintf("Wrote %d quads before failing: %v", n, err))
 		}
-		block = append(block, t)
-		n++
-		if len(block) == cap(block) {
-			api.handle.QuadWriter.AddQuadSet(block)
-			block = block[:0]
+		tx.AddQuad(t)
+		if int64(len(tx.Deltas)) == blockSize {
+			if code := write(); code != 200 {
+				return code
+			}
 		}
 	}
-	api.handle.QuadWriter.AddQuadSet(block)
+	if code := write(); code != 200 {
+		return code
+	}
 
-	fmt.Fprintf(w, "{\"result\": \"Successfully wrote %d quads.\"}", n)
+	fmt.Fprintf(w, "{\"result\": \"Successfully wrote %d quads, counting any skipped.\"}", n)
 
 	return 200
 }
@@ -112,6 +263,10 @@ func (api *API) ServeV1Delete(w http.ResponseWriter, r *http.Request, params htt
 	if api.config.ReadOnly {
 		return jsonResponse(w, 400, "Database is read-only.")
 	}
+	opts, err := ParseIgnoreOpts(r)
+	if err != nil {
+		return jsonResponse(w, 400, err)
+	}
 	bodyBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return jsonResponse(w, 400, err)
@@ -120,11 +275,15 @@ func (api *API) ServeV1Delete(w http.ResponseWriter, r *http.Request, params htt
 	if err != nil {
 		return jsonResponse(w, 400, err)
 	}
-	count := 0
+	tx := graph.NewTransaction()
+	tx.Ignore = opts
 	for _, q := range quads {
-		api.handle.QuadWriter.RemoveQuad(q)
-		count++
+		tx.RemoveQuad(q)
+	}
+	err = api.handle.QuadWriter.ApplyTransaction(tx)
+	if err != nil {
+		return jsonResponse(w, writeErrorCode(err), err)
 	}
-	fmt.Fprintf(w, "{\"result\": \"Successfully deleted %d quads.\"}", count)
+	fmt.Fprintf(w, "{\"result\": \"Successfully deleted %d quads, counting any skipped.\"}", len(quads))
 	return 200
 }
@@ -0,0 +1,160 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonld
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/google/cayley/quad"
+)
+
+// Encoder writes quads as a flattened JSON-LD document. As nodes don't say
+// whether they are IRIs or literals, objects which look like IRIs are written
+// as nodes, and the rest as literals.
+type Encoder struct {
+	w      io.Writer
+	graphs map[string]*graph
+	labels []string
+}
+
+// graph is the node objects of a graph, in the order their subjects came.
+type graph struct {
+	nodes map[string]map[string]interface{}
+	order []string
+}
+
+// NewEncoder returns a JSON-LD encoder that writes its document to the
+// provided io.Writer once it is closed.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, graphs: make(map[string]*graph)}
+}
+
+// Encode adds a quad to the document.
+func (enc *Encoder) Encode(q quad.Quad) error {
+	if !q.IsValid() {
+		return fmt.Errorf("jsonld: invalid quad %v", q)
+	}
+	g, ok := enc.graphs[q.Label]
+	if !ok {
+		g = &graph{nodes: make(map[string]map[string]interface{})}
+		enc.graphs[q.Label] = g
+		enc.labels = append(enc.labels, q.Label)
+	}
+	n := g.node(q.Subject)
+	if q.Predicate == rdfType && IsIRI(q.Object) {
+		types, _ := n["@type"].([]interface{})
+		n["@type"] = append(types, q.Object)
+		return nil
+	}
+	var o map[string]interface{}
+	if IsIRI(q.Object) {
+		o = map[string]interface{}{"@id": q.Object}
+	} else {
+		o = map[string]interface{}{"@value": q.Object}
+	}
+	values, _ := n[q.Predicate].([]interface{})
+	n[q.Predicate] = append(values, o)
+	return nil
+}
+
+func (g *graph) node(id string) map[string]interface{} {
+	n, ok := g.nodes[id]
+	if !ok {
+		n = map[string]interface{}{"@id": id}
+		g.nodes[id] = n
+		g.order = append(g.order, id)
+	}
+	return n
+}
+
+func (g *graph) list() []interface{} {
+	out := make([]interface{}, 0, len(g.order))
+	for _, id := range g.order {
+		out = append(out, g.nodes[id])
+	}
+	return out
+}
+
+// Close writes the document.
+func (enc *Encoder) Close() error {
+	def, ok := enc.graphs[""]
+	if !ok {
+		def = &graph{nodes: make(map[string]map[string]interface{})}
+	}
+	for _, label := range enc.labels {
+		if label == "" {
+			continue
+		}
+		// A named graph is a node of the default graph.
+		def.node(label)["@graph"] = enc.graphs[label].list()
+	}
+	b, err := json.MarshalIndent(map[string]interface{}{"@graph": def.list()}, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write(b)
+	return err
+}
+
+// Results returns the results of a query as a JSON-LD document, each a node
+// object. Their ids are their @ids, and their other keys are properties in
+// the vocabulary, unless they are IRIs already. Values which look like IRIs
+// are nodes, and the rest literals.
+func Results(results []interface{}, vocab string) (map[string]interface{}, error) {
+	nodes := make([]interface{}, 0, len(results))
+	for _, r := range results {
+		// Sessions return results of their own types, so take them as JSON.
+		b, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		if _, ok := v.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("jsonld: result is not an object: %s", b)
+		}
+		nodes = append(nodes, resultValue(v))
+	}
+	return map[string]interface{}{
+		"@context": map[string]interface{}{"@vocab": vocab, "id": "@id"},
+		"@graph":   nodes,
+	}, nil
+}
+
+func resultValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if k != "id" {
+				v[k] = resultValue(e)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, e := range v {
+			v[i] = resultValue(e)
+		}
+		return v
+	case string:
+		if IsIRI(v) {
+			return map[string]interface{}{"@id": v}
+		}
+	}
+	return v
+}
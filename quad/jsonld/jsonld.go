@@ -0,0 +1,516 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jsonld implements reading and writing quads as JSON-LD documents,
+// as defined by http://www.w3.org/TR/json-ld/.
+//
+// Documents may be expanded, compacted or flattened, with their contexts
+// given in the document; a context given by URL is an error, as the package
+// fetches nothing. Nodes are named as the cquads package names them: IRIs are
+// bare, and literals are their lexical form, without a datatype or language.
+package jsonld
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/google/cayley/quad"
+)
+
+const (
+	rdfType  = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+	rdfFirst = "http://www.w3.org/1999/02/22-rdf-syntax-ns#first"
+	rdfRest  = "http://www.w3.org/1999/02/22-rdf-syntax-ns#rest"
+	rdfNil   = "http://www.w3.org/1999/02/22-rdf-syntax-ns#nil"
+)
+
+// ErrRemoteContext is returned for a document with a context given by URL.
+var ErrRemoteContext = errors.New("jsonld: remote contexts are not supported")
+
+// Decoder implements JSON-LD document parsing. A document is read whole, the
+// first time a quad is asked for.
+type Decoder struct {
+	r     io.Reader
+	read  bool
+	quads []quad.Quad
+	err   error
+
+	blanks map[string]string
+	n      int
+}
+
+// NewDecoder returns a JSON-LD decoder that takes its input from the provided
+// io.Reader.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, blanks: make(map[string]string)}
+}
+
+// Unmarshal returns the next quad of the document, or io.EOF once there are
+// no more.
+func (dec *Decoder) Unmarshal() (quad.Quad, error) {
+	if !dec.read {
+		dec.read = true
+		dec.err = dec.decode()
+	}
+	if dec.err != nil {
+		return quad.Quad{}, dec.err
+	}
+	if len(dec.quads) == 0 {
+		return quad.Quad{}, io.EOF
+	}
+	q := dec.quads[0]
+	dec.quads = dec.quads[1:]
+	return q, nil
+}
+
+func (dec *Decoder) decode() error {
+	d := json.NewDecoder(dec.r)
+	// Numbers are literals, so keep them as they were written.
+	d.UseNumber()
+	var doc interface{}
+	if err := d.Decode(&doc); err != nil {
+		return fmt.Errorf("jsonld: %v", err)
+	}
+	ctx := &context{terms: make(map[string]*term)}
+	switch t := doc.(type) {
+	case []interface{}:
+		for _, v := range t {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("jsonld: top level value is not an object: %v", v)
+			}
+			if _, err := dec.node(ctx, obj, ""); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if _, err := dec.node(ctx, t, ""); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("jsonld: document is not an object or an array: %v", doc)
+	}
+	return nil
+}
+
+// blank returns the name of a blank node. Those of the document are renamed,
+// so that they can't clash with those the decoder makes up.
+func (dec *Decoder) blank(name string) string {
+	if b, ok := dec.blanks[name]; ok && name != "" {
+		return b
+	}
+	b := fmt.Sprintf("_:b%d", dec.n)
+	dec.n++
+	if name != "" {
+		dec.blanks[name] = b
+	}
+	return b
+}
+
+func (dec *Decoder) add(s, p, o, label string) {
+	dec.quads = append(dec.quads, quad.Quad{Subject: s, Predicate: p, Object: o, Label: label})
+}
+
+// node adds the quads of a node object to a graph, and returns the node's
+// name.
+func (dec *Decoder) node(ctx *context, obj map[string]interface{}, graph string) (string, error) {
+	if c, ok := obj["@context"]; ok {
+		var err error
+		ctx, err = ctx.with(c)
+		if err != nil {
+			return "", err
+		}
+	}
+	obj = ctx.unalias(obj)
+	var name string
+	if v, ok := obj["@id"]; ok {
+		id, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("jsonld: @id is not a string: %v", v)
+		}
+		name = dec.iri(ctx, id, false)
+	} else {
+		name = dec.blank("")
+	}
+
+	if v, ok := obj["@graph"]; ok {
+		// A graph with a name holds its nodes in that name, and one without
+		// is the graph it's in.
+		label := graph
+		if _, ok := obj["@id"]; ok {
+			label = name
+		}
+		for _, v := range asList(v) {
+			n, ok := v.(map[string]interface{})
+			if !ok {
+				return "", fmt.Errorf("jsonld: @graph holds a value which is not a node: %v", v)
+			}
+			if _, err := dec.node(ctx, n, label); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if v, ok := obj["@type"]; ok {
+		for _, v := range asList(v) {
+			typ, ok := v.(string)
+			if !ok {
+				return "", fmt.Errorf("jsonld: @type is not a string: %v", v)
+			}
+			dec.add(name, rdfType, dec.iri(ctx, typ, true), graph)
+		}
+	}
+
+	if v, ok := obj["@reverse"]; ok {
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("jsonld: @reverse is not an object: %v", v)
+		}
+		if err := dec.properties(ctx, name, props, graph, true); err != nil {
+			return "", err
+		}
+	}
+
+	return name, dec.properties(ctx, name, obj, graph, false)
+}
+
+// properties adds the quads of the properties of a node, or those pointing to
+// it if they are reversed.
+func (dec *Decoder) properties(ctx *context, name string, props map[string]interface{}, graph string, reverse bool) error {
+	for key, v := range props {
+		if strings.HasPrefix(key, "@") {
+			continue
+		}
+		pred, ok := ctx.expand(key, true)
+		if !ok || strings.HasPrefix(pred, "@") {
+			// Properties which aren't IRIs aren't data.
+			continue
+		}
+		t := ctx.terms[key]
+		if t == nil {
+			t = &term{}
+		}
+		objs, err := dec.values(ctx, t, v, graph)
+		if err != nil {
+			return err
+		}
+		for _, o := range objs {
+			if reverse != t.reverse {
+				dec.add(o, pred, name, graph)
+			} else {
+				dec.add(name, pred, o, graph)
+			}
+		}
+	}
+	return nil
+}
+
+// values returns the names of the values of a property, adding the quads of
+// those which are nodes.
+func (dec *Decoder) values(ctx *context, t *term, v interface{}, graph string) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		if t.container == "@list" {
+			l, err := dec.list(ctx, t, v, graph)
+			return []string{l}, err
+		}
+		var out []string
+		for _, v := range v {
+			names, err := dec.values(ctx, t, v, graph)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, names...)
+		}
+		return out, nil
+	case map[string]interface{}:
+		v = ctx.unalias(v)
+		if val, ok := v["@value"]; ok {
+			if val == nil {
+				return nil, nil
+			}
+			return []string{literal(val)}, nil
+		}
+		if l, ok := v["@list"]; ok {
+			l, err := dec.list(ctx, t, asList(l), graph)
+			return []string{l}, err
+		}
+		if s, ok := v["@set"]; ok {
+			return dec.values(ctx, &term{typ: t.typ}, asList(s), graph)
+		}
+		name, err := dec.node(ctx, v, graph)
+		return []string{name}, err
+	case string:
+		switch t.typ {
+		case "@id":
+			return []string{dec.iri(ctx, v, false)}, nil
+		case "@vocab":
+			return []string{dec.iri(ctx, v, true)}, nil
+		}
+		return []string{v}, nil
+	}
+	return []string{literal(v)}, nil
+}
+
+// list adds the quads of a list, and returns the name of its head.
+func (dec *Decoder) list(ctx *context, t *term, items []interface{}, graph string) (string, error) {
+	item := &term{typ: t.typ}
+	head := rdfNil
+	var last string
+	for _, v := range items {
+		names, err := dec.values(ctx, item, v, graph)
+		if err != nil {
+			return "", err
+		}
+		for _, o := range names {
+			b := dec.blank("")
+			if last == "" {
+				head = b
+			} else {
+				dec.add(last, rdfRest, b, graph)
+			}
+			dec.add(b, rdfFirst, o, graph)
+			last = b
+		}
+	}
+	if last != "" {
+		dec.add(last, rdfRest, rdfNil, graph)
+	}
+	return head, nil
+}
+
+// iri returns the name of a node given by an IRI, which may be a blank node.
+func (dec *Decoder) iri(ctx *context, s string, vocab bool) string {
+	if strings.HasPrefix(s, "_:") {
+		return dec.blank(s)
+	}
+	iri, ok := ctx.expand(s, vocab)
+	if !ok {
+		return s
+	}
+	return iri
+}
+
+func asList(v interface{}) []interface{} {
+	if l, ok := v.([]interface{}); ok {
+		return l
+	}
+	return []interface{}{v}
+}
+
+func literal(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// A term is the definition of a name in a context.
+type term struct {
+	id        string
+	typ       string
+	container string
+	reverse   bool
+	null      bool
+}
+
+type context struct {
+	terms map[string]*term
+	vocab string
+	base  string
+}
+
+// with returns the context made by applying a local context to c.
+func (c *context) with(local interface{}) (*context, error) {
+	out := &context{terms: make(map[string]*term, len(c.terms)), vocab: c.vocab, base: c.base}
+	for k, t := range c.terms {
+		out.terms[k] = t
+	}
+	for _, v := range asList(local) {
+		switch v := v.(type) {
+		case nil:
+			out = &context{terms: make(map[string]*term)}
+		case string:
+			return nil, ErrRemoteContext
+		case map[string]interface{}:
+			if err := out.define(v); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("jsonld: invalid context: %v", v)
+		}
+	}
+	return out, nil
+}
+
+// define adds the definitions of a context object. Terms may be defined by
+// others from the same object, in any order.
+func (c *context) define(defs map[string]interface{}) error {
+	if v, ok := defs["@base"]; ok {
+		base, _ := v.(string)
+		c.base = base
+	}
+	if v, ok := defs["@vocab"]; ok {
+		vocab, _ := v.(string)
+		c.vocab = vocab
+	}
+	pending := make(map[string]bool)
+	for k := range defs {
+		if !strings.HasPrefix(k, "@") {
+			pending[k] = true
+		}
+	}
+	var def func(string) error
+	def = func(k string) error {
+		if !pending[k] {
+			return nil
+		}
+		delete(pending, k)
+		// Define the terms this one is made of first.
+		expand := func(s string) (string, error) {
+			if i := strings.Index(s, ":"); i > 0 {
+				if err := def(s[:i]); err != nil {
+					return "", err
+				}
+			} else if err := def(s); err != nil {
+				return "", err
+			}
+			iri, _ := c.expand(s, true)
+			return iri, nil
+		}
+		t := &term{}
+		switch v := defs[k].(type) {
+		case nil:
+			t.null = true
+		case string:
+			id, err := expand(v)
+			if err != nil {
+				return err
+			}
+			t.id = id
+		case map[string]interface{}:
+			id, _ := v["@id"].(string)
+			if r, ok := v["@reverse"].(string); ok {
+				id, t.reverse = r, true
+			}
+			if id == "" {
+				id = k
+			}
+			var err error
+			if t.id, err = expand(id); err != nil {
+				return err
+			}
+			if typ, ok := v["@type"].(string); ok {
+				if typ == "@id" || typ == "@vocab" {
+					t.typ = typ
+				} else if t.typ, err = expand(typ); err != nil {
+					return err
+				}
+			}
+			t.container, _ = v["@container"].(string)
+		default:
+			return fmt.Errorf("jsonld: invalid definition of %q: %v", k, v)
+		}
+		c.terms[k] = t
+		return nil
+	}
+	for k := range defs {
+		if err := def(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unalias returns an object with the keys which are aliases of keywords, such
+// as "id" for "@id", given as the keywords.
+func (c *context) unalias(obj map[string]interface{}) map[string]interface{} {
+	var out map[string]interface{}
+	for k, v := range obj {
+		t, ok := c.terms[k]
+		if !ok || !strings.HasPrefix(t.id, "@") {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]interface{}, len(obj))
+			for k, v := range obj {
+				out[k] = v
+			}
+		}
+		delete(out, k)
+		out[t.id] = v
+	}
+	if out == nil {
+		return obj
+	}
+	return out
+}
+
+var scheme = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.\-]*:`)
+
+// expand returns the IRI a name stands for, which is against the vocabulary
+// if it's a property or a type. It returns false for a name which only a
+// property could be that isn't one.
+func (c *context) expand(s string, vocab bool) (string, bool) {
+	if strings.HasPrefix(s, "@") {
+		return s, true
+	}
+	if vocab {
+		if t, ok := c.terms[s]; ok {
+			if t.null {
+				return "", false
+			}
+			return t.id, true
+		}
+	}
+	if i := strings.Index(s, ":"); i > 0 {
+		prefix, suffix := s[:i], s[i+1:]
+		if prefix == "_" || strings.HasPrefix(suffix, "//") {
+			return s, true
+		}
+		if t, ok := c.terms[prefix]; ok && !t.null {
+			return t.id + suffix, true
+		}
+		return s, true
+	}
+	if vocab && c.vocab != "" {
+		return c.vocab + s, true
+	}
+	if !vocab && c.base != "" {
+		base, err := url.Parse(c.base)
+		if err == nil {
+			if ref, err := url.Parse(s); err == nil {
+				return base.ResolveReference(ref).String(), true
+			}
+		}
+	}
+	return s, !vocab
+}
+
+// IsIRI returns whether the name of a node looks like an IRI or a blank node,
+// rather than a literal.
+func IsIRI(s string) bool {
+	return strings.HasPrefix(s, "_:") ||
+		(scheme.MatchString(s) && !strings.ContainsAny(s, " \t\n\"<>{}|\\^`"))
+}
@@ -0,0 +1,243 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonld
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/cayley/quad"
+)
+
+var testDocuments = []struct {
+	message string
+	input   string
+	expect  []quad.Quad
+	err     bool
+}{
+	{
+		message: "parse a compacted document",
+		input: `{
+			"@context": {
+				"ex": "http://example.org/",
+				"name": "ex:name",
+				"knows": {"@id": "ex:knows", "@type": "@id"}
+			},
+			"@id": "ex:alice",
+			"@type": "ex:Person",
+			"name": "Alice",
+			"knows": "ex:bob",
+			"unmapped": "dropped"
+		}`,
+		expect: []quad.Quad{
+			{"http://example.org/alice", "http://example.org/knows", "http://example.org/bob", ""},
+			{"http://example.org/alice", "http://example.org/name", "Alice", ""},
+			{"http://example.org/alice", rdfType, "http://example.org/Person", ""},
+		},
+	},
+	{
+		message: "parse an expanded document",
+		input: `[{
+			"@id": "http://example.org/alice",
+			"http://example.org/age": [{"@value": 30}],
+			"http://example.org/knows": [{"@id": "http://example.org/bob"}]
+		}]`,
+		expect: []quad.Quad{
+			{"http://example.org/alice", "http://example.org/age", "30", ""},
+			{"http://example.org/alice", "http://example.org/knows", "http://example.org/bob", ""},
+		},
+	},
+	{
+		message: "parse a vocabulary and a base",
+		input: `{
+			"@context": {"@vocab": "http://schema.org/", "@base": "http://example.org/people/"},
+			"@id": "alice",
+			"name": "Alice",
+			"member": true
+		}`,
+		expect: []quad.Quad{
+			{"http://example.org/people/alice", "http://schema.org/member", "true", ""},
+			{"http://example.org/people/alice", "http://schema.org/name", "Alice", ""},
+		},
+	},
+	{
+		message: "parse named and default graphs",
+		input: `{
+			"@context": {"@vocab": "http://example.org/"},
+			"@graph": [
+				{"@id": "http://example.org/g", "@graph": [{"@id": "http://example.org/a", "p": "in g"}]},
+				{"@id": "http://example.org/b", "p": "in default"}
+			]
+		}`,
+		expect: []quad.Quad{
+			{"http://example.org/a", "http://example.org/p", "in g", "http://example.org/g"},
+			{"http://example.org/b", "http://example.org/p", "in default", ""},
+		},
+	},
+	{
+		message: "parse nested nodes and renamed blank nodes",
+		input: `{
+			"@context": {"@vocab": "http://example.org/"},
+			"@id": "_:b0",
+			"knows": {"name": "Bob"},
+			"likes": {"@id": "_:b0"}
+		}`,
+		expect: []quad.Quad{
+			{"_:b0", "http://example.org/knows", "_:b1", ""},
+			{"_:b0", "http://example.org/likes", "_:b0", ""},
+			{"_:b1", "http://example.org/name", "Bob", ""},
+		},
+	},
+	{
+		message: "parse a list and a reverse property",
+		input: `{
+			"@context": {
+				"@vocab": "http://example.org/",
+				"parent": {"@reverse": "http://example.org/child"}
+			},
+			"@id": "http://example.org/a",
+			"steps": {"@list": ["one", "two"]},
+			"parent": {"@id": "http://example.org/p"}
+		}`,
+		expect: []quad.Quad{
+			{"_:b0", rdfFirst, "one", ""},
+			{"_:b0", rdfRest, "_:b1", ""},
+			{"_:b1", rdfFirst, "two", ""},
+			{"_:b1", rdfRest, rdfNil, ""},
+			{"http://example.org/a", "http://example.org/steps", "_:b0", ""},
+			{"http://example.org/p", "http://example.org/child", "http://example.org/a", ""},
+		},
+	},
+	{
+		message: "reject a remote context",
+		input:   `{"@context": "http://schema.org/", "name": "Alice"}`,
+		err:     true,
+	},
+	{
+		message: "reject a document which is not an object",
+		input:   `"Alice"`,
+		err:     true,
+	},
+}
+
+func decodeAll(r io.Reader) ([]quad.Quad, error) {
+	dec := NewDecoder(r)
+	var got []quad.Quad
+	for {
+		q, err := dec.Unmarshal()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		got = append(got, q)
+	}
+	sort.Sort(ordered(got))
+	return got, nil
+}
+
+func TestDecoder(t *testing.T) {
+	for _, test := range testDocuments {
+		got, err := decodeAll(strings.NewReader(test.input))
+		if test.err {
+			if err == nil {
+				t.Errorf("Failed to %s, got no error", test.message)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to %s, unexpected error: %v", test.message, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("Failed to %s, got:%v expect:%v", test.message, got, test.expect)
+		}
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	quads := []quad.Quad{
+		{"http://example.org/a", "http://example.org/knows", "http://example.org/b", ""},
+		{"http://example.org/a", "http://example.org/name", "Alice", ""},
+		{"http://example.org/a", rdfType, "http://example.org/Person", ""},
+		{"http://example.org/b", "http://example.org/name", "Bob", "http://example.org/g"},
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, q := range quads {
+		if err := enc.Encode(q); err != nil {
+			t.Fatalf("Unexpected error encoding %v: %v", q, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Unexpected error writing the document: %v", err)
+	}
+	got, err := decodeAll(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error reading the document back: %v", err)
+	}
+	if !reflect.DeepEqual(got, quads) {
+		t.Errorf("Unexpected quads read back, got:%v expect:%v", got, quads)
+	}
+}
+
+func TestResults(t *testing.T) {
+	doc, err := Results([]interface{}{
+		map[string]string{"id": "http://example.org/a", "name": "Alice", "friend": "http://example.org/b"},
+	}, "http://localhost/api/v1/query/gremlin#")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b, _ := json.Marshal(doc)
+	got, err := decodeAll(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("Unexpected error reading the results: %v", err)
+	}
+	expect := []quad.Quad{
+		{"http://example.org/a", "http://localhost/api/v1/query/gremlin#friend", "http://example.org/b", ""},
+		{"http://example.org/a", "http://localhost/api/v1/query/gremlin#name", "Alice", ""},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected results, got:%v expect:%v", got, expect)
+	}
+
+	if _, err := Results([]interface{}{true}, ""); err == nil {
+		t.Errorf("Expected an error for results which are not objects")
+	}
+}
+
+type ordered []quad.Quad
+
+func (o ordered) Len() int { return len(o) }
+func (o ordered) Less(i, j int) bool {
+	a, b := o[i], o[j]
+	if a.Subject != b.Subject {
+		return a.Subject < b.Subject
+	}
+	if a.Predicate != b.Predicate {
+		return a.Predicate < b.Predicate
+	}
+	if a.Object != b.Object {
+		return a.Object < b.Object
+	}
+	return a.Label < b.Label
+}
+func (o ordered) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
@@ -0,0 +1,311 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datalog
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/cayley/graph"
+	_ "github.com/google/cayley/graph/memstore"
+	"github.com/google/cayley/quad"
+	"github.com/google/cayley/query"
+	_ "github.com/google/cayley/writer"
+)
+
+// This is a simple test graph.
+//
+//    +---+                        +---+
+//    | A |-------               ->| F |<--
+//    +---+       \------>+---+-/  +---+   \--+---+
+//                 ------>|#B#|      |        | E |
+//    +---+-------/      >+---+      |        +---+
+//    | C |             /            v
+//    +---+           -/           +---+
+//      ----    +---+/             |#G#|
+//          \-->|#D#|------------->+---+
+//              +---+
+//
+var simpleGraph = []quad.Quad{
+	{"A", "follows", "B", ""},
+	{"C", "follows", "B", ""},
+	{"C", "follows", "D", ""},
+	{"D", "follows", "B", ""},
+	{"B", "follows", "F", ""},
+	{"F", "follows", "G", ""},
+	{"D", "follows", "G", ""},
+	{"E", "follows", "F", ""},
+	{"B", "status", "cool", "status_graph"},
+	{"D", "status", "cool", "status_graph"},
+	{"G", "status", "cool", "status_graph"},
+}
+
+func makeTestSession(data []quad.Quad) *Session {
+	qs, _ := graph.NewQuadStore("memstore", "", nil)
+	w, _ := graph.NewQuadWriter("single", qs, nil)
+	for _, t := range data {
+		w.AddQuad(t)
+	}
+	return NewSession(qs)
+}
+
+const reachRules = `
+	edge(X, Y) :- quad(X, "follows", Y).
+	reach(X, Y) :- edge(X, Y).
+	reach(X, Y) :- edge(X, Z), reach(Z, Y).
+`
+
+var testQueries = []struct {
+	message string
+	query   string
+	expect  string
+}{
+	{
+		message: "query quads directly",
+		query:   `?- quad(X, "follows", "B").`,
+		expect:  `[{"X": "A"}, {"X": "C"}, {"X": "D"}]`,
+	},
+	{
+		message: "query quads by label",
+		query:   `?- quad(X, _, _, "status_graph").`,
+		expect:  `[{"X": "B"}, {"X": "D"}, {"X": "G"}]`,
+	},
+	{
+		message: "query a simple rule",
+		query: `
+			cool_follower(X) :- quad(X, "follows", Y), quad(Y, "status", "cool").
+			?- cool_follower(X).
+		`,
+		expect: `[{"X": "A"}, {"X": "C"}, {"X": "D"}, {"X": "F"}]`,
+	},
+	{
+		message: "compute reachability with recursion",
+		query:   reachRules + `?- reach("C", Y).`,
+		expect:  `[{"Y": "B"}, {"Y": "D"}, {"Y": "F"}, {"Y": "G"}]`,
+	},
+	{
+		message: "compute reverse reachability with recursion",
+		query:   reachRules + `reach(X, "F")?`,
+		expect:  `[{"X": "A"}, {"X": "B"}, {"X": "C"}, {"X": "D"}, {"X": "E"}]`,
+	},
+	{
+		message: "mix facts and quads",
+		query: `
+			edge(X, Y) :- quad(X, "follows", Y).
+			edge("G", "A").
+			reach(X, Y) :- edge(X, Y).
+			reach(X, Y) :- reach(X, Z), edge(Z, Y).
+			?- reach("G", "C").
+		`,
+		expect: `[false]`,
+	},
+	{
+		message: "find cycles through facts",
+		query: `
+			edge(X, Y) :- quad(X, "follows", Y).
+			edge("G", "A").
+			reach(X, Y) :- edge(X, Y).
+			reach(X, Y) :- reach(X, Z), edge(Z, Y).
+			cyclic(X) :- reach(X, X).
+			?- cyclic(X).
+		`,
+		expect: `[{"X": "A"}, {"X": "B"}, {"X": "F"}, {"X": "G"}]`,
+	},
+	{
+		message: "use comparisons",
+		query: `
+			cofollow(X, Y) :- quad(X, "follows", Z), quad(Y, "follows", Z), X != Y.
+			?- cofollow("A", Y).
+		`,
+		expect: `[{"Y": "C"}, {"Y": "D"}]`,
+	},
+	{
+		message: "repeat a variable in an atom",
+		query: `
+			edge(X, Y) :- quad(X, "follows", Y).
+			edge("G", "G").
+			loop(X) :- edge(X, X).
+			?- loop(X).
+		`,
+		expect: `[{"X": "G"}]`,
+	},
+	{
+		message: "answer a ground query",
+		query:   reachRules + `?- reach("A", "G").`,
+		expect:  `[true]`,
+	},
+}
+
+func runQuery(s *Session, query string) ([]interface{}, error) {
+	return runQueryContext(context.Background(), s, query)
+}
+
+func runQueryContext(ctx context.Context, s *Session, query string) ([]interface{}, error) {
+	c := make(chan interface{}, 5)
+	go s.ExecInput(ctx, query, c, -1)
+	for result := range c {
+		s.BuildJSON(result)
+	}
+	defer s.ClearJSON()
+	return s.GetJSON()
+}
+
+// byString sorts results so that tests do not depend on evaluation order.
+type byString []interface{}
+
+func (s byString) Len() int      { return len(s) }
+func (s byString) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byString) Less(i, j int) bool {
+	a, _ := json.Marshal(s[i])
+	b, _ := json.Marshal(s[j])
+	return string(a) < string(b)
+}
+
+func TestDatalog(t *testing.T) {
+	for _, test := range testQueries {
+		got, err := runQuery(makeTestSession(simpleGraph), test.query)
+		if err != nil {
+			t.Errorf("Failed to %s: unexpected error: %v", test.message, err)
+			continue
+		}
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("unexpected JSON marshal error: %v", err)
+		}
+		var norm, expect []interface{}
+		json.Unmarshal(b, &norm)
+		json.Unmarshal([]byte(test.expect), &expect)
+		sort.Sort(byString(norm))
+		sort.Sort(byString(expect))
+		if !reflect.DeepEqual(norm, expect) {
+			t.Errorf("Failed to %s, got: %s expected: %s", test.message, b, test.expect)
+		}
+	}
+}
+
+func TestSessionKeepsRules(t *testing.T) {
+	s := makeTestSession(simpleGraph)
+	for _, line := range []string{
+		`edge(X, Y) :- quad(X, "follows", Y).`,
+		`reach(X, Y) :- edge(X, Y).`,
+		`reach(X, Y) :- edge(X, Z), reach(Z, Y).`,
+	} {
+		if _, err := runQuery(s, line); err != nil {
+			t.Fatalf("unexpected error defining %q: %v", line, err)
+		}
+	}
+	got, err := runQuery(s, `?- reach("E", Y).`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("Unexpected results, got:%v expect two", got)
+	}
+
+	// A bad statement should leave the session as it was.
+	if _, err := runQuery(s, `ok(X) :- edge(X, Y). bad(X, Y) :- edge(X, X).`); err == nil {
+		t.Errorf("Expected an error for an unsafe rule")
+	}
+	if _, err := runQuery(s, `?- ok(X).`); err == nil {
+		t.Errorf("Expected rules from a failed statement to be dropped")
+	}
+}
+
+func TestKill(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := runQueryContext(ctx, makeTestSession(simpleGraph), reachRules+`?- reach(X, Y).`)
+	if err != query.ErrKilled {
+		t.Errorf("Unexpected error for a canceled query, got:%v expect:%v", err, query.ErrKilled)
+	}
+}
+
+var errorQueries = []struct {
+	message string
+	query   string
+}{
+	{
+		message: "reject unsafe head variables",
+		query:   `p(X, Y) :- quad(X, "follows", Z).`,
+	},
+	{
+		message: "reject unsafe comparisons",
+		query:   `p(X) :- quad(X, "follows", Z), X != Y.`,
+	},
+	{
+		message: "reject non-ground facts",
+		query:   `p(X).`,
+	},
+	{
+		message: "reject inconsistent arity",
+		query:   `p("a"). p("a", "b").`,
+	},
+	{
+		message: "reject redefining quad",
+		query:   `quad("a", "b", "c").`,
+	},
+	{
+		message: "reject undefined predicates",
+		query:   `?- nothing(X).`,
+	},
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range errorQueries {
+		if _, err := runQuery(makeTestSession(simpleGraph), test.query); err == nil {
+			t.Errorf("Failed to %s", test.message)
+		}
+	}
+}
+
+var parseTests = []struct {
+	message string
+	query   string
+	expect  query.ParseResult
+}{
+	{
+		message: "parse a rule",
+		query:   `edge(X, Y) :- quad(X, "follows", Y). % trailing comment`,
+		expect:  query.Parsed,
+	},
+	{
+		message: "ask for more on an open rule",
+		query:   `edge(X, Y) :- quad(X, "follows", Y),`,
+		expect:  query.ParseMore,
+	},
+	{
+		message: "ask for more on a missing period",
+		query:   `edge(X, Y) :- quad(X, "follows", Y)`,
+		expect:  query.ParseMore,
+	},
+	{
+		message: "fail on bad syntax",
+		query:   `edge(X, Y) :- ;`,
+		expect:  query.ParseFail,
+	},
+}
+
+func TestInputParses(t *testing.T) {
+	s := NewSession(nil)
+	for _, test := range parseTests {
+		got, _ := s.InputParses(test.query)
+		if got != test.expect {
+			t.Errorf("Failed to %s, got: %v expected: %v", test.message, got, test.expect)
+		}
+	}
+}
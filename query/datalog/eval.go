@@ -0,0 +1,437 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datalog
+
+// Bottom-up, semi-naive evaluation of Datalog rules.
+//
+// The only extensional predicate is quad/3 (or quad/4, with the label),
+// which is read straight from the QuadStore through its iterators. Every
+// other predicate is computed from the rules into an in-memory relation.
+// Each round joins one body atom at a time against only the tuples derived
+// in the previous round (the delta), so that work is not repeated for old
+// tuples, and evaluation stops when a round derives nothing new.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+)
+
+const quadPred = "quad"
+
+type tuple []string
+
+func (t tuple) key() string {
+	return strings.Join(t, "\x00")
+}
+
+// relation is a set of tuples, indexed on demand by column.
+type relation struct {
+	tuples []tuple
+	set    map[string]bool
+	index  map[int]map[string][]int
+}
+
+func newRelation() *relation {
+	return &relation{
+		set:   make(map[string]bool),
+		index: make(map[int]map[string][]int),
+	}
+}
+
+// add adds t to the relation, reporting whether it was new.
+func (r *relation) add(t tuple) bool {
+	k := t.key()
+	if r.set[k] {
+		return false
+	}
+	r.set[k] = true
+	r.tuples = append(r.tuples, t)
+	for col, idx := range r.index {
+		idx[t[col]] = append(idx[t[col]], len(r.tuples)-1)
+	}
+	return true
+}
+
+func (r *relation) has(t tuple) bool {
+	return r.set[t.key()]
+}
+
+// lookup returns the positions of the tuples whose column col is val.
+func (r *relation) lookup(col int, val string) []int {
+	idx, ok := r.index[col]
+	if !ok {
+		idx = make(map[string][]int)
+		for i, t := range r.tuples {
+			idx[t[col]] = append(idx[t[col]], i)
+		}
+		r.index[col] = idx
+	}
+	return idx[val]
+}
+
+type binding map[string]string
+
+func (b binding) value(t Term) (string, bool) {
+	if !t.IsVar() {
+		return t.Value, true
+	}
+	v, ok := b[t.Var]
+	return v, ok
+}
+
+func (b binding) key() string {
+	vars := make([]string, 0, len(b))
+	for v := range b {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	t := make(tuple, 0, 2*len(vars))
+	for _, v := range vars {
+		t = append(t, v, b[v])
+	}
+	return t.key()
+}
+
+// unify extends b with the bindings needed for args to match t, returning
+// nil if they cannot.
+func (b binding) unify(args []Term, t tuple) binding {
+	var out binding
+	for i, a := range args {
+		v, ok := b.value(a)
+		if !ok && out != nil {
+			v, ok = out[a.Var]
+		}
+		if ok {
+			if v != t[i] {
+				return nil
+			}
+			continue
+		}
+		if out == nil {
+			out = make(binding, len(b)+len(args))
+			for k, v := range b {
+				out[k] = v
+			}
+		}
+		out[a.Var] = t[i]
+	}
+	if out == nil {
+		return b
+	}
+	return out
+}
+
+// program is a checked set of rules.
+type program struct {
+	rules map[string][]Rule
+	arity map[string]int
+}
+
+func newProgram() *program {
+	return &program{
+		rules: make(map[string][]Rule),
+		arity: map[string]int{quadPred: -1},
+	}
+}
+
+// checkAtom makes sure that a predicate is always used with the same
+// number of arguments.
+func (p *program) checkAtom(a Atom) error {
+	if isBuiltin(a.Pred) {
+		return nil
+	}
+	if a.Pred == quadPred {
+		if len(a.Args) != 3 && len(a.Args) != 4 {
+			return fmt.Errorf("datalog: quad takes 3 or 4 arguments, not %d", len(a.Args))
+		}
+		return nil
+	}
+	n, ok := p.arity[a.Pred]
+	if ok && n != len(a.Args) {
+		return fmt.Errorf("datalog: %s used with %d arguments, expected %d", a.Pred, len(a.Args), n)
+	}
+	return nil
+}
+
+// add checks a rule and adds it to the program. Every variable in the head
+// and in comparisons must be bound by an atom in the body.
+func (p *program) add(r Rule) error {
+	if r.Head.Pred == quadPred {
+		return fmt.Errorf("datalog: cannot define %s; add quads to the store instead", quadPred)
+	}
+	for _, a := range append([]Atom{r.Head}, r.Body...) {
+		if err := p.checkAtom(a); err != nil {
+			return err
+		}
+	}
+	bound := make(map[string]bool)
+	var atoms, builtins []Atom
+	for _, a := range r.Body {
+		if isBuiltin(a.Pred) {
+			builtins = append(builtins, a)
+			continue
+		}
+		atoms = append(atoms, a)
+		for _, t := range a.Args {
+			if t.IsVar() {
+				bound[t.Var] = true
+			}
+		}
+	}
+	for _, a := range append([]Atom{r.Head}, builtins...) {
+		for _, t := range a.Args {
+			if t.IsVar() && !bound[t.Var] {
+				return fmt.Errorf("datalog: unsafe variable %s in %v", t.Var, r)
+			}
+		}
+	}
+	// Comparisons are checked once everything else is bound.
+	r.Body = append(atoms, builtins...)
+	p.arity[r.Head.Pred] = len(r.Head.Args)
+	p.rules[r.Head.Pred] = append(p.rules[r.Head.Pred], r)
+	return nil
+}
+
+// evaluator computes the relations needed to answer a query. It gives up,
+// leaving the relations incomplete, once kill is closed.
+type evaluator struct {
+	qs   graph.QuadStore
+	prog *program
+	full map[string]*relation
+	kill <-chan struct{}
+}
+
+// needed finds the predicates a query on pred depends on.
+func (p *program) needed(pred string) (map[string]bool, error) {
+	need := make(map[string]bool)
+	var visit func(string) error
+	visit = func(pred string) error {
+		if need[pred] || pred == quadPred || isBuiltin(pred) {
+			return nil
+		}
+		rules, ok := p.rules[pred]
+		if !ok {
+			return fmt.Errorf("datalog: undefined predicate %s", pred)
+		}
+		need[pred] = true
+		for _, r := range rules {
+			for _, a := range r.Body {
+				if err := visit(a.Pred); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return need, visit(pred)
+}
+
+func newEvaluator(qs graph.QuadStore, prog *program, pred string, kill <-chan struct{}) (*evaluator, error) {
+	need, err := prog.needed(pred)
+	if err != nil {
+		return nil, err
+	}
+	e := &evaluator{
+		qs:   qs,
+		prog: prog,
+		full: make(map[string]*relation),
+		kill: kill,
+	}
+	for p := range need {
+		e.full[p] = newRelation()
+	}
+	e.fixpoint()
+	return e, nil
+}
+
+func (e *evaluator) fixpoint() {
+	// The first round evaluates every rule against empty relations, which
+	// leaves the facts and the rules over quads alone.
+	delta := make(map[string]*relation)
+	for pred := range e.full {
+		delta[pred] = newRelation()
+	}
+	for pred := range e.full {
+		for _, r := range e.prog.rules[pred] {
+			e.fire(r, -1, nil, delta)
+		}
+	}
+	for {
+		for pred, rel := range delta {
+			for _, t := range rel.tuples {
+				e.full[pred].add(t)
+			}
+		}
+		next := make(map[string]*relation)
+		for pred := range e.full {
+			next[pred] = newRelation()
+		}
+		changed := false
+		for pred := range e.full {
+			for _, r := range e.prog.rules[pred] {
+				for i, a := range r.Body {
+					if d, ok := delta[a.Pred]; ok && len(d.tuples) > 0 {
+						e.fire(r, i, d, next)
+					}
+				}
+			}
+		}
+		for _, rel := range next {
+			if len(rel.tuples) > 0 {
+				changed = true
+			}
+		}
+		if !changed || graph.Killed(e.kill) {
+			return
+		}
+		delta = next
+	}
+}
+
+// fire evaluates a rule, reading the body atom at position i from delta and
+// the rest from the full relations, and adds new head tuples to out.
+func (e *evaluator) fire(r Rule, i int, delta *relation, out map[string]*relation) {
+	e.join(r.Body, func(j int) *relation {
+		if j == i {
+			return delta
+		}
+		return e.full[r.Body[j].Pred]
+	}, binding{}, func(b binding) bool {
+		t := make(tuple, len(r.Head.Args))
+		for k, a := range r.Head.Args {
+			t[k], _ = b.value(a)
+		}
+		if !e.full[r.Head.Pred].has(t) {
+			out[r.Head.Pred].add(t)
+		}
+		return true
+	})
+}
+
+// join finds every binding that satisfies the atoms, calling fn with each
+// until it returns false.
+func (e *evaluator) join(atoms []Atom, src func(int) *relation, b binding, fn func(binding) bool) bool {
+	return e.joinAt(atoms, 0, src, b, fn)
+}
+
+func (e *evaluator) joinAt(atoms []Atom, j int, src func(int) *relation, b binding, fn func(binding) bool) bool {
+	if graph.Killed(e.kill) {
+		return false
+	}
+	if j == len(atoms) {
+		return fn(b)
+	}
+	a := atoms[j]
+	next := func(b binding) bool {
+		return e.joinAt(atoms, j+1, src, b, fn)
+	}
+	switch {
+	case isBuiltin(a.Pred):
+		l, _ := b.value(a.Args[0])
+		r, _ := b.value(a.Args[1])
+		if (l == r) != (a.Pred == "=") {
+			return true
+		}
+		return next(b)
+	case a.Pred == quadPred:
+		return e.scanQuads(a.Args, b, func(t tuple) bool {
+			if nb := b.unify(a.Args, t); nb != nil {
+				return next(nb)
+			}
+			return true
+		})
+	}
+	rel := src(j)
+	for col, t := range a.Args {
+		if v, ok := b.value(t); ok {
+			for _, k := range rel.lookup(col, v) {
+				if nb := b.unify(a.Args, rel.tuples[k]); nb != nil && !next(nb) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	for _, t := range rel.tuples {
+		if nb := b.unify(a.Args, t); nb != nil && !next(nb) {
+			return false
+		}
+	}
+	return true
+}
+
+var quadDirs = []quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label}
+
+// scanQuads calls fn with every quad in the store matching the bound
+// arguments, as a tuple, until fn returns false.
+func (e *evaluator) scanQuads(args []Term, b binding, fn func(tuple) bool) bool {
+	var its []graph.Iterator
+	for i, a := range args {
+		if v, ok := b.value(a); ok {
+			its = append(its, e.qs.QuadIterator(quadDirs[i], e.qs.ValueOf(v)))
+		}
+	}
+	var it graph.Iterator
+	switch len(its) {
+	case 0:
+		it = e.qs.QuadsAllIterator()
+	case 1:
+		it = its[0]
+	default:
+		and := iterator.NewAnd()
+		for _, sub := range its {
+			and.AddSubIterator(sub)
+		}
+		it = and
+	}
+	if newIt, changed := it.Optimize(); changed {
+		it = newIt
+	}
+	graph.SetKill(it, e.kill)
+	defer it.Close()
+	for graph.Next(it) {
+		if graph.Killed(e.kill) {
+			return false
+		}
+		q := e.qs.Quad(it.Result())
+		t := tuple{q.Subject, q.Predicate, q.Object, q.Label}[:len(args)]
+		if !fn(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// query returns the distinct bindings that satisfy a.
+func (e *evaluator) query(a Atom) []binding {
+	var out []binding
+	seen := make(map[string]bool)
+	e.join([]Atom{a}, func(int) *relation {
+		return e.full[a.Pred]
+	}, binding{}, func(b binding) bool {
+		k := b.key()
+		if !seen[k] {
+			seen[k] = true
+			out = append(out, b)
+		}
+		return true
+	})
+	return out
+}
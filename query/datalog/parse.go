@@ -0,0 +1,322 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datalog
+
+// Parses Datalog programs. A program is a sequence of statements:
+//
+//   parent("alice", "bob").                        % a fact
+//   edge(X, Y) :- quad(X, "follows", Y).           % a rule
+//   reach(X, Y) :- edge(X, Z), reach(Z, Y), X != Y.
+//   ?- reach("alice", Y).                          % a query
+//
+// Variables start with an upper case letter or an underscore; a lone '_'
+// is a fresh variable every time it occurs. Constants are quoted strings,
+// numbers or identifiers starting with a lower case letter. '%' starts a
+// comment that runs to the end of the line.
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// errIncomplete is returned when the input ends in the middle of a
+// statement, so that the REPL can ask for more.
+var errIncomplete = errors.New("datalog: incomplete statement")
+
+// Term is an argument to an atom: a variable or a constant.
+type Term struct {
+	Var   string
+	Value string
+}
+
+func (t Term) IsVar() bool { return t.Var != "" }
+
+func (t Term) String() string {
+	if t.IsVar() {
+		return t.Var
+	}
+	return strconv.Quote(t.Value)
+}
+
+// Atom is a predicate applied to arguments. The built-in comparisons are
+// atoms with a Pred of "=" or "!=" and two arguments.
+type Atom struct {
+	Pred string
+	Args []Term
+}
+
+func (a Atom) String() string {
+	if isBuiltin(a.Pred) {
+		return fmt.Sprintf("%v %s %v", a.Args[0], a.Pred, a.Args[1])
+	}
+	args := make([]string, len(a.Args))
+	for i, t := range a.Args {
+		args[i] = t.String()
+	}
+	return fmt.Sprintf("%s(%s)", a.Pred, strings.Join(args, ", "))
+}
+
+func isBuiltin(pred string) bool {
+	return pred == "=" || pred == "!="
+}
+
+// Rule is a Horn clause. Facts are rules with an empty body.
+type Rule struct {
+	Head Atom
+	Body []Atom
+}
+
+func (r Rule) String() string {
+	if len(r.Body) == 0 {
+		return r.Head.String() + "."
+	}
+	body := make([]string, len(r.Body))
+	for i, a := range r.Body {
+		body[i] = a.String()
+	}
+	return fmt.Sprintf("%v :- %s.", r.Head, strings.Join(body, ", "))
+}
+
+// Program is the result of parsing some input.
+type Program struct {
+	Rules   []Rule
+	Queries []Atom
+}
+
+type parser struct {
+	input []rune
+	pos   int
+	anon  int
+}
+
+// Parse parses a Datalog program.
+func Parse(input string) (*Program, error) {
+	p := &parser{input: []rune(input)}
+	prog := &Program{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return prog, nil
+		}
+		if p.consume("?-") {
+			a, err := p.parseAtom()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("."); err != nil {
+				return nil, err
+			}
+			prog.Queries = append(prog.Queries, a)
+			continue
+		}
+		head, err := p.parseAtom()
+		if err != nil {
+			return nil, err
+		}
+		if isBuiltin(head.Pred) {
+			return nil, p.errorf("comparison %v cannot be a rule head", head)
+		}
+		if p.consume("?") {
+			prog.Queries = append(prog.Queries, head)
+			continue
+		}
+		r := Rule{Head: head}
+		if p.consume(":-") {
+			for {
+				a, err := p.parseAtom()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = append(r.Body, a)
+				if !p.consume(",") {
+					break
+				}
+			}
+		}
+		if err := p.expect("."); err != nil {
+			return nil, err
+		}
+		prog.Rules = append(prog.Rules, r)
+	}
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) {
+		r := p.input[p.pos]
+		switch {
+		case unicode.IsSpace(r):
+			p.pos++
+		case r == '%':
+			for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("datalog: %s at %d", fmt.Sprintf(format, args...), p.pos)
+}
+
+// consume skips the string s if it comes next.
+func (p *parser) consume(s string) bool {
+	p.skipSpace()
+	r := []rune(s)
+	if p.pos+len(r) > len(p.input) {
+		return false
+	}
+	for i := range r {
+		if p.input[p.pos+i] != r[i] {
+			return false
+		}
+	}
+	p.pos += len(r)
+	return true
+}
+
+func (p *parser) expect(s string) error {
+	if p.consume(s) {
+		return nil
+	}
+	if p.pos >= len(p.input) {
+		return errIncomplete
+	}
+	return p.errorf("expected %q, found %q", s, p.input[p.pos])
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (p *parser) parseIdent() string {
+	start := p.pos
+	for p.pos < len(p.input) && isIdentRune(p.input[p.pos]) {
+		p.pos++
+	}
+	return string(p.input[start:p.pos])
+}
+
+// parseAtom reads a predicate application or a comparison.
+func (p *parser) parseAtom() (Atom, error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return Atom{}, errIncomplete
+	}
+	if r := p.input[p.pos]; unicode.IsLower(r) {
+		start := p.pos
+		name := p.parseIdent()
+		if p.consume("(") {
+			a := Atom{Pred: name}
+			for {
+				t, err := p.parseTerm()
+				if err != nil {
+					return Atom{}, err
+				}
+				a.Args = append(a.Args, t)
+				if !p.consume(",") {
+					break
+				}
+			}
+			if err := p.expect(")"); err != nil {
+				return Atom{}, err
+			}
+			return a, nil
+		}
+		// A bare constant starting a comparison.
+		p.pos = start
+	}
+	left, err := p.parseTerm()
+	if err != nil {
+		return Atom{}, err
+	}
+	var op string
+	switch {
+	case p.consume("!="):
+		op = "!="
+	case p.consume("="):
+		op = "="
+	default:
+		if p.pos >= len(p.input) {
+			return Atom{}, errIncomplete
+		}
+		return Atom{}, p.errorf("expected a predicate or comparison")
+	}
+	right, err := p.parseTerm()
+	if err != nil {
+		return Atom{}, err
+	}
+	return Atom{Pred: op, Args: []Term{left, right}}, nil
+}
+
+func (p *parser) parseTerm() (Term, error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return Term{}, errIncomplete
+	}
+	r := p.input[p.pos]
+	switch {
+	case r == '"':
+		return p.parseString()
+	case r == '_' || unicode.IsUpper(r):
+		name := p.parseIdent()
+		if name == "_" {
+			p.anon++
+			name = fmt.Sprintf("_%d", p.anon)
+		}
+		return Term{Var: name}, nil
+	case unicode.IsLower(r) || unicode.IsDigit(r) || r == '-':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.input) && (isIdentRune(p.input[p.pos]) || p.input[p.pos] == '.' && p.pos+1 < len(p.input) && unicode.IsDigit(p.input[p.pos+1])) {
+			p.pos++
+		}
+		return Term{Value: string(p.input[start:p.pos])}, nil
+	}
+	return Term{}, p.errorf("unexpected %q", r)
+}
+
+func (p *parser) parseString() (Term, error) {
+	p.pos++
+	var val []rune
+	for {
+		if p.pos >= len(p.input) {
+			return Term{}, errIncomplete
+		}
+		r := p.input[p.pos]
+		p.pos++
+		switch r {
+		case '"':
+			return Term{Value: string(val)}, nil
+		case '\\':
+			if p.pos >= len(p.input) {
+				return Term{}, errIncomplete
+			}
+			r = p.input[p.pos]
+			p.pos++
+			switch r {
+			case 'n':
+				r = '\n'
+			case 't':
+				r = '\t'
+			}
+		}
+		val = append(val, r)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datalog
+
+// Defines a running session of the Datalog query language. Rules and facts
+// are kept for the life of the session, so they can be built up a line at a
+// time in the REPL; queries are answered against the current contents of
+// the QuadStore.
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/barakmich/glog"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/query"
+)
+
+type Session struct {
+	qs      graph.QuadStore
+	prog    *program
+	debug   bool
+	results []interface{}
+	err     error
+}
+
+func NewSession(qs graph.QuadStore) *Session {
+	var s Session
+	s.qs = qs
+	s.prog = newProgram()
+	return &s
+}
+
+func (s *Session) ToggleDebug() {
+	s.debug = !s.debug
+}
+
+func (s *Session) InputParses(input string) (query.ParseResult, error) {
+	_, err := Parse(input)
+	switch err {
+	case nil:
+		return query.Parsed, nil
+	case errIncomplete:
+		return query.ParseMore, nil
+	}
+	return query.ParseFail, err
+}
+
+// define adds the rules to the session, or none of them if any is bad.
+func (s *Session) define(rules []Rule) error {
+	next := newProgram()
+	for pred, rs := range s.prog.rules {
+		next.rules[pred] = append([]Rule(nil), rs...)
+	}
+	for pred, n := range s.prog.arity {
+		next.arity[pred] = n
+	}
+	for _, r := range rules {
+		if err := next.add(r); err != nil {
+			return err
+		}
+	}
+	s.prog = next
+	return nil
+}
+
+func (s *Session) ExecInput(ctx context.Context, input string, out chan interface{}, limit int) {
+	defer close(out)
+	s.err = nil
+	prog, err := Parse(input)
+	if err == nil {
+		err = s.define(prog.Rules)
+	}
+	if err != nil {
+		s.err = err
+		out <- err
+		return
+	}
+	nResults := 0
+	for _, q := range prog.Queries {
+		if isBuiltin(q.Pred) {
+			s.err = fmt.Errorf("datalog: cannot query comparison %v", q)
+		} else {
+			s.err = s.prog.checkAtom(q)
+		}
+		if s.err != nil {
+			out <- s.err
+			return
+		}
+		e, err := newEvaluator(s.qs, s.prog, q.Pred, ctx.Done())
+		if err != nil {
+			s.err = err
+			out <- err
+			return
+		}
+		answers := e.query(q)
+		if err := query.KillError(ctx); err != nil {
+			s.err = err
+			out <- err
+			return
+		}
+		if s.debug {
+			for pred, rel := range e.full {
+				glog.Infof("%s: %d tuples", pred, len(rel.tuples))
+			}
+		}
+		if isGround(q) {
+			out <- len(answers) > 0
+			continue
+		}
+		for _, b := range answers {
+			if limit >= 0 && nResults >= limit {
+				return
+			}
+			row := make(map[string]string)
+			for v, val := range b {
+				if !strings.HasPrefix(v, "_") {
+					row[v] = val
+				}
+			}
+			out <- row
+			nResults++
+		}
+	}
+}
+
+func isGround(a Atom) bool {
+	for _, t := range a.Args {
+		if t.IsVar() && !strings.HasPrefix(t.Var, "_") {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Session) ToText(result interface{}) string {
+	switch r := result.(type) {
+	case error:
+		return fmt.Sprintf("Error: %v\n", r)
+	case bool:
+		return fmt.Sprintln(r)
+	}
+	row := result.(map[string]string)
+	out := fmt.Sprintln("****")
+	vars := make([]string, 0, len(row))
+	for v := range row {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	for _, v := range vars {
+		out += fmt.Sprintf("%s : %s\n", v, row[v])
+	}
+	return out
+}
+
+// GetQuery has no shape to report; Datalog queries are not a single
+// iterator tree.
+func (s *Session) GetQuery(input string, out chan map[string]interface{}) {
+	close(out)
+}
+
+func (s *Session) BuildJSON(result interface{}) {
+	if _, ok := result.(error); ok {
+		return
+	}
+	s.results = append(s.results, result)
+}
+
+func (s *Session) ResultJSON(result interface{}) ([]interface{}, error) {
+	if err, ok := result.(error); ok {
+		return nil, err
+	}
+	return []interface{}{result}, nil
+}
+
+func (s *Session) GetJSON() ([]interface{}, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.results, nil
+}
+
+func (s *Session) ClearJSON() {
+	s.results = nil
+}
@@ -15,7 +15,9 @@
 package gremlin
 
 import (
+	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/barakmich/glog"
 	"github.com/robertkrimen/otto"
@@ -136,9 +138,253 @@ func buildInOutIterator(obj *otto.Object, qs graph.QuadStore, base graph.Iterato
 	and := iterator.NewAnd()
 	and.AddSubIterator(iterator.NewLinksTo(qs, predicateNodeIterator, quad.Predicate))
 	and.AddSubIterator(lto)
+	if labelIt := buildContextIterator(obj, qs); labelIt != nil {
+		and.AddSubIterator(labelIt)
+	}
 	return iterator.NewHasA(qs, and, out)
 }
 
+// buildLinkIterator returns an iterator over the nodes in one direction of
+// the quads which have a node of base in another, each once.
+func buildLinkIterator(obj *otto.Object, qs graph.QuadStore, base graph.Iterator, from, to quad.Direction) graph.Iterator {
+	and := iterator.NewAnd()
+	and.AddSubIterator(iterator.NewLinksTo(qs, base, from))
+	if labelIt := buildContextIterator(obj, qs); labelIt != nil {
+		and.AddSubIterator(labelIt)
+	}
+	return iterator.NewUnique(iterator.NewHasA(qs, and, to), qs)
+}
+
+// buildLabelsIterator returns an iterator over the labels of the quads which
+// have a node of base as their subject or object, each once.
+func buildLabelsIterator(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+	labelsOf := func(it graph.Iterator, d quad.Direction) graph.Iterator {
+		and := iterator.NewAnd()
+		and.AddSubIterator(iterator.NewLinksTo(qs, it, d))
+		// Only the quads with a label, or the unlabelled ones would give the
+		// empty label.
+		and.AddSubIterator(iterator.NewLinksTo(qs, qs.NodesAllIterator(), quad.Label))
+		return iterator.NewHasA(qs, and, quad.Label)
+	}
+	or := iterator.NewOr()
+	or.AddSubIterator(labelsOf(base.Clone(), quad.Subject))
+	or.AddSubIterator(labelsOf(base, quad.Object))
+	return iterator.NewUnique(or, qs)
+}
+
+// contextOf returns the nearest InContext step before a step, or nil if
+// there's none.
+func contextOf(obj *otto.Object) *otto.Object {
+	for {
+		prev, _ := obj.Get("_gremlin_prev")
+		if !prev.IsObject() {
+			return nil
+		}
+		obj = prev.Object()
+		if kind, _ := obj.Get("_gremlin_type"); kind.String() == "incontext" {
+			return obj
+		}
+	}
+}
+
+// buildContextIterator returns an iterator over the quads in the labels of
+// the context of a step, which also tags their labels, or nil if the step may
+// follow any quad.
+func buildContextIterator(obj *otto.Object, qs graph.QuadStore) graph.Iterator {
+	context := contextOf(obj)
+	if context == nil {
+		return nil
+	}
+	argList, _ := context.Get("_gremlin_values")
+	args := argList.Object()
+	var (
+		labels, tags []string
+		anyLabel     = true
+	)
+	if zero, _ := args.Get("0"); zero.IsString() {
+		labels, anyLabel = []string{zero.String()}, false
+	} else if zero.Class() == "Array" {
+		labels, anyLabel = stringsFrom(zero.Object()), false
+	}
+	if one, _ := args.Get("1"); one.IsString() {
+		tags = []string{one.String()}
+	} else if one.Class() == "Array" {
+		tags = stringsFrom(one.Object())
+	}
+	if anyLabel {
+		if len(tags) == 0 {
+			return nil
+		}
+		// Quads without a label still match; they just have nothing to tag.
+		all := qs.NodesAllIterator()
+		for _, tag := range tags {
+			all.Tagger().Add(tag)
+		}
+		return iterator.NewOptional(iterator.NewLinksTo(qs, all, quad.Label))
+	}
+	fixed := iterator.FixedLabels(qs, labels)
+	for _, tag := range tags {
+		fixed.Tagger().Add(tag)
+	}
+	return iterator.NewLinksTo(qs, fixed, quad.Label)
+}
+
+var filterOperators = map[string]iterator.Operator{
+	"lt":  iterator.CompareLT,
+	"lte": iterator.CompareLTE,
+	"gt":  iterator.CompareGT,
+	"gte": iterator.CompareGTE,
+}
+
+// buildFilter returns an iterator over the nodes that pass a filter built by
+// lt, lte, gt, gte, regex or prefix, or nil if the filter is malformed.
+func buildFilter(val otto.Value, qs graph.QuadStore) graph.Iterator {
+	if !val.IsObject() {
+		glog.Errorln("Filter takes the result of lt, lte, gt, gte, regex or prefix.")
+		return nil
+	}
+	kind, _ := val.Object().Get("_gremlin_filter")
+	arg, _ := val.Object().Get("_gremlin_filter_value")
+	switch kind.String() {
+	case "regex":
+		if !arg.IsString() {
+			glog.Errorln("regex takes a string.")
+			return nil
+		}
+		re, err := regexp.Compile(arg.String())
+		if err != nil {
+			glog.Errorln("Invalid regex:", err)
+			return nil
+		}
+		return iterator.NewRegex(qs.NodesAllIterator(), re, qs)
+	case "prefix":
+		if !arg.IsString() {
+			glog.Errorln("prefix takes a string.")
+			return nil
+		}
+		return iterator.NewComparison(qs.NodesAllIterator(), iterator.ComparePrefix, arg.String(), qs)
+	}
+	op, ok := filterOperators[kind.String()]
+	if !ok {
+		glog.Errorln("Filter takes the result of lt, lte, gt, gte, regex or prefix.")
+		return nil
+	}
+	var cmp interface{}
+	switch {
+	case arg.IsNumber():
+		cmp, _ = arg.ToFloat()
+	case arg.IsString():
+		cmp = arg.String()
+	case arg.Class() == "Date":
+		ms, _ := arg.Object().Call("getTime")
+		msec, _ := ms.ToInteger()
+		cmp = time.Unix(0, msec*int64(time.Millisecond)).UTC()
+	default:
+		glog.Errorln("Comparisons take a number, a string or a Date.")
+		return nil
+	}
+	return iterator.NewComparison(qs.NodesAllIterator(), op, cmp, qs)
+}
+
+func buildFilterIterator(obj *otto.Object, qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+	argList, _ := obj.Get("_gremlin_values")
+	if argList.Class() != "GoArray" {
+		glog.Errorln("How is arglist not an array? Return nothing.", argList.Class())
+		return iterator.NewNull()
+	}
+	argArray := argList.Object()
+	lengthVal, _ := argArray.Get("length")
+	length, _ := lengthVal.ToInteger()
+	and := iterator.NewAnd()
+	and.AddSubIterator(base)
+	for i := int64(0); i < length; i++ {
+		arg, _ := argArray.Get(strconv.FormatInt(i, 10))
+		filter := buildFilter(arg, qs)
+		if filter == nil {
+			return iterator.NewNull()
+		}
+		and.AddSubIterator(filter)
+	}
+	return and
+}
+
+// buildShortestPathIterator finds the paths from subIt to the vertices of its
+// first argument, following the predicates named in its string arguments, to
+// at most the depth of its numeric argument. The string arguments after that
+// are tags for the number of each vertex's path, then for its place along it.
+func buildShortestPathIterator(obj *otto.Object, qs graph.QuadStore, subIt graph.Iterator) graph.Iterator {
+	arg, _ := obj.Get("_gremlin_values")
+	firstArg, _ := arg.Object().Get("0")
+	if !firstArg.IsObject() || !isVertexChain(firstArg.Object()) {
+		glog.Errorln("ShortestPath takes a query for its goal.")
+		return iterator.NewNull()
+	}
+	goal := buildIteratorTree(firstArg.Object(), qs)
+
+	var (
+		predVals []graph.Value
+		maxDepth int64
+		tags     []string
+		hasDepth bool
+	)
+	length, _ := arg.Object().Get("length")
+	n, _ := length.ToInteger()
+	for i := int64(1); i < n; i++ {
+		v, _ := arg.Object().Get(strconv.FormatInt(i, 10))
+		switch {
+		case v.IsNumber():
+			maxDepth, _ = v.ToInteger()
+			hasDepth = true
+		case v.IsString() && hasDepth:
+			tags = append(tags, v.String())
+		case v.IsString():
+			predVals = append(predVals, qs.ValueOf(v.String()))
+		case v.IsObject() && v.Class() == "Array":
+			for _, name := range stringsFrom(v.Object()) {
+				predVals = append(predVals, qs.ValueOf(name))
+			}
+		}
+	}
+	it := iterator.NewShortestPath(qs, subIt, goal, predVals, int(maxDepth))
+	if len(tags) > 0 {
+		it.AddPathTag(tags[0])
+	}
+	if len(tags) > 1 {
+		it.AddStepTag(tags[1])
+	}
+	return it
+}
+
+// buildRecursiveIterator follows the morphism of its first argument from
+// subIt, over and over, to at most the depth of its numeric argument, tagging
+// the depth of each vertex with its string arguments.
+func buildRecursiveIterator(obj *otto.Object, qs graph.QuadStore, subIt graph.Iterator, depthTags []string) graph.Iterator {
+	arg, _ := obj.Get("_gremlin_values")
+	firstArg, _ := arg.Object().Get("0")
+	if !firstArg.IsObject() || isVertexChain(firstArg.Object()) {
+		glog.Errorln("FollowRecursive takes a morphism.")
+		return iterator.NewNull()
+	}
+	morphism := firstArg.Object()
+
+	var maxDepth int64
+	length, _ := arg.Object().Get("length")
+	n, _ := length.ToInteger()
+	for i := int64(1); i < n; i++ {
+		v, _ := arg.Object().Get(strconv.FormatInt(i, 10))
+		if v.IsNumber() {
+			maxDepth, _ = v.ToInteger()
+		}
+	}
+	it := iterator.NewRecursive(qs, subIt, func(base graph.Iterator) graph.Iterator {
+		return buildIteratorTreeHelper(morphism, qs, base)
+	}, int(maxDepth))
+	for _, tag := range depthTags {
+		it.AddDepthTag(tag)
+	}
+	return it
+}
+
 func buildIteratorTreeHelper(obj *otto.Object, qs graph.QuadStore, base graph.Iterator) graph.Iterator {
 	it := base
 
@@ -183,6 +429,9 @@ func buildIteratorTreeHelper(obj *otto.Object, qs graph.QuadStore, base graph.It
 		subAnd := iterator.NewAnd()
 		subAnd.AddSubIterator(iterator.NewLinksTo(qs, predFixed, quad.Predicate))
 		subAnd.AddSubIterator(iterator.NewLinksTo(qs, all, quad.Object))
+		if labelIt := buildContextIterator(obj, qs); labelIt != nil {
+			subAnd.AddSubIterator(labelIt)
+		}
 		hasa := iterator.NewHasA(qs, subAnd, quad.Subject)
 		and := iterator.NewAnd()
 		and.AddSubIterator(hasa)
@@ -203,6 +452,9 @@ func buildIteratorTreeHelper(obj *otto.Object, qs graph.QuadStore, base graph.It
 		subAnd := iterator.NewAnd()
 		subAnd.AddSubIterator(iterator.NewLinksTo(qs, predFixed, quad.Predicate))
 		subAnd.AddSubIterator(iterator.NewLinksTo(qs, all, quad.Subject))
+		if labelIt := buildContextIterator(obj, qs); labelIt != nil {
+			subAnd.AddSubIterator(labelIt)
+		}
 		hasa := iterator.NewHasA(qs, subAnd, quad.Object)
 		and := iterator.NewAnd()
 		and.AddSubIterator(hasa)
@@ -221,6 +473,9 @@ func buildIteratorTreeHelper(obj *otto.Object, qs graph.QuadStore, base graph.It
 		subAnd := iterator.NewAnd()
 		subAnd.AddSubIterator(iterator.NewLinksTo(qs, predFixed, quad.Predicate))
 		subAnd.AddSubIterator(iterator.NewLinksTo(qs, fixed, quad.Object))
+		if labelIt := buildContextIterator(obj, qs); labelIt != nil {
+			subAnd.AddSubIterator(labelIt)
+		}
 		hasa := iterator.NewHasA(qs, subAnd, quad.Subject)
 		and := iterator.NewAnd()
 		and.AddSubIterator(hasa)
@@ -228,6 +483,9 @@ func buildIteratorTreeHelper(obj *otto.Object, qs graph.QuadStore, base graph.It
 		it = and
 	case "morphism":
 		it = base
+	case "incontext":
+		// The context is read by the steps which follow it.
+		it = subIt
 	case "and":
 		arg, _ := obj.Get("_gremlin_values")
 		firstArg, _ := arg.Object().Get("0")
@@ -240,6 +498,14 @@ func buildIteratorTreeHelper(obj *otto.Object, qs graph.QuadStore, base graph.It
 		and.AddSubIterator(subIt)
 		and.AddSubIterator(argIt)
 		it = and
+	case "except":
+		arg, _ := obj.Get("_gremlin_values")
+		firstArg, _ := arg.Object().Get("0")
+		if !isVertexChain(firstArg.Object()) {
+			return iterator.NewNull()
+		}
+		argIt := buildIteratorTree(firstArg.Object(), qs)
+		it = iterator.NewNot(subIt, argIt)
 	case "back":
 		arg, _ := obj.Get("_gremlin_back_chain")
 		argIt := buildIteratorTree(arg.Object(), qs)
@@ -281,6 +547,16 @@ func buildIteratorTreeHelper(obj *otto.Object, qs graph.QuadStore, base graph.It
 		it = or
 	case "out":
 		it = buildInOutIterator(obj, qs, subIt, false)
+	case "outpredicates":
+		it = buildLinkIterator(obj, qs, subIt, quad.Subject, quad.Predicate)
+	case "inpredicates":
+		it = buildLinkIterator(obj, qs, subIt, quad.Object, quad.Predicate)
+	case "subjects":
+		it = buildLinkIterator(obj, qs, subIt, quad.Predicate, quad.Subject)
+	case "objects":
+		it = buildLinkIterator(obj, qs, subIt, quad.Predicate, quad.Object)
+	case "labelsof":
+		it = buildLabelsIterator(qs, subIt)
 	case "follow":
 		// Follow a morphism
 		arg, _ := obj.Get("_gremlin_values")
@@ -296,8 +572,60 @@ func buildIteratorTreeHelper(obj *otto.Object, qs graph.QuadStore, base graph.It
 			return iterator.NewNull()
 		}
 		it = buildIteratorTreeHelper(arg.Object(), qs, subIt)
+	case "followrecursive":
+		it = buildRecursiveIterator(obj, qs, subIt, stringArgs)
 	case "in":
 		it = buildInOutIterator(obj, qs, subIt, true)
+	case "filter":
+		it = buildFilterIterator(obj, qs, subIt)
+	case "limit":
+		arg, _ := obj.Get("_gremlin_values")
+		firstArg, _ := arg.Object().Get("0")
+		n, err := firstArg.ToInteger()
+		if err != nil || !firstArg.IsNumber() {
+			glog.Errorln("Limit takes a number.")
+			return iterator.NewNull()
+		}
+		it = iterator.NewLimit(subIt, n)
+	case "skip":
+		arg, _ := obj.Get("_gremlin_values")
+		firstArg, _ := arg.Object().Get("0")
+		n, err := firstArg.ToInteger()
+		if err != nil || !firstArg.IsNumber() {
+			glog.Errorln("Skip takes a number.")
+			return iterator.NewNull()
+		}
+		it = iterator.NewSkip(subIt, n)
+	case "unique":
+		it = iterator.NewUnique(subIt, qs)
+	case "shortestpath":
+		it = buildShortestPathIterator(obj, qs, subIt)
+	case "order":
+		var name string
+		if len(stringArgs) > 0 {
+			name = stringArgs[0]
+		}
+		c, err := iterator.ParseCollation(name)
+		if err != nil {
+			glog.Errorln("Order:", err)
+			return iterator.NewNull()
+		}
+		it = iterator.NewOrder(subIt, qs, "", c)
+	case "orderby":
+		if len(stringArgs) == 0 {
+			glog.Errorln("OrderBy takes a tag.")
+			return iterator.NewNull()
+		}
+		var name string
+		if len(stringArgs) > 1 {
+			name = stringArgs[1]
+		}
+		c, err := iterator.ParseCollation(name)
+		if err != nil {
+			glog.Errorln("OrderBy:", err)
+			return iterator.NewNull()
+		}
+		it = iterator.NewOrder(subIt, qs, stringArgs[0], c)
 	}
 	return it
 }
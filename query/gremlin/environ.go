@@ -23,6 +23,7 @@ import (
 	"github.com/robertkrimen/otto"
 
 	"github.com/google/cayley/graph"
+	"github.com/google/cayley/query"
 )
 
 type worker struct {
@@ -32,6 +33,7 @@ type worker struct {
 
 	results chan interface{}
 	shape   map[string]interface{}
+	explain *query.Explain
 
 	count int
 	limit int
@@ -84,9 +86,25 @@ func newWorker(qs graph.QuadStore) *worker {
 		return otto.NullValue()
 	})
 
+	for _, name := range []string{"lt", "lte", "gt", "gte", "regex", "prefix"} {
+		env.Set(name, gremlinFilter(name))
+	}
+
 	return wk
 }
 
+// gremlinFilter returns a global function describing a filter, to be passed
+// to .Filter().
+func gremlinFilter(kind string) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		call.Otto.Run("var out = {}")
+		out, _ := call.Otto.Object("out")
+		out.Set("_gremlin_filter", kind)
+		out.Set("_gremlin_filter_value", call.Argument(0))
+		return out.Value()
+	}
+}
+
 func (wk *worker) wantShape() bool {
 	return wk.shape != nil
 }
@@ -16,6 +16,7 @@ package gremlin
 
 import (
 	"encoding/json"
+	"strconv"
 
 	"github.com/barakmich/glog"
 	"github.com/robertkrimen/otto"
@@ -35,6 +36,11 @@ func (wk *worker) embedFinals(env *otto.Otto, obj *otto.Object) {
 	obj.Set("TagValue", wk.toValueFunc(env, obj, true))
 	obj.Set("Map", wk.mapFunc(env, obj))
 	obj.Set("ForEach", wk.mapFunc(env, obj))
+	obj.Set("Count", wk.countFunc(env, obj))
+	obj.Set("GroupCount", wk.groupCountFunc(env, obj))
+	obj.Set("Sum", wk.sumFunc(env, obj))
+	obj.Set("Min", wk.extremeFunc(env, obj, false))
+	obj.Set("Max", wk.extremeFunc(env, obj, true))
 }
 
 func (wk *worker) allFunc(env *otto.Otto, obj *otto.Object) func(otto.FunctionCall) otto.Value {
@@ -135,10 +141,98 @@ func (wk *worker) mapFunc(env *otto.Otto, obj *otto.Object) func(otto.FunctionCa
 	}
 }
 
+// tagOf returns the tag named by the first argument of an aggregate, or, if
+// there is none, the empty tag for the result itself.
+func tagOf(call otto.FunctionCall) string {
+	args := argsOf(call)
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+func (wk *worker) countFunc(env *otto.Otto, obj *otto.Object) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		it, _ := buildIteratorTree(obj, wk.qs).Optimize()
+		n, exact := it.Size()
+		if exact {
+			wk.explain.Start(it)
+			it.Close()
+		} else {
+			n = 0
+			wk.runIteratorForEach(it, "", func(graph.Value) { n++ })
+		}
+		val, _ := call.Otto.ToValue(n)
+		return val
+	}
+}
+
+func (wk *worker) groupCountFunc(env *otto.Otto, obj *otto.Object) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		it, _ := buildIteratorTree(obj, wk.qs).Optimize()
+		counts := make(map[string]int64)
+		wk.runIteratorForEach(it, tagOf(call), func(v graph.Value) {
+			if v != nil {
+				counts[iterator.NameOf(wk.qs, v)]++
+			}
+		})
+		val, err := call.Otto.ToValue(counts)
+		if err != nil {
+			glog.Error(err)
+			return otto.NullValue()
+		}
+		return val
+	}
+}
+
+// numberOf returns the number a value names, if it names one.
+func (wk *worker) numberOf(v graph.Value) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(iterator.NameOf(wk.qs, v), 64)
+	return f, err == nil
+}
+
+func (wk *worker) sumFunc(env *otto.Otto, obj *otto.Object) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		it, _ := buildIteratorTree(obj, wk.qs).Optimize()
+		var sum float64
+		wk.runIteratorForEach(it, tagOf(call), func(v graph.Value) {
+			if f, ok := wk.numberOf(v); ok {
+				sum += f
+			}
+		})
+		val, _ := call.Otto.ToValue(sum)
+		return val
+	}
+}
+
+func (wk *worker) extremeFunc(env *otto.Otto, obj *otto.Object, max bool) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		it, _ := buildIteratorTree(obj, wk.qs).Optimize()
+		var (
+			best  float64
+			found bool
+		)
+		wk.runIteratorForEach(it, tagOf(call), func(v graph.Value) {
+			f, ok := wk.numberOf(v)
+			if ok && (!found || (max && f > best) || (!max && f < best)) {
+				best, found = f, true
+			}
+		})
+		if !found {
+			return otto.NullValue()
+		}
+		val, _ := call.Otto.ToValue(best)
+		return val
+	}
+}
+
 func (wk *worker) tagsToValueMap(m map[string]graph.Value) map[string]string {
 	outputMap := make(map[string]string)
 	for k, v := range m {
-		outputMap[k] = wk.qs.NameOf(v)
+		outputMap[k] = iterator.NameOf(wk.qs, v)
 	}
 	return outputMap
 }
@@ -147,6 +241,11 @@ func (wk *worker) runIteratorToArray(it graph.Iterator, limit int) []map[string]
 	output := make([]map[string]string, 0)
 	n := 0
 	it, _ = it.Optimize()
+	if !wk.explain.Start(it) {
+		it.Close()
+		return output
+	}
+	graph.SetKill(it, wk.kill)
 	for {
 		select {
 		case <-wk.kill:
@@ -186,6 +285,11 @@ func (wk *worker) runIteratorToArrayNoTags(it graph.Iterator, limit int) []strin
 	output := make([]string, 0)
 	n := 0
 	it, _ = it.Optimize()
+	if !wk.explain.Start(it) {
+		it.Close()
+		return output
+	}
+	graph.SetKill(it, wk.kill)
 	for {
 		select {
 		case <-wk.kill:
@@ -195,7 +299,7 @@ func (wk *worker) runIteratorToArrayNoTags(it graph.Iterator, limit int) []strin
 		if !graph.Next(it) {
 			break
 		}
-		output = append(output, wk.qs.NameOf(it.Result()))
+		output = append(output, iterator.NameOf(wk.qs, it.Result()))
 		n++
 		if limit >= 0 && n >= limit {
 			break
@@ -208,6 +312,11 @@ func (wk *worker) runIteratorToArrayNoTags(it graph.Iterator, limit int) []strin
 func (wk *worker) runIteratorWithCallback(it graph.Iterator, callback otto.Value, this otto.FunctionCall, limit int) {
 	n := 0
 	it, _ = it.Optimize()
+	if !wk.explain.Start(it) {
+		it.Close()
+		return
+	}
+	graph.SetKill(it, wk.kill)
 	if glog.V(2) {
 		b, err := json.MarshalIndent(it.Describe(), "", "  ")
 		if err != nil {
@@ -252,6 +361,45 @@ func (wk *worker) runIteratorWithCallback(it graph.Iterator, callback otto.Value
 	it.Close()
 }
 
+// runIteratorForEach calls fn with the value under tag, or the result if tag
+// is empty, for each path of an optimized iterator, without keeping them.
+func (wk *worker) runIteratorForEach(it graph.Iterator, tag string, fn func(graph.Value)) {
+	if !wk.explain.Start(it) {
+		it.Close()
+		return
+	}
+	graph.SetKill(it, wk.kill)
+	visit := func() {
+		if tag == "" {
+			fn(it.Result())
+			return
+		}
+		tags := make(map[string]graph.Value)
+		it.TagResults(tags)
+		fn(tags[tag])
+	}
+	for {
+		select {
+		case <-wk.kill:
+			return
+		default:
+		}
+		if !graph.Next(it) {
+			break
+		}
+		visit()
+		for it.NextPath() {
+			select {
+			case <-wk.kill:
+				return
+			default:
+			}
+			visit()
+		}
+	}
+	it.Close()
+}
+
 func (wk *worker) send(r *Result) bool {
 	if wk.limit >= 0 && wk.limit == wk.count {
 		return false
@@ -278,6 +426,11 @@ func (wk *worker) runIterator(it graph.Iterator) {
 		return
 	}
 	it, _ = it.Optimize()
+	if !wk.explain.Start(it) {
+		it.Close()
+		return
+	}
+	graph.SetKill(it, wk.kill)
 	if glog.V(2) {
 		b, err := json.MarshalIndent(it.Describe(), "", "  ")
 		if err != nil {
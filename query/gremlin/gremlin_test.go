@@ -15,12 +15,16 @@
 package gremlin
 
 import (
+	"context"
+	"encoding/json"
 	"reflect"
 	"sort"
 	"testing"
 
 	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
 	"github.com/google/cayley/quad"
+	"github.com/google/cayley/query"
 
 	_ "github.com/google/cayley/graph/memstore"
 	_ "github.com/google/cayley/writer"
@@ -66,6 +70,7 @@ var testQueries = []struct {
 	message string
 	query   string
 	tag     string
+	ordered bool
 	expect  []string
 }{
 	// Simple query tests.
@@ -138,6 +143,176 @@ var testQueries = []struct {
 		`,
 		expect: []string{"A", "C", "D"},
 	},
+	{
+		message: "use .FollowRecursive()",
+		query: `
+			g.V("C").FollowRecursive(g.M().Out("follows")).All()
+		`,
+		expect: []string{"B", "D", "F", "G"},
+	},
+	{
+		message: "use .FollowRecursive() within a depth",
+		query: `
+			g.V("A").FollowRecursive(g.M().Out("follows"), 2).All()
+		`,
+		expect: []string{"B", "F"},
+	},
+	{
+		message: "use .FollowRecursive() with a depth tag",
+		query: `
+			g.V("A").FollowRecursive(g.M().Out("follows"), "depth").All()
+		`,
+		tag:    "depth",
+		expect: []string{"1", "2", "3"},
+	},
+	{
+		message: "use .FollowRecursive() to reach other starts",
+		query: `
+			g.V("B", "D", "G", "F").FollowRecursive(g.M().Out("follows"), 2).All()
+		`,
+		expect: []string{"B", "F", "G"},
+	},
+	{
+		message: "use .FollowRecursive() backward",
+		query: `
+			g.V("B").FollowRecursive(g.M().In("follows")).All()
+		`,
+		expect: []string{"A", "C", "D"},
+	},
+	{
+		message: "use .FollowRecursive() with a vertex",
+		query: `
+			g.V("A").FollowRecursive(g.V("B")).All()
+		`,
+		expect: nil,
+	},
+
+	// Difference tests.
+	{
+		message: "show simple difference",
+		query: `
+			g.V("C").Out("follows").Except(g.V("B")).All()
+		`,
+		expect: []string{"D"},
+	},
+	{
+		message: "show difference of paths",
+		query: `
+			g.V("B").In("follows").Except(g.V("C").Out("follows")).All()
+		`,
+		expect: []string{"A", "C"},
+	},
+	{
+		message: "show difference with a tag",
+		query: `
+			g.V("B").Tag("to").In("follows").Except(g.V("D")).All()
+		`,
+		tag:    "to",
+		expect: []string{"B", "B"},
+	},
+
+	// Label context tests.
+	{
+		message: "use .InContext() to follow only quads with a label",
+		query: `
+			g.V("B").InContext("status_graph").Out().All()
+		`,
+		expect: []string{"cool"},
+	},
+	{
+		message: "use .InContext() with several labels",
+		query: `
+			g.V("D").InContext(["status_graph", "other_graph"]).Both().All()
+		`,
+		expect: []string{"cool"},
+	},
+	{
+		message: "use .InContext() with a label no quad has",
+		query: `
+			g.V().InContext("other_graph").Has("status", "cool").All()
+		`,
+		expect: nil,
+	},
+	{
+		message: "use .InContext() with .Has()",
+		query: `
+			g.V().InContext("status_graph").Has("status", "cool").All()
+		`,
+		expect: []string{"B", "D", "G"},
+	},
+	{
+		message: "use .InContext() to tag labels",
+		query: `
+			g.V("B").InContext(null, "label").Out().All()
+		`,
+		tag:    "label",
+		expect: []string{"status_graph"},
+	},
+	{
+		message: "use .InContext() without labels to clear the context",
+		query: `
+			g.V("B").InContext("status_graph").InContext().Out().All()
+		`,
+		expect: []string{"F", "cool"},
+	},
+
+	// Predicate and label discovery tests.
+	{
+		message: "use .OutPredicates()",
+		query: `
+			g.V("B", "D").OutPredicates().All()
+		`,
+		expect: []string{"follows", "status"},
+	},
+	{
+		message: "use .InPredicates()",
+		query: `
+			g.V("cool", "F").InPredicates().All()
+		`,
+		expect: []string{"follows", "status"},
+	},
+	{
+		message: "use .Subjects()",
+		query: `
+			g.V("status").Subjects().All()
+		`,
+		expect: []string{"B", "D", "G"},
+	},
+	{
+		message: "use .Objects()",
+		query: `
+			g.V("status", "follows").Objects().Is("cool", "B").All()
+		`,
+		expect: []string{"B", "cool"},
+	},
+	{
+		message: "use .InContext() with .OutPredicates()",
+		query: `
+			g.V("B").InContext("status_graph").OutPredicates().All()
+		`,
+		expect: []string{"status"},
+	},
+	{
+		message: "use .LabelsOf()",
+		query: `
+			g.V("B", "C", "cool").LabelsOf().All()
+		`,
+		expect: []string{"status_graph"},
+	},
+	{
+		message: "use .LabelsOf() on unlabelled quads",
+		query: `
+			g.V("C").LabelsOf().All()
+		`,
+		expect: nil,
+	},
+	{
+		message: "use .FollowR() with .OutPredicates()",
+		query: `
+			g.V("status").FollowR(g.M().OutPredicates()).All()
+		`,
+		expect: []string{"B", "D", "G"},
+	},
 
 	// Intersection tests.
 	{
@@ -246,12 +421,203 @@ var testQueries = []struct {
 		`,
 		expect: []string{"B", "G"},
 	},
+
+	// Filter tests.
+	{
+		message: "use .Filter() with a comparison",
+		query: `
+			g.V().In("follows").Filter(lt("D")).All()
+		`,
+		expect: []string{"A", "B", "C", "C"},
+	},
+	{
+		message: "use .Filter() with a range",
+		query: `
+			g.V().In("follows").Filter(gte("D"), lte("E")).All()
+		`,
+		expect: []string{"D", "D", "E"},
+	},
+	{
+		message: "use .Filter() with a regex",
+		query: `
+			g.V().In("status").Filter(regex("[BG]")).All()
+		`,
+		expect: []string{"B", "G"},
+	},
+	{
+		message: "use .Filter() with a prefix",
+		query: `
+			g.V().Filter(prefix("fol")).All()
+		`,
+		expect: []string{"follows"},
+	},
+	{
+		message: "use .Filter() with an invalid regex",
+		query: `
+			g.V().Filter(regex("(")).All()
+		`,
+		expect: nil,
+	},
+
+	// Limit and Skip tests.
+	{
+		message: "use .Limit()",
+		query: `
+			g.V("A", "B", "C").Limit(2).All()
+		`,
+		expect: []string{"A", "B"},
+	},
+	{
+		message: "use .Skip()",
+		query: `
+			g.V("A", "B", "C").Skip(1).All()
+		`,
+		expect: []string{"B", "C"},
+	},
+	{
+		message: "use .Skip() and .Limit() to take a page",
+		query: `
+			g.V("A", "B", "C", "D").Skip(1).Limit(2).All()
+		`,
+		expect: []string{"B", "C"},
+	},
+	{
+		message: "use .Limit() mid-query",
+		query: `
+			g.V("A", "C", "D").Limit(2).Has("follows", "B").All()
+		`,
+		expect: []string{"A", "C"},
+	},
+	{
+		message: "use .Limit() before .Out()",
+		query: `
+			g.V("A", "C", "D", "E").Limit(1).Out("follows").All()
+		`,
+		expect: []string{"B"},
+	},
+	{
+		message: "use .Limit() before two .Out()s",
+		query: `
+			g.V("A", "C").Limit(1).Out("follows").Out("follows").All()
+		`,
+		expect: []string{"F"},
+	},
+	{
+		message: "use .Skip() before .Out()",
+		query: `
+			g.V("A", "C", "D", "E").Skip(3).Out("follows").All()
+		`,
+		expect: []string{"F"},
+	},
+
+	// Unique tests.
+	{
+		message: "use .Unique()",
+		query: `
+			g.V().In("follows").Filter(lt("D")).Unique().All()
+		`,
+		expect: []string{"A", "B", "C"},
+	},
+	{
+		message: "use .Unique() on repeated vertices",
+		query: `
+			g.V("A", "B", "A").Unique().All()
+		`,
+		expect: []string{"A", "B"},
+	},
+
+	// Order tests.
+	{
+		message: "use .Order()",
+		query: `
+			g.V("D", "B", "C").Order().All()
+		`,
+		ordered: true,
+		expect:  []string{"B", "C", "D"},
+	},
+	{
+		message: "use .OrderBy() on a tag",
+		query: `
+			g.V("D", "B").Tag("followed").In("follows").OrderBy("followed").All()
+		`,
+		ordered: true,
+		expect:  []string{"A", "C", "D", "C"},
+	},
+	{
+		message: "use .Order() then .Limit() to take a page",
+		query: `
+			g.V().In("follows").Unique().Order().Skip(1).Limit(2).All()
+		`,
+		ordered: true,
+		expect:  []string{"B", "C"},
+	},
+	{
+		message: "use .ShortestPath()",
+		query: `
+			g.V("A").ShortestPath(g.V("G"), "follows").All()
+		`,
+		ordered: true,
+		expect:  []string{"A", "B", "F", "G"},
+	},
+	{
+		message: "use .ShortestPath() to the nearest goal",
+		query: `
+			g.V("C").ShortestPath(g.V("F", "G"), ["follows"]).All()
+		`,
+		ordered: true,
+		expect:  []string{"C", "B", "F"},
+	},
+	{
+		message: "use .ShortestPath() with tags",
+		query: `
+			g.V("C").Tag("from").ShortestPath(g.V("G")).All()
+		`,
+		tag:    "from",
+		expect: []string{"C", "C", "C"},
+	},
+	{
+		message: "use .ShortestPath() with path and step tags",
+		query: `
+			g.V("A").ShortestPath(g.V("G"), "follows", 0, "path", "step").All()
+		`,
+		tag:     "step",
+		ordered: true,
+		expect:  []string{"0", "1", "2", "3"},
+	},
+	{
+		message: "use .ShortestPath() within a depth",
+		query: `
+			g.V("A").ShortestPath(g.V("G"), "follows", 2).All()
+		`,
+		expect: nil,
+	},
+	{
+		message: "use .ShortestPath() with another predicate",
+		query: `
+			g.V("A").ShortestPath(g.V("G"), "status").All()
+		`,
+		expect: nil,
+	},
+	{
+		message: "use .ShortestPath() to the start",
+		query: `
+			g.V("A").ShortestPath(g.V("A")).All()
+		`,
+		expect: []string{"A"},
+	},
+	{
+		message: "use .Order() with an unknown collation",
+		query: `
+			g.V("D", "B").Order("roman").All()
+		`,
+		expect: nil,
+	},
 }
 
 func runQueryGetTag(g []quad.Quad, query string, tag string) []string {
 	js := makeTestSession(g)
 	c := make(chan interface{}, 5)
-	js.ExecInput(query, c, -1)
+	js.ExecInput(context.Background(), query, c, -1)
 
 	var results []string
 	for res := range c {
@@ -259,7 +625,7 @@ func runQueryGetTag(g []quad.Quad, query string, tag string) []string {
 		if data.val == nil {
 			val := data.actualResults[tag]
 			if val != nil {
-				results = append(results, js.qs.NameOf(val))
+				results = append(results, iterator.NameOf(js.qs, val))
 			}
 		}
 	}
@@ -273,10 +639,158 @@ func TestGremlin(t *testing.T) {
 			test.tag = TopResultTag
 		}
 		got := runQueryGetTag(simpleGraph, test.query, test.tag)
-		sort.Strings(got)
-		sort.Strings(test.expect)
+		if !test.ordered {
+			sort.Strings(got)
+			sort.Strings(test.expect)
+		}
 		if !reflect.DeepEqual(got, test.expect) {
 			t.Errorf("Failed to %s, got: %v expected: %v", test.message, got, test.expect)
 		}
 	}
 }
+
+var ageGraph = []quad.Quad{
+	{"alice", "age", "30", ""},
+	{"bob", "age", "4", ""},
+	{"carol", "age", "100", ""},
+	{"dave", "age", "unknown", ""},
+	{"alice", "knows", "bob", ""},
+	{"alice", "knows", "carol", ""},
+	{"bob", "knows", "carol", ""},
+}
+
+var aggregateQueries = []struct {
+	message string
+	query   string
+	expect  string
+}{
+	{
+		message: "count the nodes",
+		query:   `g.Emit(g.V().Count())`,
+		expect:  `10`,
+	},
+	{
+		message: "count the paths of a query",
+		query:   `g.Emit(g.V().Out("knows").Count())`,
+		expect:  `3`,
+	},
+	{
+		message: "count nothing",
+		query:   `g.Emit(g.V("nobody").Out("knows").Count())`,
+		expect:  `0`,
+	},
+	{
+		message: "count the paths to each node",
+		query:   `g.Emit(g.V().Out("knows").GroupCount())`,
+		expect:  `{"bob": 1, "carol": 2}`,
+	},
+	{
+		message: "count the paths to each tagged node",
+		query:   `g.Emit(g.V().Tag("who").Out("knows").GroupCount("who"))`,
+		expect:  `{"alice": 2, "bob": 1}`,
+	},
+	{
+		message: "sum the numbers, skipping others",
+		query:   `g.Emit(g.V().Out("age").Sum())`,
+		expect:  `134`,
+	},
+	{
+		message: "sum the numbers under a tag",
+		query:   `g.Emit(g.V().Out("age").Tag("age").In("age").Out("knows").Sum("age"))`,
+		expect:  `64`,
+	},
+	{
+		message: "find the smallest number",
+		query:   `g.Emit(g.V().Out("age").Min())`,
+		expect:  `4`,
+	},
+	{
+		message: "find the largest number",
+		query:   `g.Emit(g.V().Out("age").Max())`,
+		expect:  `100`,
+	},
+	{
+		message: "find no largest number",
+		query:   `g.Emit(g.V("dave").Out("age").Max())`,
+		expect:  `null`,
+	},
+}
+
+func runQueryGetValue(g []quad.Quad, query string) interface{} {
+	js := makeTestSession(g)
+	c := make(chan interface{}, 5)
+	js.ExecInput(context.Background(), query, c, -1)
+
+	var out interface{}
+	for res := range c {
+		data := res.(*Result)
+		if data.val != nil && !data.metaresult {
+			out, _ = data.val.Export()
+		}
+	}
+	return out
+}
+
+func TestAggregates(t *testing.T) {
+	for _, test := range aggregateQueries {
+		got, _ := json.Marshal(runQueryGetValue(ageGraph, test.query))
+		var gotVal, expect interface{}
+		json.Unmarshal(got, &gotVal)
+		json.Unmarshal([]byte(test.expect), &expect)
+		if !reflect.DeepEqual(gotVal, expect) {
+			t.Errorf("Failed to %s, got: %s expected: %s", test.message, got, test.expect)
+		}
+	}
+}
+
+var explainQueries = []struct {
+	message   string
+	query     string
+	profile   bool
+	iterators int
+	results   int
+}{
+	{
+		message:   "explain a query without running it",
+		query:     `g.V("C").Out("follows").All()`,
+		iterators: 1,
+	},
+	{
+		message:   "profile a query as it runs",
+		query:     `g.V("C").Out("follows").All()`,
+		profile:   true,
+		iterators: 1,
+		results:   2,
+	},
+	{
+		message:   "explain each iterator a query runs",
+		query:     `g.V("C").Out("follows").ToArray(); g.V("B").Count()`,
+		iterators: 2,
+	},
+}
+
+func TestExplain(t *testing.T) {
+	for _, test := range explainQueries {
+		js := makeTestSession(simpleGraph)
+		e := query.NewExplain(test.profile)
+		js.SetExplain(e)
+		c := make(chan interface{}, 5)
+		js.ExecInput(context.Background(), test.query, c, -1)
+		var results int
+		for res := range c {
+			if !res.(*Result).metaresult {
+				results++
+			}
+		}
+		if results != test.results || len(e.Iterators) != test.iterators {
+			t.Errorf("Failed to %s, got %d results and %d iterators, expected %d and %d", test.message, results, len(e.Iterators), test.results, test.iterators)
+			continue
+		}
+		root := e.Iterators[0]
+		if test.profile != (root.Profile != nil) {
+			t.Errorf("Failed to %s, unexpected profile: %+v", test.message, root.Profile)
+		} else if test.profile && root.Profile.Results != int64(test.results) {
+			t.Errorf("Failed to %s, got %d profiled results, expected %d", test.message, root.Profile.Results, test.results)
+		}
+	}
+}
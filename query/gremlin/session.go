@@ -15,7 +15,7 @@
 package gremlin
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"sort"
 	"time"
@@ -25,10 +25,12 @@ import (
 	_ "github.com/robertkrimen/otto/underscore"
 
 	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
 	"github.com/google/cayley/query"
 )
 
-var ErrKillTimeout = errors.New("query timed out")
+// ErrKillTimeout is kept for existing callers; it is query.ErrKillTimeout.
+var ErrKillTimeout = query.ErrKillTimeout
 
 type Session struct {
 	qs graph.QuadStore
@@ -38,7 +40,6 @@ type Session struct {
 	persist *otto.Otto
 
 	timeout time.Duration
-	kill    chan struct{}
 
 	debug      bool
 	dataOutput []interface{}
@@ -69,6 +70,10 @@ func (s *Session) ToggleDebug() {
 	s.debug = !s.debug
 }
 
+func (s *Session) SetExplain(e *query.Explain) {
+	s.wk.explain = e
+}
+
 func (s *Session) GetQuery(input string, out chan map[string]interface{}) {
 	defer close(out)
 	s.wk.shape = make(map[string]interface{})
@@ -86,12 +91,12 @@ func (s *Session) InputParses(input string) (query.ParseResult, error) {
 	return query.Parsed, nil
 }
 
-func (s *Session) runUnsafe(input interface{}) (otto.Value, error) {
+func (s *Session) runUnsafe(ctx context.Context, input interface{}) (otto.Value, error) {
 	wk := s.wk
 	defer func() {
 		if r := recover(); r != nil {
-			if r == ErrKillTimeout {
-				s.err = ErrKillTimeout
+			if r == query.ErrKillTimeout || r == query.ErrKilled {
+				s.err = r.(error)
 				wk.env = s.persist
 				return
 			}
@@ -101,28 +106,24 @@ func (s *Session) runUnsafe(input interface{}) (otto.Value, error) {
 
 	// Use buffered chan to prevent blocking.
 	wk.env.Interrupt = make(chan func(), 1)
-	s.kill = make(chan struct{})
-	wk.kill = s.kill
+	wk.kill = ctx.Done()
 
 	done := make(chan struct{})
 	defer close(done)
-	if s.timeout >= 0 {
-		go func() {
-			time.Sleep(s.timeout)
-			select {
-			case <-done:
-			default:
-				close(s.kill)
-				wk.Lock()
-				if wk.env != nil {
-					wk.env.Interrupt <- func() {
-						panic(ErrKillTimeout)
-					}
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err := query.KillError(ctx)
+			wk.Lock()
+			if wk.env != nil {
+				wk.env.Interrupt <- func() {
+					panic(err)
 				}
-				wk.Unlock()
 			}
-		}()
-	}
+			wk.Unlock()
+		}
+	}()
 
 	wk.Lock()
 	env := wk.env
@@ -130,17 +131,27 @@ func (s *Session) runUnsafe(input interface{}) (otto.Value, error) {
 	return env.Run(input)
 }
 
-func (s *Session) ExecInput(input string, out chan interface{}, _ int) {
+func (s *Session) ExecInput(ctx context.Context, input string, out chan interface{}, _ int) {
 	defer close(out)
+	if s.timeout >= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
 	s.err = nil
 	s.wk.results = out
 	var err error
 	var value otto.Value
 	if s.script == nil {
-		value, err = s.runUnsafe(input)
+		value, err = s.runUnsafe(ctx, input)
 	} else {
-		value, err = s.runUnsafe(s.script)
+		value, err = s.runUnsafe(ctx, s.script)
+	}
+	if killErr := query.KillError(ctx); killErr != nil {
+		s.err = killErr
+		err = killErr
 	}
+	s.wk.explain.Finish()
 	out <- &Result{
 		metaresult: true,
 		err:        err,
@@ -186,7 +197,7 @@ func (s *Session) ToText(result interface{}) string {
 			if k == "$_" {
 				continue
 			}
-			out += fmt.Sprintf("%s : %s\n", k, s.qs.NameOf(tags[k]))
+			out += fmt.Sprintf("%s : %s\n", k, iterator.NameOf(s.qs, tags[k]))
 		}
 	} else {
 		if data.val.IsObject() {
@@ -205,37 +216,54 @@ func (s *Session) ToText(result interface{}) string {
 
 // Web stuff
 func (s *Session) BuildJSON(result interface{}) {
+	if v, ok := s.resultJSON(result.(*Result)); ok {
+		s.dataOutput = append(s.dataOutput, v)
+	}
+}
+
+func (s *Session) ResultJSON(result interface{}) ([]interface{}, error) {
 	data := result.(*Result)
-	if !data.metaresult {
-		if data.val == nil {
-			obj := make(map[string]string)
-			tags := data.actualResults
-			var tagKeys []string
-			for k := range tags {
-				tagKeys = append(tagKeys, k)
-			}
-			sort.Strings(tagKeys)
-			for _, k := range tagKeys {
-				name := s.qs.NameOf(tags[k])
-				if name != "" {
-					obj[k] = name
-				} else {
-					delete(obj, k)
-				}
-			}
-			if len(obj) != 0 {
-				s.dataOutput = append(s.dataOutput, obj)
-			}
-		} else {
-			if data.val.IsObject() {
-				export, _ := data.val.Export()
-				s.dataOutput = append(s.dataOutput, export)
+	if data.metaresult && data.err != nil {
+		return nil, data.err
+	}
+	if v, ok := s.resultJSON(data); ok {
+		return []interface{}{v}, nil
+	}
+	return nil, nil
+}
+
+// resultJSON returns the JSON of a result, if it has any.
+func (s *Session) resultJSON(data *Result) (interface{}, bool) {
+	if data.metaresult {
+		return nil, false
+	}
+	if data.val == nil {
+		obj := make(map[string]string)
+		tags := data.actualResults
+		var tagKeys []string
+		for k := range tags {
+			tagKeys = append(tagKeys, k)
+		}
+		sort.Strings(tagKeys)
+		for _, k := range tagKeys {
+			name := iterator.NameOf(s.qs, tags[k])
+			if name != "" {
+				obj[k] = name
 			} else {
-				strVersion, _ := data.val.ToString()
-				s.dataOutput = append(s.dataOutput, strVersion)
+				delete(obj, k)
 			}
 		}
+		if len(obj) == 0 {
+			return nil, false
+		}
+		return obj, true
 	}
+	if data.val.IsObject() {
+		export, _ := data.val.Export()
+		return export, true
+	}
+	strVersion, _ := data.val.ToString()
+	return strVersion, true
 }
 
 func (s *Session) GetJSON() ([]interface{}, error) {
@@ -243,12 +271,7 @@ func (s *Session) GetJSON() ([]interface{}, error) {
 	if s.err != nil {
 		return nil, s.err
 	}
-	select {
-	case <-s.kill:
-		return nil, ErrKillTimeout
-	default:
-		return s.dataOutput, nil
-	}
+	return s.dataOutput, nil
 }
 
 func (s *Session) ClearJSON() {
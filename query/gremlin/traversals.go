@@ -26,18 +26,33 @@ func (wk *worker) embedTraversals(env *otto.Otto, obj *otto.Object) {
 	obj.Set("Out", wk.gremlinFunc("out", obj, env))
 	obj.Set("Is", wk.gremlinFunc("is", obj, env))
 	obj.Set("Both", wk.gremlinFunc("both", obj, env))
+	obj.Set("InContext", wk.gremlinFunc("incontext", obj, env))
+	obj.Set("OutPredicates", wk.gremlinFunc("outpredicates", obj, env))
+	obj.Set("InPredicates", wk.gremlinFunc("inpredicates", obj, env))
+	obj.Set("Subjects", wk.gremlinFunc("subjects", obj, env))
+	obj.Set("Objects", wk.gremlinFunc("objects", obj, env))
+	obj.Set("LabelsOf", wk.gremlinFunc("labelsof", obj, env))
 	obj.Set("Follow", wk.gremlinFunc("follow", obj, env))
 	obj.Set("FollowR", wk.gremlinFollowR("followr", obj, env))
+	obj.Set("FollowRecursive", wk.gremlinFunc("followrecursive", obj, env))
 	obj.Set("And", wk.gremlinFunc("and", obj, env))
 	obj.Set("Intersect", wk.gremlinFunc("and", obj, env))
 	obj.Set("Union", wk.gremlinFunc("or", obj, env))
 	obj.Set("Or", wk.gremlinFunc("or", obj, env))
+	obj.Set("Except", wk.gremlinFunc("except", obj, env))
 	obj.Set("Back", wk.gremlinBack("back", obj, env))
 	obj.Set("Tag", wk.gremlinFunc("tag", obj, env))
 	obj.Set("As", wk.gremlinFunc("tag", obj, env))
 	obj.Set("Has", wk.gremlinFunc("has", obj, env))
 	obj.Set("Save", wk.gremlinFunc("save", obj, env))
 	obj.Set("SaveR", wk.gremlinFunc("saver", obj, env))
+	obj.Set("Filter", wk.gremlinFunc("filter", obj, env))
+	obj.Set("Limit", wk.gremlinFunc("limit", obj, env))
+	obj.Set("Skip", wk.gremlinFunc("skip", obj, env))
+	obj.Set("Unique", wk.gremlinFunc("unique", obj, env))
+	obj.Set("Order", wk.gremlinFunc("order", obj, env))
+	obj.Set("OrderBy", wk.gremlinFunc("orderby", obj, env))
+	obj.Set("ShortestPath", wk.gremlinFunc("shortestpath", obj, env))
 }
 
 func (wk *worker) gremlinFunc(kind string, prev *otto.Object, env *otto.Otto) func(otto.FunctionCall) otto.Value {
@@ -153,6 +168,14 @@ func reverseGremlinChainHelper(env *otto.Otto, chain *otto.Object, newBase *otto
 		newKind = "out"
 	case "out":
 		newKind = "in"
+	case "outpredicates":
+		newKind = "subjects"
+	case "subjects":
+		newKind = "outpredicates"
+	case "inpredicates":
+		newKind = "objects"
+	case "objects":
+		newKind = "inpredicates"
 	default:
 		newKind = kind
 	}
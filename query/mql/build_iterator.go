@@ -105,13 +105,56 @@ func (q *Query) buildIteratorTreeInternal(query interface{}, path Path) (it grap
 func (q *Query) buildIteratorTreeMapInternal(query map[string]interface{}, path Path) (graph.Iterator, error) {
 	it := iterator.NewAnd()
 	it.AddSubIterator(q.ses.qs.NodesAllIterator())
-	var err error
-	err = nil
 	outputStructure := make(map[string]interface{})
+	var (
+		limit     int64
+		sorted    bool
+		sortTag   string
+		collation iterator.Collation
+	)
+	labels, err := labelsOf(query["@label"], path)
+	if err != nil {
+		return nil, err
+	}
 	for key, subquery := range query {
+		if key == "@label" {
+			continue
+		}
+		if key == "limit" {
+			if path != NewPath() {
+				return nil, fmt.Errorf("limit at location %s is not at the top level", path.DisplayString())
+			}
+			n, ok := subquery.(float64)
+			if !ok || n < 0 || math.Floor(n) != n {
+				return nil, fmt.Errorf("limit at location %s is not a count", path.DisplayString())
+			}
+			limit = int64(n)
+			continue
+		}
+		if key == "return" {
+			if path != NewPath() {
+				return nil, fmt.Errorf("return at location %s is not at the top level", path.DisplayString())
+			}
+			if subquery != "count" {
+				return nil, fmt.Errorf("return at location %s is not \"count\"", path.DisplayString())
+			}
+			q.count = true
+			continue
+		}
+		if key == "sort" {
+			if path != NewPath() {
+				return nil, fmt.Errorf("sort at location %s is not at the top level", path.DisplayString())
+			}
+			sortTag, collation, err = sortOf(query, subquery, path)
+			if err != nil {
+				return nil, err
+			}
+			sorted = true
+			continue
+		}
 		optional := false
-		outputStructure[key] = nil
 		reverse := false
+		negate := false
 		pred := key
 		if strings.HasPrefix(pred, "@") {
 			i := strings.Index(pred, ":")
@@ -119,6 +162,12 @@ func (q *Query) buildIteratorTreeMapInternal(query map[string]interface{}, path
 				pred = pred[(i + 1):]
 			}
 		}
+		if strings.HasPrefix(pred, "-") {
+			negate = true
+			pred = strings.TrimPrefix(pred, "-")
+		} else {
+			outputStructure[key] = nil
+		}
 		if strings.HasPrefix(pred, "!") {
 			reverse = true
 			pred = strings.TrimPrefix(pred, "!")
@@ -126,7 +175,7 @@ func (q *Query) buildIteratorTreeMapInternal(query map[string]interface{}, path
 
 		// Other special constructs here
 		var subit graph.Iterator
-		if key == "id" {
+		if key == "id" || key == "-id" {
 			subit, optional, err = q.buildIteratorTreeInternal(subquery, path.Follow(key))
 			if err != nil {
 				return nil, err
@@ -141,6 +190,9 @@ func (q *Query) buildIteratorTreeMapInternal(query map[string]interface{}, path
 			predFixed := q.ses.qs.FixedIterator()
 			predFixed.Add(q.ses.qs.ValueOf(pred))
 			subAnd.AddSubIterator(iterator.NewLinksTo(q.ses.qs, predFixed, quad.Predicate))
+			if labels != nil {
+				subAnd.AddSubIterator(q.buildLabels(labels))
+			}
 			if reverse {
 				lto := iterator.NewLinksTo(q.ses.qs, builtIt, quad.Subject)
 				subAnd.AddSubIterator(lto)
@@ -153,7 +205,11 @@ func (q *Query) buildIteratorTreeMapInternal(query map[string]interface{}, path
 				subit = hasa
 			}
 		}
-		if optional {
+		if negate {
+			// Whatever matches is excluded, so there is nothing optional
+			// about it, and nothing to return.
+			it.AddSubIterator(iterator.NewNot(q.ses.qs.NodesAllIterator(), subit))
+		} else if optional {
 			it.AddSubIterator(iterator.NewOptional(subit))
 		} else {
 			it.AddSubIterator(subit)
@@ -163,9 +219,87 @@ func (q *Query) buildIteratorTreeMapInternal(query map[string]interface{}, path
 		return nil, err
 	}
 	q.queryStructure[path] = outputStructure
+	if path == NewPath() {
+		// The results are a set of objects, so find (and count) each once, then
+		// every way it matches.
+		var lead graph.Iterator = iterator.NewUnique(it.Clone(), q.ses.qs)
+		if sorted {
+			lead = iterator.NewOrder(lead, q.ses.qs, sortTag, collation)
+		}
+		if limit > 0 {
+			lead = iterator.NewLimit(lead, limit)
+		}
+		and := iterator.NewAnd()
+		and.AddSubIterator(lead)
+		and.AddSubIterator(it)
+		return and, nil
+	}
 	return it, nil
 }
 
+// labelsOf reads a label directive, which keeps the links from an object to
+// those in a label, as `"@label": "name"`, or in any of several, as `"@label":
+// ["one", "two"]`. It returns nil if there's no directive.
+func labelsOf(directive interface{}, path Path) ([]string, error) {
+	switch t := directive.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{t}, nil
+	case []interface{}:
+		labels := make([]string, 0, len(t))
+		for _, v := range t {
+			label, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("label at location %s is not a string", path.DisplayString())
+			}
+			labels = append(labels, label)
+		}
+		return labels, nil
+	}
+	return nil, fmt.Errorf("label at location %s is not a string or a list of them", path.DisplayString())
+}
+
+// buildLabels returns an iterator over the quads in any of the labels.
+func (q *Query) buildLabels(labels []string) graph.Iterator {
+	return iterator.NewLinksTo(q.ses.qs, iterator.FixedLabels(q.ses.qs, labels), quad.Label)
+}
+
+// sortOf reads a sort directive, which names the key of the query to sort by,
+// as `"sort": "name"`, optionally with a collation, as `"sort": {"age":
+// "numeric"}`. It returns the tag to sort by, which is empty for "id".
+func sortOf(query map[string]interface{}, directive interface{}, path Path) (string, iterator.Collation, error) {
+	var key, name string
+	switch t := directive.(type) {
+	case string:
+		key = t
+	case map[string]interface{}:
+		if len(t) != 1 {
+			return "", 0, fmt.Errorf("sort at location %s has more than one key", path.DisplayString())
+		}
+		for k, v := range t {
+			key = k
+			var ok bool
+			if name, ok = v.(string); !ok {
+				return "", 0, fmt.Errorf("sort collation at location %s is not a string", path.DisplayString())
+			}
+		}
+	default:
+		return "", 0, fmt.Errorf("sort at location %s is not a key", path.DisplayString())
+	}
+	c, err := iterator.ParseCollation(name)
+	if err != nil {
+		return "", 0, fmt.Errorf("sort at location %s: %v", path.DisplayString(), err)
+	}
+	if key == "id" {
+		return "", c, nil
+	}
+	if _, ok := query[key]; !ok || key == "sort" || key == "limit" || key == "@label" || strings.HasPrefix(key, "-") {
+		return "", 0, fmt.Errorf("sort key %q at location %s is not in the query", key, path.DisplayString())
+	}
+	return string(path.Follow(key)), c, nil
+}
+
 type byRecordLength []ResultPath
 
 func (p byRecordLength) Len() int {
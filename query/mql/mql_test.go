@@ -15,6 +15,7 @@
 package mql
 
 import (
+	"context"
 	"encoding/json"
 	"reflect"
 	"testing"
@@ -22,6 +23,7 @@ import (
 	"github.com/google/cayley/graph"
 	_ "github.com/google/cayley/graph/memstore"
 	"github.com/google/cayley/quad"
+	"github.com/google/cayley/query"
 	_ "github.com/google/cayley/writer"
 )
 
@@ -165,17 +167,102 @@ var testQueries = []struct {
 			]
 		`,
 	},
+	{
+		message: "get a limited list",
+		query:   `[{"id": null, "status": "cool", "limit": 2}]`,
+		expect: `
+			[
+				{"id": "B", "status": "cool"},
+				{"id": "D", "status": "cool"}
+			]
+		`,
+	},
+	{
+		message: "get a limited list of objects matching many ways",
+		query:   `[{"id": null, "status": "cool", "follows": [{"id": null}], "limit": 2}]`,
+		expect: `
+			[
+				{"id": "B", "status": "cool", "follows": [{"id": "F"}]},
+				{"id": "D", "status": "cool", "follows": [{"id": "B"}, {"id": "G"}]}
+			]
+		`,
+	},
+	{
+		message: "get objects without a predicate value",
+		query:   `[{"id": null, "follows": "B", "-status": "cool"}]`,
+		expect: `
+			[
+				{"id": "A", "follows": "B"},
+				{"id": "C", "follows": "B"}
+			]
+		`,
+	},
+	{
+		message: "get objects without a reverse predicate",
+		query:   `[{"id": null, "follows": "F", "-!follows": null}]`,
+		expect: `
+			[
+				{"id": "E", "follows": "F"}
+			]
+		`,
+	},
+	{
+		message: "get objects except an id",
+		query:   `[{"id": null, "status": "cool", "-id": "B"}]`,
+		expect: `
+			[
+				{"id": "D", "status": "cool"},
+				{"id": "G", "status": "cool"}
+			]
+		`,
+	},
+	{
+		message: "get objects by predicates in a label",
+		query:   `[{"id": null, "@label": "status_graph", "status": "cool"}]`,
+		expect: `
+			[
+				{"id": "B", "status": "cool"},
+				{"id": "D", "status": "cool"},
+				{"id": "G", "status": "cool"}
+			]
+		`,
+	},
+	{
+		message: "get no objects by predicates outside a label",
+		query:   `[{"id": null, "@label": "status_graph", "follows": "B"}]`,
+		expect:  `[]`,
+	},
+	{
+		message: "get objects by predicates in any of several labels",
+		query:   `[{"id": null, "@label": ["other_graph", "status_graph"], "status": null, "follows": {"id": null, "status": "cool"}}]`,
+		expect:  `[]`,
+	},
+	{
+		message: "get objects by predicates in a nested label",
+		query:   `[{"id": null, "follows": {"id": "B", "@label": ["other_graph", "status_graph"], "status": null}}]`,
+		expect: `
+			[
+				{"id": "A", "follows": {"id": "B", "status": "cool"}},
+				{"id": "C", "follows": {"id": "B", "status": "cool"}},
+				{"id": "D", "follows": {"id": "B", "status": "cool"}}
+			]
+		`,
+	},
 }
 
 func runQuery(g []quad.Quad, query string) interface{} {
+	result, _ := runQueryContext(context.Background(), g, query)
+	return result
+}
+
+func runQueryContext(ctx context.Context, g []quad.Quad, query string) (interface{}, error) {
 	s := makeTestSession(g)
 	c := make(chan interface{}, 5)
-	go s.ExecInput(query, c, -1)
+	go s.ExecInput(ctx, query, c, -1)
 	for result := range c {
 		s.BuildJSON(result)
 	}
-	result, _ := s.GetJSON()
-	return result
+	return s.GetJSON()
 }
 
 func TestMQL(t *testing.T) {
@@ -192,3 +279,151 @@ func TestMQL(t *testing.T) {
 		}
 	}
 }
+
+func TestKill(t *testing.T) {
+	q := `[{"id": null, "follows": {"id": null}}]`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := runQueryContext(ctx, simpleGraph, q); err != query.ErrKilled {
+		t.Errorf("Unexpected error for a canceled query, got:%v expect:%v", err, query.ErrKilled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if _, err := runQueryContext(ctx, simpleGraph, q); err != query.ErrKillTimeout {
+		t.Errorf("Unexpected error for a timed out query, got:%v expect:%v", err, query.ErrKillTimeout)
+	}
+}
+
+var ageGraph = []quad.Quad{
+	{"alice", "age", "30", ""},
+	{"bob", "age", "4", ""},
+	{"carol", "age", "100", ""},
+	{"dave", "age", "unknown", ""},
+	{"alice", "knows", "bob", ""},
+	{"alice", "knows", "carol", ""},
+	{"bob", "knows", "carol", ""},
+}
+
+var directiveTests = []struct {
+	message string
+	query   string
+	expect  string
+	err     bool
+}{
+	{
+		message: "sort by a key, with missing values last",
+		query:   `[{"id": null, "age": null, "sort": "age"}]`,
+		expect: `
+			[
+				{"id": "carol", "age": "100"},
+				{"id": "alice", "age": "30"},
+				{"id": "bob", "age": "4"},
+				{"id": "dave", "age": "unknown"},
+				{"id": "age", "age": null},
+				{"id": "30", "age": null},
+				{"id": "4", "age": null},
+				{"id": "100", "age": null},
+				{"id": "unknown", "age": null},
+				{"id": "knows", "age": null}
+			]
+		`,
+	},
+	{
+		message: "sort by a key as numbers, then limit",
+		query:   `[{"id": null, "age": null, "sort": {"age": "numeric"}, "limit": 2}]`,
+		expect: `
+			[
+				{"id": "bob", "age": "4"},
+				{"id": "alice", "age": "30"}
+			]
+		`,
+	},
+	{
+		message: "sort by id",
+		query:   `[{"id": null, "age": {"id": null}, "sort": "id"}]`,
+		expect: `
+			[
+				{"id": "alice", "age": {"id": "30"}},
+				{"id": "bob", "age": {"id": "4"}},
+				{"id": "carol", "age": {"id": "100"}},
+				{"id": "dave", "age": {"id": "unknown"}}
+			]
+		`,
+	},
+	{
+		message: "reject a sort key missing from the query",
+		query:   `[{"id": null, "sort": "age"}]`,
+		err:     true,
+	},
+	{
+		message: "reject an unknown collation",
+		query:   `[{"id": null, "age": null, "sort": {"age": "roman"}}]`,
+		err:     true,
+	},
+	{
+		message: "reject a nested sort",
+		query:   `[{"id": null, "age": {"id": null, "sort": "id"}}]`,
+		err:     true,
+	},
+	{
+		message: "reject a nested limit",
+		query:   `[{"id": null, "knows": [{"id": null, "limit": 1}]}]`,
+		err:     true,
+	},
+	{
+		message: "count the objects",
+		query:   `[{"id": null, "age": null, "return": "count"}]`,
+		expect:  `[10]`,
+	},
+	{
+		message: "count the objects matching many ways",
+		query:   `[{"id": null, "knows": {"id": null}, "return": "count"}]`,
+		expect:  `[2]`,
+	},
+	{
+		message: "count a limited list of objects",
+		query:   `[{"id": null, "age": {"id": null}, "limit": 3, "return": "count"}]`,
+		expect:  `[3]`,
+	},
+	{
+		message: "count no objects",
+		query:   `[{"id": null, "age": "1000", "return": "count"}]`,
+		expect:  `[0]`,
+	},
+	{
+		message: "reject returning anything but a count",
+		query:   `[{"id": null, "return": "sum"}]`,
+		err:     true,
+	},
+	{
+		message: "reject a label which is not a string",
+		query:   `[{"id": null, "@label": 1, "age": null}]`,
+		err:     true,
+	},
+	{
+		message: "reject sorting by a label",
+		query:   `[{"id": null, "@label": "g", "age": null, "sort": "@label"}]`,
+		err:     true,
+	},
+}
+
+func TestDirectives(t *testing.T) {
+	for _, test := range directiveTests {
+		result, err := runQueryContext(context.Background(), ageGraph, test.query)
+		if test.err {
+			if err == nil {
+				t.Errorf("Failed to %s, got no error", test.message)
+			}
+			continue
+		}
+		b, _ := json.MarshalIndent(result, "", " ")
+		var got, expect interface{}
+		json.Unmarshal(b, &got)
+		json.Unmarshal([]byte(test.expect), &expect)
+		if err != nil || !reflect.DeepEqual(got, expect) {
+			t.Errorf("Failed to %s, got: %s (error %v) expected: %s", test.message, b, err, test.expect)
+		}
+	}
+}
@@ -34,7 +34,10 @@ type Query struct {
 	queryResult    map[ResultPath]map[string]interface{}
 	results        []interface{}
 	resultOrder    []string
-	err            error
+	// count is set when the query asks for the number of objects, rather than
+	// the objects themselves.
+	count bool
+	err   error
 }
 
 func (q *Query) isError() bool {
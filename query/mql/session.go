@@ -15,6 +15,7 @@
 package mql
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sort"
@@ -30,6 +31,7 @@ type Session struct {
 	qs           graph.QuadStore
 	currentQuery *Query
 	debug        bool
+	explain      *query.Explain
 }
 
 func NewSession(qs graph.QuadStore) *Session {
@@ -42,6 +44,10 @@ func (s *Session) ToggleDebug() {
 	s.debug = !s.debug
 }
 
+func (s *Session) SetExplain(e *query.Explain) {
+	s.explain = e
+}
+
 func (s *Session) GetQuery(input string, out chan map[string]interface{}) {
 	defer close(out)
 	var mqlQuery interface{}
@@ -71,7 +77,7 @@ func (s *Session) InputParses(input string) (query.ParseResult, error) {
 	return query.Parsed, nil
 }
 
-func (s *Session) ExecInput(input string, c chan interface{}, _ int) {
+func (s *Session) ExecInput(ctx context.Context, input string, c chan interface{}, _ int) {
 	defer close(c)
 	var mqlQuery interface{}
 	err := json.Unmarshal([]byte(input), &mqlQuery)
@@ -92,43 +98,140 @@ func (s *Session) ExecInput(input string, c chan interface{}, _ int) {
 			glog.Infof("%s", b)
 		}
 	}
+	if !s.explain.Start(it) {
+		it.Close()
+		return
+	}
+	defer s.explain.Finish()
+	graph.SetKill(it, ctx.Done())
+	if s.currentQuery.count {
+		s.count(ctx, it, c)
+		return
+	}
 	for graph.Next(it) {
-		tags := make(map[string]graph.Value)
-		it.TagResults(tags)
-		c <- tags
+		if ctx.Err() != nil {
+			break
+		}
+		obj := &object{q: s.currentQuery}
+		obj.add(it)
 		for it.NextPath() == true {
-			tags := make(map[string]graph.Value)
-			it.TagResults(tags)
-			c <- tags
+			if ctx.Err() != nil {
+				break
+			}
+			obj.add(it)
+		}
+		if ctx.Err() != nil {
+			// Don't send an object with only some of its paths.
+			break
+		}
+		c <- obj
+	}
+	if err := query.KillError(ctx); err != nil {
+		s.currentQuery.err = err
+		c <- err
+	}
+}
+
+// An object is a result of the query: the tags of each path by which one
+// top-level object matches. It can be built into JSON on its own, so an object
+// is never split between pages or lines of results.
+type object struct {
+	q    *Query
+	tags []map[string]graph.Value
+}
+
+func (o *object) add(it graph.Iterator) {
+	tags := make(map[string]graph.Value)
+	it.TagResults(tags)
+	o.tags = append(o.tags, tags)
+}
+
+// count sends the number of objects the query matches. Each object is Next()ed
+// once, so their paths needn't be run.
+func (s *Session) count(ctx context.Context, it graph.Iterator, c chan interface{}) {
+	n, exact := it.Size()
+	if !exact {
+		n = 0
+		for graph.Next(it) {
+			if ctx.Err() != nil {
+				break
+			}
+			n++
 		}
 	}
+	if err := query.KillError(ctx); err != nil {
+		s.currentQuery.err = err
+		c <- err
+		return
+	}
+	c <- n
 }
 
 func (s *Session) ToText(result interface{}) string {
-	tags := result.(map[string]graph.Value)
-	out := fmt.Sprintln("****")
-	tagKeys := make([]string, len(tags))
-	s.currentQuery.treeifyResult(tags)
-	s.currentQuery.buildResults()
-	r, _ := json.MarshalIndent(s.currentQuery.results, "", " ")
-	fmt.Println(string(r))
-	i := 0
-	for k := range tags {
-		tagKeys[i] = string(k)
-		i++
-	}
-	sort.Strings(tagKeys)
-	for _, k := range tagKeys {
-		if k == "$_" {
-			continue
+	if err, ok := result.(error); ok {
+		return fmt.Sprintf("Error: %v\n", err)
+	}
+	if n, ok := result.(int64); ok {
+		return fmt.Sprintf("Count: %d\n", n)
+	}
+	var out string
+	for _, tags := range result.(*object).tags {
+		out += fmt.Sprintln("****")
+		tagKeys := make([]string, len(tags))
+		s.currentQuery.treeifyResult(tags)
+		s.currentQuery.buildResults()
+		r, _ := json.MarshalIndent(s.currentQuery.results, "", " ")
+		fmt.Println(string(r))
+		i := 0
+		for k := range tags {
+			tagKeys[i] = string(k)
+			i++
+		}
+		sort.Strings(tagKeys)
+		for _, k := range tagKeys {
+			if k == "$_" {
+				continue
+			}
+			out += fmt.Sprintf("%s : %s\n", k, s.qs.NameOf(tags[k]))
 		}
-		out += fmt.Sprintf("%s : %s\n", k, s.qs.NameOf(tags[k]))
 	}
 	return out
 }
 
 func (s *Session) BuildJSON(result interface{}) {
-	s.currentQuery.treeifyResult(result.(map[string]graph.Value))
+	switch t := result.(type) {
+	case error:
+		return
+	case int64:
+		s.currentQuery.results = append(s.currentQuery.results, t)
+		return
+	}
+	for _, tags := range result.(*object).tags {
+		s.currentQuery.treeifyResult(tags)
+	}
+}
+
+// ResultJSON builds a result into its object apart from the session's
+// results, so it can be done while the query runs.
+func (s *Session) ResultJSON(result interface{}) ([]interface{}, error) {
+	switch t := result.(type) {
+	case error:
+		return nil, t
+	case int64:
+		return []interface{}{t}, nil
+	}
+	obj := result.(*object)
+	q := &Query{
+		ses:            obj.q.ses,
+		isRepeated:     obj.q.isRepeated,
+		queryStructure: obj.q.queryStructure,
+		queryResult:    map[ResultPath]map[string]interface{}{"": make(map[string]interface{})},
+	}
+	for _, tags := range obj.tags {
+		q.treeifyResult(tags)
+	}
+	q.buildResults()
+	return q.results, nil
 }
 
 func (s *Session) GetJSON() ([]interface{}, error) {
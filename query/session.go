@@ -16,6 +16,33 @@ package query
 
 // Defines the graph session interface general to all query languages.
 
+import (
+	"context"
+	"errors"
+
+	"github.com/google/cayley/graph"
+)
+
+var (
+	// ErrKillTimeout is reported by a query that ran out of time.
+	ErrKillTimeout = errors.New("query timed out")
+	// ErrKilled is reported by a query that was abandoned before it
+	// finished, for instance because the client went away.
+	ErrKilled = errors.New("query killed")
+)
+
+// KillError returns the error that a query running under ctx should report
+// once ctx is done, or nil if it is not.
+func KillError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return ErrKillTimeout
+	}
+	return ErrKilled
+}
+
 type ParseResult int
 
 const (
@@ -27,7 +54,9 @@ const (
 type Session interface {
 	// Return whether the string is a valid expression.
 	InputParses(string) (ParseResult, error)
-	ExecInput(string, chan interface{}, int)
+	// Runs the query and returns individual results on the channel, giving
+	// up once the context is done.
+	ExecInput(context.Context, string, chan interface{}, int)
 	ToText(interface{}) string
 	ToggleDebug()
 }
@@ -36,10 +65,64 @@ type HTTP interface {
 	// Return whether the string is a valid expression.
 	InputParses(string) (ParseResult, error)
 	// Runs the query and returns individual results on the channel.
-	ExecInput(string, chan interface{}, int)
+	ExecInput(context.Context, string, chan interface{}, int)
 	GetQuery(string, chan map[string]interface{})
 	BuildJSON(interface{})
 	GetJSON() ([]interface{}, error)
 	ClearJSON()
+	// Returns the JSON of a single result, or the error it reports, without
+	// touching the results the session has built, so that it can be called
+	// while the query still runs.
+	ResultJSON(interface{}) ([]interface{}, error)
 	ToggleDebug()
 }
+
+// An Explainer is a session which can explain the iterators it runs.
+type Explainer interface {
+	// SetExplain has the session add each iterator it runs to e, until it is
+	// set to nil.
+	SetExplain(e *Explain)
+}
+
+// Explain gathers the optimized iterators a session runs for a query. Unless
+// it profiles them, the session doesn't run them at all.
+type Explain struct {
+	Profile   bool                `json:"profile"`
+	Iterators []graph.Explanation `json:"iterators"`
+
+	profiles map[int]*graph.Profile
+}
+
+func NewExplain(profile bool) *Explain {
+	return &Explain{
+		Profile:   profile,
+		Iterators: make([]graph.Explanation, 0),
+		profiles:  make(map[int]*graph.Profile),
+	}
+}
+
+// Start adds an optimized iterator a session is about to run, and returns
+// whether it should go on and run it. If e is nil, it always should.
+func (e *Explain) Start(it graph.Iterator) bool {
+	if e == nil {
+		return true
+	}
+	e.Iterators = append(e.Iterators, graph.Explain(it))
+	if !e.Profile {
+		return false
+	}
+	e.profiles[len(e.Iterators)-1] = graph.StartProfile(it)
+	return true
+}
+
+// Finish stops profiling the iterators a session has run.
+func (e *Explain) Finish() {
+	if e == nil {
+		return
+	}
+	for i, p := range e.profiles {
+		p.Stop()
+		p.Fill(&e.Iterators[i])
+		delete(e.profiles, i)
+	}
+}
@@ -17,6 +17,7 @@ package sexp
 // Defines a running session of the sexp query language.
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -27,8 +28,9 @@ import (
 )
 
 type Session struct {
-	qs    graph.QuadStore
-	debug bool
+	qs      graph.QuadStore
+	debug   bool
+	explain *query.Explain
 }
 
 func NewSession(qs graph.QuadStore) *Session {
@@ -41,6 +43,10 @@ func (s *Session) ToggleDebug() {
 	s.debug = !s.debug
 }
 
+func (s *Session) SetExplain(e *query.Explain) {
+	s.explain = e
+}
+
 func (s *Session) InputParses(input string) (query.ParseResult, error) {
 	var parenDepth int
 	for i, x := range input {
@@ -67,7 +73,7 @@ func (s *Session) InputParses(input string) (query.ParseResult, error) {
 	return query.ParseFail, errors.New("invalid syntax")
 }
 
-func (s *Session) ExecInput(input string, out chan interface{}, limit int) {
+func (s *Session) ExecInput(ctx context.Context, input string, out chan interface{}, limit int) {
 	it := BuildIteratorTreeForQuery(s.qs, input)
 	newIt, changed := it.Optimize()
 	if changed {
@@ -82,8 +88,16 @@ func (s *Session) ExecInput(input string, out chan interface{}, limit int) {
 			fmt.Printf("%s", b)
 		}
 	}
+	if !s.explain.Start(it) {
+		close(out)
+		return
+	}
+	graph.SetKill(it, ctx.Done())
 	nResults := 0
 	for graph.Next(it) {
+		if ctx.Err() != nil {
+			break
+		}
 		tags := make(map[string]graph.Value)
 		it.TagResults(tags)
 		out <- &tags
@@ -92,6 +106,9 @@ func (s *Session) ExecInput(input string, out chan interface{}, limit int) {
 			break
 		}
 		for it.NextPath() == true {
+			if ctx.Err() != nil {
+				break
+			}
 			tags := make(map[string]graph.Value)
 			it.TagResults(tags)
 			out <- &tags
@@ -101,10 +118,17 @@ func (s *Session) ExecInput(input string, out chan interface{}, limit int) {
 			}
 		}
 	}
+	s.explain.Finish()
+	if err := query.KillError(ctx); err != nil {
+		out <- err
+	}
 	close(out)
 }
 
 func (s *Session) ToText(result interface{}) string {
+	if err, ok := result.(error); ok {
+		return fmt.Sprintf("Error: %v\n", err)
+	}
 	out := fmt.Sprintln("****")
 	tags := result.(map[string]graph.Value)
 	tagKeys := make([]string, len(tags))
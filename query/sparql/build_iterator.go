@@ -0,0 +1,458 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sparql
+
+// Compiles a group graph pattern into iterator trees.
+//
+// Every variable becomes an And iterator tagged with the variable's name.
+// Each triple pattern hanging off that variable is a HasA over an And of
+// LinksTo iterators, one per other position in the triple, recursing into
+// the variables found there. A variable reached a second time (a cycle in
+// the pattern) is bound to a fresh alias tag instead, and the equality is
+// checked on the result rows.
+//
+// OPTIONAL groups and the alternatives of a UNION are built rooted at a
+// variable they share with the enclosing pattern, and hang off that
+// variable's And as an Optional or an Or iterator. FILTERs comparing a
+// variable with a constant become Fixed or Comparison constraints on the
+// variable; anything else is evaluated over the result rows.
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+)
+
+var (
+	errNoSharedVar = errors.New("sparql: OPTIONAL and UNION groups must share a variable with the enclosing pattern")
+	errDisjoint    = errors.New("sparql: OPTIONAL and UNION groups must be connected patterns")
+	errComplex     = errors.New("sparql: only variable-to-constant FILTERs are supported inside OPTIONAL and UNION")
+)
+
+type builder struct {
+	qs graph.QuadStore
+
+	// nodes holds the And iterator for every variable bound so far.
+	nodes map[string]*iterator.And
+	// aliases maps the extra tags used for repeated variables back to the
+	// variable they must equal.
+	aliases map[string]string
+	used    map[*Triple]bool
+	// post holds the filters that are evaluated over result rows.
+	post []Expr
+	n    int
+}
+
+func newBuilder(qs graph.QuadStore) *builder {
+	return &builder{
+		qs:      qs,
+		nodes:   make(map[string]*iterator.And),
+		aliases: make(map[string]string),
+		used:    make(map[*Triple]bool),
+	}
+}
+
+// fixed returns an iterator over the node named by a constant term. As
+// nodes may have been loaded with or without N-Quads decoration, both
+// spellings are tried. A term naming no node matches nothing.
+func (b *builder) fixed(t Term) graph.Iterator {
+	f := b.qs.FixedIterator()
+	names := []string{t.Value, "<" + t.Value + ">"}
+	if t.Literal {
+		names[1] = strconv.Quote(t.Value)
+	}
+	found := false
+	for _, name := range names {
+		v := b.qs.ValueOf(name)
+		if v != nil && b.qs.NameOf(v) == name {
+			f.Add(v)
+			found = true
+		}
+	}
+	if !found {
+		return iterator.NewNull()
+	}
+	return f
+}
+
+// buildComponents builds the top-level group. Parts of the pattern that
+// share no variables are returned as separate iterators, to be joined by
+// the caller.
+func (b *builder) buildComponents(g *Group) ([]graph.Iterator, error) {
+	var its []graph.Iterator
+	scope := make(map[string]bool)
+	for i := range g.Triples {
+		t := &g.Triples[i]
+		if b.used[t] {
+			continue
+		}
+		its = append(its, b.buildRoot(g, t, scope))
+	}
+	for _, alts := range g.Unions {
+		if v, ok := b.sharedVar(alts...); ok {
+			err := b.attachUnion(v, alts)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		it, err := b.buildFreeUnion(alts)
+		if err != nil {
+			return nil, err
+		}
+		its = append(its, it)
+	}
+	for _, opt := range g.Optionals {
+		if err := b.attachOptional(opt); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range g.Filters {
+		for _, e := range conjuncts(f) {
+			if !b.constrain(e, scope) {
+				b.post = append(b.post, e)
+			}
+		}
+	}
+	return its, nil
+}
+
+// buildRoot builds the connected part of the group reachable from the
+// triple t.
+func (b *builder) buildRoot(g *Group, t *Triple, scope map[string]bool) graph.Iterator {
+	for _, term := range []Term{t.Subject, t.Object, t.Predicate} {
+		if term.IsVar() {
+			return b.buildNode(g, term, scope)
+		}
+	}
+	// No variables at all; this is an existence check.
+	b.used[t] = true
+	and := iterator.NewAnd()
+	and.AddSubIterator(iterator.NewLinksTo(b.qs, b.fixed(t.Predicate), quad.Predicate))
+	and.AddSubIterator(iterator.NewLinksTo(b.qs, b.fixed(t.Object), quad.Object))
+	and.AddSubIterator(iterator.NewLinksTo(b.qs, b.fixed(t.Subject), quad.Subject))
+	return iterator.NewHasA(b.qs, and, quad.Subject)
+}
+
+func (b *builder) buildNode(g *Group, t Term, scope map[string]bool) graph.Iterator {
+	if !t.IsVar() {
+		return b.fixed(t)
+	}
+	if _, ok := b.nodes[t.Var]; ok {
+		b.n++
+		alias := fmt.Sprintf("%s#%d", t.Var, b.n)
+		b.aliases[alias] = t.Var
+		all := b.qs.NodesAllIterator()
+		all.Tagger().Add(alias)
+		return all
+	}
+	and := iterator.NewAnd()
+	and.Tagger().Add(t.Var)
+	and.AddSubIterator(b.qs.NodesAllIterator())
+	b.nodes[t.Var] = and
+	scope[t.Var] = true
+	b.expand(g, t, and, scope)
+	return and
+}
+
+// expand adds every unused triple in g that mentions the variable t to the
+// variable's And iterator.
+func (b *builder) expand(g *Group, t Term, and *iterator.And, scope map[string]bool) {
+	for i := range g.Triples {
+		tr := &g.Triples[i]
+		if b.used[tr] {
+			continue
+		}
+		terms := []Term{tr.Subject, tr.Predicate, tr.Object}
+		dirs := []quad.Direction{quad.Subject, quad.Predicate, quad.Object}
+		at := -1
+		for j, term := range terms {
+			if term.Var == t.Var {
+				at = j
+				break
+			}
+		}
+		if at < 0 {
+			continue
+		}
+		b.used[tr] = true
+		sub := iterator.NewAnd()
+		for j, term := range terms {
+			if j == at {
+				continue
+			}
+			sub.AddSubIterator(iterator.NewLinksTo(b.qs, b.buildNode(g, term, scope), dirs[j]))
+		}
+		and.AddSubIterator(iterator.NewHasA(b.qs, sub, dirs[at]))
+	}
+}
+
+// sharedVar finds a bound variable used by the triples of every group.
+func (b *builder) sharedVar(groups ...*Group) (string, bool) {
+	for _, v := range groupVars(groups[0]) {
+		if _, ok := b.nodes[v]; !ok {
+			continue
+		}
+		shared := true
+		for _, g := range groups {
+			if !mentions(g, v) {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// buildAt builds the group g rooted at the bound variable v, into a fresh
+// And iterator that stands in for v while g is being built.
+func (b *builder) buildAt(g *Group, v string) (graph.Iterator, error) {
+	outer := b.nodes[v]
+	defer func() { b.nodes[v] = outer }()
+
+	and := iterator.NewAnd()
+	b.nodes[v] = and
+	scope := map[string]bool{v: true}
+	b.expand(g, Term{Var: v}, and, scope)
+	for i := range g.Triples {
+		if !b.used[&g.Triples[i]] {
+			return nil, errDisjoint
+		}
+	}
+	for _, alts := range g.Unions {
+		u, ok := b.sharedVar(alts...)
+		if !ok {
+			return nil, errNoSharedVar
+		}
+		if err := b.attachUnion(u, alts); err != nil {
+			return nil, err
+		}
+	}
+	for _, opt := range g.Optionals {
+		if err := b.attachOptional(opt); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range g.Filters {
+		for _, e := range conjuncts(f) {
+			if !b.constrain(e, scope) {
+				return nil, errComplex
+			}
+		}
+	}
+	if len(and.SubIterators()) == 0 {
+		and.AddSubIterator(b.qs.NodesAllIterator())
+	}
+	return and, nil
+}
+
+func (b *builder) attachOptional(g *Group) error {
+	v, ok := b.sharedVar(g)
+	if !ok {
+		return errNoSharedVar
+	}
+	it, err := b.buildAt(g, v)
+	if err != nil {
+		return err
+	}
+	b.nodes[v].AddSubIterator(iterator.NewOptional(it))
+	return nil
+}
+
+func (b *builder) attachUnion(v string, alts []*Group) error {
+	it, err := b.buildUnion(alts, func(g *Group) (graph.Iterator, error) {
+		return b.buildAt(g, v)
+	})
+	if err != nil {
+		return err
+	}
+	b.nodes[v].AddSubIterator(it)
+	return nil
+}
+
+// buildFreeUnion builds a UNION that shares nothing with the rest of the
+// pattern, each alternative rooted wherever is convenient.
+func (b *builder) buildFreeUnion(alts []*Group) (graph.Iterator, error) {
+	return b.buildUnion(alts, func(g *Group) (graph.Iterator, error) {
+		for _, v := range groupVars(g) {
+			if _, ok := b.nodes[v]; ok {
+				return nil, errNoSharedVar
+			}
+		}
+		if len(g.Triples) == 0 {
+			return nil, errDisjoint
+		}
+		n := len(b.post)
+		its, err := b.buildComponents(g)
+		if err != nil {
+			return nil, err
+		}
+		if len(its) != 1 {
+			return nil, errDisjoint
+		}
+		if len(b.post) != n {
+			return nil, errComplex
+		}
+		return its[0], nil
+	})
+}
+
+// buildUnion builds each alternative with the variables bound by the
+// previous alternatives forgotten, and ORs them together.
+func (b *builder) buildUnion(alts []*Group, build func(*Group) (graph.Iterator, error)) (graph.Iterator, error) {
+	saved := make(map[string]*iterator.And)
+	for k, v := range b.nodes {
+		saved[k] = v
+	}
+	bound := make(map[string]*iterator.And)
+	or := iterator.NewOr()
+	for _, g := range alts {
+		b.nodes = make(map[string]*iterator.And)
+		for k, v := range saved {
+			b.nodes[k] = v
+		}
+		it, err := build(g)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range b.nodes {
+			if _, ok := saved[k]; !ok {
+				bound[k] = v
+			}
+		}
+		or.AddSubIterator(it)
+	}
+	b.nodes = saved
+	for k, v := range bound {
+		b.nodes[k] = v
+	}
+	return or, nil
+}
+
+// constrain tries to turn a filter into an iterator constraint on a
+// variable in scope, reporting whether it did.
+func (b *builder) constrain(e Expr, scope map[string]bool) bool {
+	bin, ok := e.(*Binary)
+	if !ok {
+		return false
+	}
+	l, lok := bin.Left.(Term)
+	r, rok := bin.Right.(Term)
+	if !lok || !rok {
+		return false
+	}
+	op := bin.Op
+	if !l.IsVar() {
+		l, r = r, l
+		op = flip[op]
+	}
+	if !l.IsVar() || r.IsVar() || !scope[l.Var] {
+		return false
+	}
+	and := b.nodes[l.Var]
+	switch op {
+	case "=":
+		and.AddSubIterator(b.fixed(r))
+	case "<", "<=", ">", ">=":
+		and.AddSubIterator(iterator.NewComparison(b.qs.NodesAllIterator(), operators[op], comparable(r), lexicalStore{b.qs}))
+	default:
+		return false
+	}
+	return true
+}
+
+var flip = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	"<":  ">",
+	"<=": ">=",
+	">":  "<",
+	">=": "<=",
+}
+
+var operators = map[string]iterator.Operator{
+	"<":  iterator.CompareLT,
+	"<=": iterator.CompareLTE,
+	">":  iterator.CompareGT,
+	">=": iterator.CompareGTE,
+}
+
+// comparable returns the value a Comparison iterator should compare nodes
+// against for a constant term.
+func comparable(t Term) interface{} {
+	if t.Literal {
+		if i, err := strconv.ParseInt(t.Value, 10, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(t.Value, 64); err == nil {
+			return f
+		}
+	}
+	return t.Value
+}
+
+// conjuncts splits a filter on its top-level &&s.
+func conjuncts(e Expr) []Expr {
+	if bin, ok := e.(*Binary); ok && bin.Op == "&&" {
+		return append(conjuncts(bin.Left), conjuncts(bin.Right)...)
+	}
+	return []Expr{e}
+}
+
+// groupVars lists the variables in a group in order of appearance.
+func groupVars(g *Group) []string {
+	var vars []string
+	seen := make(map[string]bool)
+	add := func(t Term) {
+		if t.IsVar() && !seen[t.Var] {
+			seen[t.Var] = true
+			vars = append(vars, t.Var)
+		}
+	}
+	for _, t := range g.Triples {
+		add(t.Subject)
+		add(t.Predicate)
+		add(t.Object)
+	}
+	for _, alts := range g.Unions {
+		for _, alt := range alts {
+			for _, v := range groupVars(alt) {
+				add(Term{Var: v})
+			}
+		}
+	}
+	for _, opt := range g.Optionals {
+		for _, v := range groupVars(opt) {
+			add(Term{Var: v})
+		}
+	}
+	return vars
+}
+
+func mentions(g *Group, v string) bool {
+	for _, t := range g.Triples {
+		if t.Subject.Var == v || t.Predicate.Var == v || t.Object.Var == v {
+			return true
+		}
+	}
+	return false
+}
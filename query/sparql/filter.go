@@ -0,0 +1,237 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sparql
+
+// Evaluates the FILTER expressions that could not be turned into iterators
+// against a single solution. As in SPARQL, an expression that errors (for
+// instance by referring to an unbound variable) does not match.
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/cayley/graph"
+)
+
+var errUnbound = errors.New("sparql: unbound variable")
+
+func matches(qs graph.QuadStore, e Expr, tags map[string]graph.Value) bool {
+	v, err := eval(qs, e, tags)
+	if err != nil {
+		return false
+	}
+	return truth(v)
+}
+
+// lexical strips N-Quads decoration from a node name.
+func lexical(name string) string {
+	if len(name) >= 2 && name[0] == '<' && name[len(name)-1] == '>' {
+		return name[1 : len(name)-1]
+	}
+	if len(name) >= 2 && name[0] == '"' {
+		if i := strings.LastIndex(name, "\""); i > 0 {
+			if s, err := strconv.Unquote(name[:i+1]); err == nil {
+				return s
+			}
+		}
+	}
+	return name
+}
+
+// lexicalStore shows the names of nodes as filters see them, without their
+// N-Quads decoration, so that comparisons pushed down into iterators agree
+// with the ones evaluated on each solution. It keeps those comparisons from
+// the underlying store, whose value index holds the decorated names.
+type lexicalStore struct {
+	graph.QuadStore
+}
+
+func (qs lexicalStore) NameOf(v graph.Value) string {
+	return lexical(qs.QuadStore.NameOf(v))
+}
+
+func (qs lexicalStore) OptimizeIterator(it graph.Iterator) (graph.Iterator, bool) {
+	if it.Type() == graph.Comparison {
+		return it, false
+	}
+	return qs.QuadStore.OptimizeIterator(it)
+}
+
+func eval(qs graph.QuadStore, e Expr, tags map[string]graph.Value) (interface{}, error) {
+	switch e := e.(type) {
+	case Term:
+		if !e.IsVar() {
+			return e.Value, nil
+		}
+		v, ok := tags[e.Var]
+		if !ok || v == nil {
+			return nil, errUnbound
+		}
+		return lexical(qs.NameOf(v)), nil
+	case *Not:
+		v, err := eval(qs, e.Expr, tags)
+		if err != nil {
+			return nil, err
+		}
+		return !truth(v), nil
+	case *Binary:
+		return evalBinary(qs, e, tags)
+	case *Call:
+		return evalCall(qs, e, tags)
+	}
+	return nil, errors.New("sparql: bad expression")
+}
+
+func evalBinary(qs graph.QuadStore, e *Binary, tags map[string]graph.Value) (interface{}, error) {
+	l, lerr := eval(qs, e.Left, tags)
+	switch e.Op {
+	case "&&":
+		if lerr == nil && !truth(l) {
+			return false, nil
+		}
+		r, err := eval(qs, e.Right, tags)
+		if err != nil {
+			return nil, err
+		}
+		if lerr != nil {
+			if truth(r) {
+				return nil, lerr
+			}
+			return false, nil
+		}
+		return truth(r), nil
+	case "||":
+		if lerr == nil && truth(l) {
+			return true, nil
+		}
+		r, err := eval(qs, e.Right, tags)
+		if err != nil {
+			return nil, err
+		}
+		if lerr != nil {
+			if truth(r) {
+				return true, nil
+			}
+			return nil, lerr
+		}
+		return truth(r), nil
+	}
+	if lerr != nil {
+		return nil, lerr
+	}
+	r, err := eval(qs, e.Right, tags)
+	if err != nil {
+		return nil, err
+	}
+	c := compare(l, r)
+	switch e.Op {
+	case "=":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return nil, errors.New("sparql: bad operator " + e.Op)
+}
+
+func evalCall(qs graph.QuadStore, e *Call, tags map[string]graph.Value) (interface{}, error) {
+	switch e.Name {
+	case "bound":
+		_, ok := tags[e.Args[0].(Term).Var]
+		return ok, nil
+	case "str":
+		v, err := eval(qs, e.Args[0], tags)
+		if err != nil {
+			return nil, err
+		}
+		return str(v), nil
+	case "regex":
+		var args []string
+		for _, a := range e.Args {
+			v, err := eval(qs, a, tags)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, str(v))
+		}
+		pattern := args[1]
+		if len(args) == 3 && strings.Contains(args[2], "i") {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(args[0]), nil
+	}
+	return nil, errors.New("sparql: unknown function " + e.Name)
+}
+
+func str(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// truth is the effective boolean value of v.
+func truth(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f != 0
+		}
+		return v != "" && v != "false"
+	}
+	return false
+}
+
+// compare orders two values numerically if both are numbers, and as strings
+// otherwise.
+func compare(a, b interface{}) int {
+	as, bs := str(a), str(b)
+	af, aerr := strconv.ParseFloat(as, 64)
+	bf, berr := strconv.ParseFloat(bs, 64)
+	if aerr == nil && berr == nil {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case as < bs:
+		return -1
+	case as > bs:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,251 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sparql
+
+// A small hand-rolled lexer for the subset of SPARQL we understand.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokIRI
+	tokPName
+	tokVar
+	tokString
+	tokNumber
+	tokKeyword
+	tokPunct
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokEOF:
+		return "end of input"
+	case tokIRI:
+		return "<" + t.val + ">"
+	case tokVar:
+		return "?" + t.val
+	case tokString:
+		return fmt.Sprintf("%q", t.val)
+	}
+	return fmt.Sprintf("%q", t.val)
+}
+
+// errIncomplete is returned when the input ended before the query was
+// complete, so that the REPL can ask for more.
+var errIncomplete = errors.New("sparql: incomplete query")
+
+type lexer struct {
+	input []rune
+	pos   int
+}
+
+func lex(input string) ([]token, error) {
+	l := &lexer{input: []rune(input)}
+	var toks []token
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, t)
+		if t.typ == tokEOF {
+			return toks, nil
+		}
+	}
+}
+
+func (l *lexer) peek(n int) rune {
+	if l.pos+n >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+n]
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.input) {
+		r := l.input[l.pos]
+		switch {
+		case unicode.IsSpace(r):
+			l.pos++
+		case r == '#':
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func isNameRune(r rune) bool {
+	return r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpace()
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{typ: tokEOF, pos: start}, nil
+	}
+	r := l.input[l.pos]
+	switch {
+	case r == '<':
+		if iri, ok := l.scanIRI(); ok {
+			return token{typ: tokIRI, val: iri, pos: start}, nil
+		}
+		if l.peek(1) == '=' {
+			l.pos += 2
+			return token{typ: tokPunct, val: "<=", pos: start}, nil
+		}
+		l.pos++
+		return token{typ: tokPunct, val: "<", pos: start}, nil
+	case r == '>':
+		if l.peek(1) == '=' {
+			l.pos += 2
+			return token{typ: tokPunct, val: ">=", pos: start}, nil
+		}
+		l.pos++
+		return token{typ: tokPunct, val: ">", pos: start}, nil
+	case r == '!':
+		if l.peek(1) == '=' {
+			l.pos += 2
+			return token{typ: tokPunct, val: "!=", pos: start}, nil
+		}
+		l.pos++
+		return token{typ: tokPunct, val: "!", pos: start}, nil
+	case r == '&' || r == '|':
+		if l.peek(1) != r {
+			return token{}, fmt.Errorf("sparql: unexpected %q at %d", r, start)
+		}
+		l.pos += 2
+		return token{typ: tokPunct, val: string([]rune{r, r}), pos: start}, nil
+	case strings.ContainsRune("{}().;,*=", r):
+		l.pos++
+		return token{typ: tokPunct, val: string(r), pos: start}, nil
+	case r == '?' || r == '$':
+		l.pos++
+		for l.pos < len(l.input) && isNameRune(l.input[l.pos]) {
+			l.pos++
+		}
+		if l.pos == start+1 {
+			return token{}, fmt.Errorf("sparql: empty variable name at %d", start)
+		}
+		return token{typ: tokVar, val: string(l.input[start+1 : l.pos]), pos: start}, nil
+	case r == '"' || r == '\'':
+		return l.scanString()
+	case unicode.IsDigit(r) || ((r == '-' || r == '+') && unicode.IsDigit(l.peek(1))):
+		l.pos++
+		for l.pos < len(l.input) && (unicode.IsDigit(l.input[l.pos]) || l.input[l.pos] == '.' && unicode.IsDigit(l.peek(1))) {
+			l.pos++
+		}
+		return token{typ: tokNumber, val: string(l.input[start:l.pos]), pos: start}, nil
+	case isNameRune(r) || r == ':':
+		for l.pos < len(l.input) && (isNameRune(l.input[l.pos]) || l.input[l.pos] == ':' || l.input[l.pos] == '.' && isNameRune(l.peek(1))) {
+			l.pos++
+		}
+		word := string(l.input[start:l.pos])
+		if strings.ContainsRune(word, ':') {
+			return token{typ: tokPName, val: word, pos: start}, nil
+		}
+		return token{typ: tokKeyword, val: word, pos: start}, nil
+	}
+	return token{}, fmt.Errorf("sparql: unexpected %q at %d", r, start)
+}
+
+// scanIRI tries to read an IRIREF at the current position. A '<' that is not
+// closed before whitespace is the less-than operator instead.
+func (l *lexer) scanIRI() (string, bool) {
+	for i := l.pos + 1; i < len(l.input); i++ {
+		switch r := l.input[i]; {
+		case r == '>':
+			iri := string(l.input[l.pos+1 : i])
+			l.pos = i + 1
+			return iri, true
+		case unicode.IsSpace(r) || strings.ContainsRune("<\"{}|^`", r):
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func (l *lexer) scanString() (token, error) {
+	start := l.pos
+	quote := l.input[l.pos]
+	l.pos++
+	var val []rune
+	for {
+		if l.pos >= len(l.input) {
+			return token{}, errIncomplete
+		}
+		r := l.input[l.pos]
+		l.pos++
+		if r == quote {
+			break
+		}
+		if r != '\\' {
+			val = append(val, r)
+			continue
+		}
+		if l.pos >= len(l.input) {
+			return token{}, errIncomplete
+		}
+		r = l.input[l.pos]
+		l.pos++
+		switch r {
+		case 't':
+			val = append(val, '\t')
+		case 'n':
+			val = append(val, '\n')
+		case 'r':
+			val = append(val, '\r')
+		default:
+			val = append(val, r)
+		}
+	}
+	// Language tags and datatypes are accepted but not kept; Cayley nodes
+	// are plain strings.
+	if l.peek(0) == '@' {
+		l.pos++
+		for l.pos < len(l.input) && isNameRune(l.input[l.pos]) {
+			l.pos++
+		}
+	} else if l.peek(0) == '^' && l.peek(1) == '^' {
+		l.pos += 2
+		if l.peek(0) == '<' {
+			if _, ok := l.scanIRI(); !ok {
+				return token{}, fmt.Errorf("sparql: bad datatype IRI at %d", l.pos)
+			}
+		} else {
+			for l.pos < len(l.input) && (isNameRune(l.input[l.pos]) || l.input[l.pos] == ':') {
+				l.pos++
+			}
+		}
+	}
+	return token{typ: tokString, val: string(val), pos: start}, nil
+}
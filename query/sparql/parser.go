@@ -0,0 +1,506 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sparql
+
+// Parses the supported subset of SPARQL 1.1:
+//
+//   PREFIX/BASE declarations
+//   SELECT [DISTINCT] (* | ?var...) [WHERE] { ... } [LIMIT n] [OFFSET n]
+//   ASK [WHERE] { ... }
+//
+// where a group may contain triple patterns (with ';' and ',' shorthands),
+// OPTIONAL groups, UNIONs of groups and FILTER expressions.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const rdfType = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+
+// Term is either a variable or a constant node in a triple pattern.
+type Term struct {
+	Var     string
+	Value   string
+	Literal bool
+}
+
+func (t Term) IsVar() bool { return t.Var != "" }
+
+func (t Term) String() string {
+	switch {
+	case t.IsVar():
+		return "?" + t.Var
+	case t.Literal:
+		return strconv.Quote(t.Value)
+	}
+	return "<" + t.Value + ">"
+}
+
+type Triple struct {
+	Subject, Predicate, Object Term
+}
+
+// Group is a group graph pattern, the contents of a pair of braces.
+type Group struct {
+	Triples   []Triple
+	Optionals []*Group
+	Unions    [][]*Group
+	Filters   []Expr
+}
+
+// Expr is a FILTER expression. It is one of Term, *Binary, *Not or *Call.
+type Expr interface{}
+
+type Binary struct {
+	Op          string
+	Left, Right Expr
+}
+
+type Not struct {
+	Expr Expr
+}
+
+type Call struct {
+	Name string
+	Args []Expr
+}
+
+// Query is a parsed SELECT or ASK query.
+type Query struct {
+	Ask      bool
+	Distinct bool
+	Vars     []string
+	Where    *Group
+	Limit    int
+	Offset   int
+}
+
+type parser struct {
+	toks     []token
+	pos      int
+	prefixes map[string]string
+	base     string
+}
+
+// Parse parses a SPARQL query string.
+func Parse(input string) (*Query, error) {
+	toks, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, prefixes: make(map[string]string)}
+	return p.parseQuery()
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) advance() token {
+	t := p.toks[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.typ == tokKeyword && strings.EqualFold(t.val, kw)
+}
+
+func (p *parser) isPunct(s string) bool {
+	t := p.peek()
+	return t.typ == tokPunct && t.val == s
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	if t.typ == tokEOF {
+		return errIncomplete
+	}
+	return fmt.Errorf("sparql: %s at %d, found %v", fmt.Sprintf(format, args...), t.pos, t)
+}
+
+func (p *parser) expectPunct(s string) error {
+	if !p.isPunct(s) {
+		return p.errorf("expected %q", s)
+	}
+	p.advance()
+	return nil
+}
+
+func (p *parser) parseQuery() (*Query, error) {
+	for {
+		if p.isKeyword("PREFIX") {
+			p.advance()
+			t := p.advance()
+			if t.typ != tokPName || !strings.HasSuffix(t.val, ":") {
+				p.pos--
+				return nil, p.errorf("expected prefix name")
+			}
+			iri := p.advance()
+			if iri.typ != tokIRI {
+				p.pos--
+				return nil, p.errorf("expected IRI")
+			}
+			p.prefixes[strings.TrimSuffix(t.val, ":")] = p.resolve(iri.val)
+			continue
+		}
+		if p.isKeyword("BASE") {
+			p.advance()
+			iri := p.advance()
+			if iri.typ != tokIRI {
+				p.pos--
+				return nil, p.errorf("expected IRI")
+			}
+			p.base = iri.val
+			continue
+		}
+		break
+	}
+
+	q := &Query{Limit: -1}
+	switch {
+	case p.isKeyword("SELECT"):
+		p.advance()
+		if p.isKeyword("DISTINCT") || p.isKeyword("REDUCED") {
+			q.Distinct = true
+			p.advance()
+		}
+		if p.isPunct("*") {
+			p.advance()
+		} else {
+			for p.peek().typ == tokVar {
+				q.Vars = append(q.Vars, p.advance().val)
+			}
+			if len(q.Vars) == 0 {
+				return nil, p.errorf("expected projection")
+			}
+		}
+	case p.isKeyword("ASK"):
+		p.advance()
+		q.Ask = true
+	default:
+		return nil, p.errorf("expected SELECT or ASK")
+	}
+	if p.isKeyword("WHERE") {
+		p.advance()
+	}
+	var err error
+	q.Where, err = p.parseGroup()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isKeyword("LIMIT"):
+			p.advance()
+			q.Limit, err = p.parseInt()
+		case p.isKeyword("OFFSET"):
+			p.advance()
+			q.Offset, err = p.parseInt()
+		default:
+			if p.peek().typ != tokEOF {
+				return nil, p.errorf("unexpected token")
+			}
+			return q, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseInt() (int, error) {
+	t := p.peek()
+	if t.typ != tokNumber {
+		return 0, p.errorf("expected integer")
+	}
+	n, err := strconv.Atoi(t.val)
+	if err != nil || n < 0 {
+		return 0, p.errorf("expected non-negative integer")
+	}
+	p.advance()
+	return n, nil
+}
+
+func (p *parser) resolve(iri string) string {
+	if p.base == "" || strings.Contains(iri, ":") {
+		return iri
+	}
+	return p.base + iri
+}
+
+func (p *parser) parseGroup() (*Group, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+	g := &Group{}
+	for {
+		switch {
+		case p.isPunct("}"):
+			p.advance()
+			return g, nil
+		case p.isPunct("."):
+			p.advance()
+		case p.isKeyword("OPTIONAL"):
+			p.advance()
+			sub, err := p.parseGroup()
+			if err != nil {
+				return nil, err
+			}
+			g.Optionals = append(g.Optionals, sub)
+		case p.isKeyword("FILTER"):
+			p.advance()
+			e, err := p.parseConstraint()
+			if err != nil {
+				return nil, err
+			}
+			g.Filters = append(g.Filters, e)
+		case p.isPunct("{"):
+			sub, err := p.parseGroup()
+			if err != nil {
+				return nil, err
+			}
+			alts := []*Group{sub}
+			for p.isKeyword("UNION") {
+				p.advance()
+				sub, err = p.parseGroup()
+				if err != nil {
+					return nil, err
+				}
+				alts = append(alts, sub)
+			}
+			if len(alts) == 1 {
+				// A plain nested group; fold it into this one.
+				g.Triples = append(g.Triples, sub.Triples...)
+				g.Optionals = append(g.Optionals, sub.Optionals...)
+				g.Unions = append(g.Unions, sub.Unions...)
+				g.Filters = append(g.Filters, sub.Filters...)
+				continue
+			}
+			g.Unions = append(g.Unions, alts)
+		default:
+			if err := p.parseTriples(g); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (p *parser) parseTriples(g *Group) error {
+	s, err := p.parseTerm(false)
+	if err != nil {
+		return err
+	}
+	for {
+		var pred Term
+		if p.isKeyword("a") {
+			p.advance()
+			pred = Term{Value: rdfType}
+		} else {
+			pred, err = p.parseTerm(false)
+			if err != nil {
+				return err
+			}
+			if pred.Literal {
+				return fmt.Errorf("sparql: literal %v used as predicate", pred)
+			}
+		}
+		for {
+			o, err := p.parseTerm(true)
+			if err != nil {
+				return err
+			}
+			g.Triples = append(g.Triples, Triple{Subject: s, Predicate: pred, Object: o})
+			if !p.isPunct(",") {
+				break
+			}
+			p.advance()
+		}
+		if !p.isPunct(";") {
+			return nil
+		}
+		p.advance()
+		// A trailing ';' is allowed before the end of the block.
+		if p.isPunct(".") || p.isPunct("}") {
+			return nil
+		}
+	}
+}
+
+// parseTerm reads a variable, IRI, prefixed name or, if literals are
+// allowed, a string or number literal.
+func (p *parser) parseTerm(literals bool) (Term, error) {
+	t := p.peek()
+	switch t.typ {
+	case tokVar:
+		p.advance()
+		return Term{Var: t.val}, nil
+	case tokIRI:
+		p.advance()
+		return Term{Value: p.resolve(t.val)}, nil
+	case tokPName:
+		i := strings.Index(t.val, ":")
+		ns, ok := p.prefixes[t.val[:i]]
+		if !ok {
+			return Term{}, p.errorf("undefined prefix %q", t.val[:i])
+		}
+		p.advance()
+		return Term{Value: ns + t.val[i+1:]}, nil
+	case tokString, tokNumber:
+		if literals {
+			p.advance()
+			return Term{Value: t.val, Literal: true}, nil
+		}
+	case tokKeyword:
+		if literals && (t.val == "true" || t.val == "false") {
+			p.advance()
+			return Term{Value: t.val, Literal: true}, nil
+		}
+	}
+	return Term{}, p.errorf("expected term")
+}
+
+func (p *parser) parseConstraint() (Expr, error) {
+	if p.isPunct("(") {
+		p.advance()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expectPunct(")")
+	}
+	if p.peek().typ == tokKeyword {
+		return p.parseCall()
+	}
+	return nil, p.errorf("expected FILTER constraint")
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isPunct("||") {
+		p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Op: "||", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseRelational()
+	if err != nil {
+		return nil, err
+	}
+	for p.isPunct("&&") {
+		p.advance()
+		right, err := p.parseRelational()
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Op: "&&", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseRelational() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"=", "!=", "<", "<=", ">", ">="} {
+		if p.isPunct(op) {
+			p.advance()
+			right, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &Binary{Op: op, Left: left, Right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	switch {
+	case p.isPunct("!"):
+		p.advance()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Expr: e}, nil
+	case p.isPunct("("):
+		p.advance()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expectPunct(")")
+	case p.peek().typ == tokKeyword && p.peek().val != "true" && p.peek().val != "false":
+		return p.parseCall()
+	}
+	return p.parseTerm(true)
+}
+
+func (p *parser) parseCall() (Expr, error) {
+	name := strings.ToLower(p.peek().val)
+	switch name {
+	case "bound", "regex", "str":
+	default:
+		return nil, p.errorf("unsupported function")
+	}
+	p.advance()
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	c := &Call{Name: name}
+	for !p.isPunct(")") {
+		if len(c.Args) > 0 {
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		c.Args = append(c.Args, e)
+	}
+	p.advance()
+	switch {
+	case name == "bound" && (len(c.Args) != 1 || !isVar(c.Args[0])):
+		return nil, fmt.Errorf("sparql: BOUND takes a single variable")
+	case name == "regex" && (len(c.Args) < 2 || len(c.Args) > 3):
+		return nil, fmt.Errorf("sparql: REGEX takes two or three arguments")
+	case name == "str" && len(c.Args) != 1:
+		return nil, fmt.Errorf("sparql: STR takes a single argument")
+	}
+	return c, nil
+}
+
+func isVar(e Expr) bool {
+	t, ok := e.(Term)
+	return ok && t.IsVar()
+}
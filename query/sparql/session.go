@@ -0,0 +1,323 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sparql
+
+// Defines a running session of the SPARQL query language.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/barakmich/glog"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/query"
+)
+
+type Session struct {
+	qs      graph.QuadStore
+	debug   bool
+	vars    []string
+	results []interface{}
+	err     error
+	explain *query.Explain
+}
+
+func NewSession(qs graph.QuadStore) *Session {
+	var s Session
+	s.qs = qs
+	return &s
+}
+
+func (s *Session) ToggleDebug() {
+	s.debug = !s.debug
+}
+
+func (s *Session) SetExplain(e *query.Explain) {
+	s.explain = e
+}
+
+func (s *Session) InputParses(input string) (query.ParseResult, error) {
+	_, err := Parse(input)
+	switch err {
+	case nil:
+		return query.Parsed, nil
+	case errIncomplete:
+		return query.ParseMore, nil
+	}
+	return query.ParseFail, err
+}
+
+// compiled is a query ready to run.
+type compiled struct {
+	q    *Query
+	vars []string
+	its  []graph.Iterator
+	b    *builder
+}
+
+func (s *Session) compile(input string) (*compiled, error) {
+	q, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	b := newBuilder(s.qs)
+	its, err := b.buildComponents(q.Where)
+	if err != nil {
+		return nil, err
+	}
+	vars := q.Vars
+	if vars == nil {
+		vars = groupVars(q.Where)
+	}
+	return &compiled{q: q, vars: vars, its: its, b: b}, nil
+}
+
+func (s *Session) GetQuery(input string, out chan map[string]interface{}) {
+	defer close(out)
+	c, err := s.compile(input)
+	if err != nil || len(c.its) == 0 {
+		return
+	}
+	output := make(map[string]interface{})
+	iterator.OutputQueryShapeForIterator(c.its[0], s.qs, output)
+	out <- output
+}
+
+func (s *Session) ExecInput(ctx context.Context, input string, out chan interface{}, limit int) {
+	defer close(out)
+	s.err = nil
+	c, err := s.compile(input)
+	if err != nil {
+		s.err = err
+		out <- err
+		return
+	}
+	s.vars = c.vars
+	defer s.explain.Finish()
+	run := true
+	for i, it := range c.its {
+		it, _ = it.Optimize()
+		graph.SetKill(it, ctx.Done())
+		c.its[i] = it
+		if !s.explain.Start(it) {
+			run = false
+		}
+		if s.debug || bool(glog.V(2)) {
+			b, err := json.MarshalIndent(it.Describe(), "", "  ")
+			if err != nil {
+				glog.Infof("failed to format description: %v", err)
+			} else {
+				glog.Infof("%s", b)
+			}
+		}
+	}
+	defer func() {
+		for _, it := range c.its {
+			it.Close()
+		}
+	}()
+	if !run {
+		return
+	}
+
+	defer func() {
+		if err := query.KillError(ctx); err != nil {
+			s.err = err
+			out <- err
+		}
+	}()
+
+	if c.q.Ask {
+		found := false
+		c.solutions(s.qs, ctx.Done(), func(map[string]graph.Value) bool {
+			found = true
+			return false
+		})
+		if ctx.Err() == nil {
+			out <- found
+		}
+		return
+	}
+
+	var (
+		seen    = make(map[string]bool)
+		skipped int
+		sent    int
+	)
+	c.solutions(s.qs, ctx.Done(), func(tags map[string]graph.Value) bool {
+		row := make(map[string]string)
+		for _, v := range c.vars {
+			if val, ok := tags[v]; ok && val != nil {
+				row[v] = s.qs.NameOf(val)
+			}
+		}
+		if c.q.Distinct {
+			key := rowKey(c.vars, row)
+			if seen[key] {
+				return true
+			}
+			seen[key] = true
+		}
+		if skipped < c.q.Offset {
+			skipped++
+			return true
+		}
+		out <- row
+		sent++
+		if c.q.Limit >= 0 && sent >= c.q.Limit {
+			return false
+		}
+		return limit < 0 || sent < limit
+	})
+}
+
+// solutions calls fn with every solution to the query, until fn returns
+// false or kill is closed. Disconnected parts of the pattern are joined as a
+// cross product, and the checks that could not be built into the iterators
+// are applied.
+func (c *compiled) solutions(qs graph.QuadStore, kill <-chan struct{}, fn func(map[string]graph.Value) bool) {
+	accept := func(tags map[string]graph.Value) bool {
+		for alias, v := range c.b.aliases {
+			got, ok := tags[alias]
+			if !ok {
+				continue
+			}
+			if want, ok := tags[v]; ok && want != got {
+				return true
+			}
+		}
+		for _, e := range c.b.post {
+			if !matches(qs, e, tags) {
+				return true
+			}
+		}
+		return !graph.Killed(kill) && fn(tags)
+	}
+	if len(c.its) == 0 {
+		// An empty pattern has exactly one, empty, solution.
+		accept(make(map[string]graph.Value))
+		return
+	}
+	rest := make([][]map[string]graph.Value, len(c.its)-1)
+	for i, it := range c.its[1:] {
+		eachResult(it, kill, func(tags map[string]graph.Value) bool {
+			rest[i] = append(rest[i], tags)
+			return true
+		})
+	}
+	eachResult(c.its[0], kill, func(tags map[string]graph.Value) bool {
+		return product(tags, rest, accept)
+	})
+}
+
+func product(tags map[string]graph.Value, rest [][]map[string]graph.Value, fn func(map[string]graph.Value) bool) bool {
+	if len(rest) == 0 {
+		return fn(tags)
+	}
+	for _, other := range rest[0] {
+		joined := make(map[string]graph.Value, len(tags)+len(other))
+		for k, v := range tags {
+			joined[k] = v
+		}
+		for k, v := range other {
+			joined[k] = v
+		}
+		if !product(joined, rest[1:], fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// eachResult calls fn with the tags of every result and path of it, until
+// fn returns false or kill is closed.
+func eachResult(it graph.Iterator, kill <-chan struct{}, fn func(map[string]graph.Value) bool) {
+	for graph.Next(it) {
+		if graph.Killed(kill) {
+			return
+		}
+		tags := make(map[string]graph.Value)
+		it.TagResults(tags)
+		if !fn(tags) {
+			return
+		}
+		for it.NextPath() {
+			if graph.Killed(kill) {
+				return
+			}
+			tags := make(map[string]graph.Value)
+			it.TagResults(tags)
+			if !fn(tags) {
+				return
+			}
+		}
+	}
+}
+
+func rowKey(vars []string, row map[string]string) string {
+	parts := make([]string, len(vars))
+	for i, v := range vars {
+		if val, ok := row[v]; ok {
+			parts[i] = "+" + val
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func (s *Session) ToText(result interface{}) string {
+	switch r := result.(type) {
+	case error:
+		return fmt.Sprintf("Error: %v\n", r)
+	case bool:
+		return fmt.Sprintln(r)
+	}
+	row := result.(map[string]string)
+	out := fmt.Sprintln("****")
+	for _, v := range s.vars {
+		if val, ok := row[v]; ok {
+			out += fmt.Sprintf("?%s : %s\n", v, val)
+		}
+	}
+	return out
+}
+
+func (s *Session) BuildJSON(result interface{}) {
+	if _, ok := result.(error); ok {
+		return
+	}
+	s.results = append(s.results, result)
+}
+
+func (s *Session) ResultJSON(result interface{}) ([]interface{}, error) {
+	if err, ok := result.(error); ok {
+		return nil, err
+	}
+	return []interface{}{result}, nil
+}
+
+func (s *Session) GetJSON() ([]interface{}, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.results, nil
+}
+
+func (s *Session) ClearJSON() {
+	s.results = nil
+}
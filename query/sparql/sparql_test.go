@@ -0,0 +1,393 @@
+// Copyright 2014 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sparql
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/cayley/graph"
+	_ "github.com/google/cayley/graph/memstore"
+	"github.com/google/cayley/quad"
+	"github.com/google/cayley/query"
+	_ "github.com/google/cayley/writer"
+)
+
+// This is a simple test graph.
+//
+//    +---+                        +---+
+//    | A |-------               ->| F |<--
+//    +---+       \------>+---+-/  +---+   \--+---+
+//                 ------>|#B#|      |        | E |
+//    +---+-------/      >+---+      |        +---+
+//    | C |             /            v
+//    +---+           -/           +---+
+//      ----    +---+/             |#G#|
+//          \-->|#D#|------------->+---+
+//              +---+
+//
+var simpleGraph = []quad.Quad{
+	{"A", "follows", "B", ""},
+	{"C", "follows", "B", ""},
+	{"C", "follows", "D", ""},
+	{"D", "follows", "B", ""},
+	{"B", "follows", "F", ""},
+	{"F", "follows", "G", ""},
+	{"D", "follows", "G", ""},
+	{"E", "follows", "F", ""},
+	{"B", "status", "cool", "status_graph"},
+	{"D", "status", "cool", "status_graph"},
+	{"G", "status", "cool", "status_graph"},
+	{"A", "age", "21", ""},
+	{"B", "age", "25", ""},
+	{"C", "age", "30", ""},
+	{"D", "age", "7", ""},
+}
+
+func makeTestSession(data []quad.Quad) *Session {
+	qs, _ := graph.NewQuadStore("memstore", "", nil)
+	w, _ := graph.NewQuadWriter("single", qs, nil)
+	for _, t := range data {
+		w.AddQuad(t)
+	}
+	return NewSession(qs)
+}
+
+var testQueries = []struct {
+	message string
+	query   string
+	expect  string
+}{
+	{
+		message: "select a simple triple pattern",
+		query:   `SELECT ?x WHERE { ?x <follows> <B> }`,
+		expect:  `[{"x": "A"}, {"x": "C"}, {"x": "D"}]`,
+	},
+	{
+		message: "use prefixes and a shared variable",
+		query: `
+			PREFIX : <>
+			SELECT ?x ?y WHERE { ?x :follows ?y . ?y :status "cool" }
+		`,
+		expect: `[
+			{"x": "A", "y": "B"},
+			{"x": "C", "y": "B"},
+			{"x": "C", "y": "D"},
+			{"x": "D", "y": "B"},
+			{"x": "D", "y": "G"},
+			{"x": "F", "y": "G"}
+		]`,
+	},
+	{
+		message: "use predicate-object list shorthand",
+		query:   `SELECT * { ?x <follows> <B> ; <follows> <G> }`,
+		expect:  `[{"x": "D"}]`,
+	},
+	{
+		message: "follow a cycle in the pattern",
+		query:   `SELECT ?x ?y { ?x <follows> ?y . ?y <follows> ?z . ?x <follows> ?z }`,
+		expect:  `[{"x": "C", "y": "D"}]`,
+	},
+	{
+		message: "bind a predicate variable",
+		query:   `SELECT ?p ?o { <D> ?p ?o }`,
+		expect: `[
+			{"p": "age", "o": "7"},
+			{"p": "follows", "o": "B"},
+			{"p": "follows", "o": "G"},
+			{"p": "status", "o": "cool"}
+		]`,
+	},
+	{
+		message: "return optional bindings",
+		query:   `SELECT ?x ?s { ?x <follows> <B> OPTIONAL { ?x <status> ?s } }`,
+		expect: `[
+			{"x": "A"},
+			{"x": "C"},
+			{"x": "D", "s": "cool"}
+		]`,
+	},
+	{
+		message: "filter on unbound optional variables",
+		query:   `SELECT ?x { ?x <follows> <B> OPTIONAL { ?x <status> ?s } FILTER (!bound(?s)) }`,
+		expect:  `[{"x": "A"}, {"x": "C"}]`,
+	},
+	{
+		message: "union alternatives",
+		query:   `SELECT ?x { { ?x <follows> <G> } UNION { ?x <status> "cool" } }`,
+		expect:  `[{"x": "B"}, {"x": "D"}, {"x": "D"}, {"x": "F"}, {"x": "G"}]`,
+	},
+	{
+		message: "union alternatives sharing a variable",
+		query:   `SELECT DISTINCT ?x ?y { ?x <follows> ?y { ?y <follows> <G> } UNION { ?y <status> "cool" } }`,
+		expect: `[
+			{"x": "A", "y": "B"},
+			{"x": "C", "y": "B"},
+			{"x": "C", "y": "D"},
+			{"x": "D", "y": "B"},
+			{"x": "D", "y": "G"},
+			{"x": "B", "y": "F"},
+			{"x": "E", "y": "F"},
+			{"x": "F", "y": "G"}
+		]`,
+	},
+	{
+		message: "filter with a numeric comparison",
+		query:   `SELECT ?x { ?x <age> ?a FILTER (?a >= 21 && ?a < 30) }`,
+		expect:  `[{"x": "A"}, {"x": "B"}]`,
+	},
+	{
+		message: "filter with a flipped comparison",
+		query:   `SELECT ?x { ?x <age> ?a FILTER (10 > ?a) }`,
+		expect:  `[{"x": "D"}]`,
+	},
+	{
+		message: "filter a variable which is not the root",
+		query:   `SELECT ?x ?a { ?x <age> ?a FILTER (?a > 5) }`,
+		expect:  `[{"x": "A", "a": "21"}, {"x": "B", "a": "25"}, {"x": "C", "a": "30"}, {"x": "D", "a": "7"}]`,
+	},
+	{
+		message: "filter a variable which is not the root by a literal",
+		query:   `SELECT ?x ?a { ?x <age> ?a FILTER (?a < "8") }`,
+		expect:  `[{"x": "D", "a": "7"}]`,
+	},
+	{
+		message: "filter a variable which is not the root out entirely",
+		query:   `SELECT ?x ?a { ?x <age> ?a FILTER (?a < "6") }`,
+		expect:  `null`,
+	},
+	{
+		message: "filter with an equality",
+		query:   `SELECT ?x { ?x <follows> ?y FILTER (?y = <F>) }`,
+		expect:  `[{"x": "B"}, {"x": "E"}]`,
+	},
+	{
+		message: "filter with an inequality",
+		query:   `SELECT ?y { <C> <follows> ?y FILTER (?y != <B>) }`,
+		expect:  `[{"y": "D"}]`,
+	},
+	{
+		message: "filter with a regex",
+		query:   `SELECT ?x { ?x <status> ?s FILTER regex(?s, "^CO", "i") }`,
+		expect:  `[{"x": "B"}, {"x": "D"}, {"x": "G"}]`,
+	},
+	{
+		message: "join disconnected patterns",
+		query:   `SELECT ?x ?y { ?x <follows> <D> . ?y <age> "7" }`,
+		expect:  `[{"x": "C", "y": "D"}]`,
+	},
+	{
+		message: "ask about a matching pattern",
+		query:   `ASK { <A> <follows> ?x . ?x <status> "cool" }`,
+		expect:  `[true]`,
+	},
+	{
+		message: "ask about a missing pattern",
+		query:   `ASK { <A> <follows> <G> }`,
+		expect:  `[false]`,
+	},
+}
+
+func runQuery(g []quad.Quad, query string) ([]interface{}, error) {
+	s := makeTestSession(g)
+	c := make(chan interface{}, 5)
+	go s.ExecInput(context.Background(), query, c, -1)
+	for result := range c {
+		s.BuildJSON(result)
+	}
+	return s.GetJSON()
+}
+
+// byString sorts results so that tests do not depend on iteration order.
+type byString []interface{}
+
+func (s byString) Len() int      { return len(s) }
+func (s byString) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byString) Less(i, j int) bool {
+	a, _ := json.Marshal(s[i])
+	b, _ := json.Marshal(s[j])
+	return string(a) < string(b)
+}
+
+func TestSPARQL(t *testing.T) {
+	checkQueries(t, simpleGraph, testQueries)
+}
+
+// decoratedGraph holds names as N-Quads spells them, with IRIs in angle
+// brackets and literals in quotes.
+var decoratedGraph = []quad.Quad{
+	{"<A>", "<age>", `"21"`, ""},
+	{"<B>", "<age>", `"25"`, ""},
+	{"<C>", "<age>", `"30"`, ""},
+	{"<A>", "<follows>", "<B>", ""},
+}
+
+var decoratedQueries = []struct {
+	message string
+	query   string
+	expect  string
+}{
+	{
+		message: "filter decorated literals with a comparison",
+		query:   `SELECT ?x { ?x <age> ?a FILTER (?a > 22) }`,
+		expect:  `[{"x": "<B>"}, {"x": "<C>"}]`,
+	},
+	{
+		// The || isn't turned into an iterator, so it's evaluated on each
+		// solution, and must agree with the comparison above.
+		message: "filter decorated literals after matching",
+		query:   `SELECT ?x { ?x <age> ?a FILTER (?a > 22 || ?a < 0) }`,
+		expect:  `[{"x": "<B>"}, {"x": "<C>"}]`,
+	},
+	{
+		message: "filter decorated literals by equality",
+		query:   `SELECT ?x { ?x <age> ?a FILTER (?a = "25") }`,
+		expect:  `[{"x": "<B>"}]`,
+	},
+	{
+		message: "match nothing for an unknown node",
+		query:   `SELECT ?x { ?x <follows> <Z> }`,
+		expect:  `null`,
+	},
+	{
+		message: "match nothing when filtering on an unknown node",
+		query:   `SELECT ?x { ?x <follows> ?y FILTER (?y = <Z>) }`,
+		expect:  `null`,
+	},
+}
+
+func TestDecoratedNames(t *testing.T) {
+	checkQueries(t, decoratedGraph, decoratedQueries)
+}
+
+func checkQueries(t *testing.T, g []quad.Quad, tests []struct {
+	message string
+	query   string
+	expect  string
+}) {
+	for _, test := range tests {
+		got, err := runQuery(g, test.query)
+		if err != nil {
+			t.Errorf("Failed to %s: unexpected error: %v", test.message, err)
+			continue
+		}
+		// Round trip through JSON to compare like with like.
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("unexpected JSON marshal error: %v", err)
+		}
+		var norm, expect []interface{}
+		json.Unmarshal(b, &norm)
+		json.Unmarshal([]byte(test.expect), &expect)
+		sort.Sort(byString(norm))
+		sort.Sort(byString(expect))
+		if !reflect.DeepEqual(norm, expect) {
+			t.Errorf("Failed to %s, got: %s expected: %s", test.message, b, test.expect)
+		}
+	}
+}
+
+var limitTests = []struct {
+	query  string
+	expect int
+}{
+	{query: `SELECT ?x { ?x <follows> ?y } LIMIT 3`, expect: 3},
+	{query: `SELECT ?x { ?x <follows> ?y } LIMIT 3 OFFSET 6`, expect: 2},
+	{query: `SELECT DISTINCT ?x { ?x <follows> ?y } OFFSET 2`, expect: 4},
+}
+
+func TestLimitOffset(t *testing.T) {
+	for _, test := range limitTests {
+		got, err := runQuery(simpleGraph, test.query)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != test.expect {
+			t.Errorf("Unexpected number of results for %q, got:%d expect:%d", test.query, len(got), test.expect)
+		}
+	}
+}
+
+var parseTests = []struct {
+	message string
+	query   string
+	expect  query.ParseResult
+}{
+	{
+		message: "parse a complete query",
+		query:   `SELECT ?x WHERE { ?x <follows> "B" . }`,
+		expect:  query.Parsed,
+	},
+	{
+		message: "ask for more on an open group",
+		query:   `SELECT ?x WHERE { ?x <follows> `,
+		expect:  query.ParseMore,
+	},
+	{
+		message: "ask for more on an open string",
+		query:   `SELECT ?x WHERE { ?x <name> "B`,
+		expect:  query.ParseMore,
+	},
+	{
+		message: "fail on an undefined prefix",
+		query:   `SELECT ?x WHERE { ?x foo:bar "B" }`,
+		expect:  query.ParseFail,
+	},
+	{
+		message: "fail on a literal predicate",
+		query:   `SELECT ?x WHERE { ?x "bar" "B" }`,
+		expect:  query.ParseFail,
+	},
+	{
+		message: "fail on trailing garbage",
+		query:   `SELECT ?x WHERE { ?x <follows> "B" } }`,
+		expect:  query.ParseFail,
+	},
+}
+
+func TestInputParses(t *testing.T) {
+	s := NewSession(nil)
+	for _, test := range parseTests {
+		got, _ := s.InputParses(test.query)
+		if got != test.expect {
+			t.Errorf("Failed to %s, got: %v expected: %v", test.message, got, test.expect)
+		}
+	}
+}
+
+var errorQueries = []struct {
+	message string
+	query   string
+}{
+	{
+		message: "reject an unconnected OPTIONAL",
+		query:   `SELECT * { ?x <follows> ?y OPTIONAL { ?a <status> ?b } }`,
+	},
+	{
+		message: "reject a complex FILTER inside an OPTIONAL",
+		query:   `SELECT * { ?x <follows> ?y OPTIONAL { ?y <status> ?b FILTER (?b != ?x) } }`,
+	},
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range errorQueries {
+		if _, err := runQuery(simpleGraph, test.query); err == nil {
+			t.Errorf("Failed to %s", test.message)
+		}
+	}
+}
@@ -27,17 +27,27 @@ func init() {
 }
 
 type Single struct {
-	nextID int64
-	qs     graph.QuadStore
-	mut    sync.Mutex
+	nextID     int64
+	qs         graph.QuadStore
+	ignoreOpts graph.IgnoreOpts
+	mut        sync.Mutex
 }
 
+// NewSingleReplication creates a writer for qs. The "ignore_duplicate" and
+// "ignore_missing" options make it skip adding quads that are present and
+// removing quads that are not, rather than failing.
 func NewSingleReplication(qs graph.QuadStore, opts graph.Options) (graph.QuadWriter, error) {
 	horizon := qs.Horizon()
 	rep := &Single{nextID: horizon + 1, qs: qs}
 	if horizon <= 0 {
 		rep.nextID = 1
 	}
+	if v, ok := opts.BoolKey("ignore_duplicate"); ok {
+		rep.ignoreOpts.IgnoreDup = v
+	}
+	if v, ok := opts.BoolKey("ignore_missing"); ok {
+		rep.ignoreOpts.IgnoreMissing = v
+	}
 	return rep, nil
 }
 
@@ -57,7 +67,7 @@ func (s *Single) AddQuad(q quad.Quad) error {
 		Action:    graph.Add,
 		Timestamp: time.Now(),
 	}
-	return s.qs.ApplyDeltas(deltas)
+	return s.qs.ApplyDeltas(deltas, s.ignoreOpts)
 }
 
 func (s *Single) AddQuadSet(set []quad.Quad) error {
@@ -70,8 +80,7 @@ func (s *Single) AddQuadSet(set []quad.Quad) error {
 			Timestamp: time.Now(),
 		}
 	}
-	s.qs.ApplyDeltas(deltas)
-	return nil
+	return s.qs.ApplyDeltas(deltas, s.ignoreOpts)
 }
 
 func (s *Single) RemoveQuad(q quad.Quad) error {
@@ -82,7 +91,36 @@ func (s *Single) RemoveQuad(q quad.Quad) error {
 		Action:    graph.Delete,
 		Timestamp: time.Now(),
 	}
-	return s.qs.ApplyDeltas(deltas)
+	return s.qs.ApplyDeltas(deltas, s.ignoreOpts)
+}
+
+func (s *Single) ApplyTransaction(t *graph.Transaction) error {
+	deltas := make([]graph.Delta, len(t.Deltas))
+	for i, d := range t.Deltas {
+		deltas[i] = graph.Delta{
+			ID:        s.AcquireNextID(),
+			Quad:      d.Quad,
+			Action:    d.Action,
+			Timestamp: time.Now(),
+		}
+	}
+	return s.qs.ApplyDeltas(deltas, t.Ignore.Over(s.ignoreOpts))
+}
+
+// BulkLoad loads quads with the QuadStore's BulkLoad, if it has one, and
+// then continues numbering deltas after the quads it loaded.
+func (s *Single) BulkLoad(dec quad.Unmarshaler) error {
+	bl, ok := s.qs.(graph.BulkLoader)
+	if !ok {
+		return graph.ErrCannotBulkLoad
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	err := bl.BulkLoad(dec)
+	if horizon := s.qs.Horizon(); horizon >= s.nextID {
+		s.nextID = horizon + 1
+	}
+	return err
 }
 
 func (s *Single) Close() error {